@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kastelo/go-import-redirector/redirector"
+)
+
+var (
+	logFile   = flag.String("log-file", "", "append an access log to `file` (- for standard output), reopened on SIGHUP")
+	logFormat = flag.String("log-format", "combined", "access log `format`: combined or json")
+)
+
+// accessLog is the access log file, if any.
+var accessLog struct {
+	mu sync.Mutex
+	w  io.Writer
+	f  *os.File // nil for standard output
+}
+
+// openAccessLog opens the -log-file.
+func openAccessLog() error {
+	switch *logFormat {
+	case "combined", "json":
+	default:
+		return fmt.Errorf("unknown -log-format %q: want combined or json", *logFormat)
+	}
+	if *logFile == "-" {
+		accessLog.w = os.Stdout
+		return nil
+	}
+	return reopenAccessLog()
+}
+
+// reopenAccessLog (re)opens the -log-file, so that it can be rotated by
+// renaming it and sending SIGHUP.
+func reopenAccessLog() error {
+	f, err := os.OpenFile(*logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	accessLog.mu.Lock()
+	old := accessLog.f
+	accessLog.f, accessLog.w = f, f
+	accessLog.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// accessKey is the context key of the *accessEntry of a request.
+type accessKey struct{}
+
+// An accessEntry is what the redirect handler adds to the access log line
+// of a request.
+type accessEntry struct {
+	route      string
+	importRoot string
+}
+
+// noteAccess records the resolution of req for its access log line.
+func noteAccess(req *http.Request, r *redirector.Resolution) {
+	if e, ok := req.Context().Value(accessKey{}).(*accessEntry); ok {
+		e.route, e.importRoot = r.Route, r.ImportRoot
+	}
+}
+
+// accessLogged wraps h to write a line to the access log for each request.
+func accessLogged(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		e := new(accessEntry)
+		cw := &countingWriter{ResponseWriter: w}
+		h.ServeHTTP(cw, req.WithContext(context.WithValue(req.Context(), accessKey{}, e)))
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		var line []byte
+		if *logFormat == "json" {
+			line = jsonLine(req, e, cw, start)
+		} else {
+			line = combinedLine(req, cw, start)
+		}
+		accessLog.mu.Lock()
+		accessLog.w.Write(line)
+		accessLog.mu.Unlock()
+	})
+}
+
+func remoteHost(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// combinedLine formats the request in the Apache combined log format,
+// followed with -geoip-db by the client's country and autonomous system.
+func combinedLine(req *http.Request, cw *countingWriter, start time.Time) []byte {
+	size := "-"
+	if cw.n > 0 {
+		size = strconv.FormatInt(cw.n, 10)
+	}
+	line := fmt.Sprintf("%s - - [%s] %q %d %s %q %q",
+		clientIP(req), start.Format("02/Jan/2006:15:04:05 -0700"),
+		req.Method+" "+req.RequestURI+" "+req.Proto, cw.status, size,
+		orDash(req.Referer()), orDash(req.UserAgent()))
+	if geoIPDB != nil {
+		country, as := geoLookup(clientIP(req))
+		line += fmt.Sprintf(" %q %q", orDash(country), orDash(as))
+	}
+	return []byte(line + "\n")
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// jsonLine formats the request as a JSON object.
+func jsonLine(req *http.Request, e *accessEntry, cw *countingWriter, start time.Time) []byte {
+	country, as := geoLookup(clientIP(req))
+	line, _ := json.Marshal(struct {
+		Time       time.Time `json:"time"`
+		Remote     string    `json:"remote"`
+		Method     string    `json:"method"`
+		Host       string    `json:"host"`
+		URI        string    `json:"uri"`
+		Status     int       `json:"status"`
+		Bytes      int64     `json:"bytes"`
+		DurationMS float64   `json:"duration_ms"`
+		ImportRoot string    `json:"import_root,omitempty"`
+		Route      string    `json:"route,omitempty"`
+		GoGet      bool      `json:"go_get"`
+		Referer    string    `json:"referer,omitempty"`
+		UserAgent  string    `json:"user_agent,omitempty"`
+		Country    string    `json:"country,omitempty"`
+		AS         string    `json:"as,omitempty"`
+	}{
+		Time:       start,
+		Remote:     clientIP(req),
+		Method:     req.Method,
+		Host:       req.Host,
+		URI:        req.RequestURI,
+		Status:     cw.status,
+		Bytes:      cw.n,
+		DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+		ImportRoot: e.importRoot,
+		Route:      e.route,
+		GoGet:      req.URL.Query().Get("go-get") == "1",
+		Referer:    req.Referer(),
+		UserAgent:  req.UserAgent(),
+		Country:    country,
+		AS:         as,
+	})
+	return append(line, '\n')
+}
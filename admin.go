@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/subtle"
+	"flag"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var adminToken = flag.String("admin-token", "", "require bearer `token` for administrative endpoints (default $GIR_ADMIN_TOKEN)")
+
+// adminSecret returns the configured admin token, or "" if administrative
+// endpoints are disabled.
+func adminSecret() string {
+	if *adminToken != "" {
+		return *adminToken
+	}
+	return os.Getenv("GIR_ADMIN_TOKEN")
+}
+
+// adminAuthorized reports whether the Authorization header value
+// authorization carries the admin bearer token.
+func adminAuthorized(authorization string) bool {
+	token := adminSecret()
+	got, ok := strings.CutPrefix(authorization, "Bearer ")
+	return token != "" && ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// adminOnly restricts h to requests carrying the admin bearer token.
+// Without a configured token, administrative endpoints do not exist.
+func adminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if adminSecret() == "" {
+			http.NotFound(w, req)
+			return
+		}
+		if !adminAuthorized(req.Header.Get("Authorization")) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="go-import-redirector"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, req)
+	}
+}
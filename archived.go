@@ -0,0 +1,124 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var checkArchived = flag.Bool("check-archived", false, "mark modules whose GitHub, GitLab.com or Codeberg repo is archived")
+
+const (
+	archivedTTL        = 6 * time.Hour
+	maxArchivedChecked = 10000
+)
+
+type archivedState struct {
+	repo     string
+	archived bool
+	fetched  time.Time
+	fetching bool
+}
+
+// archivedCache holds whether each repo is archived, by repo URL,
+// evicting the least recently used repos beyond maxArchivedChecked.
+var archivedCache = struct {
+	sync.Mutex
+	m   map[string]*list.Element
+	lru list.List // of *archivedState, most recently used first
+}{m: make(map[string]*list.Element)}
+
+// repoArchived reports whether repo is known to be archived, and
+// refreshes the knowledge in the background when it is missing or stale,
+// like moduleVersions, so that pages are never held up by the forge.
+func repoArchived(repo string) bool {
+	if !*checkArchived {
+		return false
+	}
+	api := forgeAPI(repo)
+	if api == "" {
+		return false
+	}
+	archivedCache.Lock()
+	defer archivedCache.Unlock()
+	var s *archivedState
+	if e := archivedCache.m[repo]; e != nil {
+		archivedCache.lru.MoveToFront(e)
+		s = e.Value.(*archivedState)
+	} else {
+		s = &archivedState{repo: keepString(repo)}
+		archivedCache.m[repo] = archivedCache.lru.PushFront(s)
+		if archivedCache.lru.Len() > maxArchivedChecked {
+			old := archivedCache.lru.Remove(archivedCache.lru.Back()).(*archivedState)
+			delete(archivedCache.m, old.repo)
+		}
+	}
+	if !s.fetching && time.Since(s.fetched) > archivedTTL {
+		s.fetching = true
+		go fetchArchived(api, s)
+	}
+	return s.archived
+}
+
+func fetchArchived(api string, s *archivedState) {
+	archived, err := queryArchived(api)
+	archivedCache.Lock()
+	defer archivedCache.Unlock()
+	s.fetching = false
+	s.fetched = time.Now()
+	if err == nil {
+		s.archived = archived
+	}
+}
+
+// queryArchived asks the forge API at api whether the repo is archived,
+// with the GIR_FORGE_TOKEN so that private repos can be seen. GitHub,
+// GitLab and Gitea all describe the repo with an "archived" field.
+func queryArchived(api string) (bool, error) {
+	resp, err := forgeGet(viaForgeURL(api))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("%s: %s", api, resp.Status)
+	}
+	var repo struct {
+		Archived bool `json:"archived"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&repo); err != nil {
+		return false, err
+	}
+	return repo.Archived, nil
+}
+
+// forgeAPI returns the API URL describing repo on the forges that are
+// known, or "".
+func forgeAPI(repo string) string {
+	u, err := url.Parse(repo)
+	if err != nil || u.Scheme != "https" {
+		return ""
+	}
+	p := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	switch u.Host {
+	case "github.com":
+		if strings.Count(p, "/") == 1 {
+			return "https://api.github.com/repos/" + p
+		}
+	case "gitlab.com":
+		if strings.Contains(p, "/") {
+			return "https://gitlab.com/api/v4/projects/" + url.PathEscape(p)
+		}
+	case "codeberg.org":
+		if strings.Count(p, "/") == 1 {
+			return "https://codeberg.org/api/v1/repos/" + p
+		}
+	}
+	return ""
+}
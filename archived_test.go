@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kastelo/go-import-redirector/forgetest"
+)
+
+func TestForgeAPI(t *testing.T) {
+	tests := []struct{ repo, api string }{
+		{"https://github.com/acme/hello", "https://api.github.com/repos/acme/hello"},
+		{"https://github.com/acme/hello.git", "https://api.github.com/repos/acme/hello"},
+		{"https://gitlab.com/group/sub/hello", "https://gitlab.com/api/v4/projects/group%2Fsub%2Fhello"},
+		{"https://codeberg.org/acme/hello", "https://codeberg.org/api/v1/repos/acme/hello"},
+		{"https://github.com/acme", ""},
+		{"http://github.com/acme/hello", ""},
+		{"https://example.com/acme/hello", ""},
+	}
+	for _, tt := range tests {
+		if got := forgeAPI(tt.repo); got != tt.api {
+			t.Errorf("forgeAPI(%q) = %q, want %q", tt.repo, got, tt.api)
+		}
+	}
+}
+
+func TestQueryArchived(t *testing.T) {
+	f := serveForge(t)
+	f.AddRepo("github.com/acme/old", forgetest.Repo{Archived: true})
+	f.AddRepo("github.com/acme/new", forgetest.Repo{})
+	f.AddRepo("gitlab.com/group/sub/old", forgetest.Repo{Archived: true})
+	f.AddRepo("codeberg.org/acme/old", forgetest.Repo{Archived: true})
+	f.AddRepo("codeberg.org/acme/new", forgetest.Repo{})
+
+	tests := []struct {
+		repo     string
+		archived bool
+		err      string
+	}{
+		{"https://github.com/acme/old", true, ""},
+		{"https://github.com/acme/new", false, ""},
+		{"https://gitlab.com/group/sub/old", true, ""},
+		{"https://codeberg.org/acme/old", true, ""},
+		{"https://codeberg.org/acme/new", false, ""},
+		{"https://github.com/acme/gone", false, "404"},
+	}
+	for _, tt := range tests {
+		archived, err := queryArchived(forgeAPI(tt.repo))
+		switch {
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: error %v, want one with %q", tt.repo, err, tt.err)
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.repo, err)
+		case archived != tt.archived:
+			t.Errorf("%s: archived %v, want %v", tt.repo, archived, tt.archived)
+		}
+	}
+
+	f.RateLimit = f.Requests()
+	if _, err := queryArchived(forgeAPI("https://github.com/acme/old")); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("rate limited: error %v, want 403", err)
+	}
+}
+
+func TestQueryArchivedToken(t *testing.T) {
+	f := serveForge(t)
+	f.Token = "secret"
+	f.AddRepo("github.com/acme/private", forgetest.Repo{Archived: true})
+	api := forgeAPI("https://github.com/acme/private")
+	if _, err := queryArchived(api); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("without the token: error %v, want 401", err)
+	}
+	t.Setenv("GIR_FORGE_TOKEN", "secret")
+	if archived, err := queryArchived(api); err != nil || !archived {
+		t.Errorf("with the token: got %v, %v; want archived", archived, err)
+	}
+}
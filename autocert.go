@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var (
+	autocertFlag  = flag.Bool("autocert", false, "serve https on :443 with certificates obtained from Let's Encrypt")
+	autocertCache = flag.String("autocert-cache", "autocert-cache", "keep obtained certificates and the ACME account key in `dir`")
+	autocertEmail = flag.String("autocert-email", "", "register the ACME account with contact `address`")
+	autocertHTTP  = flag.String("autocert-http", ":http", "answer HTTP-01 challenges on `address` (empty to rely on TLS-ALPN-01 alone)")
+	autocertDir   = flag.String("autocert-directory", autocert.DefaultACMEDirectory, "obtain certificates from the ACME directory at `url`")
+)
+
+// certManager is the -autocert certificate manager. It is replaced by a
+// new one, sharing the cache, to force a certificate renewal.
+var certManager atomic.Pointer[autocert.Manager]
+
+// setupAutocert creates the certificate manager for the import hosts and
+// starts the HTTP-01 challenge server. It returns the TLS configuration
+// to serve with, which also answers TLS-ALPN-01 challenges.
+func setupAutocert() *tls.Config {
+	m := newCertManager(checkedCache{autocert.DirCache(*autocertCache)})
+	certManager.Store(m)
+	go checkCachedChains(m.Cache)
+	if *autocertHTTP != "" {
+		go func() {
+			// Anything but a challenge is redirected to https.
+			log.Fatal(http.ListenAndServe(*autocertHTTP, m.HTTPHandler(nil)))
+		}()
+	}
+	cfg := m.TLSConfig()
+	cfg.GetCertificate = servedCertificate
+	return cfg
+}
+
+// newCertManager returns a certificate manager for the import hosts,
+// keeping the certificates in cache.
+func newCertManager(cache autocert.Cache) *autocert.Manager {
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      cache,
+		HostPolicy: importHostPolicy,
+		Email:      *autocertEmail,
+		Client:     &acme.Client{DirectoryURL: *autocertDir},
+	}
+	if *autocertHTTP != "" {
+		// Enable HTTP-01 challenges. The challenge server of the first
+		// manager finds the tokens of later ones in the shared cache.
+		m.HTTPHandler(nil)
+	}
+	return m
+}
+
+// A checkedCache is an autocert cache verifying the chain of each
+// certificate of an import host put in it, as -tls certificates are
+// verified when loaded.
+type checkedCache struct {
+	autocert.Cache
+}
+
+func (c checkedCache) Put(ctx context.Context, key string, data []byte) error {
+	if err := c.Cache.Put(ctx, key, data); err != nil {
+		return err
+	}
+	gen := holdMapping()
+	defer releaseMapping(gen)
+	host := strings.TrimSuffix(key, "+rsa")
+	if slices.Contains(importHosts(), host) {
+		noteChain(host, "the autocert cache", verifyChain(&tls.Certificate{Certificate: pemChain(data)}, host))
+	}
+	return nil
+}
+
+// checkCachedChains verifies the chains of the certificates of the import
+// hosts already in the autocert cache at startup.
+func checkCachedChains(cache autocert.Cache) {
+	gen := holdMapping()
+	defer releaseMapping(gen)
+	for _, host := range importHosts() {
+		host = keepString(host)
+		if chain, err := cachedChain(context.Background(), cache, host); err == nil {
+			noteChain(host, "the autocert cache", verifyChain(&tls.Certificate{Certificate: chain}, host))
+		}
+	}
+}
+
+// importHostPolicy lets certificates be obtained for the current import
+// hosts only, so that hosts added by a reload are covered.
+func importHostPolicy(ctx context.Context, host string) error {
+	gen := holdMapping()
+	defer releaseMapping(gen)
+	for _, h := range importHosts() {
+		if h == host {
+			return nil
+		}
+	}
+	return fmt.Errorf("host %q is not an import host", host)
+}
+
+// tlsEnabled reports whether https is served, with -tls or -autocert.
+func tlsEnabled() bool {
+	return *tlsFlag || *autocertFlag
+}
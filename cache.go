@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"flag"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kastelo/go-import-redirector/redirector"
+)
+
+var maxAge = flag.Duration("max-age", 5*time.Minute, "Cache-Control max-age of served pages (0 for no-cache)")
+
+// maxCachedPageBytes bounds the size of the pages in the pageCache.
+const maxCachedPageBytes = 32 << 20
+
+// A renderedPage is a page rendered for a resolution, with its ETag.
+type renderedPage struct {
+	key      string
+	rule     *redirector.Rule
+	repoRoot string
+	owner    string
+	versions []string // listed on browser pages
+	body     []byte
+	etag     string
+}
+
+// pageCache memoizes the pages by import path, Accept-Language, archived
+// state and whether they are for go get, evicting the least recently used
+// beyond maxCachedPageBytes. An entry is used only while the resolution has
+// the same rule, repo and owner, and for browser pages the same module
+// versions, so that reloads, cutovers and CODEOWNERS changes take effect
+// at once.
+var pageCache = struct {
+	sync.Mutex
+	m     map[string]*list.Element
+	lru   list.List // of *renderedPage, most recently used first
+	bytes int       // of the bodies
+}{m: make(map[string]*list.Element)}
+
+// cachedPage returns the memoized page for key rendered for r with owner
+// and versions, or nil.
+func cachedPage(key string, r *redirector.Resolution, owner string, versions []string) *renderedPage {
+	pageCache.Lock()
+	defer pageCache.Unlock()
+	e := pageCache.m[key]
+	if e == nil {
+		return nil
+	}
+	p := e.Value.(*renderedPage)
+	if p.rule != r.Rule || p.repoRoot != r.RepoRoot || p.owner != owner || !slices.Equal(p.versions, versions) {
+		return nil
+	}
+	pageCache.lru.MoveToFront(e)
+	return p
+}
+
+// storePage memoizes the page body for key rendered for r with owner and
+// versions, and returns it.
+func storePage(key string, r *redirector.Resolution, owner string, versions []string, body []byte) *renderedPage {
+	p := &renderedPage{key: key, rule: r.Rule, repoRoot: r.RepoRoot, owner: owner, versions: versions, body: body, etag: redirector.ETag(body)}
+	pageCache.Lock()
+	defer pageCache.Unlock()
+	if e := pageCache.m[key]; e != nil {
+		pageCache.bytes -= len(e.Value.(*renderedPage).body)
+		e.Value = p
+		pageCache.lru.MoveToFront(e)
+	} else {
+		pageCache.m[key] = pageCache.lru.PushFront(p)
+	}
+	pageCache.bytes += len(body)
+	for pageCache.bytes > maxCachedPageBytes {
+		old := pageCache.lru.Remove(pageCache.lru.Back()).(*renderedPage)
+		delete(pageCache.m, old.key)
+		pageCache.bytes -= len(old.body)
+	}
+	return p
+}
+
+// clearPageCache empties the page cache, whose pages are of replaced rules
+// after a reload, and returns the number of pages removed.
+func clearPageCache() int {
+	pageCache.Lock()
+	defer pageCache.Unlock()
+	n := len(pageCache.m)
+	clear(pageCache.m)
+	pageCache.lru.Init()
+	pageCache.bytes = 0
+	return n
+}
+
+// bufPool holds the buffers pages are rendered into.
+var bufPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// cacheControl is the Cache-Control of served pages, from -max-age.
+var cacheControl = sync.OnceValue(func() []string {
+	if *maxAge > 0 {
+		return []string{"public, max-age=" + strconv.Itoa(int(maxAge.Seconds()))}
+	}
+	return []string{"no-cache"}
+})
+
+// writeBody writes body as a cacheable response with redirector.WriteBody,
+// setting the -max-age Cache-Control unless already set. With -sign-key,
+// the response is signed for the status sent, 200 or 304 Not Modified. The
+// Content-Type header must already be set.
+func writeBody(w http.ResponseWriter, req *http.Request, body []byte, etag string) {
+	if etag == "" {
+		etag = redirector.ETag(body)
+	}
+	h := w.Header()
+	h["Etag"] = []string{etag}
+	if _, ok := h["Cache-Control"]; !ok {
+		h["Cache-Control"] = cacheControl()
+	}
+	if redirector.NotModified(req, etag) {
+		signResponse(w, req, http.StatusNotModified, nil)
+	} else {
+		signResponse(w, req, http.StatusOK, body)
+	}
+	redirector.WriteBody(w, req, body, etag)
+}
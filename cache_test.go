@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/kastelo/go-import-redirector/redirector"
+)
+
+func TestPageKey(t *testing.T) {
+	keys := make(map[string]string)
+	for _, path := range []string{"example.com/foo", "example.com/foo/bar"} {
+		for _, lang := range []string{"", "de"} {
+			for _, archived := range []bool{false, true} {
+				for _, goGet := range []bool{false, true} {
+					desc := fmt.Sprintf("%s %q archived=%v go-get=%v", path, lang, archived, goGet)
+					k := pageKey(path, lang, archived, goGet)
+					if prev, ok := keys[k]; ok {
+						t.Errorf("%s has the key of %s", desc, prev)
+					}
+					keys[k] = desc
+				}
+			}
+		}
+	}
+}
+
+func TestCachedPage(t *testing.T) {
+	clearPageCache()
+	defer clearPageCache()
+	rule := &redirector.Rule{}
+	r := &redirector.Resolution{Rule: rule, RepoRoot: "https://github.com/example/foo"}
+	key := pageKey("example.com/foo", "", false, false)
+	p := storePage(key, r, "@alice", []string{"v1.0.0"}, []byte("page"))
+	if got := cachedPage(key, r, "@alice", []string{"v1.0.0"}); got != p {
+		t.Errorf("cachedPage = %v, want the stored page", got)
+	}
+	for _, tt := range []struct {
+		desc     string
+		r        *redirector.Resolution
+		owner    string
+		versions []string
+	}{
+		{"another rule", &redirector.Resolution{Rule: &redirector.Rule{}, RepoRoot: r.RepoRoot}, "@alice", []string{"v1.0.0"}},
+		{"another repo", &redirector.Resolution{Rule: rule, RepoRoot: "https://github.com/example/bar"}, "@alice", []string{"v1.0.0"}},
+		{"another owner", r, "@bob", []string{"v1.0.0"}},
+		{"no owner", r, "", []string{"v1.0.0"}},
+		{"other versions", r, "@alice", []string{"v1.0.0", "v1.1.0"}},
+	} {
+		if got := cachedPage(key, tt.r, tt.owner, tt.versions); got != nil {
+			t.Errorf("%s: cachedPage = %v, want nil", tt.desc, got)
+		}
+	}
+	if got := cachedPage(pageKey("example.com/foo", "", false, true), r, "@alice", []string{"v1.0.0"}); got != nil {
+		t.Errorf("go get key: cachedPage = %v, want nil", got)
+	}
+	if n := clearPageCache(); n != 1 {
+		t.Errorf("clearPageCache removed %d pages, want 1", n)
+	}
+	if got := cachedPage(key, r, "@alice", []string{"v1.0.0"}); got != nil {
+		t.Errorf("after clearPageCache: cachedPage = %v, want nil", got)
+	}
+}
+
+// TestPageCacheReload checks that pages cached for the same key are not
+// served once a reload repoints the rule.
+func TestPageCacheReload(t *testing.T) {
+	name := serveConfig(t, testConfig)
+	const url = "http://example.com/foo/bar?go-get=1"
+	first := get(url)
+	if !strings.Contains(first.Body.String(), "https://github.com/example/foo") {
+		t.Fatalf("page lacks the repo:\n%s", first.Body)
+	}
+	if again := get(url); again.Header().Get("Etag") != first.Header().Get("Etag") {
+		t.Errorf("ETag changed without a reload: %s, then %s", first.Header().Get("Etag"), again.Header().Get("Etag"))
+	}
+
+	moved := strings.Replace(testConfig, "github.com/example/foo", "github.com/example/moved", 1)
+	if err := os.WriteFile(name, []byte(moved), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadRules(); err != nil {
+		t.Fatal(err)
+	}
+	w := get(url)
+	if body := w.Body.String(); strings.Contains(body, "github.com/example/foo") || !strings.Contains(body, "https://github.com/example/moved") {
+		t.Errorf("page after reload not for the new repo:\n%s", body)
+	}
+	if w.Header().Get("Etag") == first.Header().Get("Etag") {
+		t.Errorf("ETag %s unchanged after the reload", w.Header().Get("Etag"))
+	}
+}
+
+func BenchmarkPageCache(b *testing.B) {
+	serveConfig(b, testConfig)
+	b.Run("hit", func(b *testing.B) {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/foo/bar?go-get=1", nil)
+		redirect(httptest.NewRecorder(), req)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			redirect(httptest.NewRecorder(), req)
+		}
+	})
+	b.Run("miss", func(b *testing.B) {
+		reqs := make([]*http.Request, 1000)
+		for i := range reqs {
+			reqs[i] = httptest.NewRequest(http.MethodGet, fmt.Sprintf("http://example.com/foo/p%d?go-get=1", i), nil)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if i%len(reqs) == 0 {
+				b.StopTimer()
+				clearPageCache()
+				b.StartTimer()
+			}
+			redirect(httptest.NewRecorder(), reqs[i%len(reqs)])
+		}
+	})
+}
+
+// TestConditionalRequests checks the ETag, HEAD and method handling of the
+// redirect handler.
+func TestConditionalRequests(t *testing.T) {
+	serveConfig(t, testConfig)
+	do := func(method string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://example.com/foo?go-get=1", nil)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		redirect(w, req)
+		return w
+	}
+
+	w := do("GET")
+	etag := w.Header().Get("ETag")
+	if w.Code != 200 || etag == "" || w.Body.Len() == 0 {
+		t.Fatalf("GET: %d, ETag %q, %d bytes", w.Code, etag, w.Body.Len())
+	}
+	if cc := w.Header().Get("Cache-Control"); !strings.HasPrefix(cc, "public") {
+		t.Errorf("Cache-Control %q", cc)
+	}
+
+	for _, inm := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		w := do("GET", "If-None-Match", inm)
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Errorf("If-None-Match %s: %d with %d bytes, want 304 without a body", inm, w.Code, w.Body.Len())
+		}
+		if got := w.Header().Get("ETag"); got != etag {
+			t.Errorf("If-None-Match %s: ETag %q, want %q", inm, got, etag)
+		}
+	}
+	if w := do("GET", "If-None-Match", `"other"`); w.Code != 200 || w.Body.Len() == 0 {
+		t.Errorf("If-None-Match of another ETag: %d with %d bytes", w.Code, w.Body.Len())
+	}
+
+	w = do("HEAD")
+	if w.Code != 200 || w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+		t.Errorf("HEAD: %d, ETag %q, %d bytes; want 200, %q, no body", w.Code, w.Header().Get("ETag"), w.Body.Len(), etag)
+	}
+
+	for _, method := range []string{"POST", "PUT", "DELETE", "OPTIONS"} {
+		w := do(method)
+		if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, HEAD" {
+			t.Errorf("%s: %d, Allow %q; want 405, GET, HEAD", method, w.Code, w.Header().Get("Allow"))
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/youmark/pkcs8"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+var (
+	tlsCertFile       = flag.String("tls-cert", "", "load the certificate from `file` (PEM, DER, combined PEM or PKCS #12; default <host>.crt)")
+	tlsKeyFile        = flag.String("tls-key", "", "load the private key from `file` (default <host>.key, or the -tls-cert file if it holds the key)")
+	tlsPassphraseEnv  = flag.String("tls-passphrase-env", "GIR_TLS_PASSPHRASE", "read the key or PKCS #12 passphrase from environment `variable`")
+	tlsPassphraseFile = flag.String("tls-passphrase-file", "", "read the key or PKCS #12 passphrase from `file`")
+)
+
+// tlsPassphrase returns the configured passphrase for encrypted keys.
+func tlsPassphrase() (string, error) {
+	if *tlsPassphraseFile != "" {
+		buf, err := os.ReadFile(*tlsPassphraseFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(buf), "\r\n"), nil
+	}
+	return os.Getenv(*tlsPassphraseEnv), nil
+}
+
+// loadKeyPair loads a certificate chain and private key. The certificate
+// file may be a PKCS #12 bundle (.p12 or .pfx), or PEM, optionally also
+// holding the key. PEM keys may be PKCS #1, SEC 1, PKCS #8 or encrypted
+// PKCS #8. Files without PEM blocks are read as DER certificates or keys.
+func loadKeyPair(certFile, keyFile string) (tls.Certificate, error) {
+	pass, err := tlsPassphrase()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	switch strings.ToLower(filepath.Ext(certFile)) {
+	case ".p12", ".pfx":
+		data, err := os.ReadFile(certFile)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		key, leaf, cas, err := pkcs12.DecodeChain(data, pass)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("%s: %v", certFile, err)
+		}
+		cert := tls.Certificate{Certificate: [][]byte{leaf.Raw}, PrivateKey: key, Leaf: leaf}
+		for _, c := range cas {
+			cert.Certificate = append(cert.Certificate, c.Raw)
+		}
+		return cert, nil
+	}
+
+	files := []string{certFile}
+	if keyFile != "" && keyFile != certFile {
+		files = append(files, keyFile)
+	}
+	var certPEM, keyPEM []byte
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		if b, _ := pem.Decode(data); b == nil {
+			data = derToPEM(data)
+		}
+		for {
+			var b *pem.Block
+			b, data = pem.Decode(data)
+			if b == nil {
+				break
+			}
+			switch {
+			case b.Type == "CERTIFICATE":
+				certPEM = append(certPEM, pem.EncodeToMemory(b)...)
+			case b.Type == "ENCRYPTED PRIVATE KEY":
+				key, err := pkcs8.ParsePKCS8PrivateKey(b.Bytes, []byte(pass))
+				if err != nil {
+					return tls.Certificate{}, fmt.Errorf("%s: decrypting key: %v", file, err)
+				}
+				der, err := x509.MarshalPKCS8PrivateKey(key)
+				if err != nil {
+					return tls.Certificate{}, err
+				}
+				keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+			case strings.HasSuffix(b.Type, "PRIVATE KEY"):
+				keyPEM = pem.EncodeToMemory(b)
+			}
+		}
+	}
+	if keyPEM == nil {
+		return tls.Certificate{}, fmt.Errorf("no private key found in %s", strings.Join(files, " or "))
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// derToPEM returns the PEM encoding of the DER certificates or the PKCS #8,
+// PKCS #1 or SEC 1 private key der, or nil if it is neither.
+func derToPEM(der []byte) []byte {
+	if certs, err := x509.ParseCertificates(der); err == nil && len(certs) > 0 {
+		var b []byte
+		for _, c := range certs {
+			b = append(b, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+		}
+		return b
+	}
+	typ := ""
+	if _, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		typ = "PRIVATE KEY"
+	} else if _, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		typ = "RSA PRIVATE KEY"
+	} else if _, err := x509.ParseECPrivateKey(der); err == nil {
+		typ = "EC PRIVATE KEY"
+	} else {
+		return nil
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+}
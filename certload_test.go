@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/youmark/pkcs8"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func TestLoadKeyPair(t *testing.T) {
+	ca, caKey := newCert(t, nil, nil)
+	leaf, key := newCert(t, ca, caKey, "go.example.com")
+
+	sec1, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8DER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := pkcs8.MarshalPrivateKey(key, []byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p12, err := pkcs12.Modern.Encode(key, leaf, []*x509.Certificate{ca}, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainPEM := pemCerts(leaf, ca)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1})
+	encryptedPEM := pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: encrypted})
+
+	tests := []struct {
+		name      string
+		cert, key []byte // files, no key file if nil
+		certName  string
+		pass      string // instead of secret
+		chain     int    // certificates loaded
+		err       string
+	}{
+		{name: "PEM", cert: chainPEM, key: keyPEM, chain: 2},
+		{name: "PKCS #8 PEM", cert: chainPEM, key: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8DER}), chain: 2},
+		{name: "combined PEM", cert: append(append([]byte(nil), chainPEM...), keyPEM...), chain: 2},
+		{name: "encrypted PKCS #8", cert: chainPEM, key: encryptedPEM, chain: 2},
+		{name: "DER", cert: leaf.Raw, key: pkcs8DER, chain: 1},
+		{name: "DER SEC 1 key", cert: leaf.Raw, key: sec1, chain: 1},
+		{name: "PKCS #12", cert: p12, certName: "go.example.com.p12", chain: 2},
+		{name: "no key", cert: chainPEM, err: "no private key found"},
+		{name: "wrong key", cert: pemCerts(ca), key: keyPEM, err: "private key does not match"},
+		{name: "encrypted PKCS #8, wrong passphrase", cert: chainPEM, key: encryptedPEM, pass: "wrong", err: "decrypting key"},
+		{name: "PKCS #12, wrong passphrase", cert: p12, certName: "go.example.com.pfx", pass: "wrong", err: "password"},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		certFile := filepath.Join(dir, "go.example.com.crt")
+		if tt.certName != "" {
+			certFile = filepath.Join(dir, tt.certName)
+		}
+		if err := os.WriteFile(certFile, tt.cert, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		keyFile := ""
+		if tt.key != nil {
+			keyFile = filepath.Join(dir, "go.example.com.key")
+			if err := os.WriteFile(keyFile, tt.key, 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if tt.pass == "" {
+			tt.pass = "secret"
+		}
+		t.Setenv(*tlsPassphraseEnv, tt.pass)
+
+		c, err := loadKeyPair(certFile, keyFile)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %s", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(c.Certificate) != tt.chain || string(c.Certificate[0]) != string(leaf.Raw) {
+			t.Errorf("%s: loaded %d certificates, want %d starting with the leaf", tt.name, len(c.Certificate), tt.chain)
+		}
+		if k, ok := c.PrivateKey.(*ecdsa.PrivateKey); !ok || !k.Equal(key) {
+			t.Errorf("%s: loaded another private key", tt.name)
+		}
+	}
+}
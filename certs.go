@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certRenewBefore is how long before expiry a certificate is due for
+// renewal, as autocert renews them by default.
+const certRenewBefore = 30 * 24 * time.Hour
+
+// A certEntry describes a certificate the instance serves.
+type certEntry struct {
+	Source     string    `json:"source"`         // file or autocert
+	File       string    `json:"file,omitempty"` // certificate file, for source file
+	Hosts      []string  `json:"hosts"`          // import hosts served the certificate
+	DNSNames   []string  `json:"dns_names,omitempty"`
+	Issuer     string    `json:"issuer,omitempty"`
+	NotAfter   time.Time `json:"not_after,omitempty"`
+	DaysLeft   int       `json:"days_left"`
+	Renewal    string    `json:"renewal"` // automatic, or manual for files
+	Status     string    `json:"status"`  // ok, renewal_due, expired or missing
+	ChainError string    `json:"chain_error,omitempty"`
+}
+
+// certInventory lists the -tls certificates, or the -autocert ones of the
+// import hosts as found in the cache.
+func certInventory(ctx context.Context) []*certEntry {
+	list := []*certEntry{}
+	if m := certManager.Load(); m != nil {
+		for _, host := range importHosts() {
+			e := &certEntry{Source: "autocert", Hosts: []string{host}, Renewal: "automatic", Status: "missing"}
+			if leaf, err := cachedCert(ctx, m, host); err == nil {
+				e.describe(leaf)
+			}
+			certMu.RLock()
+			if err := chainErrs[host]; err != nil {
+				e.ChainError = err.Error()
+			}
+			certMu.RUnlock()
+			list = append(list, e)
+		}
+		return list
+	}
+	certMu.RLock()
+	defer certMu.RUnlock()
+	for _, sc := range tlsCerts {
+		e := &certEntry{Source: "file", File: sc.file, Hosts: []string{}, Renewal: "manual"}
+		for _, host := range importHosts() {
+			if hostCerts[host] == sc {
+				e.Hosts = append(e.Hosts, host)
+				if err := chainErrs[host]; err != nil && e.ChainError == "" {
+					e.ChainError = err.Error()
+				}
+			}
+		}
+		if leaf, err := x509.ParseCertificate(sc.cert.Load().Certificate[0]); err == nil {
+			e.describe(leaf)
+		}
+		list = append(list, e)
+	}
+	return list
+}
+
+// describe fills in e from the leaf certificate.
+func (e *certEntry) describe(leaf *x509.Certificate) {
+	e.DNSNames = leaf.DNSNames
+	e.Issuer = leaf.Issuer.CommonName
+	e.NotAfter = leaf.NotAfter
+	left := time.Until(leaf.NotAfter)
+	e.DaysLeft = int(left.Hours() / 24)
+	switch {
+	case left <= 0:
+		e.Status = "expired"
+	case left < certRenewBefore:
+		e.Status = "renewal_due"
+	default:
+		e.Status = "ok"
+	}
+}
+
+// cachedCert returns the leaf of the certificate for host in the autocert
+// cache: the ECDSA one, or else the RSA one.
+func cachedCert(ctx context.Context, m *autocert.Manager, host string) (*x509.Certificate, error) {
+	chain, err := cachedChain(ctx, m.Cache, host)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(chain[0])
+}
+
+// cachedChain returns the certificate chain for host in the autocert
+// cache, as cachedCert chooses it.
+func cachedChain(ctx context.Context, cache autocert.Cache, host string) ([][]byte, error) {
+	data, err := cache.Get(ctx, host)
+	if err == autocert.ErrCacheMiss {
+		data, err = cache.Get(ctx, host+"+rsa")
+	}
+	if err != nil {
+		return nil, err
+	}
+	chain := pemChain(data)
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no certificate for %s in the cache", host)
+	}
+	return chain, nil
+}
+
+// pemChain returns the DER certificates in the PEM data of an autocert
+// cache entry, which has the private key first.
+func pemChain(data []byte) [][]byte {
+	var chain [][]byte
+	for {
+		var b *pem.Block
+		if b, data = pem.Decode(data); b == nil {
+			return chain
+		}
+		if b.Type == "CERTIFICATE" {
+			chain = append(chain, b.Bytes)
+		}
+	}
+}
+
+// renewCert forces the renewal of the certificate for host. An -autocert
+// certificate is obtained anew and then replaces the old one, which stays
+// served should that fail; -tls certificate files are loaded again, for
+// certificates renewed by other means.
+func renewCert(ctx context.Context, host string) error {
+	if err := importHostPolicy(ctx, host); err != nil {
+		return err
+	}
+	m := certManager.Load()
+	if m == nil {
+		if len(tlsCerts) == 0 {
+			return errors.New("no certificates served: neither -tls nor -autocert is set")
+		}
+		return reloadCertFiles()
+	}
+	// The manager keeps the certificates it has in memory, so a new one
+	// obtains the certificate, not seeing the cached ones of host.
+	renewed := newCertManager(&renewalCache{Cache: m.Cache, hidden: map[string]bool{host: true, host + "+rsa": true}})
+	hello := &tls.ClientHelloInfo{
+		ServerName:       host,
+		CipherSuites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		SupportedCurves:  []tls.CurveID{tls.CurveP256},
+	}
+	if _, err := renewed.GetCertificate(hello); err != nil {
+		return err
+	}
+	certManager.Store(renewed)
+	return nil
+}
+
+// A renewalCache is an autocert cache hiding the certificates of a host
+// until new ones are put in it, for a manager to obtain new certificates
+// while the old ones stay in the cache.
+type renewalCache struct {
+	autocert.Cache
+	mu     sync.Mutex
+	hidden map[string]bool
+}
+
+func (c *renewalCache) Get(ctx context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	hidden := c.hidden[key]
+	c.mu.Unlock()
+	if hidden {
+		return nil, autocert.ErrCacheMiss
+	}
+	return c.Cache.Get(ctx, key)
+}
+
+func (c *renewalCache) Put(ctx context.Context, key string, data []byte) error {
+	if err := c.Cache.Put(ctx, key, data); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	delete(c.hidden, key)
+	c.mu.Unlock()
+	return nil
+}
+
+// reloadCertFiles loads the -tls certificate files again and assigns the
+// import hosts their certificates anew.
+func reloadCertFiles() error {
+	for _, sc := range tlsCerts {
+		cert, err := loadKeyPair(sc.file, sc.key)
+		if err != nil {
+			return err
+		}
+		sc.cert.Store(&cert)
+		sc.stapled.Store(nil)
+		if *ocspStaple {
+			sc.staple()
+		}
+	}
+	for _, h := range importHosts() {
+		assignCert(h) // a chain that does not verify is logged and listed
+	}
+	return nil
+}
+
+// revokeCert obtains a new -autocert certificate for host, and then
+// revokes the old one with the ACME account that obtained it.
+func revokeCert(ctx context.Context, host string) error {
+	if err := importHostPolicy(ctx, host); err != nil {
+		return err
+	}
+	m := certManager.Load()
+	if m == nil {
+		return errors.New("only -autocert certificates can be revoked here; revoke -tls certificates with the CA that issued them")
+	}
+	leaf, err := cachedCert(ctx, m, host)
+	if err != nil {
+		return err
+	}
+	key, err := acmeAccountKey(ctx, m)
+	if err != nil {
+		return err
+	}
+	if err := renewCert(ctx, host); err != nil {
+		return err
+	}
+	c := &acme.Client{Key: key, DirectoryURL: *autocertDir}
+	return c.RevokeCert(ctx, nil, leaf.Raw, acme.CRLReasonUnspecified)
+}
+
+// acmeAccountKey returns the ACME account key autocert keeps in its cache.
+func acmeAccountKey(ctx context.Context, m *autocert.Manager) (crypto.Signer, error) {
+	data, err := m.Cache.Get(ctx, "acme_account+key")
+	if err != nil {
+		return nil, fmt.Errorf("ACME account key: %v", err)
+	}
+	b, _ := pem.Decode(data)
+	if b == nil {
+		return nil, errors.New("ACME account key: not PEM")
+	}
+	if k, err := x509.ParseECPrivateKey(b.Bytes); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(b.Bytes); err == nil {
+		return k, nil
+	}
+	k, err := x509.ParsePKCS8PrivateKey(b.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("ACME account key: %v", err)
+	}
+	signer, ok := k.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("ACME account key: unsupported key type")
+	}
+	return signer, nil
+}
+
+// serveCerts lists the certificates as JSON on GET, and on POST to
+// /-/certs/renew or /-/certs/revoke with ?host=, renews or revokes the
+// certificate for the host and lists them again.
+func serveCerts(w http.ResponseWriter, req *http.Request) {
+	action := strings.TrimPrefix(req.URL.Path, "/-/certs")
+	switch {
+	case action == "" && req.Method == http.MethodGet:
+	case action == "/renew" && req.Method == http.MethodPost:
+		if err := renewCert(req.Context(), req.FormValue("host")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case action == "/revoke" && req.Method == http.MethodPost:
+		if err := revokeCert(req.Context(), req.FormValue("host")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case action == "":
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	case action == "/renew" || action == "/revoke":
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	default:
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(certInventory(req.Context()))
+}
+
+const certsUsage = `usage: go-import-redirector certs [-server url] [-token token] [command]
+commands:
+	list                 list the served certificates (the default)
+	renew <host> ...     obtain the certificates of the hosts anew (-tls: load the files again)
+	revoke <host> ...    obtain new -autocert certificates for the hosts and revoke the old ones
+`
+
+// runCerts runs the certs subcommand against a running instance and
+// returns the exit status.
+func runCerts(args []string) int {
+	fs := flag.NewFlagSet("certs", flag.ExitOnError)
+	server := fs.String("server", "http://localhost", "manage the instance at base `url`")
+	token := fs.String("token", "", "admin bearer `token` (default $GIR_ADMIN_TOKEN)")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, certsUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *token == "" {
+		*token = os.Getenv("GIR_ADMIN_TOKEN")
+	}
+	c := &client{base: strings.TrimSuffix(*server, "/"), token: *token}
+	cmd, hosts := fs.Arg(0), fs.Args()
+	if len(hosts) > 0 {
+		hosts = hosts[1:]
+	}
+	var paths []string
+	switch {
+	case (cmd == "" || cmd == "list") && len(hosts) == 0:
+		paths = []string{""}
+	case (cmd == "renew" || cmd == "revoke") && len(hosts) > 0:
+		for _, h := range hosts {
+			paths = append(paths, "/"+cmd+"?"+url.Values{"host": {h}}.Encode())
+		}
+	default:
+		fs.Usage()
+		return 2
+	}
+	var list []*certEntry
+	for _, p := range paths {
+		method := http.MethodGet
+		if p != "" {
+			method = http.MethodPost
+		}
+		resp, err := c.do(method, "/-/certs"+p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "go-import-redirector certs: %v\n", err)
+			return 1
+		}
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "go-import-redirector certs: %v\n", err)
+			return 1
+		}
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "SOURCE\tHOSTS\tNAMES\tEXPIRES\tDAYS\tRENEWAL\tSTATUS\n")
+	for _, e := range list {
+		source, expires := e.Source, "-"
+		if e.File != "" {
+			source += " " + e.File
+		}
+		if !e.NotAfter.IsZero() {
+			expires = e.NotAfter.Format(time.DateOnly)
+		}
+		status := e.Status
+		if e.ChainError != "" {
+			status += " (chain: " + e.ChainError + ")"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", source, strings.Join(e.Hosts, ","), strings.Join(e.DNSNames, ","), expires, e.DaysLeft, e.Renewal, status)
+	}
+	tw.Flush()
+	return 0
+}
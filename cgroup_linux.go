@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// cgroupRoot is where the cgroup file systems are mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// containerLimits returns the CPU quota, in CPUs, and the memory limit, in
+// bytes, of the cgroups of the process, with cgroup v2 or v1, or 0 where
+// there is none. The limit of a cgroup is the lowest set on it or its
+// parents.
+func containerLimits() (cpus float64, memory int64) {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return 0, 0
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		f := strings.SplitN(line, ":", 3)
+		if len(f) != 3 {
+			continue
+		}
+		controllers := strings.Split(f[1], ",")
+		switch {
+		case f[0] == "0" && f[1] == "":
+			walkCgroup(cgroupRoot, f[2], func(dir string) {
+				cpus = lowerLimit(cpus, cpuMax(dir))
+				memory = lowerLimit(memory, readLimit(filepath.Join(dir, "memory.max")))
+			})
+		case slices.Contains(controllers, "cpu"):
+			walkCgroup(filepath.Join(cgroupRoot, "cpu"), f[2], func(dir string) {
+				cpus = lowerLimit(cpus, cfsQuota(dir))
+			})
+		case slices.Contains(controllers, "memory"):
+			walkCgroup(filepath.Join(cgroupRoot, "memory"), f[2], func(dir string) {
+				memory = lowerLimit(memory, readLimit(filepath.Join(dir, "memory.limit_in_bytes")))
+			})
+		}
+	}
+	return cpus, memory
+}
+
+// walkCgroup calls f with the directory of the cgroup path under root and
+// those of its parents. Inside a cgroup namespace, the path is not found
+// under root but its parents are.
+func walkCgroup(root, path string, f func(dir string)) {
+	for p := filepath.Clean("/" + path); ; p = filepath.Dir(p) {
+		f(filepath.Join(root, p))
+		if p == "/" {
+			return
+		}
+	}
+}
+
+// cpuMax returns the CPU quota in the cgroup v2 directory dir, or 0.
+func cpuMax(dir string) float64 {
+	data, err := os.ReadFile(filepath.Join(dir, "cpu.max"))
+	if err != nil {
+		return 0
+	}
+	f := strings.Fields(string(data))
+	if len(f) != 2 {
+		return 0
+	}
+	quota, err1 := strconv.ParseFloat(f[0], 64) // "max" for no limit
+	period, err2 := strconv.ParseFloat(f[1], 64)
+	if err1 != nil || err2 != nil || quota <= 0 || period <= 0 {
+		return 0
+	}
+	return quota / period
+}
+
+// cfsQuota returns the CPU quota in the cgroup v1 directory dir, or 0.
+func cfsQuota(dir string) float64 {
+	quota := readLimit(filepath.Join(dir, "cpu.cfs_quota_us")) // -1 for no limit
+	period := readLimit(filepath.Join(dir, "cpu.cfs_period_us"))
+	if quota <= 0 || period <= 0 {
+		return 0
+	}
+	return float64(quota) / float64(period)
+}
+
+// readLimit returns the positive number in the file name, or 0 if there is
+// none or it is too large to be a real limit, as cgroup v1 reports no
+// limit.
+func readLimit(name string) int64 {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return 0
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil || n <= 0 || n >= 1<<60 {
+		return 0
+	}
+	return n
+}
+
+// lowerLimit returns the lower of the limits a and b, where 0 is none.
+func lowerLimit[T int64 | float64](a, b T) T {
+	if a == 0 || b > 0 && b < a {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// containerLimits returns no limits, as there are no cgroups.
+func containerLimits() (cpus float64, memory int64) {
+	return 0, 0
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Fault injection for game-day testing. These flags are left out of the
+// usage message and cannot be set from the environment.
+var (
+	chaosRoutes      = flag.String("chaos-routes", "", "inject faults only for import paths under these comma-separated `prefixes`")
+	chaosLatency     = flag.Duration("chaos-latency", 0, "delay matching requests by `duration`")
+	chaosErrorRate   = flag.Float64("chaos-error-rate", 0, "answer this `fraction` of matching requests with 503")
+	chaosTLSFailRate = flag.Float64("chaos-tls-failure-rate", 0, "fail this `fraction` of TLS handshakes for matching hosts")
+)
+
+func chaosEnabled() bool {
+	return *chaosLatency > 0 || *chaosErrorRate > 0 || *chaosTLSFailRate > 0
+}
+
+// chaosMatch reports whether faults should be injected for path,
+// or for any path on the host if hostOnly is set.
+func chaosMatch(path string, hostOnly bool) bool {
+	if *chaosRoutes == "" {
+		return true
+	}
+	for _, p := range strings.Split(*chaosRoutes, ",") {
+		p = strings.TrimSuffix(strings.TrimSpace(p), "/")
+		if hostOnly {
+			p, _, _ = strings.Cut(p, "/")
+		}
+		if path == p || strings.HasPrefix(path, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// warnChaos logs, once at startup, that faults are being injected.
+func warnChaos() {
+	if chaosEnabled() {
+		log.Printf("WARNING: fault injection enabled (latency %v, error rate %v, TLS failure rate %v)", *chaosLatency, *chaosErrorRate, *chaosTLSFailRate)
+	}
+}
+
+// chaos wraps h with the configured latency and error injection.
+func chaos(h http.HandlerFunc) http.HandlerFunc {
+	if !chaosEnabled() {
+		return h
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		if chaosMatch(req.Host+req.URL.Path, false) {
+			time.Sleep(*chaosLatency)
+			if rand.Float64() < *chaosErrorRate {
+				http.Error(w, "injected fault", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		h(w, req)
+	}
+}
+
+// chaosTLSFailure returns an error for the configured fraction of
+// handshakes to matching hosts.
+func chaosTLSFailure(serverName string) error {
+	if *chaosTLSFailRate > 0 && chaosMatch(serverName, true) && rand.Float64() < *chaosTLSFailRate {
+		return errors.New("injected certificate failure")
+	}
+	return nil
+}
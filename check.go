@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// runCheck validates the -config or -snapshot file and the options without
+// serving, and shows how each import path in args would be served. It
+// returns 1 if the configuration is invalid, has -strict problems with
+// -strict set, would repoint an -immutable-state import path, or an import
+// path is not served.
+func runCheck(args []string) int {
+	if configName() == "" {
+		fmt.Fprintf(os.Stderr, "usage: go-import-redirector -config file check [import path ...]\n")
+		return 2
+	}
+	fail := func(err error) int {
+		fmt.Fprintf(os.Stderr, "go-import-redirector check: %v\n", err)
+		return 1
+	}
+	if err := loadOffline(); err != nil {
+		return fail(err)
+	}
+	if err := checkImmutable(currentRules()); err != nil {
+		return fail(err)
+	}
+	status := 0
+	for _, err := range strictProblems(currentRules()) {
+		if *strict {
+			fmt.Printf("error: %v\n", err)
+			status = 1
+		} else {
+			fmt.Printf("warning: %v\n", err)
+		}
+	}
+	fmt.Printf("%s: %d rules for %s\n", configName(), len(currentRules()), strings.Join(importHosts(), ", "))
+
+	for _, arg := range args {
+		host, p, _ := strings.Cut(strings.TrimPrefix(arg, "https://"), "/")
+		sim := simulate(host, p, true)
+		fmt.Printf("\n%s: %d %s", sim.Path, sim.Status, http.StatusText(sim.Status))
+		if sim.Code != "" {
+			fmt.Printf(" (%s)", sim.Code)
+		}
+		fmt.Println()
+		for _, t := range sim.Tags {
+			fmt.Printf("\t%s\n", t)
+		}
+		if sim.Redirect != "" {
+			fmt.Printf("\tbrowsers: %s\n", sim.Redirect)
+		}
+		if sim.Error != "" {
+			fmt.Printf("\terror: %s\n", sim.Error)
+		}
+		if sim.Status != http.StatusOK && sim.Status != http.StatusFound || sim.Error != "" {
+			status = 1
+		}
+	}
+	return status
+}
+
+// loadOffline loads the rules and what else the redirect handler needs,
+// for the subcommands running it without serving.
+func loadOffline() error {
+	if err := loadRules(nil); err != nil {
+		return err
+	}
+	for _, load := range []func() error{checkDegrade, loadLocales, checkBlockPatterns, checkReferrerPolicy, parseTrustedProxies, loadSignKey, loadSite, loadShardState} {
+		if err := load(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const clientUsage = `usage: go-import-redirector client [-server url] [-token token] <command>
+commands:
+	routes [-tag tags]         list the served import paths, or those of rules with all tags
+	simulate [-host h] <path>  show how a request for path would be resolved
+	stats [-tag tags] <name>   show usage, egress, go-versions or referers statistics
+	reload                     reload the -config file
+	prune [target ...]         prune stats, caches, shard-pins or autocert state (default all)
+	events                     stream resolution events
+`
+
+// runClient runs the client subcommand with the given arguments and
+// returns the exit status.
+func runClient(args []string) int {
+	fs := flag.NewFlagSet("client", flag.ExitOnError)
+	server := fs.String("server", "http://localhost", "query the instance at base `url`")
+	token := fs.String("token", "", "admin bearer `token` (default $GIR_ADMIN_TOKEN)")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, clientUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if *token == "" {
+		*token = os.Getenv("GIR_ADMIN_TOKEN")
+	}
+	c := &client{base: strings.TrimSuffix(*server, "/"), token: *token}
+	var err error
+	switch cmd, args := fs.Arg(0), fs.Args()[1:]; cmd {
+	case "routes":
+		rfs := flag.NewFlagSet("routes", flag.ExitOnError)
+		tag := rfs.String("tag", "", "list only the rules with all of the comma separated `tags`")
+		rfs.Parse(args)
+		if rfs.NArg() != 0 {
+			fs.Usage()
+			return 2
+		}
+		err = c.routes(*tag)
+	case "simulate":
+		sfs := flag.NewFlagSet("simulate", flag.ExitOnError)
+		host := sfs.String("host", "", "simulate a request to `host` (default the first import host)")
+		sfs.Parse(args)
+		if sfs.NArg() != 1 {
+			fs.Usage()
+			return 2
+		}
+		err = c.copy("GET", "/-/simulate?"+url.Values{"host": {*host}, "path": {sfs.Arg(0)}}.Encode())
+	case "stats":
+		sfs := flag.NewFlagSet("stats", flag.ExitOnError)
+		tag := sfs.String("tag", "", "show only the modules of the rules with all of the comma separated `tags`")
+		sfs.Parse(args)
+		if sfs.NArg() != 1 {
+			fs.Usage()
+			return 2
+		}
+		p := "/-/stats/" + sfs.Arg(0)
+		if *tag != "" {
+			p += "?" + url.Values{"tag": {*tag}}.Encode()
+		}
+		err = c.copy("GET", p)
+	case "reload":
+		err = c.copy("POST", "/-/reload")
+	case "prune":
+		p := "/-/prune"
+		if len(args) > 0 {
+			p += "?" + url.Values{"targets": {strings.Join(args, ",")}}.Encode()
+		}
+		err = c.copy("POST", p)
+	case "events":
+		err = c.copy("GET", "/-/events")
+	default:
+		fs.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "go-import-redirector client: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// A client talks to the HTTP API of a running instance.
+type client struct {
+	base, token string
+}
+
+// do sends a request for path and returns the response if it succeeded.
+func (c *client) do(method, path string) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// copy sends a request for path and copies the response body to standard
+// output, line by line so that event streams show up as they arrive.
+func (c *client) copy(method, path string) error {
+	resp, err := c.do(method, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		fmt.Println(sc.Text())
+	}
+	return sc.Err()
+}
+
+// routes lists the served import paths from /.well-known/go-modules, of
+// the rules with all of tags if not empty.
+func (c *client) routes(tags string) error {
+	p := "/.well-known/go-modules"
+	if tags != "" {
+		p += "?" + url.Values{"tag": {tags}}.Encode()
+	}
+	resp, err := c.do("GET", p)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var wk wellKnown
+	if err := json.NewDecoder(resp.Body).Decode(&wk); err != nil {
+		return err
+	}
+	for _, ns := range wk.Namespaces {
+		if len(ns.Tags) > 0 {
+			fmt.Printf("%s\t%s %s\t%s\n", ns.ImportPath, ns.VCS, ns.Repo, strings.Join(ns.Tags, ","))
+		} else {
+			fmt.Printf("%s\t%s %s\n", ns.ImportPath, ns.VCS, ns.Repo)
+		}
+	}
+	return nil
+}
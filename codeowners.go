@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/kastelo/go-import-redirector/redirector"
+)
+
+var codeownersPaths = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+// fetchCodeowners returns the default owners in the CODEOWNERS file of the
+// repo name of the -discover owner, or "".
+func fetchCodeowners(owner, name string) string {
+	host, org, _ := strings.Cut(owner, "/")
+	for _, p := range codeownersPaths {
+		var u string
+		switch host {
+		case "github.com":
+			u = "https://raw.githubusercontent.com/" + org + "/" + name + "/HEAD/" + p
+		case "gitlab.com":
+			u = "https://gitlab.com/" + org + "/" + name + "/-/raw/HEAD/" + p
+		default:
+			return ""
+		}
+		resp, err := forgeGet(viaForgeURL(u))
+		if err != nil {
+			return ""
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			continue
+		}
+		owners := defaultOwners(io.LimitReader(resp.Body, 1<<20))
+		resp.Body.Close()
+		return owners
+	}
+	return ""
+}
+
+// defaultOwners returns the owners of the whole repo in a CODEOWNERS file:
+// those of the last * or / pattern, space separated.
+func defaultOwners(r io.Reader) string {
+	var owners string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		f := strings.Fields(line)
+		if len(f) < 2 || strings.HasPrefix(f[0], "[") || strings.HasPrefix(f[0], "^[") {
+			continue // no owners, or a GitLab section header
+		}
+		switch f[0] {
+		case "*", "/", "/*", "/**":
+			owners = strings.Join(f[1:], " ")
+		}
+	}
+	return owners
+}
+
+// codeowner returns the CODEOWNERS default owners of the repo of the
+// wildcard resolution r, if its owner is a -discover owner.
+func codeowner(r *redirector.Resolution) string {
+	if len(r.Captures) == 0 {
+		return ""
+	}
+	discovered.RLock()
+	defer discovered.RUnlock()
+	for owner, m := range discovered.owners {
+		if rest, ok := strings.CutPrefix(r.RepoRoot, "https://"+owner+"/"); ok {
+			name, _, _ := strings.Cut(rest, "/")
+			return m[strings.TrimSuffix(name, ".git")]
+		}
+	}
+	return ""
+}
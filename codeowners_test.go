@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kastelo/go-import-redirector/forgetest"
+)
+
+func TestDefaultOwners(t *testing.T) {
+	tests := []struct{ file, owners string }{
+		{"* @acme/core\n", "@acme/core"},
+		{"# owners\n*   @alice  @bob # the team\n*.go @gophers\n", "@alice @bob"},
+		{"* @first\n/docs/ @writers\n/ @last\n", "@last"},
+		{"[Backend]\n* @backend\n", "@backend"},
+		{"*.md @writers\n", ""},
+		{"*\n", ""},
+	}
+	for _, tt := range tests {
+		if got := defaultOwners(strings.NewReader(tt.file)); got != tt.owners {
+			t.Errorf("defaultOwners(%q) = %q, want %q", tt.file, got, tt.owners)
+		}
+	}
+}
+
+func TestFetchCodeowners(t *testing.T) {
+	f := serveForge(t)
+	f.AddRepo("github.com/acme/hub", forgetest.Repo{Files: map[string]string{".github/CODEOWNERS": "* @acme/hub\n"}})
+	f.AddRepo("github.com/acme/root", forgetest.Repo{DefaultBranch: "trunk", Files: map[string]string{"CODEOWNERS": "* @acme/root\n"}})
+	f.AddRepo("github.com/acme/none", forgetest.Repo{})
+	f.AddRepo("gitlab.com/group/lab", forgetest.Repo{Files: map[string]string{"docs/CODEOWNERS": "* @group/lab\n"}})
+	f.AddRepo("gitlab.com/group/sub/deep", forgetest.Repo{Files: map[string]string{"CODEOWNERS": "* @group/deep\n"}})
+
+	tests := []struct{ owner, name, owners string }{
+		{"github.com/acme", "hub", "@acme/hub"},
+		{"github.com/acme", "root", "@acme/root"},
+		{"github.com/acme", "none", ""},
+		{"github.com/acme", "gone", ""},
+		{"gitlab.com/group", "lab", "@group/lab"},
+		{"gitlab.com/group", "sub/deep", "@group/deep"},
+		{"codeberg.org/acme", "hub", ""},
+	}
+	for _, tt := range tests {
+		if got := fetchCodeowners(tt.owner, tt.name); got != tt.owners {
+			t.Errorf("fetchCodeowners(%q, %q) = %q, want %q", tt.owner, tt.name, got, tt.owners)
+		}
+	}
+}
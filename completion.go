@@ -0,0 +1,198 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// subcommands are the words accepted in place of <import> <repo>.
+var subcommands = []string{"client", "completion", "manpage", "snapshot", "check", "soak", "certs", "metrics"}
+
+// visibleFlags returns the documented command-line flags, in
+// lexicographical order. The chaos- fault injection flags are left out.
+func visibleFlags() []*flag.Flag {
+	var list []*flag.Flag
+	flag.VisitAll(func(f *flag.Flag) {
+		if !strings.HasPrefix(f.Name, "chaos-") {
+			list = append(list, f)
+		}
+	})
+	return list
+}
+
+// isBoolFlag reports whether f takes no argument.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// runCompletion writes the completion script for shell to standard output
+// and returns the exit status.
+func runCompletion(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: go-import-redirector completion bash|zsh|fish\n")
+		return 2
+	}
+	switch args[0] {
+	case "bash":
+		writeBashCompletion(os.Stdout)
+	case "zsh":
+		writeZshCompletion(os.Stdout)
+	case "fish":
+		writeFishCompletion(os.Stdout)
+	default:
+		fmt.Fprintf(os.Stderr, "go-import-redirector completion: unknown shell %q\n", args[0])
+		return 2
+	}
+	return 0
+}
+
+func writeBashCompletion(w io.Writer) {
+	var flags, files []string
+	for _, f := range visibleFlags() {
+		flags = append(flags, "-"+f.Name)
+		if name, _ := flag.UnquoteUsage(f); name == "file" || name == "dir" {
+			files = append(files, "-"+f.Name)
+		}
+	}
+	fmt.Fprintf(w, `# bash completion for go-import-redirector
+_go_import_redirector() {
+	local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+	case $prev in
+	%s)
+		COMPREPLY=($(compgen -f -- "$cur"))
+		return
+		;;
+	esac
+	if [[ $cur == -* ]]; then
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+	elif [[ $COMP_CWORD -eq 1 ]]; then
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+	fi
+}
+complete -F _go_import_redirector go-import-redirector
+`, strings.Join(files, "|"), strings.Join(flags, " "), strings.Join(subcommands, " "))
+}
+
+func writeZshCompletion(w io.Writer) {
+	fmt.Fprintf(w, "#compdef go-import-redirector\n\n_arguments \\\n")
+	for _, f := range visibleFlags() {
+		name, usage := flag.UnquoteUsage(f)
+		spec := fmt.Sprintf("-%s[%s]", f.Name, zshEscape(usage))
+		switch {
+		case isBoolFlag(f):
+		case name == "file" || name == "dir":
+			spec += ":" + name + ":_files"
+		default:
+			spec += ":" + name + ": "
+		}
+		fmt.Fprintf(w, "\t'%s' \\\n", strings.ReplaceAll(spec, "'", `'\''`))
+	}
+	fmt.Fprintf(w, "\t'1:command or import path:(%s)' \\\n\t'2:repo URL: '\n", strings.Join(subcommands, " "))
+}
+
+func zshEscape(s string) string {
+	return strings.NewReplacer("[", `\[`, "]", `\]`, ":", `\:`).Replace(s)
+}
+
+func writeFishCompletion(w io.Writer) {
+	fmt.Fprintf(w, "# fish completion for go-import-redirector\n")
+	fmt.Fprintf(w, "complete -c go-import-redirector -n __fish_use_subcommand -f -a '%s'\n", strings.Join(subcommands, " "))
+	for _, f := range visibleFlags() {
+		name, usage := flag.UnquoteUsage(f)
+		arg := " -r"
+		switch {
+		case isBoolFlag(f):
+			arg = ""
+		case name == "file" || name == "dir":
+			arg = " -r -F"
+		}
+		fmt.Fprintf(w, "complete -c go-import-redirector -o %s%s -d %s\n", f.Name, arg, fishQuote(usage))
+	}
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// runManpage writes the go-import-redirector(1) manual page in roff to
+// standard output and returns the exit status.
+func runManpage(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintf(os.Stderr, "usage: go-import-redirector manpage\n")
+		return 2
+	}
+	writeManpage(os.Stdout)
+	return 0
+}
+
+func writeManpage(w io.Writer) {
+	date := time.Now()
+	if sec, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		date = time.Unix(sec, 0) // for reproducible package builds
+	}
+	fmt.Fprintf(w, ".TH GO-IMPORT-REDIRECTOR 1 %q\n", date.UTC().Format("2006-01-02"))
+	fmt.Fprintf(w, ".SH NAME\ngo-import-redirector \\- serve go-import meta tags for a custom import domain\n")
+	fmt.Fprintf(w, ".SH SYNOPSIS\n")
+	fmt.Fprintf(w, ".B go-import-redirector\n[\\fIoptions\\fR] \\fIimport\\fR \\fIrepo\\fR\n.br\n")
+	fmt.Fprintf(w, ".B go-import-redirector\n[\\fIoptions\\fR] \\fB\\-config\\fR \\fIfile\\fR\n.br\n")
+	fmt.Fprintf(w, ".B go-import-redirector\n[\\fIoptions\\fR] \\fB\\-snapshot\\fR \\fIfile\\fR\n.br\n")
+	fmt.Fprintf(w, ".B go-import-redirector\n\\fB\\-config\\fR \\fIfile\\fR \\fBsnapshot\\fR \\fIoutput\\fR\n.br\n")
+	fmt.Fprintf(w, ".B go-import-redirector\n\\fB\\-config\\fR \\fIfile\\fR \\fBcheck\\fR [\\fIimport path\\fR ...]\n.br\n")
+	fmt.Fprintf(w, ".B go-import-redirector\n\\fB\\-config\\fR \\fIfile\\fR \\fBsoak\\fR [\\fIoptions\\fR]\n.br\n")
+	fmt.Fprintf(w, ".B go-import-redirector client\n[\\fB\\-server\\fR \\fIurl\\fR] [\\fB\\-token\\fR \\fItoken\\fR] \\fIcommand\\fR\n.br\n")
+	fmt.Fprintf(w, ".B go-import-redirector certs\n[\\fB\\-server\\fR \\fIurl\\fR] [\\fB\\-token\\fR \\fItoken\\fR] [\\fIcommand\\fR [\\fIhost\\fR ...]]\n.br\n")
+	fmt.Fprintf(w, ".B go-import-redirector completion\n\\fBbash\\fR|\\fBzsh\\fR|\\fBfish\\fR\n.br\n")
+	fmt.Fprintf(w, ".B go-import-redirector manpage\n")
+	fmt.Fprintf(w, `.SH DESCRIPTION
+Go-import-redirector is an HTTP server for a custom Go import domain.
+It responds to requests in a given import path root with a meta tag
+specifying the source repository for the
+.B go get
+command and an HTML redirect to the source repo for that package.
+If both
+.I import
+and
+.I repo
+end in /*, the corresponding path element is taken from the import path
+and substituted in repo on each request.
+.PP
+The full documentation is in the package documentation:
+.B go doc github.com/kastelo/go-import-redirector
+`)
+	fmt.Fprintf(w, ".SH OPTIONS\n")
+	for _, f := range visibleFlags() {
+		name, usage := flag.UnquoteUsage(f)
+		fmt.Fprintf(w, ".TP\n\\fB\\-%s\\fR", roffEscape(f.Name))
+		if name != "" {
+			fmt.Fprintf(w, " \\fI%s\\fR", roffEscape(name))
+		}
+		fmt.Fprintf(w, "\n%s", roffEscape(usage))
+		if f.DefValue != "" && f.DefValue != "false" && f.DefValue != "0" {
+			fmt.Fprintf(w, " (default %s)", roffEscape(f.DefValue))
+		}
+		fmt.Fprintf(w, "\n")
+	}
+	fmt.Fprintf(w, ".SH SIGNALS\n.TP\n.B SIGHUP\nReopen the access log and reload the \\fB\\-config\\fR file.\n")
+	fmt.Fprintf(w, ".TP\n.B SIGTERM\\fR, \\fBSIGINT\nFinish in-flight requests and exit.\n")
+	fmt.Fprintf(w, ".SH ENVIRONMENT\n.TP\n.B GIR_ADMIN_TOKEN\nThe admin bearer token, if \\fB\\-admin\\-token\\fR is not given.\n")
+	fmt.Fprintf(w, ".TP\n.B GIR_FORGE_TOKEN\nThe bearer token for the forge APIs of \\fB\\-discover\\fR.\n")
+	fmt.Fprintf(w, ".TP\n.B GIR_TLS_PASSPHRASE\nThe passphrase of an encrypted key, if \\fB\\-tls\\-passphrase\\-file\\fR is not given.\n")
+	fmt.Fprintf(w, ".TP\n.B GIR_IMPORT\\fR, \\fBGIR_REPO\nThe \\fIimport\\fR and \\fIrepo\\fR arguments, if none are given.\n")
+	fmt.Fprintf(w, ".TP\n.B GIR_CONFIG_DATA\nThe YAML configuration, if neither \\fB\\-config\\fR nor \\fB\\-snapshot\\fR is given.\n")
+	fmt.Fprintf(w, ".TP\n.B GIR_\\fIOPTION\\fR\nThe value of each option not given on the command line, as GIR_ADDR for \\fB\\-addr\\fR.\n")
+}
+
+// roffEscape escapes s for use in roff text.
+func roffEscape(s string) string {
+	s = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(s)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
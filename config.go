@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/kastelo/go-import-redirector/redirector"
+	"gopkg.in/yaml.v3"
+)
+
+var configFile = flag.String("config", "", "load the import path rules from `file` (YAML, or TOML if named *.toml)")
+
+// rules indexes the served rules, in configuration order. Reloading the
+// configuration replaces them as a whole; read them with currentRules.
+var rules atomic.Pointer[redirector.Index]
+
+// currentRules returns the served rules.
+func currentRules() []*redirector.Rule {
+	return rules.Load().Rules()
+}
+
+type config struct {
+	// Include lists files whose rules, redirects, certificates and
+	// security headers are added to those of this one.
+	Include []string `yaml:"include,omitempty" toml:"include,omitempty"`
+
+	Rules     []*redirector.Rule `yaml:"rules" toml:"rules"`
+	Redirects []*shortcut        `yaml:"redirects" toml:"redirects"`
+
+	// Certificates are the -tls certificates, loaded at startup only.
+	Certificates []certPair `yaml:"certificates" toml:"certificates"`
+
+	// SecurityHeaders change the default security headers; an empty
+	// value removes one.
+	SecurityHeaders map[string]string `yaml:"security_headers" toml:"security_headers"`
+
+	// Profiles are overrides of the configuration selected by -profile.
+	Profiles map[string]*config `yaml:"profiles,omitempty" toml:"profiles,omitempty"`
+
+	// mapping is the -snapshot file mapped into memory, which the strings
+	// of a snapshot refer to.
+	mapping []byte
+
+	// templateErr is the first rule template that failed to load and
+	// was replaced by the minimal page under -degrade template.
+	templateErr error
+}
+
+// loadRules sets up rules from the -config or -snapshot file, or from the
+// <import> and <repo> arguments and the per-rule flags.
+func loadRules(args []string) error {
+	if err := redirector.CheckVCS(*vcs); err != nil {
+		return fmt.Errorf("-vcs: %v", err)
+	}
+	if *configFile != "" && *snapshotFile != "" {
+		return errors.New("-config and -snapshot cannot be used together")
+	}
+	if *profile != "" && (configName() == "" || *snapshotFile != "") {
+		return errors.New("-profile requires -config; snapshots are compiled with the profile")
+	}
+	if configName() == "" {
+		r := &redirector.Rule{Import: args[0], Repo: args[1], OldRepo: *oldRepo, Cutover: *cutoverFlag}
+		if err := setupRule(r); err != nil {
+			return err
+		}
+		rules.Store(redirector.NewIndex([]*redirector.Rule{r}))
+		storeSecurityHeaders(nil)
+		return nil
+	}
+	if *oldRepo != "" || *cutoverFlag != "" {
+		return errors.New("-old-repo and -cutover cannot be used with -config or -snapshot; set old_repo and cutover in the rules instead")
+	}
+	c, err := readConfig()
+	if err != nil {
+		return err
+	}
+	rules.Store(redirector.NewIndex(c.Rules))
+	storeShortcuts(c.Redirects)
+	storeSecurityHeaders(c.SecurityHeaders)
+	for _, p := range c.Certificates {
+		// The certificates are kept beyond the configuration.
+		configCerts = append(configCerts, certPair{Cert: strings.Clone(p.Cert), Key: strings.Clone(p.Key)})
+	}
+	servedMapping = c.mapping
+	if c.templateErr != nil {
+		setDegraded("template", c.templateErr)
+	}
+	return nil
+}
+
+// readConfig reads the -config or -snapshot file, or GIR_CONFIG_DATA, and
+// initializes its rules and redirects.
+func readConfig() (*config, error) {
+	defer func(start time.Time) {
+		configLoadSeconds.Set(time.Since(start).Seconds())
+	}(time.Now())
+	var c *config
+	var err error
+	switch {
+	case *snapshotFile != "":
+		c, err = readSnapshot()
+	case configEnv != "":
+		c, err = expandConfig([]byte(os.Getenv(configEnv)))
+	default:
+		var buf []byte
+		if buf, err = os.ReadFile(*configFile); err == nil {
+			c, err = expandConfig(buf)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := c.init(); err != nil {
+		unmapFile(c.mapping)
+		return nil, err
+	}
+	return c, nil
+}
+
+// isTOML reports whether the -config file is in TOML.
+func isTOML() bool {
+	return isTOMLFile(*configFile)
+}
+
+// isTOMLFile reports whether the configuration file name is in TOML.
+func isTOMLFile(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".toml")
+}
+
+// decodeConfig decodes the contents of the -config file as written,
+// without interpolating variables or reading included files.
+func decodeConfig(buf []byte) (*config, error) {
+	return decodeFile(buf, configName(), isTOML())
+}
+
+// decodeFile decodes the contents of the configuration file name, in
+// TOML if asTOML is set and otherwise in YAML.
+func decodeFile(buf []byte, name string, asTOML bool) (*config, error) {
+	var c config
+	if asTOML {
+		md, err := toml.Decode(string(buf), &c)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		if un := md.Undecoded(); len(un) > 0 {
+			return nil, fmt.Errorf("%s: unknown key %s", name, un[0])
+		}
+	} else {
+		d := yaml.NewDecoder(bytes.NewReader(buf))
+		d.KnownFields(true)
+		if err := d.Decode(&c); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return &c, nil
+}
+
+// init validates and initializes the rules and redirects of c.
+func (c *config) init() error {
+	if len(c.Rules) == 0 {
+		return fmt.Errorf("%s: no rules", configName())
+	}
+	if err := c.loadPages(); err != nil {
+		return err
+	}
+	aliases, err := setupRules(c.Rules)
+	if err != nil {
+		return err
+	}
+	// The rules are served followed by their aliases; from holds the
+	// configured rule number of each.
+	var all []*redirector.Rule
+	var from []int
+	for i, r := range c.Rules {
+		for _, a := range append([]*redirector.Rule{r}, aliases[i]...) {
+			all = append(all, a)
+			from = append(from, i)
+		}
+	}
+	if j, i, ok := redirector.Overlapping(all); ok {
+		return fmt.Errorf("%s: rule %d: import path %s overlaps rule %d (%s)", configName(), from[i]+1, all[i].Import, from[j]+1, all[j].Import)
+	}
+	if err := redirector.FlattenMoves(all); err != nil {
+		return fmt.Errorf("%s: %v", configName(), err)
+	}
+	c.Rules = all
+	for i, p := range c.Certificates {
+		if p.Cert == "" {
+			return fmt.Errorf("%s: certificate %d: no cert file", configName(), i+1)
+		}
+	}
+	seen := make(map[string]bool)
+	for i, s := range c.Redirects {
+		if err := s.init(); err != nil {
+			return fmt.Errorf("%s: redirect %d: %v", configName(), i+1, err)
+		}
+		if seen[s.From] {
+			return fmt.Errorf("%s: redirect %d: duplicate from %s", configName(), i+1, s.From)
+		}
+		seen[s.From] = true
+	}
+	var hosts []string
+	for _, r := range all {
+		if !slices.Contains(hosts, r.Host()) {
+			hosts = append(hosts, r.Host())
+		}
+	}
+	if err := flattenShortcuts(c.Redirects, hosts); err != nil {
+		return fmt.Errorf("%s: %v", configName(), err)
+	}
+	if err := checkSecurityHeaders(c.SecurityHeaders); err != nil {
+		return fmt.Errorf("%s: %v", configName(), err)
+	}
+	return nil
+}
+
+// loadPages parses the page templates of the rules. Under -degrade
+// template, a rule whose template fails to load gets the minimal page
+// instead, and the first such failure is kept in templateErr.
+func (c *config) loadPages() error {
+	for i, r := range c.Rules {
+		if r.Template == "" {
+			continue
+		}
+		t, err := loadTemplate(r.Template)
+		if err != nil {
+			err = fmt.Errorf("%s: rule %d: %v", configName(), i+1, err)
+			if !degrades("template") {
+				return err
+			}
+			if c.templateErr == nil {
+				c.templateErr = err
+			}
+			t = redirector.MinimalPage
+		}
+		r.Page = t
+	}
+	return nil
+}
+
+// setupRules sets up rs in parallel, as large configurations have many
+// thousands of rules, and returns the alias rules of each. The error is
+// that of the first failing rule.
+func setupRules(rs []*redirector.Rule) ([][]*redirector.Rule, error) {
+	aliases := make([][]*redirector.Rule, len(rs))
+	errs := make([]error, len(rs))
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for n := runtime.GOMAXPROCS(0); n > 0; n-- {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= len(rs) {
+					return
+				}
+				if errs[i] = setupRule(rs[i]); errs[i] == nil {
+					aliases[i], errs[i] = rs[i].AliasRules()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("%s: rule %d: %v", configName(), i+1, err)
+		}
+	}
+	return aliases, nil
+}
+
+// setupRule fills in the defaults for r from the -vcs, -mod-proxy,
+// -min-go, -source-dir, -source-file, -branch, -dir-url, -browser-redirect
+// and -overlap flags, and initializes it.
+func setupRule(r *redirector.Rule) error {
+	if r.VCS == "" {
+		r.VCS = *vcs
+	}
+	if r.ModProxy == "" && r.VCS != "mod" {
+		r.ModProxy = *modProxy
+	}
+	if r.MinGo == "" {
+		r.MinGo = *minGo
+	}
+	if r.SourceDir == "" && r.SourceFile == "" {
+		r.SourceDir, r.SourceFile = *sourceDir, *sourceFile
+	}
+	if r.BrowserRedirect == "" {
+		r.BrowserRedirect = *browserRedirect
+	}
+	if r.Branch == "" {
+		r.Branch = *branch
+	}
+	if r.DirURL == "" {
+		r.DirURL = *dirURL
+	}
+	r.Overlap = *overlap
+	return r.Init()
+}
+
+// importHosts returns the distinct hosts of the served import paths.
+func importHosts() []string {
+	return rules.Load().Hosts()
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestOverlapError(t *testing.T) {
+	tests := []struct {
+		config string
+		err    string // after the file name
+	}{
+		{`rules:
+  - import: example.com/x/{name}
+    repo: https://github.com/x/{name}
+  - import: example.com/y
+    repo: https://github.com/y/y
+  - import: example.com/x/{other}
+    repo: https://github.com/other/{other}
+`, "rule 3: import path example.com/x/{other} overlaps rule 1 (example.com/x/{name})"},
+		{`rules:
+  - import: example.com/x/*
+    repo: https://github.com/x/*
+  - import: example.com/x/{name}
+    repo: https://github.com/x/{name}
+`, "rule 2: import path example.com/x/{name} overlaps rule 1 (example.com/x/*)"},
+		{`rules:
+  - import: example.com/foo
+    repo: https://github.com/x/foo
+    aliases: [example.com/bar]
+  - import: example.com/bar
+    repo: https://github.com/x/bar
+`, "rule 2: import path example.com/bar overlaps rule 1 (example.com/bar)"},
+	}
+	for _, tt := range tests {
+		name := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(name, []byte(tt.config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		old := *configFile
+		*configFile = name
+		_, err := readConfig()
+		*configFile = old
+		if want := name + ": " + tt.err; err == nil || err.Error() != want {
+			t.Errorf("error %v, want %s", err, want)
+		}
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	t.Setenv("GIR_TEST_HOST", "example.com")
+	t.Setenv("GIR_TEST_EMPTY", "")
+	tests := []struct {
+		in, out, err string
+	}{
+		{"import: ${GIR_TEST_HOST}/foo", "import: example.com/foo", ""},
+		{"a: ${GIR_TEST_HOST}${GIR_TEST_HOST}\nb: x${GIR_TEST_EMPTY}y", "a: example.comexample.com\nb: xy", ""},
+		{"a: $${GIR_TEST_HOST} $HOME $ {x}", "a: ${GIR_TEST_HOST} $HOME $ {x}", ""},
+		{"a: 1\nb: ${GIR_TEST_UNSET}", "", "line 2: undefined variable GIR_TEST_UNSET"},
+		{"a: ${GIR_TEST_HOST", "", "line 1: unterminated ${"},
+		{"a: ${1X}", "", `line 1: invalid variable name "1X"`},
+	}
+	for _, tt := range tests {
+		out, err := interpolate([]byte(tt.in))
+		switch {
+		case tt.err != "" && (err == nil || err.Error() != tt.err):
+			t.Errorf("%q: error %v, want %s", tt.in, err, tt.err)
+		case tt.err == "" && (err != nil || string(out) != tt.out):
+			t.Errorf("%q: %q, %v; want %q", tt.in, out, err, tt.out)
+		}
+	}
+}
+
+// writeFiles writes the files, by name relative to dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestInclude(t *testing.T) {
+	t.Setenv("GIR_TEST_HOST", "example.com")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"config.yaml": `include: [teams/infra.yaml, web.toml]
+rules:
+  - import: ${GIR_TEST_HOST}/foo
+    repo: https://github.com/example/foo
+security_headers:
+  X-Frame-Options: SAMEORIGIN
+`,
+		"teams/infra.yaml": `include: [../more/tools.yaml]
+rules:
+  - import: ${GIR_TEST_HOST}/infra
+    repo: https://github.com/example/infra
+security_headers:
+  X-Frame-Options: DENY
+  Content-Security-Policy: "default-src 'self'"
+`,
+		"more/tools.yaml": `rules:
+  - import: ${GIR_TEST_HOST}/tools
+    repo: https://github.com/example/tools
+`,
+		"web.toml": `[[rules]]
+import = "${GIR_TEST_HOST}/web"
+repo = "https://github.com/example/web"
+`,
+	})
+	old := *configFile
+	*configFile = filepath.Join(dir, "config.yaml")
+	t.Cleanup(func() { *configFile = old })
+	c, err := readConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var imports []string
+	for _, r := range c.Rules {
+		imports = append(imports, r.Import)
+	}
+	if want := []string{"example.com/foo", "example.com/infra", "example.com/tools", "example.com/web"}; !slices.Equal(imports, want) {
+		t.Errorf("rules %v, want %v", imports, want)
+	}
+	if h := c.SecurityHeaders; h["X-Frame-Options"] != "SAMEORIGIN" || h["Content-Security-Policy"] != "default-src 'self'" {
+		t.Errorf("security headers %v", h)
+	}
+
+	for _, tt := range []struct{ name, data, err string }{
+		{"more/tools.yaml", "include: [../config.yaml]\n", "includes itself"},
+		{"more/tools.yaml", "include: [missing.yaml]\n", "missing.yaml"},
+		{"more/tools.yaml", "rules: [{import: ${GIR_TEST_UNSET}}]\n", "tools.yaml: line 1: undefined variable GIR_TEST_UNSET"},
+	} {
+		writeFiles(t, dir, map[string]string{tt.name: tt.data})
+		if _, err := readConfig(); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want one with %q", tt.data, err, tt.err)
+		}
+	}
+}
+
+func TestProfile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"config.yaml": `include: [web.yaml]
+rules:
+  - import: example.com/foo
+    repo: https://github.com/example/foo
+  - import: example.com/bar
+    repo: https://github.com/example/bar
+redirects:
+  - from: example.com/docs
+    to: https://docs.example.com
+security_headers:
+  X-Frame-Options: DENY
+profiles:
+  staging:
+    rules:
+      - import: example.com/bar
+        repo: https://github.com/example-staging/bar
+      - import: example.com/new
+        repo: https://github.com/example-staging/new
+    redirects:
+      - from: example.com/docs
+        to: https://docs.staging.example.com
+    security_headers:
+      X-Frame-Options: ""
+  prod: {}
+`,
+		"web.yaml": `rules:
+  - import: example.com/web
+    repo: https://github.com/example/web
+`,
+	})
+	oldConfig, oldProfile := *configFile, *profile
+	*configFile = filepath.Join(dir, "config.yaml")
+	t.Cleanup(func() { *configFile, *profile = oldConfig, oldProfile })
+
+	tests := []struct {
+		profile string
+		rules   []string // import and repo
+		to, xfo string
+	}{
+		{"", []string{"example.com/foo github.com/example/foo", "example.com/bar github.com/example/bar", "example.com/web github.com/example/web"}, "https://docs.example.com", "DENY"},
+		{"prod", []string{"example.com/foo github.com/example/foo", "example.com/bar github.com/example/bar", "example.com/web github.com/example/web"}, "https://docs.example.com", "DENY"},
+		{"staging", []string{"example.com/foo github.com/example/foo", "example.com/bar github.com/example-staging/bar", "example.com/web github.com/example/web", "example.com/new github.com/example-staging/new"}, "https://docs.staging.example.com", ""},
+	}
+	for _, tt := range tests {
+		*profile = tt.profile
+		c, err := readConfig()
+		if err != nil {
+			t.Errorf("profile %q: %v", tt.profile, err)
+			continue
+		}
+		var rules []string
+		for _, r := range c.Rules {
+			rules = append(rules, r.Import+" "+strings.TrimPrefix(r.Repo, "https://"))
+		}
+		if !slices.Equal(rules, tt.rules) {
+			t.Errorf("profile %q: rules %q, want %q", tt.profile, rules, tt.rules)
+		}
+		if len(c.Redirects) != 1 || c.Redirects[0].To != tt.to {
+			t.Errorf("profile %q: redirects %+v, want one to %s", tt.profile, c.Redirects, tt.to)
+		}
+		if xfo, ok := c.SecurityHeaders["X-Frame-Options"]; !ok || xfo != tt.xfo {
+			t.Errorf("profile %q: X-Frame-Options %q, want %q", tt.profile, xfo, tt.xfo)
+		}
+	}
+
+	*profile = "dev"
+	if _, err := readConfig(); err == nil || !strings.Contains(err.Error(), "no such profile (have prod, staging)") {
+		t.Errorf("unknown profile: error %v", err)
+	}
+	*profile = ""
+	writeFiles(t, dir, map[string]string{"web.yaml": "profiles: {dev: {}}\n"})
+	if _, err := readConfig(); err == nil || !strings.Contains(err.Error(), "profiles must be in the -config file") {
+		t.Errorf("profiles in an included file: error %v", err)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kastelo/go-import-redirector/redirector"
+)
+
+var strict = flag.Bool("strict", false, "refuse questionable configurations at startup, and check every rendered page against the go command's meta tag rules before serving it")
+
+// The parsing below follows cmd/go/internal/vcs/discovery.go and
+// matchGoImport in cmd/go/internal/vcs/vcs.go, so that rendered pages
+// can be checked the way the go command will read them.
+
+// metaImport is a parsed go-import meta tag.
+type metaImport struct {
+	Prefix, VCS, RepoRoot, SubDir string
+}
+
+// metaSource is a parsed go-source meta tag.
+type metaSource struct {
+	Prefix, Home, Dir, File string
+}
+
+// knownVCS are the VCS types the go command understands in go-import tags.
+var knownVCS = map[string]bool{"git": true, "hg": true, "svn": true, "bzr": true, "fossil": true, "mod": true}
+
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "utf-8", "ascii":
+		return input, nil
+	default:
+		return nil, fmt.Errorf("can't decode XML document using charset %q", charset)
+	}
+}
+
+// parseMeta returns the go-import and go-source meta tags in the HTML in r.
+// Parsing ends at the end of the <head> section or the beginning of the <body>.
+// If preferMod is set, mod entries supersede other entries with the same prefix,
+// as in module mode.
+func parseMeta(r io.Reader, preferMod bool) ([]metaImport, []metaSource, error) {
+	d := xml.NewDecoder(r)
+	d.CharsetReader = charsetReader
+	d.Strict = false
+	var imports []metaImport
+	var sources []metaSource
+	for {
+		t, err := d.RawToken()
+		if err != nil {
+			if err != io.EOF && len(imports) == 0 {
+				return nil, nil, err
+			}
+			break
+		}
+		if e, ok := t.(xml.StartElement); ok && strings.EqualFold(e.Name.Local, "body") {
+			break
+		}
+		if e, ok := t.(xml.EndElement); ok && strings.EqualFold(e.Name.Local, "head") {
+			break
+		}
+		e, ok := t.(xml.StartElement)
+		if !ok || !strings.EqualFold(e.Name.Local, "meta") {
+			continue
+		}
+		f := strings.Fields(attrValue(e.Attr, "content"))
+		switch attrValue(e.Attr, "name") {
+		case "go-import":
+			if len(f) == 3 || len(f) == 4 {
+				mi := metaImport{Prefix: f[0], VCS: f[1], RepoRoot: f[2]}
+				if len(f) == 4 {
+					mi.SubDir = f[3]
+				}
+				imports = append(imports, mi)
+			}
+		case "go-source":
+			if len(f) == 4 {
+				sources = append(sources, metaSource{Prefix: f[0], Home: f[1], Dir: f[2], File: f[3]})
+			}
+		}
+	}
+
+	var list []metaImport
+	var have map[string]bool
+	if preferMod {
+		have = make(map[string]bool)
+		for _, m := range imports {
+			if m.VCS == "mod" {
+				have[m.Prefix] = true
+				list = append(list, m)
+			}
+		}
+	}
+	for _, m := range imports {
+		if m.VCS != "mod" && !have[m.Prefix] {
+			list = append(list, m)
+		}
+	}
+	return list, sources, nil
+}
+
+// metaTags returns the go-import and go-source meta tags in the HTML page
+// as "name: content" strings, in page order.
+func metaTags(body []byte) []string {
+	d := xml.NewDecoder(bytes.NewReader(body))
+	d.CharsetReader = charsetReader
+	d.Strict = false
+	var tags []string
+	for {
+		t, err := d.RawToken()
+		if err != nil {
+			return tags
+		}
+		e, ok := t.(xml.StartElement)
+		if !ok || !strings.EqualFold(e.Name.Local, "meta") {
+			continue
+		}
+		if name := attrValue(e.Attr, "name"); name == "go-import" || name == "go-source" {
+			tags = append(tags, name+": "+attrValue(e.Attr, "content"))
+		}
+	}
+}
+
+func attrValue(attrs []xml.Attr, name string) string {
+	for _, a := range attrs {
+		if strings.EqualFold(a.Name.Local, name) {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// hasPathPrefix reports whether the slash-separated path s begins with prefix.
+func hasPathPrefix(s, prefix string) bool {
+	return s == prefix || strings.HasPrefix(s, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+// matchGoImport returns the single entry applying to importPath.
+func matchGoImport(imports []metaImport, importPath string) (metaImport, error) {
+	match := -1
+	for i, im := range imports {
+		if !hasPathPrefix(importPath, im.Prefix) {
+			continue
+		}
+		if match >= 0 {
+			if imports[match].VCS == "mod" && im.VCS != "mod" {
+				break
+			}
+			return metaImport{}, fmt.Errorf("multiple meta tags match import path %q", importPath)
+		}
+		match = i
+	}
+	if match == -1 {
+		return metaImport{}, fmt.Errorf("no go-import meta tag matches import path %q", importPath)
+	}
+	return imports[match], nil
+}
+
+// checkMeta verifies that the go command would resolve importPath from the
+// page in body in module mode and, if the page has non-mod entries, in
+// GOPATH mode.
+func checkMeta(body []byte, importPath string) error {
+	for _, preferMod := range []bool{true, false} {
+		imports, sources, err := parseMeta(bytes.NewReader(body), preferMod)
+		if err != nil {
+			return err
+		}
+		if !preferMod && len(imports) == 0 {
+			break
+		}
+		mi, err := matchGoImport(imports, importPath)
+		if err != nil {
+			return err
+		}
+		if !knownVCS[mi.VCS] {
+			return fmt.Errorf("unknown VCS %q in go-import meta tag", mi.VCS)
+		}
+		u, err := url.Parse(mi.RepoRoot)
+		if err != nil {
+			return fmt.Errorf("invalid repo root %q: %v", mi.RepoRoot, err)
+		}
+		if u.Scheme == "" || u.Host == "" && u.Scheme != "file" {
+			return fmt.Errorf("invalid repo root %q: no scheme or host", mi.RepoRoot)
+		}
+		for _, ms := range sources {
+			if ms.Prefix != mi.Prefix && hasPathPrefix(importPath, ms.Prefix) {
+				return fmt.Errorf("go-source prefix %q does not match go-import prefix %q", ms.Prefix, mi.Prefix)
+			}
+		}
+	}
+	return nil
+}
+
+// checkStrict reports, with -strict, the strictProblems of rs.
+func checkStrict(rs []*redirector.Rule) error {
+	if !*strict {
+		return nil
+	}
+	if errs := strictProblems(rs); len(errs) > 0 {
+		return fmt.Errorf("-strict: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// strictProblems returns what would otherwise be accepted in rs with at
+// most a warning: a rule nested in the namespace of another, a repo URL
+// that does not parse or is not served over https, the forgeProblems,
+// pages without a go-source tag, and with -verify-repos, a fixed repo that
+// does not exist.
+func strictProblems(rs []*redirector.Rule) []error {
+	var errs []error
+	roots := make(map[string][]*redirector.Rule)
+	for _, r := range rs {
+		roots[r.Root()] = append(roots[r.Root()], r)
+	}
+	for _, r := range rs {
+		for p := r.Root(); strings.Contains(p, "/"); {
+			p = p[:strings.LastIndexByte(p, '/')]
+			for _, o := range roots[p] {
+				errs = append(errs, fmt.Errorf("%s overlaps %s", r.Import, o.Import))
+			}
+		}
+		for _, repo := range []string{r.Repo, r.OldRepo} {
+			// Placeholders may stand anywhere, including in the host.
+			u, err := url.Parse(strings.NewReplacer("{", "", "}", "").Replace(repo))
+			if repo != "" && (err != nil || u.Host == "" && u.Scheme != "file") {
+				errs = append(errs, fmt.Errorf("%s: repo %s is not a valid URL", r.Import, repo))
+			}
+		}
+		if r.VCS == "mod" {
+			continue
+		}
+		for _, repo := range []string{r.Repo, r.OldRepo} {
+			if repo != "" && !strings.HasPrefix(repo, "https://") {
+				errs = append(errs, fmt.Errorf("%s: repo %s is not served over https", r.Import, repo))
+			}
+		}
+		for _, repo := range []string{r.Repo, r.OldRepo} {
+			if repo != "" {
+				errs = append(errs, forgeProblems(r, repo)...)
+			}
+		}
+		if !r.HasSource() {
+			errs = append(errs, fmt.Errorf("%s: no go-source templates for %s (set source_dir and source_file)", r.Import, r.Repo))
+		}
+		if repo, _ := r.ActiveRepo(time.Now()); *verifyRepos && !strings.ContainsAny(r.Import, "*{") && repoMissing(repo) {
+			errs = append(errs, fmt.Errorf("%s: repo %s does not exist", r.Import, repo))
+		}
+	}
+	return errs
+}
+
+// placeholdersRE matches the placeholders of a repo template.
+var placeholdersRE = regexp.MustCompile(`\{[^}]*\}`)
+
+// gitlabMaxDepth is the most path elements a GitLab project can have:
+// 20 levels of groups and the project.
+const gitlabMaxDepth = 21
+
+// forgeProblems returns the mistakes in mapping r to repo, a repo on
+// GitHub, GitLab.com or Bitbucket, that the forge would not catch until a
+// user fetches the module: a path of the wrong shape for the forge, with
+// wildcards and placeholders counted as one element each, and on GitHub,
+// which ignores the case of repo names, uppercase letters in the repo, or
+// wildcard import paths that reach the same repo in different cases
+// without a lower transform, as the go command then sees different
+// modules.
+func forgeProblems(r *redirector.Rule, repo string) []error {
+	u, err := url.Parse(strings.NewReplacer("{", "", "}", "").Replace(repo))
+	if err != nil {
+		return nil // reported as an invalid URL
+	}
+	elems := strings.Split(strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git"), "/")
+	var errs []error
+	switch strings.TrimPrefix(u.Host, "www.") {
+	case "github.com":
+		if len(elems) != 2 {
+			errs = append(errs, fmt.Errorf("%s: GitHub repo %s is not owner/name", r.Import, repo))
+		}
+		substituted := strings.ContainsAny(repo, "*{")
+		if literal := placeholdersRE.ReplaceAllString(repo, ""); literal != strings.ToLower(literal) {
+			errs = append(errs, fmt.Errorf("%s: GitHub repo %s has uppercase letters; GitHub ignores case, the go command does not, so name it in lowercase", r.Import, repo))
+		}
+		if substituted && !slices.Contains(r.Transforms, "lower") {
+			errs = append(errs, fmt.Errorf("%s: import paths differing in case reach the same GitHub repo %s as different modules (add transforms: [lower])", r.Import, repo))
+		}
+	case "gitlab.com":
+		if len(elems) < 2 {
+			errs = append(errs, fmt.Errorf("%s: GitLab repo %s is not group/project", r.Import, repo))
+		} else if len(elems) > gitlabMaxDepth {
+			errs = append(errs, fmt.Errorf("%s: GitLab repo %s is deeper than GitLab nests groups (%d elements, at most %d)", r.Import, repo, len(elems), gitlabMaxDepth))
+		}
+	case "bitbucket.org":
+		if len(elems) != 2 {
+			errs = append(errs, fmt.Errorf("%s: Bitbucket repo %s is not workspace/repo; Bitbucket projects are not part of repo URLs", r.Import, repo))
+		}
+	}
+	return errs
+}
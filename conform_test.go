@@ -0,0 +1,169 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckMeta(t *testing.T) {
+	const (
+		imp = `<meta name="go-import" content="example.com/foo git https://github.com/x/foo">`
+		src = `<meta name="go-source" content="example.com/foo https://github.com/x/foo https://github.com/x/foo/tree/HEAD{/dir} https://github.com/x/foo/blob/HEAD{/dir}/{file}#L{line}">`
+	)
+	tests := []struct {
+		head, path string
+		err        string // in the error, if any
+	}{
+		{imp + src, "example.com/foo", ""},
+		{imp, "example.com/foo/bar", ""},
+		{imp + `<meta name="go-import" content="example.com/foo mod https://proxy.example.com">`, "example.com/foo", ""},
+		{"", "example.com/foo", "no go-import meta tag"},
+		{imp, "example.com/bar", "no go-import meta tag"},
+		{imp, "example.com/foobar", "no go-import meta tag"},
+		{`<meta name="go-import" content="example.com/foo cvs https://github.com/x/foo">`, "example.com/foo", "unknown VCS"},
+		{`<meta name="go-import" content="example.com/foo git github.com/x/foo">`, "example.com/foo", "no scheme or host"},
+		{imp + strings.Replace(src, "example.com/foo ", "example.com/other ", 1), "example.com/foo", ""},
+		{imp + strings.Replace(src, "example.com/foo ", "example.com ", 1), "example.com/foo", "does not match go-import prefix"},
+	}
+	for _, tt := range tests {
+		body := "<!DOCTYPE html><html><head>" + tt.head + "</head><body></body></html>"
+		err := checkMeta([]byte(body), tt.path)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s in %s: %v", tt.path, tt.head, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s in %s: error %v, want one with %q", tt.path, tt.head, err, tt.err)
+		}
+	}
+}
+
+// metaTemplate is a page template with the meta tags the go command reads,
+// and mark in the body.
+func metaTemplate(mark string) string {
+	return `<!DOCTYPE html>
+<html>
+<head>
+<meta name="go-import" content="{{.ImportRoot}} {{.VCS}} {{.VCSRoot}}">
+{{- if .ModProxy}}
+<meta name="go-import" content="{{.ImportRoot}} mod {{.ModProxy}}">
+{{- end}}
+{{- with .Source}}
+<meta name="go-source" content="{{$.ImportRoot}} {{.Home}} {{.Dir}} {{.File}}">
+{{- end}}
+</head>
+<body>` + mark + ` {{.ImportRoot}}{{.Suffix}}</body>
+</html>
+`
+}
+
+// TestPageMeta checks that the pages of every template pass checkMeta: the
+// default page, localized pages, a per-rule template and the minimal page.
+func TestPageMeta(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "locales"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{
+		"locales/de.html": metaTemplate("Paket"),
+		"locales/fr.html": metaTemplate("Paquet"),
+		"custom.html":     metaTemplate("Custom"),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := *localeDir
+	*localeDir = filepath.Join(dir, "locales")
+	t.Cleanup(func() { *localeDir = old })
+	serveConfig(t, `rules:
+  - import: example.com/foo
+    repo: https://github.com/example/foo
+  - import: example.com/x/*
+    repo: https://github.com/example/*
+  - import: example.com/{team}/svc/{name}
+    repo: https://git.example.com/{team}/{name}.git
+  - import: example.com/proxied
+    repo: https://github.com/example/proxied
+    mod_proxy: https://proxy.example.com
+  - import: example.com/custom
+    repo: https://github.com/example/custom
+    template: `+filepath.Join(dir, "custom.html")+`
+`)
+
+	tests := []struct {
+		path, lang, mark string
+	}{
+		{"example.com/foo", "", "<style>"},
+		{"example.com/foo/bar/baz", "", "<style>"},
+		{"example.com/x/y/z", "", "<style>"},
+		{"example.com/ops/svc/api/v2", "", "<style>"},
+		{"example.com/proxied", "", "<style>"},
+		{"example.com/foo", "de-DE,de;q=0.9", "Paket"},
+		{"example.com/x/y", "fr", "Paquet"},
+		{"example.com/foo", "ja", "<style>"},
+		{"example.com/custom/sub", "", "Custom"},
+		{"example.com/custom", "de", "Custom"},
+	}
+	for _, tt := range tests {
+		checkPageMeta(t, tt.path, tt.lang, tt.mark)
+	}
+
+	// The minimal page, served in every language once the templates fail.
+	locales.Store(minimalLocales())
+	clearPageCache()
+	for _, tt := range tests {
+		if tt.mark != "Custom" {
+			checkPageMeta(t, tt.path, tt.lang, minimalMark)
+		}
+	}
+}
+
+// TestPageMetaDegraded checks the minimal page served under -degrade
+// template for a per-rule template that fails to load.
+func TestPageMetaDegraded(t *testing.T) {
+	old := *degradeList
+	*degradeList = "template"
+	t.Cleanup(func() {
+		*degradeList = old
+		setDegraded("template", nil)
+	})
+	serveConfig(t, `rules:
+  - import: example.com/custom
+    repo: https://github.com/example/custom
+    template: `+filepath.Join(t.TempDir(), "missing.html")+`
+`)
+	checkPageMeta(t, "example.com/custom/sub", "", minimalMark)
+}
+
+// minimalMark is in the minimal page only.
+const minimalMark = "<body>\n<a href="
+
+// checkPageMeta checks that the go get and browser pages for path, in the
+// language of the Accept-Language header lang, contain mark and pass
+// checkMeta.
+func checkPageMeta(t *testing.T, path, lang, mark string) {
+	t.Helper()
+	for _, query := range []string{"?go-get=1", ""} {
+		req := httptest.NewRequest(http.MethodGet, "http://"+path+query, nil)
+		if lang != "" {
+			req.Header.Set("Accept-Language", lang)
+		}
+		w := httptest.NewRecorder()
+		redirect(w, req)
+		body := w.Body.String()
+		if w.Code != http.StatusOK {
+			t.Errorf("%s%s: status %d", path, query, w.Code)
+			continue
+		}
+		if !strings.Contains(body, mark) {
+			t.Errorf("%s%s (%s): page lacks %q:\n%s", path, query, lang, mark, body)
+		}
+		if err := checkMeta(w.Body.Bytes(), path); err != nil {
+			t.Errorf("%s%s (%s): %v:\n%s", path, query, lang, err, body)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// A dashboardPanel is a Grafana time series panel of Prometheus queries,
+// placed on a 24 column grid.
+type dashboardPanel struct {
+	title, unit string
+	w, h        int
+	queries     [][2]string // expression, legend
+}
+
+// dashboardPanels are the panels of the metrics dashboard, in order.
+var dashboardPanels = []dashboardPanel{
+	{"Requests by status", "reqps", 12, 8, [][2]string{
+		{`sum by (status) (rate(gir_requests_total[$__rate_interval]))`, "{{status}}"},
+	}},
+	{"Error ratio", "percentunit", 12, 8, [][2]string{
+		{`sum(rate(gir_requests_total{status=~"5.."}[$__rate_interval])) / sum(rate(gir_requests_total[$__rate_interval]))`, "5xx"},
+		{`sum(rate(gir_requests_total{status=~"4.."}[$__rate_interval])) / sum(rate(gir_requests_total[$__rate_interval]))`, "4xx"},
+	}},
+	{"Latency", "s", 12, 8, [][2]string{
+		{`histogram_quantile(0.5, sum by (le, client) (rate(gir_request_duration_seconds_bucket[$__rate_interval])))`, "p50 {{client}}"},
+		{`histogram_quantile(0.99, sum by (le, client) (rate(gir_request_duration_seconds_bucket[$__rate_interval])))`, "p99 {{client}}"},
+	}},
+	{"Requests by client", "reqps", 12, 8, [][2]string{
+		{`sum by (client) (rate(gir_requests_total[$__rate_interval]))`, "{{client}}"},
+	}},
+	{"Top import roots", "reqps", 12, 8, [][2]string{
+		{`topk(10, sum by (import_root) (rate(gir_requests_total[$__rate_interval])))`, "{{import_root}}"},
+	}},
+	{"Egress by module", "Bps", 12, 8, [][2]string{
+		{`topk(10, sum by (module) (rate(gir_egress_bytes_total[$__rate_interval])))`, "{{module}}"},
+	}},
+	{"Certificate days left", "d", 8, 8, [][2]string{
+		{`(gir_cert_expiry_timestamp_seconds - time()) / 86400`, "{{host}}"},
+	}},
+	{"TLS", "short", 8, 8, [][2]string{
+		{`sum(rate(gir_tls_handshake_errors_total[$__rate_interval]))`, "handshake errors/s"},
+		{`sum by (host) (gir_tls_chain_invalid)`, "invalid chain {{host}}"},
+	}},
+	{"Configuration", "s", 8, 8, [][2]string{
+		{`time() - gir_config_loaded_timestamp_seconds`, "since loaded"},
+		{`clamp_min(gir_config_file_modified_timestamp_seconds - gir_config_loaded_timestamp_seconds, 0)`, "changes not served"},
+		{`gir_config_load_seconds`, "load time"},
+	}},
+}
+
+// dashboard returns the Grafana dashboard of the metrics, with the
+// Prometheus data source as an input chosen on import.
+func dashboard() map[string]any {
+	ds := map[string]string{"type": "prometheus", "uid": "${DS_PROMETHEUS}"}
+	var panels []map[string]any
+	x, y := 0, 0
+	for i, p := range dashboardPanels {
+		if x+p.w > 24 {
+			x, y = 0, y+p.h
+		}
+		var targets []map[string]any
+		for j, q := range p.queries {
+			targets = append(targets, map[string]any{
+				"datasource":   ds,
+				"expr":         q[0],
+				"legendFormat": q[1],
+				"refId":        string(rune('A' + j)),
+			})
+		}
+		panels = append(panels, map[string]any{
+			"id":          i + 1,
+			"type":        "timeseries",
+			"title":       p.title,
+			"datasource":  ds,
+			"gridPos":     map[string]int{"x": x, "y": y, "w": p.w, "h": p.h},
+			"fieldConfig": map[string]any{"defaults": map[string]string{"unit": p.unit}, "overrides": []any{}},
+			"targets":     targets,
+		})
+		x += p.w
+	}
+	return map[string]any{
+		"__inputs": []map[string]string{{
+			"name": "DS_PROMETHEUS", "label": "Prometheus", "type": "datasource",
+			"pluginId": "prometheus", "pluginName": "Prometheus",
+		}},
+		"title":         "go-import-redirector",
+		"uid":           "go-import-redirector",
+		"tags":          []string{"go-import-redirector"},
+		"editable":      true,
+		"schemaVersion": 39,
+		"time":          map[string]string{"from": "now-6h", "to": "now"},
+		"refresh":       "1m",
+		"panels":        panels,
+	}
+}
+
+// alertRules are the example Prometheus alerting rules of the metrics.
+const alertRules = `groups:
+  - name: go-import-redirector
+    rules:
+      - alert: GoImportRedirectorErrors
+        expr: |
+          sum(rate(gir_requests_total{status=~"5.."}[5m]))
+            / sum(rate(gir_requests_total[5m])) > 0.05
+        for: 10m
+        labels:
+          severity: page
+        annotations:
+          summary: More than 5% of import path requests fail with 5xx.
+      - alert: GoImportRedirectorCertExpiring
+        expr: gir_cert_expiry_timestamp_seconds - time() < 14 * 86400
+        for: 1h
+        labels:
+          severity: warning
+        annotations:
+          summary: The certificate of {{ $labels.host }} expires within 14 days.
+      - alert: GoImportRedirectorCertChainInvalid
+        expr: gir_tls_chain_invalid == 1
+        for: 15m
+        labels:
+          severity: warning
+        annotations:
+          summary: The certificate chain served for {{ $labels.host }} does not verify.
+      - alert: GoImportRedirectorReloadFailed
+        expr: gir_config_last_reload_successful == 0
+        for: 15m
+        labels:
+          severity: warning
+        annotations:
+          summary: The -config file was rejected on reload; the previous rules are served.
+      - alert: GoImportRedirectorConfigStale
+        expr: gir_config_file_modified_timestamp_seconds > gir_config_loaded_timestamp_seconds
+        for: 15m
+        labels:
+          severity: warning
+        annotations:
+          summary: The -config file has changed since the served configuration was loaded.
+`
+
+// runMetrics writes the Grafana dashboard or the alerting rules of the
+// metrics to standard output.
+func runMetrics(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: go-import-redirector metrics dashboard|alerts\n")
+		return 2
+	}
+	switch args[0] {
+	case "dashboard":
+		writeDashboard(os.Stdout)
+	case "alerts":
+		io.WriteString(os.Stdout, alertRules)
+	default:
+		fmt.Fprintf(os.Stderr, "go-import-redirector metrics: unknown output %q\n", args[0])
+		return 2
+	}
+	return 0
+}
+
+func writeDashboard(w io.Writer) {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(dashboard())
+}
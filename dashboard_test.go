@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+var metricNameRE = regexp.MustCompile(`\bgir_\w+`)
+
+// TestDashboardMetrics checks that the dashboard and the alerting rules
+// query only metrics the server exports, and are valid JSON and YAML.
+func TestDashboardMetrics(t *testing.T) {
+	src, err := os.ReadFile("metrics.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported := make(map[string]bool)
+	for _, m := range regexp.MustCompile(`"(gir_\w+)"`).FindAllSubmatch(src, -1) {
+		exported[string(m[1])] = true
+	}
+
+	var buf bytes.Buffer
+	writeDashboard(&buf)
+	var d struct {
+		Panels []struct {
+			Title   string
+			Targets []struct{ Expr string }
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &d); err != nil {
+		t.Fatalf("dashboard: %v", err)
+	}
+	var exprs []string
+	for _, p := range d.Panels {
+		if len(p.Targets) == 0 {
+			t.Errorf("panel %s has no queries", p.Title)
+		}
+		for _, q := range p.Targets {
+			exprs = append(exprs, q.Expr)
+		}
+	}
+
+	var rules struct {
+		Groups []struct {
+			Rules []struct {
+				Alert, Expr string
+			}
+		}
+	}
+	if err := yaml.Unmarshal([]byte(alertRules), &rules); err != nil {
+		t.Fatalf("alerting rules: %v", err)
+	}
+	if len(rules.Groups) != 1 || len(rules.Groups[0].Rules) == 0 {
+		t.Fatalf("alerting rules: %+v", rules)
+	}
+	for _, r := range rules.Groups[0].Rules {
+		exprs = append(exprs, r.Expr)
+	}
+
+	for _, e := range exprs {
+		for _, name := range metricNameRE.FindAllString(e, -1) {
+			if base := strings.TrimSuffix(name, "_bucket"); !exported[name] && !exported[base] {
+				t.Errorf("%s queries %s, which is not exported", e, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var degradeList = flag.String("degrade", "", "keep serving when these comma-separated `subsystems` fail: stats (without the -geoip-db), discovery (from the last -discover repo lists) and template (pages with only the meta tags)")
+
+// degradeSubsystems names the subsystems -degrade lets fail, in the order
+// /-/status lists them.
+var degradeSubsystems = []string{"stats", "discovery", "template"}
+
+// degradation is the state of a subsystem on /-/status.
+type degradation struct {
+	Subsystem string     `json:"subsystem"`
+	Tolerated bool       `json:"tolerated"` // named by -degrade
+	Degraded  bool       `json:"degraded"`
+	Since     *time.Time `json:"since,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// degraded holds the subsystems that have failed, by name.
+var degraded struct {
+	sync.Mutex
+	m map[string]*degradation
+}
+
+// checkDegrade checks that -degrade names known subsystems.
+func checkDegrade() error {
+	for _, s := range strings.Split(*degradeList, ",") {
+		if s = strings.TrimSpace(s); s != "" && !slices.Contains(degradeSubsystems, s) {
+			return fmt.Errorf("unknown -degrade subsystem %q: want one of %s", s, strings.Join(degradeSubsystems, ", "))
+		}
+	}
+	return nil
+}
+
+// degrades reports whether -degrade lets the subsystem fail.
+func degrades(subsystem string) bool {
+	for _, s := range strings.Split(*degradeList, ",") {
+		if strings.TrimSpace(s) == subsystem {
+			return true
+		}
+	}
+	return false
+}
+
+// setDegraded records that the subsystem failed with err, or with a nil
+// err that it works again, logging the changes.
+func setDegraded(subsystem string, err error) {
+	degraded.Lock()
+	defer degraded.Unlock()
+	d := degraded.m[subsystem]
+	switch {
+	case err == nil && d != nil:
+		log.Printf("%s recovered after %v", subsystem, time.Since(*d.Since).Round(time.Second))
+		delete(degraded.m, subsystem)
+	case err != nil && d == nil:
+		log.Printf("%s degraded: %v", subsystem, err)
+		now := time.Now()
+		if degraded.m == nil {
+			degraded.m = make(map[string]*degradation)
+		}
+		degraded.m[subsystem] = &degradation{Subsystem: subsystem, Degraded: true, Since: &now, Error: err.Error()}
+	case err != nil:
+		d.Error = err.Error()
+	}
+}
+
+type statusReport struct {
+	Degraded   bool          `json:"degraded"`
+	Subsystems []degradation `json:"subsystems"`
+}
+
+// serveStatus reports, as JSON, which subsystems have failed and whether
+// -degrade lets them.
+func serveStatus(w http.ResponseWriter, req *http.Request) {
+	var s statusReport
+	degraded.Lock()
+	for _, name := range degradeSubsystems {
+		d := degradation{Subsystem: name}
+		if p := degraded.m[name]; p != nil {
+			d = *p
+			s.Degraded = true
+		}
+		d.Tolerated = degrades(name)
+		s.Subsystems = append(s.Subsystems, d)
+	}
+	degraded.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(s)
+}
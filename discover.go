@@ -0,0 +1,433 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kastelo/go-import-redirector/redirector"
+)
+
+var (
+	discoverOwners     = flag.String("discover", "", "answer /* import paths for repos of these comma-separated `owners` (such as github.com/myorg or gitlab.com/group) only if the repo exists")
+	discoverInterval   = flag.Duration("discover-interval", time.Hour, "refresh the -discover repo lists every `duration`")
+	discoverCodeowners = flag.Bool("discover-codeowners", false, "take the owner of -discover repos without one from their CODEOWNERS files")
+	discoverState      = flag.String("discover-state", "", "keep the last -discover repo lists in `file`, to serve from with -degrade discovery when the forge cannot be reached at startup")
+	forgeURL           = flag.String("forge-url", "", "send the forge API and raw file requests to `url`/host/path instead of https://host/path, such as a fake forge of the forgetest package")
+)
+
+const maxForgeRepos = 10000
+
+// A forgeRepo is a repo listed by a forge API.
+type forgeRepo struct {
+	name     string
+	archived bool
+}
+
+// discovered holds the repos of each -discover owner, by name.
+var discovered struct {
+	sync.RWMutex
+	repos  map[string]map[string]bool      // owner -> repo name -> archived
+	owners map[string]map[string]string    // owner -> repo name -> CODEOWNERS owners
+	gone   map[string]map[string]*goneRepo // owner -> repo name -> deleted or archived
+	listed map[string]time.Time            // owner -> time of the last listing attempt
+	errs   map[string]error                // owner -> error of the last listing, if it failed
+}
+
+// A goneRepo is a -discover repo that was deleted or archived, which
+// -gone-after answers with 410 Gone after a grace period.
+type goneRepo struct {
+	Since    time.Time `json:"since"`
+	Notified bool      `json:"notified,omitempty"` // the -gone-webhook was sent
+}
+
+// discoverSaved is the -discover-state file.
+type discoverSaved struct {
+	Repos map[string]map[string]bool      `json:"repos"`
+	Gone  map[string]map[string]*goneRepo `json:"gone,omitempty"`
+}
+
+func discoverList() []string {
+	var list []string
+	for _, o := range strings.Split(*discoverOwners, ",") {
+		if o = strings.Trim(strings.TrimSpace(o), "/"); o != "" {
+			list = append(list, o)
+		}
+	}
+	return list
+}
+
+// startDiscovery lists the repos of the -discover owners, and refreshes
+// the lists in the background every -discover-interval. A failed refresh
+// keeps the previous list. With -degrade discovery, an owner that cannot
+// be listed at startup has its list from -discover-state, or without one
+// its wildcard import paths served unchecked, until a refresh lists it.
+// The lists in -discover-state also tell which repos were deleted while
+// the instance was not running.
+func startDiscovery() error {
+	owners := discoverList()
+	saved, err := loadDiscoverState()
+	if err != nil {
+		log.Printf("-discover-state: %v", err)
+	}
+	discovered.Lock()
+	discovered.repos = make(map[string]map[string]bool)
+	discovered.owners = make(map[string]map[string]string)
+	discovered.gone = make(map[string]map[string]*goneRepo)
+	for _, o := range owners {
+		if saved.Repos[o] != nil {
+			discovered.repos[o] = saved.Repos[o]
+		}
+		if saved.Gone[o] != nil {
+			discovered.gone[o] = saved.Gone[o]
+		}
+	}
+	discovered.Unlock()
+	var failed error
+	for _, o := range owners {
+		m, err := discoverOwner(o)
+		if err != nil {
+			err = fmt.Errorf("-discover %s: %v", o, err)
+			if !degrades("discovery") {
+				return err
+			}
+			failed = err
+			if saved.Repos[o] != nil {
+				log.Printf("-discover %s: serving the %d repos listed in %s", o, len(saved.Repos[o]), *discoverState)
+			}
+			continue
+		}
+		storeDiscovered(o, m, time.Now())
+	}
+	if failed != nil {
+		setDegraded("discovery", failed)
+	} else {
+		saveDiscoverState()
+	}
+	go func() {
+		// CODEOWNERS files are fetched in the background, as there is
+		// one request per repo.
+		for _, o := range owners {
+			discovered.RLock()
+			m := discovered.repos[o]
+			discovered.RUnlock()
+			refreshCodeowners(o, m)
+		}
+		for range time.Tick(*discoverInterval) {
+			var failed error
+			for _, o := range owners {
+				m, err := discoverOwner(o)
+				if err != nil {
+					failed = fmt.Errorf("-discover %s: %v", o, err)
+					log.Print(failed)
+					continue
+				}
+				storeDiscovered(o, m, time.Now())
+				refreshCodeowners(o, m)
+			}
+			setDegraded("discovery", failed)
+			if failed == nil {
+				saveDiscoverState()
+			}
+		}
+	}()
+	return nil
+}
+
+// storeDiscovered stores the repos m listed for owner at time now, noting
+// those deleted or archived since they were last listed, and forgetting
+// that of those listed unarchived again.
+func storeDiscovered(owner string, m map[string]bool, now time.Time) {
+	discovered.Lock()
+	defer discovered.Unlock()
+	gone := discovered.gone[owner]
+	if gone == nil {
+		gone = make(map[string]*goneRepo)
+		discovered.gone[owner] = gone
+	}
+	for name, archived := range m {
+		switch {
+		case !archived:
+			delete(gone, name)
+		case gone[name] == nil:
+			gone[name] = &goneRepo{Since: now}
+		}
+	}
+	for name := range discovered.repos[owner] {
+		if _, ok := m[name]; !ok && gone[name] == nil {
+			gone[name] = &goneRepo{Since: now}
+		}
+	}
+	discovered.repos[owner] = m
+}
+
+// loadDiscoverState reads the -discover-state file, if any.
+func loadDiscoverState() (discoverSaved, error) {
+	var saved discoverSaved
+	if *discoverState == "" {
+		return saved, nil
+	}
+	buf, err := os.ReadFile(*discoverState)
+	if errors.Is(err, fs.ErrNotExist) {
+		return saved, nil
+	}
+	if err != nil {
+		return saved, err
+	}
+	if err := json.Unmarshal(buf, &saved); err != nil {
+		return discoverSaved{}, fmt.Errorf("%s: %v", *discoverState, err)
+	}
+	return saved, nil
+}
+
+// saveDiscoverState writes the repo lists of every owner, and the repos
+// deleted or archived, to the -discover-state file.
+func saveDiscoverState() {
+	if *discoverState == "" {
+		return
+	}
+	discovered.RLock()
+	buf, err := json.Marshal(discoverSaved{discovered.repos, discovered.gone})
+	discovered.RUnlock()
+	if err == nil {
+		err = writeFileAtomic(*discoverState, buf)
+	}
+	if err != nil {
+		log.Printf("-discover-state: %v", err)
+	}
+}
+
+// refreshCodeowners fetches the CODEOWNERS default owners of the repos of
+// owner, with -discover-codeowners.
+func refreshCodeowners(owner string, repos map[string]bool) {
+	if !*discoverCodeowners {
+		return
+	}
+	m := make(map[string]string)
+	for name := range repos {
+		if o := fetchCodeowners(owner, name); o != "" {
+			m[name] = o
+		}
+	}
+	discovered.Lock()
+	discovered.owners[owner] = m
+	discovered.Unlock()
+}
+
+// discoverOwner lists the repos of owner, recording the outcome for the
+// health report.
+func discoverOwner(owner string) (map[string]bool, error) {
+	list, err := listForgeRepos(owner)
+	discovered.Lock()
+	if discovered.listed == nil {
+		discovered.listed = make(map[string]time.Time)
+		discovered.errs = make(map[string]error)
+	}
+	discovered.listed[owner] = time.Now()
+	discovered.errs[owner] = err
+	discovered.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]bool)
+	for _, r := range list {
+		m[r.name] = r.archived
+	}
+	return m, nil
+}
+
+// discoveredRepos returns the names of the unarchived repos of owner, and
+// whether owner is a -discover owner.
+func discoveredRepos(owner string) ([]string, bool) {
+	discovered.RLock()
+	defer discovered.RUnlock()
+	m, ok := discovered.repos[owner]
+	if !ok {
+		return nil, false
+	}
+	var names []string
+	for name, archived := range m {
+		if !archived {
+			names = append(names, name)
+		}
+	}
+	return names, true
+}
+
+// discoveryRefusal returns the error code for the wildcard resolution r
+// whose repo belongs to a -discover owner: upstream_unverified if the
+// owner has no such repo, and with -gone-after, gone once the repo has
+// been deleted or archived for that long, notifying the -gone-webhook.
+// During that grace period the repo is served as before.
+func discoveryRefusal(r *redirector.Resolution, now time.Time) string {
+	if len(r.Captures) == 0 {
+		return ""
+	}
+	listed, g, ok := discoveredRepo(r.RepoRoot)
+	switch {
+	case !ok:
+	case *goneAfter == 0 || g == nil:
+		if !listed {
+			return redirector.CodeUpstreamUnverified
+		}
+	case now.Sub(g.Since) >= *goneAfter:
+		discovered.Lock()
+		notify := !g.Notified
+		g.Notified = true
+		discovered.Unlock()
+		if notify {
+			postGoneNotice(r, g.Since)
+		}
+		return redirector.CodeGone
+	}
+	return ""
+}
+
+// discoveredRepo looks up the repo URL among the repos of the -discover
+// owners. It reports whether the repo is listed, and if it was deleted or
+// archived, since when; ok is false if it belongs to no -discover owner.
+func discoveredRepo(repo string) (listed bool, g *goneRepo, ok bool) {
+	discovered.RLock()
+	defer discovered.RUnlock()
+	for owner, m := range discovered.repos {
+		rest, ok := strings.CutPrefix(repo, "https://"+owner+"/")
+		if !ok {
+			continue
+		}
+		name, listed := discoveredName(m, rest)
+		if !listed {
+			name, _ = discoveredName(discovered.gone[owner], rest)
+		}
+		return listed, discovered.gone[owner][name], true
+	}
+	return false, nil, false
+}
+
+// discoveredName returns the longest name in repos that the repo path
+// rest below the owner starts with, on a path element boundary, as the
+// names of GitLab projects in subgroups have several elements.
+func discoveredName[V any](repos map[string]V, rest string) (string, bool) {
+	name := strings.TrimSuffix(rest, "/")
+	for {
+		n := strings.TrimSuffix(name, ".git")
+		if _, ok := repos[n]; ok {
+			return n, true
+		}
+		i := strings.LastIndex(name, "/")
+		if i < 0 {
+			return "", false
+		}
+		name = name[:i]
+	}
+}
+
+func unarchived(repos []forgeRepo) []string {
+	var names []string
+	for _, r := range repos {
+		if !r.archived {
+			names = append(names, r.name)
+		}
+	}
+	return names
+}
+
+// listForgeRepos lists the repos of owner, host/name on GitHub or
+// GitLab.com, following the pages of the forge API. GitHub owners are
+// listed as organizations, whose listing includes the private repos the
+// token can see, and as users if there is no such organization. GitLab
+// groups are listed with their subgroups, the repos named by their path
+// below the group. An owner with more than maxForgeRepos repos is an
+// error rather than a list cut short.
+func listForgeRepos(owner string) ([]forgeRepo, error) {
+	host, name, _ := strings.Cut(owner, "/")
+	var next string
+	switch host {
+	case "github.com":
+		next = "https://api.github.com/orgs/" + name + "/repos?per_page=100"
+	case "gitlab.com":
+		next = "https://gitlab.com/api/v4/groups/" + url.PathEscape(name) + "/projects?per_page=100&include_subgroups=true"
+	default:
+		return nil, fmt.Errorf("unsupported forge %s: want github.com or gitlab.com", host)
+	}
+	next = viaForgeURL(next)
+	var list []forgeRepo
+	for first := true; next != ""; first = false {
+		resp, err := forgeGet(next)
+		if err != nil {
+			return nil, err
+		}
+		if first && host == "github.com" && resp.StatusCode == http.StatusNotFound {
+			resp.Body.Close()
+			next = viaForgeURL("https://api.github.com/users/" + name + "/repos?per_page=100")
+			continue
+		}
+		var page []struct {
+			Name              string `json:"name"`                // GitHub
+			PathWithNamespace string `json:"path_with_namespace"` // GitLab
+			Archived          bool   `json:"archived"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("listing repos: %s", resp.Status)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range page {
+			n := r.Name
+			if host == "gitlab.com" {
+				n = strings.TrimPrefix(r.PathWithNamespace, name+"/")
+			}
+			list = append(list, forgeRepo{name: n, archived: r.Archived})
+		}
+		if len(list) > maxForgeRepos {
+			return nil, fmt.Errorf("more than %d repos", maxForgeRepos)
+		}
+		next = nextLink(resp.Header.Get("Link"))
+	}
+	return list, nil
+}
+
+// forgeGet requests the forge URL u, which is already rewritten for
+// -forge-url. The GIR_FORGE_TOKEN environment variable, if set, is sent
+// as a bearer token, for private repos and higher rate limits.
+func forgeGet(u string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("GIR_FORGE_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return versionClient.Do(req)
+}
+
+// viaForgeURL returns the URL to request for the forge URL u, which is
+// rewritten to the -forge-url if one is given.
+func viaForgeURL(u string) string {
+	if *forgeURL == "" {
+		return u
+	}
+	return strings.TrimSuffix(*forgeURL, "/") + "/" + strings.TrimPrefix(u, "https://")
+}
+
+// nextLink returns the rel="next" URL of a Link header, or "".
+func nextLink(header string) string {
+	for _, l := range strings.Split(header, ",") {
+		target, params, _ := strings.Cut(strings.TrimSpace(l), ";")
+		if strings.Contains(params, `rel="next"`) {
+			return strings.Trim(target, "<>")
+		}
+	}
+	return ""
+}
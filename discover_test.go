@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kastelo/go-import-redirector/forgetest"
+	"github.com/kastelo/go-import-redirector/redirector"
+)
+
+// serveForge sends the forge requests to a new fake forge.
+func serveForge(t *testing.T) *forgetest.Forge {
+	t.Helper()
+	f := forgetest.New()
+	srv := httptest.NewServer(f)
+	old := *forgeURL
+	*forgeURL = srv.URL
+	t.Cleanup(func() {
+		*forgeURL = old
+		srv.Close()
+	})
+	return f
+}
+
+func TestListForgeRepos(t *testing.T) {
+	f := serveForge(t)
+	for i := 0; i < 250; i++ {
+		f.AddRepo(fmt.Sprintf("github.com/acme/repo%03d", i), forgetest.Repo{Archived: i%100 == 0})
+	}
+	f.AddRepo("github.com/jdoe/dotfiles", forgetest.Repo{})
+	f.AddUser("github.com/jdoe")
+	f.AddRepo("gitlab.com/group/top", forgetest.Repo{})
+	f.AddRepo("gitlab.com/group/sub/nested", forgetest.Repo{Archived: true})
+
+	tests := []struct {
+		owner    string
+		n        int
+		names    []string // some of the names listed
+		archived []string // all the archived repos
+	}{
+		{"github.com/acme", 250, []string{"repo000", "repo099", "repo100", "repo249"}, []string{"repo000", "repo100", "repo200"}},
+		{"github.com/jdoe", 1, []string{"dotfiles"}, nil},
+		{"gitlab.com/group", 2, []string{"top", "sub/nested"}, []string{"sub/nested"}},
+	}
+	for _, tt := range tests {
+		list, err := listForgeRepos(tt.owner)
+		if err != nil {
+			t.Errorf("%s: %v", tt.owner, err)
+			continue
+		}
+		if len(list) != tt.n {
+			t.Errorf("%s: %d repos, want %d", tt.owner, len(list), tt.n)
+		}
+		names := make(map[string]bool)
+		var archived []string
+		for _, r := range list {
+			names[r.name] = true
+			if r.archived {
+				archived = append(archived, r.name)
+			}
+		}
+		for _, n := range tt.names {
+			if !names[n] {
+				t.Errorf("%s: %s not listed", tt.owner, n)
+			}
+		}
+		if strings.Join(archived, " ") != strings.Join(tt.archived, " ") {
+			t.Errorf("%s: archived %q, want %q", tt.owner, archived, tt.archived)
+		}
+	}
+}
+
+func TestListForgeReposErrors(t *testing.T) {
+	f := serveForge(t)
+	for i := 0; i < 150; i++ {
+		f.AddRepo(fmt.Sprintf("github.com/acme/repo%03d", i), forgetest.Repo{})
+	}
+	tests := []struct {
+		owner string
+		setup func()
+		err   string
+	}{
+		{"github.com/nobody", nil, "404"},
+		{"gitlab.com/nobody", nil, "404"},
+		{"example.com/acme", nil, "unsupported forge"},
+		{"github.com/acme", func() { f.Token = "secret" }, "401"},
+		{"github.com/acme", func() { f.RateLimit = 1 }, "403"}, // the second page
+	}
+	for _, tt := range tests {
+		f.Token, f.RateLimit = "", 0
+		if tt.setup != nil {
+			tt.setup()
+		}
+		list, err := listForgeRepos(tt.owner)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got %d repos and error %v, want an error with %q", tt.owner, len(list), err, tt.err)
+		}
+	}
+}
+
+func TestListForgeReposToken(t *testing.T) {
+	f := serveForge(t)
+	f.Token = "secret"
+	f.AddRepo("github.com/acme/private", forgetest.Repo{})
+	t.Setenv("GIR_FORGE_TOKEN", "secret")
+	list, err := listForgeRepos("github.com/acme")
+	if err != nil || len(list) != 1 {
+		t.Errorf("got %v, %v; want the repo", list, err)
+	}
+}
+
+func TestListForgeReposLimit(t *testing.T) {
+	f := serveForge(t)
+	for i := 0; i <= maxForgeRepos; i++ {
+		f.AddRepo(fmt.Sprintf("github.com/huge/repo%05d", i), forgetest.Repo{})
+	}
+	list, err := listForgeRepos("github.com/huge")
+	if err == nil || !strings.Contains(err.Error(), "more than") {
+		t.Errorf("got %d repos and error %v, want too many repos", len(list), err)
+	}
+}
+
+// useDiscovered serves the -discover owners from fresh lists, restoring
+// the current ones when t is done.
+func useDiscovered(t *testing.T) {
+	discovered.Lock()
+	oldRepos, oldGone := discovered.repos, discovered.gone
+	oldListed, oldErrs := discovered.listed, discovered.errs
+	discovered.repos = make(map[string]map[string]bool)
+	discovered.gone = make(map[string]map[string]*goneRepo)
+	discovered.listed, discovered.errs = nil, nil
+	discovered.Unlock()
+	t.Cleanup(func() {
+		discovered.Lock()
+		discovered.repos, discovered.gone = oldRepos, oldGone
+		discovered.listed, discovered.errs = oldListed, oldErrs
+		discovered.Unlock()
+	})
+}
+
+// listDiscovered lists the owners on the fake forge as at time now.
+func listDiscovered(t *testing.T, now time.Time, owners ...string) {
+	t.Helper()
+	for _, o := range owners {
+		m, err := discoverOwner(o)
+		if err != nil {
+			t.Fatalf("%s: %v", o, err)
+		}
+		storeDiscovered(o, m, now)
+	}
+}
+
+// resolveWith resolves path with a rule from imp to repo.
+func resolveWith(t *testing.T, imp, repo, path string) *redirector.Resolution {
+	t.Helper()
+	r := &redirector.Rule{Import: imp, Repo: repo}
+	if err := r.Init(); err != nil {
+		t.Fatalf("%s: %v", imp, err)
+	}
+	return redirector.Resolve([]*redirector.Rule{r}, path, time.Now())
+}
+
+func TestDiscoveryRefusal(t *testing.T) {
+	f := serveForge(t)
+	f.AddRepo("github.com/acme/hello", forgetest.Repo{})
+	f.AddRepo("gitlab.com/group/top", forgetest.Repo{})
+	f.AddRepo("gitlab.com/group/sub/nested", forgetest.Repo{Archived: true})
+	useDiscovered(t)
+	listDiscovered(t, time.Now(), "github.com/acme", "gitlab.com/group")
+
+	tests := []struct {
+		imp, repo, path string
+		code            string
+	}{
+		{"example.com/*", "https://github.com/acme/*", "example.com/hello/pkg", ""},
+		{"example.com/*", "https://github.com/acme/*", "example.com/gone", redirector.CodeUpstreamUnverified},
+		{"example.com/*", "https://github.com/other/*", "example.com/gone", ""},
+		{"example.com/*", "https://gitlab.com/group/*", "example.com/top", ""},
+		{"example.com/*/*", "https://gitlab.com/group/*/*", "example.com/sub/nested/pkg", ""},
+		{"example.com/sub/*", "https://gitlab.com/group/sub/*", "example.com/sub/nested", ""},
+		{"example.com/sub/*", "https://gitlab.com/group/sub/*", "example.com/sub/gone", redirector.CodeUpstreamUnverified},
+		{"example.com/sub/*", "https://gitlab.com/group/sub/*", "example.com/sub", ""},
+	}
+	for _, tt := range tests {
+		res := resolveWith(t, tt.imp, tt.repo, tt.path)
+		if got := discoveryRefusal(res, time.Now()); got != tt.code {
+			t.Errorf("%s from %s: refused with %q, want %q", tt.path, res.RepoRoot, got, tt.code)
+		}
+	}
+}
+
+func TestDiscoveryGone(t *testing.T) {
+	old := *goneAfter
+	*goneAfter = 24 * time.Hour
+	t.Cleanup(func() { *goneAfter = old })
+	var mu sync.Mutex
+	var notices []string
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		mu.Lock()
+		notices = append(notices, string(body))
+		mu.Unlock()
+	}))
+	defer hook.Close()
+	oldHook := *goneWebhook
+	*goneWebhook = hook.URL
+	t.Cleanup(func() { *goneWebhook = oldHook })
+
+	f := serveForge(t)
+	f.AddRepo("github.com/acme/kept", forgetest.Repo{})
+	f.AddRepo("github.com/acme/deleted", forgetest.Repo{})
+	f.AddRepo("github.com/acme/archived", forgetest.Repo{})
+	f.AddRepo("github.com/acme/old", forgetest.Repo{Archived: true})
+	useDiscovered(t)
+	start := time.Now()
+	listDiscovered(t, start, "github.com/acme")
+	f.RemoveRepo("github.com/acme/deleted")
+	f.AddRepo("github.com/acme/archived", forgetest.Repo{Archived: true})
+	listDiscovered(t, start.Add(time.Hour), "github.com/acme")
+
+	tests := []struct {
+		name   string
+		after  time.Duration // since start
+		code   string
+		notice bool
+	}{
+		{"kept", 48 * time.Hour, "", false},
+		{"deleted", 2 * time.Hour, "", false},
+		{"deleted", 26 * time.Hour, redirector.CodeGone, true},
+		{"deleted", 27 * time.Hour, redirector.CodeGone, false}, // notified once
+		{"archived", 24 * time.Hour, "", false},
+		{"archived", 25 * time.Hour, redirector.CodeGone, true},
+		{"old", 23 * time.Hour, "", false},
+		{"old", 24 * time.Hour, redirector.CodeGone, true},
+		{"never", time.Hour, redirector.CodeUpstreamUnverified, false},
+	}
+	for _, tt := range tests {
+		res := resolveWith(t, "example.com/*", "https://github.com/acme/*", "example.com/"+tt.name)
+		mu.Lock()
+		notices = nil
+		mu.Unlock()
+		code := discoveryRefusal(res, start.Add(tt.after))
+		if code != tt.code {
+			t.Errorf("%s after %v: refused with %q, want %q", tt.name, tt.after, code, tt.code)
+		}
+		time.Sleep(50 * time.Millisecond) // the notice is posted in the background
+		mu.Lock()
+		if got := len(notices) == 1 && strings.Contains(notices[0], `"import_root":"example.com/`+tt.name+`"`); got != tt.notice {
+			t.Errorf("%s after %v: notices %q, want one: %v", tt.name, tt.after, notices, tt.notice)
+		}
+		mu.Unlock()
+	}
+
+	// A repo listed unarchived again is served again.
+	f.AddRepo("github.com/acme/deleted", forgetest.Repo{})
+	listDiscovered(t, start.Add(48*time.Hour), "github.com/acme")
+	res := resolveWith(t, "example.com/*", "https://github.com/acme/*", "example.com/deleted")
+	if code := discoveryRefusal(res, start.Add(72*time.Hour)); code != "" {
+		t.Errorf("restored repo: refused with %q", code)
+	}
+}
+
+func TestDiscoveryHealth(t *testing.T) {
+	serveConfig(t, testConfig)
+	f := serveForge(t)
+	f.AddRepo("github.com/acme/hello", forgetest.Repo{})
+	f.AddRepo("github.com/acme/world", forgetest.Repo{})
+	useDiscovered(t)
+	old := *discoverOwners
+	*discoverOwners = "github.com/acme,github.com/missing,github.com/unlisted"
+	t.Cleanup(func() { *discoverOwners = old })
+	listDiscovered(t, time.Now(), "github.com/acme")
+	if _, err := discoverOwner("github.com/missing"); err == nil {
+		t.Fatal("listing an unknown owner succeeded")
+	}
+
+	got := currentHealth().Discovery
+	if len(got) != 3 {
+		t.Fatalf("discovery health %+v, want three owners", got)
+	}
+	if d := got[0]; !d.Ready || d.Repos != 2 || d.LastListing == nil || d.Error != "" {
+		t.Errorf("listed owner: %+v", d)
+	}
+	if d := got[1]; d.Ready || d.LastListing == nil || d.Error == "" {
+		t.Errorf("failed owner: %+v", d)
+	}
+	if d := got[2]; d.Ready || d.LastListing != nil || d.Error != "" {
+		t.Errorf("owner not listed yet: %+v", d)
+	}
+
+	// A failed refresh keeps the list, but reports the error.
+	*forgeURL = "http://127.0.0.1:1"
+	if _, err := discoverOwner("github.com/acme"); err == nil {
+		t.Fatal("listing from an unreachable forge succeeded")
+	}
+	if d := currentHealth().Discovery[0]; !d.Ready || d.Repos != 2 || d.Error == "" {
+		t.Errorf("owner failing to refresh: %+v", d)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var egressBudget = flag.Int64("egress-budget", 0, "warn when response bytes in a calendar month exceed `bytes`")
+
+// egressStats accounts response body bytes per import root.
+type egressStats struct {
+	mu     sync.Mutex
+	today  string
+	total  map[dayModule]int64 // per day and import root, for -stats-days
+	roots  moduleSet
+	month  string
+	inMon  int64 // in the current month, all routes
+	warned bool
+}
+
+var egress = &egressStats{total: make(map[dayModule]int64), roots: make(moduleSet)}
+
+// add accounts n bytes sent for the import root.
+func (e *egressStats) add(root string, n int64) {
+	egressBytes.WithLabelValues(metricRoot(root)).Add(float64(n))
+	if root == "" {
+		root = "(unmatched)"
+	}
+	now := time.Now().UTC()
+	day, month := now.Format("2006-01-02"), now.Format("2006-01")
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if day != e.today {
+		e.today = day
+		e.expire(oldestDay(now))
+	}
+	e.total[dayModule{day, e.roots.add(root, day)}] += n
+	if month != e.month {
+		e.month, e.inMon, e.warned = month, 0, false
+	}
+	e.inMon += n
+	if *egressBudget > 0 && e.inMon > *egressBudget && !e.warned {
+		e.warned = true
+		log.Printf("egress budget exceeded: %d bytes sent in %s, budget is %d", e.inMon, month, *egressBudget)
+	}
+}
+
+// expire removes the counts of the days before oldest, returning the
+// number of entries removed. The caller holds e.mu.
+func (e *egressStats) expire(oldest string) int {
+	n := 0
+	for k := range e.total {
+		if k.Day < oldest {
+			delete(e.total, k)
+			n++
+		}
+	}
+	e.roots.expire(oldest)
+	return n
+}
+
+// countingWriter counts the body bytes written through it and
+// records the response status.
+type countingWriter struct {
+	http.ResponseWriter
+	n      int64
+	status int
+}
+
+func (w *countingWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Flush passes flushes on, for streamed responses such as /-/events.
+func (w *countingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the connection.
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// serveEgress serves the egress accounting as JSON, the bytes per route
+// summed over the last -stats-days, of the routes with the ?tag= tags.
+func serveEgress(w http.ResponseWriter, req *http.Request) {
+	egress.mu.Lock()
+	resp := struct {
+		Month      string           `json:"month"`
+		MonthBytes int64            `json:"month_bytes"`
+		Budget     int64            `json:"budget,omitempty"`
+		Routes     map[string]int64 `json:"routes"`
+	}{
+		Month:      egress.month,
+		MonthBytes: egress.inMon,
+		Budget:     *egressBudget,
+		Routes:     make(map[string]int64),
+	}
+	for k, v := range egress.total {
+		resp.Routes[k.Module] += v
+	}
+	egress.mu.Unlock()
+	filterTagged(resp.Routes, queryTags(req))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+var watchConfig = flag.Duration("watch-config", 10*time.Second, "reload the -config or -snapshot file when it changes, checking every `interval` (0 to reload only on SIGHUP or /-/reload)")
+
+// configEnv names the environment variable the rules are read from, if
+// they come from GIR_CONFIG_DATA rather than a file.
+var configEnv string
+
+// envName returns the environment variable setting the flag name.
+func envName(name string) string {
+	return "GIR_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyEnv sets each flag not given on the command line from its GIR_
+// environment variable, if set, except for the chaos- fault injection
+// flags, and reads the rules from GIR_CONFIG_DATA
+// if neither -config nor -snapshot is given.
+func applyEnv() error {
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Name, "chaos-") {
+			return
+		}
+		v, ok := os.LookupEnv(envName(f.Name))
+		if !ok || err != nil || flagSet(f.Name) {
+			return
+		}
+		if e := flag.Set(f.Name, v); e != nil {
+			err = fmt.Errorf("invalid value %q for %s: %v", v, envName(f.Name), e)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if *configFile == "" && *snapshotFile == "" && os.Getenv("GIR_CONFIG_DATA") != "" {
+		configEnv = "GIR_CONFIG_DATA"
+	}
+	return nil
+}
+
+// serveArgs returns the <import> and <repo> arguments: those on the
+// command line, or else GIR_IMPORT and GIR_REPO if set.
+func serveArgs() []string {
+	if flag.NArg() > 0 || configName() != "" {
+		return flag.Args()
+	}
+	imp, repo := os.Getenv("GIR_IMPORT"), os.Getenv("GIR_REPO")
+	if imp == "" && repo == "" {
+		return nil
+	}
+	return []string{imp, repo}
+}
+
+// watchConfigFile reloads the rules whenever the -config or -snapshot
+// file changes, checking every -watch-config. The file is compared by
+// identity as well as size and time, to notice a Kubernetes ConfigMap
+// volume swapping in a new file.
+func watchConfigFile() {
+	name := *configFile
+	if *snapshotFile != "" {
+		name = *snapshotFile
+	}
+	last, _ := os.Stat(name)
+	for range time.Tick(*watchConfig) {
+		fi, err := os.Stat(name)
+		if err != nil {
+			continue // in the middle of being replaced, perhaps
+		}
+		if last != nil && os.SameFile(fi, last) && fi.Size() == last.Size() && fi.ModTime().Equal(last.ModTime()) {
+			continue
+		}
+		last = fi
+		reloadConfig()
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// An event describes one resolved request.
+type event struct {
+	Time   time.Time `json:"time"`
+	Path   string    `json:"path"`
+	Route  string    `json:"route,omitempty"`
+	Status int       `json:"status"`
+}
+
+// eventHub fans resolution events out to the connected /-/events streams.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan event]bool
+}
+
+var events = &eventHub{subs: make(map[chan event]bool)}
+
+// publish sends an event to every subscriber that keeps up; slow
+// subscribers miss events rather than delaying requests.
+func (h *eventHub) publish(path, route string, status int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.subs) == 0 {
+		return
+	}
+	e := event{Time: time.Now().UTC(), Path: path, Route: keepString(route), Status: status}
+	for c := range h.subs {
+		select {
+		case c <- e:
+		default:
+		}
+	}
+}
+
+func (h *eventHub) subscribe() chan event {
+	c := make(chan event, 64)
+	h.mu.Lock()
+	h.subs[c] = true
+	h.mu.Unlock()
+	return c
+}
+
+func (h *eventHub) unsubscribe(c chan event) {
+	h.mu.Lock()
+	delete(h.subs, c)
+	h.mu.Unlock()
+}
+
+// serveEvents streams resolution events as server-sent events until the
+// client disconnects.
+func serveEvents(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	c := events.subscribe()
+	defer events.unsubscribe(c)
+	http.NewResponseController(w).SetWriteDeadline(time.Time{}) // exempt from -write-timeout
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case e := <-c:
+			buf, err := json.Marshal(e)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "event: resolution\ndata: %s\n\n", buf)
+		case <-keepalive.C:
+			fmt.Fprintf(w, ": keepalive\n\n")
+		case <-req.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
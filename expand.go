@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/kastelo/go-import-redirector/redirector"
+)
+
+var profile = flag.String("profile", "", "apply the overrides of the `name`d profile of the -config file")
+
+// varRE matches the ${NAME} references to environment variables in a
+// configuration file, and $${ escaping a literal ${.
+var varRE = regexp.MustCompile(`\$(\$?)\{([^}\n]*)(\}?)`)
+
+// varNameRE matches the names of environment variables.
+var varNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// interpolate replaces the ${NAME} references in buf with the values of
+// the environment variables, and $${ with ${. A reference to an unset
+// variable is an error, so that a configuration does not silently serve
+// empty hosts or tokens.
+func interpolate(buf []byte) ([]byte, error) {
+	var out []byte
+	last := 0
+	for _, m := range varRE.FindAllSubmatchIndex(buf, -1) {
+		out = append(out, buf[last:m[0]]...)
+		last = m[1]
+		line := 1 + bytes.Count(buf[:m[0]], []byte("\n"))
+		if m[3] > m[2] { // $${
+			out = append(out, buf[m[0]+1:m[1]]...)
+			continue
+		}
+		name := string(buf[m[4]:m[5]])
+		switch {
+		case m[7] == m[6]:
+			return nil, fmt.Errorf("line %d: unterminated ${", line)
+		case !varNameRE.MatchString(name):
+			return nil, fmt.Errorf("line %d: invalid variable name %q", line, name)
+		}
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("line %d: undefined variable %s", line, name)
+		}
+		out = append(out, v...)
+	}
+	return append(out, buf[last:]...), nil
+}
+
+// expandConfig decodes buf, the contents of the -config file or of
+// GIR_CONFIG_DATA, with its environment variables interpolated, its
+// included files merged in and the -profile applied.
+func expandConfig(buf []byte) (*config, error) {
+	dir, seen := ".", []string(nil)
+	if *configFile != "" {
+		dir = filepath.Dir(*configFile)
+		if abs, err := filepath.Abs(*configFile); err == nil {
+			seen = append(seen, abs)
+		}
+	}
+	c, err := expandFile(buf, configName(), isTOML(), dir, seen)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.applyProfile(*profile); err != nil {
+		return nil, fmt.Errorf("%s: %v", configName(), err)
+	}
+	return c, nil
+}
+
+// applyProfile applies the overrides of the profile name to c, if name is
+// set: its rules replace those of c with the same import path and are
+// otherwise added, its redirects likewise by from, its certificates
+// replace those of c, and its security headers are set over those of c.
+func (c *config) applyProfile(name string) error {
+	profiles := c.Profiles
+	c.Profiles = nil
+	if name == "" {
+		return nil
+	}
+	p := profiles[name]
+	if p == nil {
+		if len(profiles) == 0 {
+			return fmt.Errorf("-profile %s: no profiles", name)
+		}
+		var names []string
+		for n := range profiles {
+			names = append(names, n)
+		}
+		slices.Sort(names)
+		return fmt.Errorf("-profile %s: no such profile (have %s)", name, strings.Join(names, ", "))
+	}
+	if len(p.Include) > 0 || len(p.Profiles) > 0 {
+		return fmt.Errorf("profile %s: profiles cannot have include or profiles", name)
+	}
+	for _, r := range p.Rules {
+		if i := slices.IndexFunc(c.Rules, func(o *redirector.Rule) bool { return o.Import == r.Import }); i >= 0 {
+			c.Rules[i] = r
+		} else {
+			c.Rules = append(c.Rules, r)
+		}
+	}
+	for _, s := range p.Redirects {
+		if i := slices.IndexFunc(c.Redirects, func(o *shortcut) bool { return o.From == s.From }); i >= 0 {
+			c.Redirects[i] = s
+		} else {
+			c.Redirects = append(c.Redirects, s)
+		}
+	}
+	if len(p.Certificates) > 0 {
+		c.Certificates = p.Certificates
+	}
+	for k, v := range p.SecurityHeaders {
+		if c.SecurityHeaders == nil {
+			c.SecurityHeaders = make(map[string]string)
+		}
+		c.SecurityHeaders[k] = v
+	}
+	return nil
+}
+
+// expandFile decodes buf, the contents of the configuration file name,
+// with its environment variables interpolated and its included files,
+// named relative to dir, merged in. Seen lists the files including it.
+func expandFile(buf []byte, name string, asTOML bool, dir string, seen []string) (*config, error) {
+	buf, err := interpolate(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	c, err := decodeFile(buf, name, asTOML)
+	if err != nil {
+		return nil, err
+	}
+	for _, inc := range c.Include {
+		if !filepath.IsAbs(inc) {
+			inc = filepath.Join(dir, inc)
+		}
+		abs, err := filepath.Abs(inc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: include %s: %v", name, inc, err)
+		}
+		if slices.Contains(seen, abs) {
+			return nil, fmt.Errorf("%s: include %s: includes itself", name, inc)
+		}
+		b, err := os.ReadFile(inc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: include: %v", name, err)
+		}
+		ic, err := expandFile(b, inc, isTOMLFile(inc), filepath.Dir(inc), append(slices.Clip(seen), abs))
+		if err != nil {
+			return nil, err
+		}
+		if len(ic.Profiles) > 0 {
+			return nil, fmt.Errorf("%s: profiles must be in the -config file", inc)
+		}
+		c.merge(ic)
+	}
+	c.Include = nil
+	return c, nil
+}
+
+// merge adds the rules, redirects and certificates of the included
+// configuration ic to c, and the security headers c does not set.
+func (c *config) merge(ic *config) {
+	c.Rules = append(c.Rules, ic.Rules...)
+	c.Redirects = append(c.Redirects, ic.Redirects...)
+	c.Certificates = append(c.Certificates, ic.Certificates...)
+	for k, v := range ic.SecurityHeaders {
+		if _, ok := c.SecurityHeaders[k]; !ok {
+			if c.SecurityHeaders == nil {
+				c.SecurityHeaders = make(map[string]string)
+			}
+			c.SecurityHeaders[k] = v
+		}
+	}
+}
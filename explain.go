@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// serveExplain answers a request with ?__explain=1 with a plain-text
+// account of how it would be served: the shortcut taking precedence, if
+// any, the rules considered and why each was rejected, the resolution,
+// and the policies and redirects applied to it.
+func serveExplain(w http.ResponseWriter, req *http.Request) {
+	path := strings.TrimSuffix(requestHost(req)+req.URL.Path, "/")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if s := findShortcut(req); s != nil {
+		fmt.Fprintf(w, "redirect %s -> %s takes precedence over the rules\n\n", s.From, s.To)
+	}
+	now := time.Now()
+	x := rules.Load()
+	x.Explain(w, path, now)
+	r := resolve(path, now)
+	if r.Status != http.StatusOK {
+		return
+	}
+	if hashed := x.Resolve(path, now).RepoRoot; r.RepoRoot != hashed {
+		fmt.Fprintf(w, "\npinned by -shard-state to %s instead of %s\n", r.RepoRoot, hashed)
+	}
+	if code := refusal(req.Context(), r, false); code != "" {
+		fmt.Fprintf(w, "\nrefused by policy: %d %s (%s)\n", refusalStatus(code), http.StatusText(refusalStatus(code)), code)
+		return
+	}
+	fmt.Fprintf(w, "\nbrowsers: ")
+	switch {
+	case r.MovedTo != "":
+		fmt.Fprintf(w, "301 to the new path https://%s\n", r.MovedTo)
+	case r.Canonical != "":
+		fmt.Fprintf(w, "301 to the canonical path https://%s\n", r.Canonical)
+	case r.BrowserURL() != "":
+		fmt.Fprintf(w, "302 to %s\n", r.BrowserURL())
+	case newData(r, true).Refresh:
+		fmt.Fprintf(w, "the page, redirecting to %s\n", newData(r, true).BrowseURL)
+	default:
+		fmt.Fprintf(w, "the page\n")
+	}
+}
@@ -0,0 +1,273 @@
+// Package forgetest provides a fake forge for exercising the forge
+// integrations of go-import-redirector offline: the repo listings of
+// -discover, the repo descriptions of -check-archived and the CODEOWNERS
+// files of -discover-codeowners. A Forge answers the GitHub, GitLab.com
+// and Gitea (Codeberg) API requests and raw file downloads the redirector
+// makes, as a handler for an httptest.Server or any other server:
+//
+//	f := forgetest.New()
+//	f.AddRepo("github.com/acme/hello", forgetest.Repo{})
+//	srv := httptest.NewServer(f)
+//
+// and the redirector started with -forge-url srv.URL. Requests name the
+// forge's host as the first path element, as -forge-url sends them, such
+// as /api.github.com/users/acme/repos.
+package forgetest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A Repo is a repo on the fake forge.
+type Repo struct {
+	Archived      bool
+	DefaultBranch string            // "main" if empty
+	Files         map[string]string // contents by path, such as CODEOWNERS
+}
+
+// A Forge is a fake GitHub, GitLab.com and Codeberg, holding the repos
+// added to it. It is safe for concurrent use.
+type Forge struct {
+	// Token, if set, is the bearer token the API requires; other API
+	// requests are answered with 401 Unauthorized.
+	Token string
+
+	// RateLimit, if positive, is the number of API requests answered;
+	// later ones are refused as GitHub refuses them once the rate limit
+	// is used up, with 403 Forbidden and X-RateLimit-Remaining: 0.
+	RateLimit int
+
+	mu          sync.Mutex
+	repos       map[string]*Repo // by host/owner/name
+	users       map[string]bool  // owners that are users, by host/owner
+	requests    int
+	apiRequests int
+}
+
+// New returns a Forge without repos.
+func New() *Forge {
+	return &Forge{repos: make(map[string]*Repo), users: make(map[string]bool)}
+}
+
+// AddUser makes owner, host/owner such as github.com/jdoe, a user rather
+// than an organization: GitHub and Codeberg list its repos under /users
+// only, answering /orgs with 404 Not Found.
+func (f *Forge) AddUser(owner string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.users[owner] = true
+}
+
+// AddRepo adds or replaces the repo at path, host/owner/name such as
+// github.com/acme/hello; GitLab owners may be subgroups, as in
+// gitlab.com/acme/tools/hello.
+func (f *Forge) AddRepo(path string, r Repo) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.repos[path] = &r
+}
+
+// RemoveRepo removes the repo at path.
+func (f *Forge) RemoveRepo(path string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.repos, path)
+}
+
+// Requests returns the number of requests served so far.
+func (f *Forge) Requests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+func (f *Forge) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+	host, rest, _ := strings.Cut(strings.TrimPrefix(req.URL.EscapedPath(), "/"), "/")
+	api := strings.HasPrefix(host, "api.") || strings.HasPrefix(rest, "api/")
+	if api && f.Token != "" && req.Header.Get("Authorization") != "Bearer "+f.Token {
+		http.Error(w, `{"message":"Bad credentials"}`, http.StatusUnauthorized)
+		return
+	}
+	if api {
+		f.apiRequests++
+		if f.RateLimit > 0 && f.apiRequests > f.RateLimit {
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(f.RateLimit))
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			http.Error(w, `{"message":"API rate limit exceeded"}`, http.StatusForbidden)
+			return
+		}
+	}
+	switch host {
+	case "api.github.com":
+		f.github(w, req, rest)
+	case "raw.githubusercontent.com":
+		// owner/name/ref/path
+		if p := strings.SplitN(rest, "/", 4); len(p) == 4 {
+			f.serveFile(w, "github.com/"+p[0]+"/"+p[1], p[2], p[3])
+			return
+		}
+		http.NotFound(w, req)
+	case "gitlab.com":
+		f.gitlab(w, req, rest)
+	case "codeberg.org":
+		f.gitea(w, req, rest)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (f *Forge) github(w http.ResponseWriter, req *http.Request, rest string) {
+	p := strings.Split(rest, "/")
+	switch {
+	case len(p) == 3 && p[0] == "repos":
+		path := "github.com/" + p[1] + "/" + p[2]
+		if r := f.repos[path]; r != nil {
+			writeJSON(w, describe(path, r))
+			return
+		}
+	case len(p) == 3 && (p[0] == "users" || p[0] == "orgs" && !f.users["github.com/"+p[1]]) && p[2] == "repos":
+		f.list(w, req, "github.com/"+p[1], false, 30)
+		return
+	}
+	http.NotFound(w, req)
+}
+
+func (f *Forge) gitlab(w http.ResponseWriter, req *http.Request, rest string) {
+	switch {
+	case strings.HasPrefix(rest, "api/v4/projects/"):
+		// The project is a single escaped path element.
+		id, err := url.PathUnescape(strings.TrimPrefix(rest, "api/v4/projects/"))
+		path := "gitlab.com/" + id
+		if r := f.repos[path]; err == nil && r != nil {
+			writeJSON(w, describe(path, r))
+			return
+		}
+	case strings.HasPrefix(rest, "api/v4/groups/") && strings.HasSuffix(rest, "/projects"):
+		id, err := url.PathUnescape(strings.TrimSuffix(strings.TrimPrefix(rest, "api/v4/groups/"), "/projects"))
+		if err == nil {
+			f.list(w, req, "gitlab.com/"+id, req.URL.Query().Get("include_subgroups") == "true", 20)
+			return
+		}
+	default:
+		// owner/name/-/raw/ref/path, the owner possibly a subgroup
+		if repo, file, ok := strings.Cut(rest, "/-/raw/"); ok {
+			if ref, p, ok := strings.Cut(file, "/"); ok {
+				f.serveFile(w, "gitlab.com/"+repo, ref, p)
+				return
+			}
+		}
+	}
+	http.NotFound(w, req)
+}
+
+func (f *Forge) gitea(w http.ResponseWriter, req *http.Request, rest string) {
+	p := strings.Split(rest, "/")
+	switch {
+	case len(p) == 5 && p[0] == "api" && p[1] == "v1" && p[2] == "repos":
+		path := "codeberg.org/" + p[3] + "/" + p[4]
+		if r := f.repos[path]; r != nil {
+			writeJSON(w, describe(path, r))
+			return
+		}
+	case len(p) == 5 && p[0] == "api" && p[1] == "v1" && (p[2] == "users" || p[2] == "orgs" && !f.users["codeberg.org/"+p[3]]) && p[4] == "repos":
+		f.list(w, req, "codeberg.org/"+p[3], false, 30)
+		return
+	case len(p) >= 6 && p[2] == "raw" && p[3] == "branch":
+		// owner/name/raw/branch/ref/path
+		f.serveFile(w, "codeberg.org/"+p[0]+"/"+p[1], p[4], strings.Join(p[5:], "/"))
+		return
+	}
+	http.NotFound(w, req)
+}
+
+// describe returns the API description of the repo at path: the fields
+// of GitHub, GitLab and Gitea that the redirector reads, and their names.
+func describe(path string, r *Repo) map[string]any {
+	_, full, _ := strings.Cut(path, "/")
+	name := full[strings.LastIndexByte(full, '/')+1:]
+	return map[string]any{
+		"name":                name,
+		"path":                name,
+		"full_name":           full,
+		"path_with_namespace": full,
+		"archived":            r.Archived,
+		"default_branch":      branch(r),
+	}
+}
+
+// list writes the page of the ?page= and ?per_page= parameters of the
+// repos of owner, sorted by name, with a Link header to the next page.
+// Unless subgroups is set, only the repos directly under owner are
+// listed.
+func (f *Forge) list(w http.ResponseWriter, req *http.Request, owner string, subgroups bool, perPage int) {
+	var paths []string
+	for p := range f.repos {
+		name, ok := strings.CutPrefix(p, owner+"/")
+		if ok && (subgroups || !strings.Contains(name, "/")) {
+			paths = append(paths, p)
+		}
+	}
+	if len(paths) == 0 {
+		http.NotFound(w, req)
+		return
+	}
+	slices.Sort(paths)
+	q := req.URL.Query()
+	if n, err := strconv.Atoi(q.Get("per_page")); err == nil && n > 0 {
+		perPage = min(n, 100)
+	}
+	page := 1
+	if n, err := strconv.Atoi(q.Get("page")); err == nil && n > 0 {
+		page = n
+	}
+	start := min((page-1)*perPage, len(paths))
+	end := min(start+perPage, len(paths))
+	if end < len(paths) {
+		q.Set("page", strconv.Itoa(page+1))
+		next := url.URL{Scheme: "http", Host: req.Host, Path: req.URL.Path, RawPath: req.URL.RawPath, RawQuery: q.Encode()}
+		w.Header().Set("Link", "<"+next.String()+`>; rel="next"`)
+	}
+	list := []map[string]any{}
+	for _, p := range paths[start:end] {
+		list = append(list, describe(p, f.repos[p]))
+	}
+	writeJSON(w, list)
+}
+
+// serveFile writes the file at path in the repo at repo, on the branch
+// ref or HEAD.
+func (f *Forge) serveFile(w http.ResponseWriter, repo, ref, path string) {
+	r := f.repos[repo]
+	if r == nil || ref != "HEAD" && ref != branch(r) {
+		http.Error(w, "404: Not Found", http.StatusNotFound)
+		return
+	}
+	data, ok := r.Files[path]
+	if !ok {
+		http.Error(w, "404: Not Found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(data))
+}
+
+func branch(r *Repo) string {
+	if r.DefaultBranch == "" {
+		return "main"
+	}
+	return r.DefaultBranch
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+var (
+	geoIPFile = flag.String("geoip-db", "", "add country and ASN to usage statistics and the access log, and country to the metrics, from MMDB `file`")
+	geoIPDB   *maxminddb.Reader
+)
+
+// geoRecord holds the fields we use from GeoLite2/GeoIP2 Country, City
+// and ASN databases. Any of them may be absent depending on the database.
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	ASN   uint   `maxminddb:"autonomous_system_number"`
+	ASOrg string `maxminddb:"autonomous_system_organization"`
+}
+
+// openGeoIP opens the -geoip-db database.
+func openGeoIP() error {
+	if *geoIPFile == "" {
+		return nil
+	}
+	db, err := maxminddb.Open(*geoIPFile)
+	if err != nil {
+		return err
+	}
+	geoIPDB = db
+	return nil
+}
+
+// metricCountry returns the country label of the metrics for the client
+// address: its ISO country code, or "unknown". The codes are a small fixed
+// set, which bounds the label values.
+func metricCountry(remoteAddr string) string {
+	country, _ := geoLookup(remoteAddr)
+	if len(country) != 2 || country[0] < 'A' || country[0] > 'Z' || country[1] < 'A' || country[1] > 'Z' {
+		return "unknown"
+	}
+	return country
+}
+
+// geoLookup returns the country code and the autonomous system ("AS123 Org")
+// of the client address, or empty strings if unknown.
+func geoLookup(remoteAddr string) (country, as string) {
+	if geoIPDB == nil {
+		return "", ""
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", ""
+	}
+	var rec geoRecord
+	if err := geoIPDB.Lookup(ip, &rec); err != nil {
+		return "", ""
+	}
+	if rec.ASN != 0 {
+		as = fmt.Sprintf("AS%d", rec.ASN)
+		if rec.ASOrg != "" {
+			as += " " + rec.ASOrg
+		}
+	}
+	return rec.Country.ISOCode, as
+}
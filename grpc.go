@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"github.com/kastelo/go-import-redirector/redirector"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	_ "google.golang.org/protobuf/types/known/structpb" // for google/protobuf/struct.proto
+)
+
+var grpcAddr = flag.String("grpc-addr", "", "also serve the administrative API over gRPC on `address`")
+
+// grpcServer is the server of -grpc-addr once it is serving.
+var grpcServer atomic.Pointer[grpc.Server]
+
+// The gRPC admin service is described in admin.proto. Rather than being
+// generated, its messages are built at run time from the descriptor below
+// and converted to and from the JSON types of the REST endpoints, so both
+// APIs share one implementation.
+
+const adminService = "goimportredirector.admin.v1.Admin"
+
+var adminFile = &descriptorpb.FileDescriptorProto{
+	Name:       proto.String("admin.proto"),
+	Package:    proto.String("goimportredirector.admin.v1"),
+	Dependency: []string{"google/protobuf/struct.proto"},
+	Syntax:     proto.String("proto3"),
+	MessageType: []*descriptorpb.DescriptorProto{
+		protoMessage("SimulateRequest",
+			protoField(1, "host", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+			protoField(2, "path", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+			protoField(3, "offline", descriptorpb.FieldDescriptorProto_TYPE_BOOL, false),
+		),
+		protoMessage("SimulateResponse",
+			protoField(1, "path", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+			protoField(2, "status", descriptorpb.FieldDescriptorProto_TYPE_INT32, false),
+			protoField(3, "route", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+			protoField(4, "captures", descriptorpb.FieldDescriptorProto_TYPE_STRING, true),
+			protoField(5, "import_root", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+			protoField(6, "repo_root", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+			protoField(7, "suffix", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+			protoField(8, "location", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+			protoField(9, "in_overlap", descriptorpb.FieldDescriptorProto_TYPE_BOOL, false),
+			protoField(10, "tags", descriptorpb.FieldDescriptorProto_TYPE_STRING, true),
+			protoField(11, "redirect", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+			protoField(12, "error", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+			protoField(13, "code", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+			protoField(14, "subdir", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+			protoField(15, "vcs", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+			protoField(16, "browse_url", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+			protoField(17, "canonical", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+			protoField(18, "moved_to", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+			protoField(19, "expr_error", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+		),
+		protoMessage("ReloadRequest"),
+		protoMessage("ReloadResponse",
+			protoField(1, "rules", descriptorpb.FieldDescriptorProto_TYPE_INT32, false),
+		),
+		protoMessage("ListRulesRequest",
+			protoField(1, "tags", descriptorpb.FieldDescriptorProto_TYPE_STRING, true),
+		),
+		protoMessage("ListRulesResponse",
+			protoMessageField(1, "rules", ".google.protobuf.Struct", true),
+		),
+		protoMessage("RuleRequest",
+			protoField(1, "import_path", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+		),
+		protoMessage("ReplaceRuleRequest",
+			protoField(1, "import_path", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+			protoMessageField(2, "rule", ".google.protobuf.Struct", false),
+		),
+		protoMessage("DeleteRuleResponse"),
+		protoMessage("ListCertsRequest"),
+		protoMessage("CertRequest",
+			protoField(1, "host", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+		),
+		protoMessage("Cert",
+			protoField(1, "source", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+			protoField(2, "file", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+			protoField(3, "hosts", descriptorpb.FieldDescriptorProto_TYPE_STRING, true),
+			protoField(4, "dns_names", descriptorpb.FieldDescriptorProto_TYPE_STRING, true),
+			protoField(5, "issuer", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+			protoField(6, "not_after", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+			protoField(7, "days_left", descriptorpb.FieldDescriptorProto_TYPE_INT32, false),
+			protoField(8, "renewal", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+			protoField(9, "status", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+			protoField(10, "chain_error", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+		),
+		protoMessage("CertsResponse",
+			protoMessageField(1, "certs", ".goimportredirector.admin.v1.Cert", true),
+		),
+		protoMessage("PruneRequest",
+			protoField(1, "targets", descriptorpb.FieldDescriptorProto_TYPE_STRING, true),
+		),
+		protoMap(protoMessage("PruneResponse",
+			protoMessageField(1, "removed", ".goimportredirector.admin.v1.PruneResponse.RemovedEntry", true),
+		), "RemovedEntry", descriptorpb.FieldDescriptorProto_TYPE_INT32),
+	},
+	Service: []*descriptorpb.ServiceDescriptorProto{{
+		Name: proto.String("Admin"),
+		Method: []*descriptorpb.MethodDescriptorProto{
+			protoMethod("Simulate", "SimulateRequest", "SimulateResponse"),
+			protoMethod("Reload", "ReloadRequest", "ReloadResponse"),
+			protoMethod("ListRules", "ListRulesRequest", "ListRulesResponse"),
+			protoMethod("GetRule", "RuleRequest", ".google.protobuf.Struct"),
+			protoMethod("CreateRule", ".google.protobuf.Struct", ".google.protobuf.Struct"),
+			protoMethod("ReplaceRule", "ReplaceRuleRequest", ".google.protobuf.Struct"),
+			protoMethod("DeleteRule", "RuleRequest", "DeleteRuleResponse"),
+			protoMethod("ListCerts", "ListCertsRequest", "CertsResponse"),
+			protoMethod("RenewCert", "CertRequest", "CertsResponse"),
+			protoMethod("RevokeCert", "CertRequest", "CertsResponse"),
+			protoMethod("Prune", "PruneRequest", "PruneResponse"),
+		},
+	}},
+}
+
+func protoMessage(name string, fields ...*descriptorpb.FieldDescriptorProto) *descriptorpb.DescriptorProto {
+	return &descriptorpb.DescriptorProto{Name: proto.String(name), Field: fields}
+}
+
+func protoField(num int32, name string, typ descriptorpb.FieldDescriptorProto_Type, repeated bool) *descriptorpb.FieldDescriptorProto {
+	label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	if repeated {
+		label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	}
+	return &descriptorpb.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(num), Type: typ.Enum(), Label: label.Enum()}
+}
+
+func protoMessageField(num int32, name, typeName string, repeated bool) *descriptorpb.FieldDescriptorProto {
+	f := protoField(num, name, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, repeated)
+	f.TypeName = proto.String(typeName)
+	return f
+}
+
+// protoMap adds to m the entry message of a map from strings to values of
+// type typ, as protoc generates for a map field.
+func protoMap(m *descriptorpb.DescriptorProto, entry string, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.DescriptorProto {
+	e := protoMessage(entry,
+		protoField(1, "key", descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+		protoField(2, "value", typ, false),
+	)
+	e.Options = &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)}
+	m.NestedType = append(m.NestedType, e)
+	return m
+}
+
+// protoMethod describes a method taking and returning the named messages
+// of the admin package, or fully qualified ones.
+func protoMethod(name, in, out string) *descriptorpb.MethodDescriptorProto {
+	qualify := func(msg string) *string {
+		if !strings.HasPrefix(msg, ".") {
+			msg = ".goimportredirector.admin.v1." + msg
+		}
+		return proto.String(msg)
+	}
+	return &descriptorpb.MethodDescriptorProto{Name: proto.String(name), InputType: qualify(in), OutputType: qualify(out)}
+}
+
+// serveGRPC serves the admin service and gRPC server reflection on -grpc-addr,
+// with the served certificate if -tls or -autocert is set.
+func serveGRPC() {
+	fd, err := protodesc.NewFile(adminFile, protoregistry.GlobalFiles)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := protoregistry.GlobalFiles.RegisterFile(fd); err != nil {
+		log.Fatal(err)
+	}
+	sd := fd.Services().ByName("Admin")
+
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(grpcAdminOnly), grpc.StreamInterceptor(grpcAdminOnlyStream)}
+	if tlsEnabled() {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(&tls.Config{GetCertificate: servedCertificate})))
+	}
+	s := grpc.NewServer(opts...)
+	s.RegisterService(&grpc.ServiceDesc{
+		ServiceName: adminService,
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{
+			grpcUnary(sd, "Simulate", func(_ context.Context, req struct {
+				Host    string `json:"host"`
+				Path    string `json:"path"`
+				Offline bool   `json:"offline"`
+			}) (any, error) {
+				return simulate(req.Host, req.Path, req.Offline), nil
+			}),
+			grpcUnary(sd, "Reload", func(context.Context, struct{}) (any, error) {
+				if configName() == "" {
+					return nil, status.Error(codes.FailedPrecondition, "no -config file to reload")
+				}
+				if err := reloadRules(); err != nil {
+					return nil, status.Error(codes.FailedPrecondition, err.Error())
+				}
+				log.Printf("reloaded %s: %d rules", configName(), len(currentRules()))
+				return struct {
+					Rules int `json:"rules"`
+				}{len(currentRules())}, nil
+			}),
+			grpcUnary(sd, "ListRules", func(_ context.Context, req struct {
+				Tags []string `json:"tags"`
+			}) (any, error) {
+				if *configFile == "" {
+					return nil, errNoConfigFile
+				}
+				rs, err := configuredRules()
+				if err != nil {
+					return nil, grpcRuleError(err)
+				}
+				return struct {
+					Rules []*redirector.Rule `json:"rules"`
+				}{taggedRules(rs, req.Tags)}, nil
+			}),
+			grpcUnary(sd, "GetRule", func(_ context.Context, req struct {
+				ImportPath string `json:"import_path"`
+			}) (any, error) {
+				if *configFile == "" {
+					return nil, errNoConfigFile
+				}
+				r, err := configuredRule(req.ImportPath)
+				if err != nil {
+					return nil, grpcRuleError(err)
+				}
+				return r, nil
+			}),
+			grpcUnary(sd, "CreateRule", func(_ context.Context, rule json.RawMessage) (any, error) {
+				if *configFile == "" {
+					return nil, errNoConfigFile
+				}
+				r, err := parseRule(bytes.NewReader(rule), "")
+				if err == nil {
+					err = addRule(r)
+				}
+				if err != nil {
+					return nil, grpcRuleError(err)
+				}
+				return r, nil
+			}),
+			grpcUnary(sd, "ReplaceRule", func(_ context.Context, req struct {
+				ImportPath string          `json:"import_path"`
+				Rule       json.RawMessage `json:"rule"`
+			}) (any, error) {
+				if *configFile == "" {
+					return nil, errNoConfigFile
+				}
+				r, err := parseRule(bytes.NewReader(req.Rule), req.ImportPath)
+				if err == nil {
+					err = replaceRule(req.ImportPath, r)
+				}
+				if err != nil {
+					return nil, grpcRuleError(err)
+				}
+				return r, nil
+			}),
+			grpcUnary(sd, "DeleteRule", func(_ context.Context, req struct {
+				ImportPath string `json:"import_path"`
+			}) (any, error) {
+				if *configFile == "" {
+					return nil, errNoConfigFile
+				}
+				if err := deleteRule(req.ImportPath); err != nil {
+					return nil, grpcRuleError(err)
+				}
+				return struct{}{}, nil
+			}),
+			grpcUnary(sd, "ListCerts", func(ctx context.Context, _ struct{}) (any, error) {
+				return grpcCerts(ctx), nil
+			}),
+			grpcUnary(sd, "RenewCert", func(ctx context.Context, req struct {
+				Host string `json:"host"`
+			}) (any, error) {
+				if err := renewCert(ctx, req.Host); err != nil {
+					return nil, status.Error(codes.InvalidArgument, err.Error())
+				}
+				return grpcCerts(ctx), nil
+			}),
+			grpcUnary(sd, "RevokeCert", func(ctx context.Context, req struct {
+				Host string `json:"host"`
+			}) (any, error) {
+				if err := revokeCert(ctx, req.Host); err != nil {
+					return nil, status.Error(codes.InvalidArgument, err.Error())
+				}
+				return grpcCerts(ctx), nil
+			}),
+			grpcUnary(sd, "Prune", func(_ context.Context, req struct {
+				Targets []string `json:"targets"`
+			}) (any, error) {
+				targets := pruneTargets
+				if len(req.Targets) > 0 {
+					targets = req.Targets
+				}
+				removed, err := prune(targets)
+				if err != nil {
+					return nil, status.Error(codes.InvalidArgument, err.Error())
+				}
+				log.Printf("pruned %v", removed)
+				return struct {
+					Removed map[string]int `json:"removed"`
+				}{removed}, nil
+			}),
+		},
+		Metadata: "admin.proto",
+	}, struct{}{})
+	reflection.Register(s)
+
+	ln, err := net.Listen("tcp", *grpcAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	grpcServer.Store(s)
+	if err := s.Serve(ln); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// stopGRPC stops the -grpc-addr server on shutdown, letting the calls in
+// flight finish until ctx is done.
+func stopGRPC(ctx context.Context) {
+	s := grpcServer.Load()
+	if s == nil {
+		return
+	}
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.Stop()
+	}
+}
+
+// errNoConfigFile answers the rule methods without a -config file to
+// change.
+var errNoConfigFile = status.Error(codes.FailedPrecondition, "no -config file")
+
+// grpcRuleError returns the status of a failed rule method, as serveRules
+// chooses the HTTP status.
+func grpcRuleError(err error) error {
+	switch {
+	case errors.Is(err, errNoRule):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, errRuleExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	}
+	return status.Error(codes.InvalidArgument, err.Error())
+}
+
+// grpcCerts returns the certificate inventory as a CertsResponse.
+func grpcCerts(ctx context.Context) any {
+	return struct {
+		Certs []*certEntry `json:"certs"`
+	}{certInventory(ctx)}
+}
+
+// grpcUnary returns the gRPC method of sd named method, calling fn with the
+// request decoded into Req and encoding its result as the output message,
+// by way of their JSON forms.
+func grpcUnary[Req any](sd protoreflect.ServiceDescriptor, method string, fn func(context.Context, Req) (any, error)) grpc.MethodDesc {
+	md := sd.Methods().ByName(protoreflect.Name(method))
+	in, out := md.Input(), md.Output()
+	call := func(ctx context.Context, m any) (any, error) {
+		gen := holdMapping()
+		defer releaseMapping(gen)
+		var req Req
+		buf, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(m.(proto.Message))
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(buf, &req); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		result, err := fn(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		buf, err = json.Marshal(result)
+		if err != nil {
+			return nil, err
+		}
+		resp := dynamicpb.NewMessage(out)
+		if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(buf, resp); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+	handler := func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+		m := dynamicpb.NewMessage(in)
+		if err := dec(m); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(ctx, m)
+		}
+		return interceptor(ctx, m, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + adminService + "/" + method}, call)
+	}
+	return grpc.MethodDesc{MethodName: method, Handler: handler}
+}
+
+// grpcAdminOnly is the gRPC counterpart of adminOnly: calls must carry the
+// admin bearer token in their authorization metadata.
+func grpcAdminOnly(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := grpcAuthorize(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// grpcAdminOnlyStream checks streaming calls, those of server reflection,
+// as grpcAdminOnly does unary ones.
+func grpcAdminOnlyStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := grpcAuthorize(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// grpcAuthorize checks the admin bearer token in the metadata of ctx.
+func grpcAuthorize(ctx context.Context) error {
+	if adminSecret() == "" {
+		return status.Error(codes.Unimplemented, "administrative API disabled")
+	}
+	var auth string
+	if v := metadata.ValueFromIncomingContext(ctx, "authorization"); len(v) > 0 {
+		auth = v[0]
+	}
+	if !adminAuthorized(auth) {
+		return status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	return nil
+}
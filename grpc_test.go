@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+var (
+	protoMessageRE = regexp.MustCompile(`^message (\w+) \{(\})?$`)
+	protoFieldRE   = regexp.MustCompile(`^\s+(repeated )?(map<string, (\w+)>|[\w.]+) (\w+) = (\d+);`)
+	protoRPCRE     = regexp.MustCompile(`^\s+rpc (\w+)\(([\w.]+)\) returns \(([\w.]+)\);`)
+)
+
+// protoTypes are the types of admin.proto fields by descriptor type.
+var protoTypes = map[descriptorpb.FieldDescriptorProto_Type]string{
+	descriptorpb.FieldDescriptorProto_TYPE_STRING: "string",
+	descriptorpb.FieldDescriptorProto_TYPE_INT32:  "int32",
+	descriptorpb.FieldDescriptorProto_TYPE_BOOL:   "bool",
+}
+
+// protoTypeName returns the admin.proto spelling of the message type name
+// of the descriptor.
+func protoTypeName(name string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(name, "."), "goimportredirector.admin.v1.")
+}
+
+// describeDescriptor describes the messages and methods of adminFile, one
+// per line, as describeProto does admin.proto.
+func describeDescriptor() []string {
+	var lines []string
+	for _, m := range adminFile.MessageType {
+		lines = append(lines, "message "+m.GetName())
+		for _, f := range m.Field {
+			typ := protoTypes[f.GetType()]
+			if f.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+				typ = protoTypeName(f.GetTypeName())
+			}
+			for _, e := range m.NestedType {
+				if e.GetOptions().GetMapEntry() && typ == m.GetName()+"."+e.GetName() {
+					typ = "map<string, " + protoTypes[e.Field[1].GetType()] + ">"
+				}
+			}
+			if f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED && !strings.HasPrefix(typ, "map<") {
+				typ = "repeated " + typ
+			}
+			lines = append(lines, fmt.Sprintf("  %s %s = %d", typ, f.GetName(), f.GetNumber()))
+		}
+	}
+	for _, s := range adminFile.Service {
+		for _, m := range s.Method {
+			lines = append(lines, fmt.Sprintf("rpc %s %s.%s(%s) %s", adminFile.GetPackage(), s.GetName(), m.GetName(), protoTypeName(m.GetInputType()), protoTypeName(m.GetOutputType())))
+		}
+	}
+	return lines
+}
+
+// describeProto describes the messages and methods of admin.proto.
+func describeProto(t *testing.T) []string {
+	f, err := os.Open("admin.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines, rpcs []string
+	var pkg, service string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if p, ok := strings.CutPrefix(line, "package "); ok {
+			pkg = strings.TrimSuffix(p, ";")
+		} else if s, ok := strings.CutPrefix(line, "service "); ok {
+			service = strings.TrimSuffix(s, " {")
+		} else if m := protoMessageRE.FindStringSubmatch(line); m != nil {
+			lines = append(lines, "message "+m[1])
+		} else if m := protoRPCRE.FindStringSubmatch(line); m != nil {
+			rpcs = append(rpcs, fmt.Sprintf("rpc %s %s.%s(%s) %s", pkg, service, m[1], protoTypeName(m[2]), protoTypeName(m[3])))
+		} else if m := protoFieldRE.FindStringSubmatch(line); m != nil {
+			lines = append(lines, fmt.Sprintf("  %s%s %s = %s", m[1], m[2], m[4], m[5]))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return append(lines, rpcs...)
+}
+
+// TestAdminProto checks that admin.proto and the descriptor the gRPC
+// server is built from describe the same messages and methods.
+func TestAdminProto(t *testing.T) {
+	want, got := describeProto(t), describeDescriptor()
+	if !slices.Equal(got, want) {
+		t.Errorf("the descriptor in grpc.go differs from admin.proto:\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if fmt.Sprintf("%s.%s", adminFile.GetPackage(), adminFile.Service[0].GetName()) != adminService {
+		t.Errorf("service %s.%s, want %s", adminFile.GetPackage(), adminFile.Service[0].GetName(), adminService)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// defaultSecurityHeaders are sent with the responses of the redirect,
+// index and ping handlers, along with the -referrer-policy, unless the
+// security_headers of the -config file change them. The pages only have
+// inline styles and scripts of their own.
+var defaultSecurityHeaders = map[string]string{
+	"Content-Security-Policy": "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; img-src 'self' data:; base-uri 'none'; form-action 'none'; frame-ancestors 'none'",
+	"X-Content-Type-Options":  "nosniff",
+	"X-Frame-Options":         "DENY",
+}
+
+// securityHeaders holds the security headers in effect.
+var securityHeaders atomic.Pointer[http.Header]
+
+// checkSecurityHeaders validates the security_headers of a -config file.
+func checkSecurityHeaders(m map[string]string) error {
+	for k, v := range m {
+		if !httpguts.ValidHeaderFieldName(k) {
+			return fmt.Errorf("invalid security header name %q", k)
+		}
+		if !httpguts.ValidHeaderFieldValue(v) {
+			return fmt.Errorf("invalid value for security header %s", k)
+		}
+	}
+	return nil
+}
+
+// storeSecurityHeaders puts in effect the default security headers as
+// changed by m, where an empty value removes a header.
+func storeSecurityHeaders(m map[string]string) {
+	h := make(http.Header)
+	for k, v := range defaultSecurityHeaders {
+		h.Set(k, v)
+	}
+	if *referrerPolicy != "" {
+		h.Set("Referrer-Policy", *referrerPolicy)
+	}
+	for k, v := range m {
+		if v == "" {
+			h.Del(k)
+		} else {
+			h.Set(k, v)
+		}
+	}
+	securityHeaders.Store(&h)
+}
+
+// withSecurityHeaders wraps h to send the security headers.
+func withSecurityHeaders(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if sh := securityHeaders.Load(); sh != nil {
+			for k, v := range *sh {
+				w.Header()[k] = v
+			}
+		}
+		h(w, req)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	startTime    = time.Now()
+	shuttingDown atomic.Bool
+)
+
+// lastReload records the outcome of the last configuration reload.
+var lastReload struct {
+	sync.Mutex
+	time time.Time
+	err  error
+}
+
+type certExpiry struct {
+	Host     string    `json:"host"`
+	NotAfter time.Time `json:"not_after"`
+	DaysLeft int       `json:"days_left"`
+}
+
+// A discoverHealth is the state of the repo list of a -discover owner.
+type discoverHealth struct {
+	Owner       string     `json:"owner"`
+	Ready       bool       `json:"ready"` // a repo list is served
+	Repos       int        `json:"repos"`
+	LastListing *time.Time `json:"last_listing,omitempty"`
+	Error       string     `json:"error,omitempty"` // of the last listing
+}
+
+type health struct {
+	Status        string           `json:"status"` // ok or shutting_down
+	UptimeSeconds int64            `json:"uptime_seconds"`
+	Rules         int              `json:"rules"`
+	LastReload    *time.Time       `json:"last_reload,omitempty"`
+	ReloadError   string           `json:"reload_error,omitempty"`
+	Certificates  []certExpiry     `json:"certificates,omitempty"`
+	Discovery     []discoverHealth `json:"discovery,omitempty"`
+}
+
+// currentHealth describes the state of the process.
+func currentHealth() *health {
+	h := &health{
+		Status:        "ok",
+		UptimeSeconds: int64(time.Since(startTime).Seconds()),
+		Rules:         len(currentRules()),
+	}
+	if shuttingDown.Load() {
+		h.Status = "shutting_down"
+	}
+	lastReload.Lock()
+	if !lastReload.time.IsZero() {
+		t := lastReload.time
+		h.LastReload = &t
+		if lastReload.err != nil {
+			h.ReloadError = lastReload.err.Error()
+		}
+	}
+	lastReload.Unlock()
+	discovered.RLock()
+	for _, o := range discoverList() {
+		d := discoverHealth{Owner: o}
+		repos, ok := discovered.repos[o]
+		d.Ready, d.Repos = ok, len(repos)
+		if t, ok := discovered.listed[o]; ok {
+			d.LastListing = &t
+		}
+		if err := discovered.errs[o]; err != nil {
+			d.Error = err.Error()
+		}
+		h.Discovery = append(h.Discovery, d)
+	}
+	discovered.RUnlock()
+	if m := certManager.Load(); m != nil || len(tlsCerts) > 0 {
+		for _, host := range importHosts() {
+			var leaf *x509.Certificate
+			var err error
+			if m != nil {
+				leaf, err = cachedCert(context.Background(), m, host)
+			} else {
+				sc, _ := certFor(host)
+				leaf, err = x509.ParseCertificate(sc.cert.Load().Certificate[0])
+			}
+			if err != nil {
+				continue
+			}
+			h.Certificates = append(h.Certificates, certExpiry{
+				Host:     host,
+				NotAfter: leaf.NotAfter,
+				DaysLeft: int(time.Until(leaf.NotAfter).Hours() / 24),
+			})
+		}
+	}
+	return h
+}
+
+// serveHealthz reports that the process is alive, with its state.
+func serveHealthz(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(currentHealth())
+}
+
+// serveReadyz is like serveHealthz, but answers 503 Service Unavailable
+// once the server is shutting down, so that load balancers stop sending
+// it requests.
+func serveReadyz(w http.ResponseWriter, req *http.Request) {
+	h := currentHealth()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if h.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(h)
+}
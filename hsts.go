@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	httpRedirect = flag.String("http-redirect", "", "with -tls, also listen on `address` and redirect plain HTTP requests to HTTPS")
+	hsts         = flag.Duration("hsts", 0, "send Strict-Transport-Security with this max-age on HTTPS responses (0 to not send it)")
+)
+
+// serveHTTPRedirect listens on the -http-redirect address and answers
+// every request with 301 Moved Permanently to the same URL on HTTPS at
+// tlsAddr.
+func serveHTTPRedirect(tlsAddr string) {
+	port := ""
+	if _, p, err := net.SplitHostPort(tlsAddr); err == nil && p != "https" && p != "443" {
+		port = ":" + p
+	}
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host, _, err := net.SplitHostPort(req.Host)
+		if err != nil {
+			host = req.Host
+		}
+		http.Redirect(w, req, "https://"+host+port+req.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+	srv := &http.Server{Addr: *httpRedirect, Handler: forwarded(h)}
+	limitServer(srv)
+	log.Fatal(srv.ListenAndServe())
+}
+
+// strictTransport wraps h to send the -hsts Strict-Transport-Security
+// header on responses to HTTPS requests, including those a trusted proxy
+// received over HTTPS.
+func strictTransport(h http.Handler) http.Handler {
+	value := "max-age=" + strconv.Itoa(int(*hsts/time.Second))
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if isHTTPS(req) {
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+		h.ServeHTTP(w, req)
+	})
+}
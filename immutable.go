@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/kastelo/go-import-redirector/redirector"
+)
+
+var (
+	immutableState = flag.String("immutable-state", "", "refuse to repoint import paths recorded in `file`")
+	immutableNS    = flag.String("immutable", "", "with -immutable-state, freeze only the import paths in the comma-separated `namespaces` (default all)")
+	allowRepoint   = flag.Bool("allow-repoint", false, "allow changing the repo of an import path recorded in -immutable-state")
+)
+
+// A publishedRule records how an import path was published in the
+// -immutable-state file, which maps the Key of each rule to one.
+type publishedRule struct {
+	Import      string `json:"import"`
+	Repo        string `json:"repo"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// isImmutable reports whether r is in a namespace frozen by -immutable.
+func isImmutable(r *redirector.Rule) bool {
+	if *immutableNS == "" {
+		return true
+	}
+	for _, ns := range strings.Split(*immutableNS, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" && hasPathPrefix(r.Key(), ns) {
+			return true
+		}
+	}
+	return false
+}
+
+// readPublished reads the -immutable-state file.
+func readPublished() (map[string]publishedRule, error) {
+	published := make(map[string]publishedRule)
+	buf, err := os.ReadFile(*immutableState)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(buf, &published); err != nil {
+			return nil, fmt.Errorf("%s: %v", *immutableState, err)
+		}
+	}
+	return published, nil
+}
+
+// checkImmutable verifies that none of the immutable rules in rs serves
+// different metadata than recorded in the -immutable-state file, whether
+// through its repo, any other option or a rule spelled differently
+// taking its place.
+func checkImmutable(rs []*redirector.Rule) error {
+	if *immutableState == "" {
+		if *immutableNS != "" {
+			return errors.New("-immutable requires -immutable-state")
+		}
+		return nil
+	}
+	published, err := readPublished()
+	if err != nil {
+		return err
+	}
+	for _, r := range rs {
+		old, ok := published[r.Key()]
+		if !ok || old.Fingerprint == r.Fingerprint() || !isImmutable(r) {
+			continue
+		}
+		change := "repoint it to " + r.Repo
+		if old.Import != r.Import {
+			change = "replace it with " + r.Import
+		} else if old.Repo == r.Repo {
+			change = "change its metadata"
+		}
+		if !*allowRepoint {
+			return fmt.Errorf("%s is immutable: published as %s, refusing to %s (use -allow-repoint to override)", old.Import, old.Repo, change)
+		}
+		log.Printf("%s is immutable: published as %s, allowed to %s", old.Import, old.Repo, change)
+	}
+	return nil
+}
+
+// recordImmutable records the metadata of the immutable rules in rs in
+// the -immutable-state file. Call it once rs has passed checkImmutable and
+// every other check, as it is served.
+func recordImmutable(rs []*redirector.Rule) error {
+	if *immutableState == "" {
+		return nil
+	}
+	published, err := readPublished()
+	if err != nil {
+		return err
+	}
+	for _, r := range rs {
+		if isImmutable(r) {
+			published[r.Key()] = publishedRule{r.Import, r.Repo, r.Fingerprint()}
+		}
+	}
+	buf, err := json.MarshalIndent(published, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(*immutableState, append(buf, '\n'))
+}
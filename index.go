@@ -0,0 +1,172 @@
+package main
+
+import (
+	"flag"
+	"html/template"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kastelo/go-import-redirector/redirector"
+)
+
+var (
+	indexFlag   = flag.Bool("index", false, "serve browsers an index of the modules on host roots and wildcard import roots")
+	indexGitHub = flag.Bool("index-github", false, "list the repos of GitHub owners served by /* rules on the -index")
+)
+
+const githubListTTL = time.Hour
+
+// An indexEntry is a line on the index page. Patterns have no links.
+type indexEntry struct {
+	ImportPath string
+	Repo       string
+	DocsURL    string // empty for patterns
+	RepoURL    string // empty for patterns
+	Archived   bool
+}
+
+var indexPage = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="color-scheme" content="light dark">
+<title>{{.Root}}</title>
+<style>
+body { margin: 0; font: 1rem/1.5 system-ui, sans-serif; }
+main { max-width: 50rem; margin: 0 auto; padding: 2rem 1rem; }
+h1 { font-size: 1.5rem; overflow-wrap: anywhere; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: .25rem .5rem .25rem 0; overflow-wrap: anywhere; }
+</style>
+</head>
+<body>
+<main>
+<h1>{{.Root}}</h1>
+<table>
+<tr><th>Import path</th><th>Documentation</th><th>Source</th></tr>
+{{- range .Entries}}
+<tr>
+<td><code>{{.ImportPath}}</code>{{if .Archived}} (archived){{end}}</td>
+<td>{{with .DocsURL}}<a href="{{.}}" rel="noopener noreferrer">pkg.go.dev</a>{{end}}</td>
+<td>{{if .RepoURL}}<a href="{{.RepoURL}}" rel="noopener noreferrer">{{.Repo}}</a>{{else}}{{.Repo}}{{end}}</td>
+</tr>
+{{- end}}
+</table>
+</main>
+</body>
+</html>
+`))
+
+// isIndexPath reports whether path, resolved as r, gets the index page:
+// a host root without a rule of its own, or the root of a wildcard rule.
+func isIndexPath(path string, r *redirector.Resolution) bool {
+	if r.Rule != nil {
+		return r.Status == http.StatusFound && path == r.Rule.Root()
+	}
+	return !strings.Contains(path, "/") && slices.Contains(importHosts(), path)
+}
+
+// indexEntries lists the modules served under root by the rules with all
+// of tags.
+func indexEntries(root string, tags []string, now time.Time) []indexEntry {
+	var list []indexEntry
+	for _, rl := range taggedRules(currentRules(), tags) {
+		if rl.Canonical() != "" || rl.Root() != root && !strings.HasPrefix(rl.Root(), root+"/") {
+			continue
+		}
+		repo, _ := rl.ActiveRepo(now)
+		if rl.Import == rl.Root() {
+			list = append(list, indexEntry{ImportPath: rl.Import, Repo: repo, DocsURL: "https://pkg.go.dev/" + rl.Import, RepoURL: repo, Archived: repoArchived(repo)})
+			continue
+		}
+		owner, ok := githubOwner(rl, repo)
+		if !ok {
+			list = append(list, indexEntry{ImportPath: rl.Import, Repo: rl.Repo})
+			continue
+		}
+		for _, name := range githubRepos(owner) {
+			p := rl.Root() + "/" + name
+			res := resolve(p, now)
+			if res.Status != http.StatusOK || res.Rule != rl {
+				continue // served by a more specific rule
+			}
+			list = append(list, indexEntry{ImportPath: p, Repo: res.RepoRoot, DocsURL: "https://pkg.go.dev/" + p, RepoURL: res.RepoRoot})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ImportPath < list[j].ImportPath })
+	return list
+}
+
+// serveIndex serves the index page for root, listing the modules of the
+// rules with all of the ?tag= tags if given.
+func serveIndex(w http.ResponseWriter, req *http.Request, root string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	indexPage.Execute(w, struct {
+		Root    string
+		Entries []indexEntry
+	}{root, indexEntries(root, queryTags(req), time.Now())})
+}
+
+// githubOwner returns the GitHub owner whose repos rl serves, if it is a
+// rule with one wildcard for repos directly under https://github.com/<owner>
+// and -index-github is set.
+func githubOwner(rl *redirector.Rule, repo string) (string, bool) {
+	if !*indexGitHub || rl.Import != rl.Root()+"/*" || rl.VCS != "git" {
+		return "", false
+	}
+	owner, ok := strings.CutPrefix(repo, "https://github.com/")
+	if !ok || owner == "" || strings.Contains(owner, "/") {
+		return "", false
+	}
+	return owner, true
+}
+
+type repoList struct {
+	names    []string
+	fetched  time.Time
+	fetching bool
+}
+
+// githubCache holds the repo names of each GitHub owner.
+var githubCache = struct {
+	sync.Mutex
+	m map[string]*repoList
+}{m: make(map[string]*repoList)}
+
+// githubRepos returns the cached names of the unarchived repos of owner
+// and refreshes the cache in the background when it is missing or stale,
+// like moduleVersions. Owners given to -discover are listed from there.
+func githubRepos(owner string) []string {
+	if names, ok := discoveredRepos("github.com/" + owner); ok {
+		return names
+	}
+	githubCache.Lock()
+	defer githubCache.Unlock()
+	rl := githubCache.m[owner]
+	if rl == nil {
+		owner = keepString(owner)
+		rl = new(repoList)
+		githubCache.m[owner] = rl
+	}
+	if !rl.fetching && time.Since(rl.fetched) > githubListTTL {
+		rl.fetching = true
+		go fetchGitHubRepos(owner, rl)
+	}
+	return rl.names
+}
+
+func fetchGitHubRepos(owner string, rl *repoList) {
+	repos, err := listForgeRepos("github.com/" + owner)
+	githubCache.Lock()
+	defer githubCache.Unlock()
+	rl.fetching = false
+	rl.fetched = time.Now()
+	if err == nil {
+		rl.names = unarchived(repos)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+var (
+	memoryLimit      = flag.String("memory-limit", "", "set the Go runtime soft memory `limit`, such as 512MiB (default $GOMEMLIMIT, or -memory-limit-ratio of the container memory limit)")
+	memoryLimitRatio = flag.Float64("memory-limit-ratio", 0.9, "without -memory-limit or $GOMEMLIMIT, set the memory limit to `fraction` of the container memory limit")
+)
+
+// runtimeLimits records the container limits found and where the
+// GOMAXPROCS and memory limit in effect came from.
+type runtimeLimits struct {
+	ContainerCPUs    float64 `json:"container_cpus,omitempty"`
+	ContainerMemory  int64   `json:"container_memory,omitempty"`
+	GOMAXPROCSSource string  `json:"gomaxprocs_source"` // environment, container or default
+	GOMEMLIMITSource string  `json:"gomemlimit_source"` // flag, environment, container or default
+}
+
+var limits runtimeLimits
+
+// applyLimits sets GOMAXPROCS to the CPU limit of the container, unless
+// $GOMAXPROCS is set, and the Go runtime memory limit to -memory-limit,
+// $GOMEMLIMIT or -memory-limit-ratio of the container memory limit.
+func applyLimits() error {
+	cpus, memory := containerLimits()
+	limits.ContainerCPUs, limits.ContainerMemory = cpus, memory
+	switch {
+	case os.Getenv("GOMAXPROCS") != "":
+		limits.GOMAXPROCSSource = "environment"
+	case cpus > 0 && int(math.Ceil(cpus)) < runtime.GOMAXPROCS(0):
+		runtime.GOMAXPROCS(int(math.Ceil(cpus)))
+		limits.GOMAXPROCSSource = "container"
+	default:
+		limits.GOMAXPROCSSource = "default"
+	}
+
+	if *memoryLimitRatio <= 0 || *memoryLimitRatio > 1 {
+		return errors.New("-memory-limit-ratio must be above 0 and at most 1")
+	}
+	switch {
+	case *memoryLimit != "":
+		n, err := parseBytes(*memoryLimit)
+		if err != nil {
+			return fmt.Errorf("invalid -memory-limit: %v", err)
+		}
+		debug.SetMemoryLimit(n)
+		limits.GOMEMLIMITSource = "flag"
+	case os.Getenv("GOMEMLIMIT") != "":
+		limits.GOMEMLIMITSource = "environment" // applied by the runtime
+	case memory > 0:
+		debug.SetMemoryLimit(int64(float64(memory) * *memoryLimitRatio))
+		limits.GOMEMLIMITSource = "container"
+	default:
+		limits.GOMEMLIMITSource = "default"
+	}
+	return nil
+}
+
+// byteUnits are the suffixes GOMEMLIMIT accepts.
+var byteUnits = []struct {
+	suffix string
+	n      int64
+}{{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10}, {"B", 1}}
+
+// parseBytes parses a size with the syntax of GOMEMLIMIT: a number of
+// bytes, optionally followed by B, KiB, MiB, GiB or TiB.
+func parseBytes(s string) (int64, error) {
+	num, unit := s, int64(1)
+	for _, u := range byteUnits {
+		if v, ok := strings.CutSuffix(s, u.suffix); ok {
+			num, unit = v, u.n
+			break
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/unit {
+		return 0, fmt.Errorf("%q is not a size such as 512MiB", s)
+	}
+	return n * unit, nil
+}
+
+type versionInfo struct {
+	Version    string        `json:"version"`
+	Revision   string        `json:"revision,omitempty"`
+	GoVersion  string        `json:"go_version"`
+	NumCPU     int           `json:"num_cpu"`
+	GOMAXPROCS int           `json:"gomaxprocs"`
+	GOMEMLIMIT *int64        `json:"gomemlimit"` // bytes, null for none
+	Limits     runtimeLimits `json:"limits"`
+}
+
+// serveVersion reports the build and the runtime limits in effect.
+func serveVersion(w http.ResponseWriter, req *http.Request) {
+	v := versionInfo{
+		Version:    "(devel)",
+		GoVersion:  runtime.Version(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		NumCPU:     runtime.NumCPU(),
+		Limits:     limits,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		v.Version = bi.Main.Version
+		for _, s := range bi.Settings {
+			if s.Key == "vcs.revision" {
+				v.Revision = s.Value
+			}
+		}
+	}
+	if n := debug.SetMemoryLimit(-1); n != math.MaxInt64 {
+		v.GOMEMLIMIT = &n
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
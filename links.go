@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kastelo/go-import-redirector/redirector"
+)
+
+var (
+	referrerPolicy = flag.String("referrer-policy", "strict-origin-when-cross-origin", "send this Referrer-Policy with pages (empty for none)")
+	trackClicks    = flag.Bool("track-clicks", false, "route the page's outbound links through /-/out and count the clicks")
+)
+
+// referrerPolicies are the values of the Referrer-Policy header.
+var referrerPolicies = []string{
+	"no-referrer", "no-referrer-when-downgrade", "origin", "origin-when-cross-origin",
+	"same-origin", "strict-origin", "strict-origin-when-cross-origin", "unsafe-url",
+}
+
+// checkReferrerPolicy validates -referrer-policy.
+func checkReferrerPolicy() error {
+	if *referrerPolicy == "" {
+		return nil
+	}
+	for _, p := range referrerPolicies {
+		if *referrerPolicy == p {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid -referrer-policy %q: want one of %s", *referrerPolicy, strings.Join(referrerPolicies, ", "))
+}
+
+// outLink returns the /-/out link to target (source or docs) for the full
+// import path p.
+func outLink(target, p string) string {
+	return "/-/out?" + url.Values{"to": {target}, "path": {p}}.Encode()
+}
+
+// serveOut counts a click on a page link and redirects to its target.
+// The target is computed from the import path, not taken from the request,
+// so that /-/out cannot be used as an open redirect.
+func serveOut(w http.ResponseWriter, req *http.Request) {
+	r := resolve(strings.TrimSuffix(req.FormValue("path"), "/"), time.Now())
+	if r.Status != http.StatusOK {
+		redirector.ServeError(w, req, http.StatusNotFound, r.Code)
+		return
+	}
+	if code := refusal(req.Context(), r, false); code != "" {
+		redirector.ServeError(w, req, http.StatusNotFound, code)
+		return
+	}
+	d := r.Data()
+	var u string
+	target := req.FormValue("to")
+	switch target {
+	case "source":
+		u = d.BrowseURL
+	case "docs":
+		u = d.DocsURL
+	default:
+		http.Error(w, "unknown link target", http.StatusBadRequest)
+		return
+	}
+	outboundClicks.WithLabelValues(metricRoot(r.ImportRoot), target).Inc()
+	if *referrerPolicy != "" {
+		w.Header().Set("Referrer-Policy", *referrerPolicy)
+	}
+	http.Redirect(w, req, u, http.StatusFound)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	inheritFD  = flag.Int("inherit-fd", -1, "serve on the already bound listening socket passed as file descriptor `n`")
+	socketMode = flag.String("socket-mode", "0660", "file `mode` of the Unix socket of -addr unix:path")
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket
+// activation.
+const listenFDsStart = 3
+
+// listen returns the listener to serve on: the inherited socket if
+// -inherit-fd is set, the socket passed by systemd socket activation if
+// any, a Unix socket if addr is unix:path, and otherwise a new TCP
+// listener on addr.
+func listen(addr string) (net.Listener, error) {
+	fd := *inheritFD
+	if fd < 0 {
+		fd = activationFD()
+	}
+	if fd >= 0 {
+		return fileListener(fd)
+	}
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return listenUnix(path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// activationFD returns the first socket passed by systemd socket
+// activation, or -1. The activation variables are cleared so that child
+// processes do not take them for their own.
+func activationFD() int {
+	pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if pid != os.Getpid() || n < 1 {
+		return -1
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	return listenFDsStart
+}
+
+func fileListener(fd int) (net.Listener, error) {
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("fd %d", fd))
+	if f == nil {
+		return nil, fmt.Errorf("invalid file descriptor %d", fd)
+	}
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("fd %d: %v", fd, err)
+	}
+	return ln, nil
+}
+
+// listenUnix listens on the Unix socket path with the -socket-mode,
+// replacing a stale socket left behind by an earlier run. The socket is
+// removed again when the listener is closed on shutdown.
+func listenUnix(path string) (net.Listener, error) {
+	mode, err := strconv.ParseUint(*socketMode, 8, 32)
+	if err != nil || mode&^uint64(fs.ModePerm) != 0 {
+		return nil, fmt.Errorf("invalid -socket-mode %q", *socketMode)
+	}
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("%s is in use", path)
+		}
+		os.Remove(path)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return listenUnixMode(path, fs.FileMode(mode))
+}
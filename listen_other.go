@@ -0,0 +1,23 @@
+//go:build !unix
+
+package main
+
+import (
+	"io/fs"
+	"net"
+	"os"
+)
+
+// listenUnixMode listens on the Unix socket path and then gives it mode,
+// as there is no umask to create it with.
+func listenUnixMode(path string, mode fs.FileMode) (net.Listener, error) {
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	old := *socketMode
+	t.Cleanup(func() { *socketMode = old })
+	path := filepath.Join(t.TempDir(), "gir.sock")
+	for _, mode := range []fs.FileMode{0o600, 0o660, 0o666} {
+		*socketMode = "0" + strconv.FormatUint(uint64(mode), 8)
+		ln, err := listenUnix(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != mode {
+			t.Errorf("socket mode %v, want %v", fi.Mode().Perm(), mode)
+		}
+		ln.Close()
+	}
+	for _, mode := range []string{"rw", "4755", "1777"} {
+		*socketMode = mode
+		if _, err := listenUnix(path); err == nil {
+			t.Errorf("-socket-mode %s accepted", mode)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package main
+
+import (
+	"io/fs"
+	"net"
+	"syscall"
+)
+
+// listenUnixMode listens on the Unix socket path, created with mode by
+// setting the umask around the bind, so that the socket is never more
+// widely accessible than mode, even briefly.
+func listenUnixMode(path string, mode fs.FileMode) (net.Listener, error) {
+	old := syscall.Umask(int(^mode & fs.ModePerm))
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/kastelo/go-import-redirector/redirector"
+	"golang.org/x/text/language"
+)
+
+var (
+	localeDir    = flag.String("locales", "", "load localized page templates named <language>.html from `dir`")
+	templateFile = flag.String("template", "", "use the html/template in `file` as the default page")
+)
+
+// pageLocales holds the page templates by language.
+type pageLocales struct {
+	tags    []language.Tag // supported languages, the default first
+	tmpls   []*template.Template
+	matcher language.Matcher
+}
+
+// locales holds the page templates served, replaced on reload.
+var locales atomic.Pointer[pageLocales]
+
+// loadLocales parses the -template page and the localized page templates
+// in -locales, and serves them.
+func loadLocales() error {
+	l, err := readLocales()
+	if err != nil {
+		return err
+	}
+	locales.Store(l)
+	return nil
+}
+
+// readLocales parses the -template page and the localized page templates
+// in -locales. Each is a complete page receiving the same data as the
+// default page.
+func readLocales() (*pageLocales, error) {
+	l := &pageLocales{
+		tags:  []language.Tag{language.English},
+		tmpls: []*template.Template{redirector.Page},
+	}
+	if *templateFile != "" {
+		t, err := loadTemplate(*templateFile)
+		if err != nil {
+			return nil, err
+		}
+		l.tmpls[0] = t
+	}
+	if *localeDir != "" {
+		files, err := filepath.Glob(filepath.Join(*localeDir, "*.html"))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			name := strings.TrimSuffix(filepath.Base(f), ".html")
+			tag, err := language.Parse(name)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", f, err)
+			}
+			t, err := loadTemplate(f)
+			if err != nil {
+				return nil, err
+			}
+			if tag == language.English {
+				l.tmpls[0] = t
+				continue
+			}
+			l.tags = append(l.tags, tag)
+			l.tmpls = append(l.tmpls, t)
+		}
+	}
+	l.matcher = language.NewMatcher(l.tags)
+	return l, nil
+}
+
+// minimalLocales returns the minimal page in every language, in place of
+// page templates that failed to load.
+func minimalLocales() *pageLocales {
+	tags := []language.Tag{language.English}
+	return &pageLocales{
+		tags:    tags,
+		tmpls:   []*template.Template{redirector.MinimalPage},
+		matcher: language.NewMatcher(tags),
+	}
+}
+
+// loadTemplate parses the page template in file.
+func loadTemplate(file string) (*template.Template, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	t, err := template.New(filepath.Base(file)).Parse(string(buf))
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// pageTemplate returns the page template for rule: its own template if it
+// has one, and otherwise the one best matching the acceptLanguage header.
+func pageTemplate(rule *redirector.Rule, acceptLanguage string) *template.Template {
+	if rule.Page != nil {
+		return rule.Page
+	}
+	l := locales.Load()
+	if len(l.tmpls) <= 1 {
+		return l.tmpls[0]
+	}
+	prefs, _, _ := language.ParseAcceptLanguage(acceptLanguage)
+	_, i, _ := l.matcher.Match(prefs...)
+	return l.tmpls[i]
+}
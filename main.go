@@ -9,7 +9,16 @@
 //
 // Usage:
 //
-//	go-import-redirector [-addr address] [-tls] [-vcs sys] <import> <repo>
+//	go-import-redirector [-addr address] [-tls | -autocert] [-vcs sys] <import> <repo>
+//	go-import-redirector [-addr address] [-tls | -autocert] -config file
+//	go-import-redirector [-addr address] [-tls | -autocert] -snapshot file
+//	go-import-redirector -config file snapshot <output>
+//	go-import-redirector -config file check [import path ...]
+//	go-import-redirector -config file soak [-duration d] [-concurrency n] [-invalid fraction] [-paths n]
+//	go-import-redirector client [-server url] [-token token] <command>
+//	go-import-redirector certs [-server url] [-token token] [list | renew host... | revoke host...]
+//	go-import-redirector completion bash|zsh|fish
+//	go-import-redirector manpage
 //
 // Go-import-redirector listens on address (default “:80”)
 // and responds to requests for URLs in the given import path root
@@ -39,16 +48,837 @@
 //
 // Note that the wildcard element (x86) has been included in the Git repo path.
 //
+// For other mappings, <import> may instead have {name} placeholders as
+// whole path elements, each matching one element of the request path, and
+// <repo> may use the matched values anywhere. For example, with
+//
+//	go-import-redirector example.com/x/{name} https://git.example.com/group-{name}/{name}.git
+//
+// example.com/x/foo/bar is served from https://git.example.com/group-foo/foo.git.
+// Of several rules matching equally long import paths, the one with more
+// literal path elements wins; -config rejects rules that would match the
+// same paths with the same number of literal elements, a wildcard counting
+// as a placeholder.
+//
+// To serve several import paths from one process, the -config option names
+// a YAML file (or a TOML file, if its name ends in .toml) listing the rules
+// instead of <import> and <repo>:
+//
+//	rules:
+//	  - import: rsc.io/*
+//	    repo: https://github.com/rsc/*
+//	  - import: 9fans.net/go
+//	    repo: https://hg.example.com/9fans/go
+//	    vcs: hg
+//
+// Besides import and repo, a rule may set vcs, mod_proxy, min_go, old_repo,
+// cutover, source_dir, source_file, browser_redirect and template, with the
+// same meaning as the options of those names below; the -vcs, -mod-proxy,
+// -min-go, -source-dir, -source-file and -browser-redirect options give the
+// defaults for rules that do not. A request is served by the rule with the
+// longest import path containing it.
+//
+// A rule with wildcards or placeholders may list transforms, applied in
+// order to the captured values before they are substituted in the repo,
+// for repos named differently from their import paths: lower lowercases,
+// replace:<old>:<new> replaces each <old>, and trim-prefix:<prefix> and
+// trim-suffix:<suffix> remove a prefix or suffix. The wildcard elements are
+// transformed together, joined with slashes; placeholder values one by one.
+// For example, with
+//
+//	rules:
+//	  - import: example.com/x/*/*
+//	    repo: https://github.com/Example/*/*
+//	    transforms: [lower, "replace:/:-", "trim-prefix:go-"]
+//
+// example.com/x/Go-Tools/Lint is served from
+// https://github.com/Example/tools-lint.
+//
+// Modules nested in a monorepo are served with subdir, the directory of
+// the repo holding the module, which the go-import meta tag carries as its
+// fourth field so that the import root is the module root where its
+// go.mod is; the go command reads it from Go 1.25 on, and expects the
+// module's tags to be prefixed with the directory, as in tools/foo/v1.2.3.
+// Browsers and go-source links are sent to the directory. With
+// wildcards, subdir instead of repo ends in /*:
+//
+//	rules:
+//	  - import: example.com/foo
+//	    repo: https://github.com/example/mono
+//	    subdir: tools/foo
+//	  - import: example.com/libs/*
+//	    repo: https://github.com/example/mono
+//	    subdir: libs/*
+//
+// A rule with wildcards or placeholders may list shards, further repos
+// of the same form as repo, to spread its import roots across several
+// upstream organizations or accounts. Each import root is assigned to
+// repo or one of the shards by weighted rendezvous hashing of its path
+// below the rule's root, so the assignment is deterministic and adding a
+// shard moves only the import roots that the new shard takes over. A
+// shard may be followed by a weight (default 1, as for repo):
+//
+//	rules:
+//	  - import: example.com/*
+//	    repo: https://github.com/example/*
+//	    shards: ["https://github.com/example-2/*", "https://github.com/example-3/* 2"]
+//
+// With -shard-state, each import root is pinned to the repo root it was
+// first served to the go command with, recorded in the given file, and
+// keeps resolving to it when shards are added, removed or reweighted.
+// Removing a line from the file unpins its import root.
+//
+// For mappings that neither wildcards, placeholders nor transforms can
+// express, a rule may compute the repo root, VCS and browse URL of each
+// import root with the expressions repo_expr, vcs_expr and browse_expr, in
+// a small side-effect-free subset of CEL. They see the requested path as
+// path, its import root and suffix as root and suffix, its elements as the
+// list elems, the wildcard elements or placeholder values as the list
+// captures and by placeholder name, and the repo and vcs the rule resolves
+// otherwise (or the repo_expr and vcs_expr results) as repo and vcs. There
+// are string, integer, boolean and list values; + - == != < <= > >= && || !,
+// c ? a : b and list[i]; and the functions lower, upper, replace,
+// trim_prefix, trim_suffix, has_prefix, has_suffix, contains, split, join,
+// size, matches(s, re) and sub(s, re, repl), whose regular expressions
+// must be literals. Expressions are checked when the rules are loaded and
+// run for at most 10ms; one that fails answers with 500 and expr_failed.
+// For example, with
+//
+//	rules:
+//	  - import: example.com/{team}/{name}
+//	    repo: https://git.example.com/{team}/{name}.git
+//	    repo_expr: 'team == "legacy" ? "https://hg.example.com/" + name : repo'
+//	    vcs_expr: 'team == "legacy" ? "hg" : vcs'
+//
+// the legacy team's modules are served from Mercurial.
+//
+// References to environment variables in the -config file, written
+// ${NAME}, are replaced by their values when it is read, so that one file
+// can serve several environments with different hosts and tokens; an
+// unset variable is an error rather than an empty value, and $${ stands
+// for a literal ${. The references are replaced throughout the file,
+// comments included. The include list names further files, relative to
+// the including one, in YAML or TOML by their names, whose rules,
+// redirects and certificates are added after those of the including file
+// and whose security headers apply unless it sets them:
+//
+//	include: [teams/infra.yaml, teams/web.yaml]
+//	rules:
+//	  - import: ${IMPORT_HOST}/tools
+//	    repo: https://github.com/example/tools
+//
+// Included files are read again on reload, but only changes of the -config
+// file itself are noticed by -watch-config, and the rule management API
+// edits the -config file as written.
+//
+// The profiles section of the -config file holds named overrides, such as
+// for staging and production, and the -profile option selects the one to
+// apply, so that the environments need not have near-duplicate files. A
+// profile's rules replace the rules with the same import path and are
+// otherwise added, its redirects likewise by from, its certificates
+// replace the others, and its security headers are set over the others:
+//
+//	rules:
+//	  - import: example.com/tools
+//	    repo: https://github.com/example/tools
+//	profiles:
+//	  staging:
+//	    rules:
+//	      - import: example.com/tools
+//	        repo: https://github.com/example-staging/tools
+//
+// The snapshot subcommand compiles the rules with the -profile given to it.
+//
+// Rules are set up in parallel and indexed by import path root, so that
+// org-scale files with hundreds of thousands of rules load in seconds and
+// requests are matched without scanning them all. The load time is logged
+// at startup and exported in the metrics.
+//
+// To build the routing table once and ship it to many replicas, the
+// snapshot subcommand compiles the -config file, with the defaults given
+// by the per-rule options filled in, into an immutable snapshot file:
+//
+//	go-import-redirector -config rules.yaml snapshot rules.snap
+//
+// Replicas started with -snapshot rules.snap in place of -config map the
+// file into memory and serve its rules without parsing a configuration,
+// sharing the pages of the file between processes. The snapshot is
+// reloaded like a -config file, but must be replaced by renaming a new one
+// over it, as the subcommand does, rather than rewritten in place; the
+// mapping of the replaced snapshot is unmapped once the requests started
+// before the reload are done.
+// Snapshots are specific to the go-import-redirector version building them;
+// template files named by rules are read when the snapshot is loaded.
+//
+// A rule may also name who is responsible for its modules with owner, team
+// and contact (an email address or URL). They are shown on the page and
+// included in the JSON resolution, in /.well-known/go-modules and, owner
+// and team, in the usage statistics, so that consumers of a shared domain
+// know whom to ask about a module.
+//
+// Rules may carry tags, free-form labels such as tier=internal, for
+// managing large tables: ?tag= selects the rules with all of the given
+// tags, repeated or comma-separated, on the index page,
+// /.well-known/go-modules (and the client's routes), GET /-/rules and the
+// ListRules call of the admin API, and the /-/stats/ endpoints, which
+// then count only the modules of those rules. Tags do not change what is
+// served.
+//
+// The configuration file may also list plain redirects for other paths on
+// the served hosts, such as
+//
+//	redirects:
+//	  - from: example.com/blog
+//	    to: https://blog.example.com/
+//	  - from: /slack
+//	    to: https://join.slack.com/t/example/shared_invite/...
+//	    permanent: true
+//
+// A from without a host applies to every host. Redirects take precedence
+// over the import path rules and answer with 302 Found, or with 301 Moved
+// Permanently if permanent is set. A redirect to another one on the served
+// hosts is followed when the configuration is loaded, so that clients are
+// sent to the end of the chain at once, permanently only if every step is;
+// a chain that loops is a configuration error.
+//
+// A rule may list aliases, other import path roots serving the same
+// namespace, for instance while moving it between the apex and a
+// subdomain:
+//
+//	rules:
+//	  - import: example.com/x/*
+//	    repo: https://github.com/example/*
+//	    aliases: [go.example.com/x]
+//
+// The rule's own import path is canonical. Under an alias, go get
+// requests receive meta tags for the alias import path, with a Link
+// rel="canonical" header naming the canonical one, and browsers are sent
+// to the canonical path with 301 Moved Permanently. The alias hosts are
+// served like the other import hosts.
+//
+// When modules move to another import path, their rule may name the new
+// root as moved_to, keeping the old paths resolvable:
+//
+//	rules:
+//	  - import: old.example.com/foo
+//	    repo: https://github.com/example/foo
+//	    moved_to: new.example.com/foo
+//	    mod_proxy: https://proxy.golang.org
+//
+// Go get requests for the old paths still receive their go-import meta
+// tags, which must name the import path requested, with a Link
+// rel="successor-version" header naming the new path, and the page says
+// the module has moved. Browsers are sent to the new path with 301 Moved
+// Permanently, and JSON responses name it as moved_to. With mod_proxy set,
+// the page also carries the go-import mod tag, so that builds of versions
+// already published under the old path keep working from the module proxy
+// while consumers migrate. When the new path has moved on in turn,
+// browsers are sent to where the chain of moves ends, and under an alias of
+// a moved rule, straight there rather than through the canonical path; a
+// chain of moves that loops is a configuration error.
+//
+// On SIGHUP, the -config file and the page templates are read again and
+// replace the served ones at once, without dropping connections; if the
+// file is invalid, a template fails to load without -degrade template, or
+// the file would repoint an -immutable-state import path, the current
+// rules stay.
+// The -config or -snapshot file is also reloaded whenever it changes,
+// including when a Kubernetes ConfigMap volume swaps in a new version of
+// it, checking every -watch-config interval (default 10s); -watch-config 0
+// turns this off, leaving reloads to SIGHUP and /-/reload.
+// On SIGTERM or SIGINT, the server stops accepting connections and exits
+// once in-flight requests have finished, or after -shutdown-timeout
+// (default 30s).
+//
 // The -addr option specifies the HTTP address to serve (default “:http”).
 //
 // The -tls option causes go-import-redirector to serve HTTPS on port 443,
 // loading an X.509 certificate and key pair from files in the current directory
-// named after the host in the import path with .crt and .key appended
-// (for example, rsc.io.crt and rsc.io.key).
+// named after the host in the (first) import path with .crt and .key appended
+// (for example, rsc.io.crt and rsc.io.key). When several hosts are served,
+// the certificate must be valid for all of them, unless they have their own.
 // Like for http.ListenAndServeTLS, the certificate file should contain the
 // concatenation of the server's certificate and the signing certificate authority's certificate.
+// An explicit -addr overrides the port 443 default.
+// The -tls-cert and -tls-key options name other certificate and key files.
+// When several hosts are served, each host with its own <host>.crt and
+// <host>.key files is served that certificate, selected by the TLS server
+// name (SNI), and the others the first host's. Alternatively, the
+// certificates field of the -config file lists the certificates to load,
+// as in
+//
+//	certificates:
+//	  - cert: go.example.com.pem
+//	    key: go.example.com.key
+//	  - cert: pkg.other.org.p12
+//
+// and each host is served the first one valid for it. Certificates are
+// loaded at startup only; hosts added on reload are served one of them.
+// Besides separate PEM files, DER certificate and key files, a combined PEM
+// file holding both the chain and the key, and PKCS #12 bundles (.p12 or
+// .pfx) are accepted. Encrypted PKCS #8 keys and PKCS #12 bundles are
+// decrypted with the passphrase read from the file named by
+// -tls-passphrase-file, or else from the environment variable
+// named by -tls-passphrase-env (default GIR_TLS_PASSPHRASE).
+// At startup the chain is verified against the system roots, or against the
+// CA certificates in the file named by -tls-ca, and a warning is logged if it
+// does not verify, typically because intermediate certificates are missing;
+// the gir_tls_chain_invalid metric is 1 for each import host whose chain
+// does not verify.
+// The -ocsp-staple option fetches OCSP responses for the certificate from
+// its issuer's responder and staples them to TLS handshakes, refreshing them
+// in the background halfway through each response's validity.
+//
+// The -autocert option instead serves HTTPS with certificates for the import
+// hosts obtained and renewed automatically from Let's Encrypt, accepting its
+// terms of service. Certificates and the ACME account key are kept in the
+// directory named by -autocert-cache (default autocert-cache), and
+// -autocert-email sets the account's contact address. TLS-ALPN-01 challenges
+// are answered on the HTTPS port and HTTP-01 challenges on the address given
+// by -autocert-http (default “:http”, empty to disable), where other
+// requests are redirected to HTTPS. Both ports must be reachable from the
+// Internet. The -autocert-directory option names another ACME directory,
+// such as a staging or internal CA. The chains of the certificates in the
+// cache are verified like those of -tls, at startup and as they are
+// obtained, against the system roots or -tls-ca.
+//
+// With -index, browsers requesting an import host's root, or the import
+// path root of a /* rule, are served an HTML index of the modules under
+// it, with links to their documentation on pkg.go.dev and their repos,
+// instead of a 404 or a redirect to the repo. Rules whose repo is
+// https://github.com/<owner>/* list the modules of the public, unarchived
+// repos of the owner on the index with -index-github; the list is fetched
+// from the GitHub API in the background and kept for an hour.
+//
+// So that crawlers do not index the redirect stubs, /robots.txt denies
+// them the whole site; -robots allow lets them in, and -robots with a file
+// name serves that file instead. With -sitemap, /sitemap.xml lists the
+// modules of the requested import host: the import paths of its rules
+// without wildcards, the repos of /* rules listed with -index-github, and
+// those found by -discover; robots.txt then names it with -robots allow.
+// /favicon.ico is answered with the -favicon file, or else with an empty
+// response, rather than by the rules.
+//
+// Requests for paths on other hosts than the import hosts, such as a bare
+// IP address, a wrong Host header or none at all from HTTP/1.0 clients,
+// are unknown routes, unless -default-host names the import host to
+// assume for them.
+//
+// Behind a load balancer or reverse proxy, requests carry the Host the proxy
+// connects to, which may be an internal name. The -trusted-proxies option
+// lists the addresses or CIDR ranges of the proxies, comma-separated, and
+// unix for the peers of the -addr unix:path socket, which have no address
+// to list; on requests from them, X-Forwarded-Host takes the place of the
+// Host for routing and matching the import paths, and X-Forwarded-Proto
+// tells whether the client used HTTPS, for -hsts and the scheme of alias
+// redirects, and X-Forwarded-For gives the client address for the rate
+// limit, the access log and the usage statistics. From other peers the
+// headers are ignored, so clients cannot spoof them.
+//
+// The -rate-limit option limits each client IP address to the given average
+// number of requests per second, in bursts of up to -rate-burst (default 20);
+// further requests are answered with 429 Too Many Requests and a Retry-After
+// header. Behind -trusted-proxies, the client address is taken from
+// X-Forwarded-For; on a Unix socket, whose peers have no address, -rate-limit
+// requires unix in -trusted-proxies. The -max-conns option caps the
+// connections served at once, leaving more to wait in the listen queue.
+// Clients have -read-timeout (default 10s) to send a request, responses are
+// abandoned after -write-timeout (default 30s), except for /-/events streams,
+// and idle keep-alive connections are closed after -idle-timeout (default
+// 2m). Resolving an import path, with the -verify-repos check and the rule's
+// expressions, is given -request-timeout (default 10s), under the request's
+// own context, so that a stuck upstream cannot hold handlers: a request
+// running out of it is answered with 504 Gateway Timeout and the error code
+// timeout.
+//
+// With -tls, nothing answers plain HTTP unless -http-redirect names an
+// address, such as :http, on which every request is redirected to the same
+// URL on HTTPS with 301 Moved Permanently. With -tls or -autocert, -hsts
+// sets the max-age of a Strict-Transport-Security header sent on HTTPS
+// responses, such as -hsts 8760h for a year; browsers then use HTTPS for
+// the import hosts without trying HTTP first.
+//
+// The /healthz endpoint reports, as JSON, the process uptime, the number of
+// rules served, the time and any error of the last configuration reload and,
+// with -tls or -autocert, each import host's certificate expiry and days
+// left, for monitoring. With -discover, it also lists each owner with the
+// number of repos served for it, whether it has a list yet, and the time and
+// any error of its last listing. /readyz reports the same, but with status
+// 503 once the server is shutting down, for load balancer health checks; a
+// forge that fails to list does not make it fail, as the last list of the
+// owner, or with -degrade discovery none, keeps being served.
+//
+// The -degrade option lists the subsystems, comma-separated, whose failure
+// the server rides out rather than refusing to start or failing the
+// request. With stats, a -geoip-db that cannot be opened leaves the usage
+// statistics without countries and networks. With discovery, a -discover
+// owner that cannot be listed at startup is served from the lists last
+// saved to the -discover-state file, or without one, its wildcard import
+// paths are served unchecked until a refresh lists it; a failed refresh
+// keeps the previous lists either way. With template, a -template, -locales
+// or per-rule template page that fails to load or to render is replaced by
+// a minimal page with only the go-import and go-source meta tags and a link
+// to the repo; the templates are loaded again on reload, and once they all
+// load the subsystem is no longer degraded. The /-/status endpoint reports,
+// as JSON, which subsystems are degraded, since when and with what error,
+// and whether -degrade tolerates them.
+//
+// The /-/tlsinfo endpoint returns, as JSON, the negotiated TLS version,
+// cipher suite, server name (SNI), the certificate chain presented to the
+// client and whether that chain verified at startup, or with -autocert, the
+// chain in the cache and whether it verifies, to help diagnose “certificate
+// signed by unknown authority” reports.
+//
+// In a container, GOMAXPROCS is set to the CPU limit of its cgroup, rounded
+// up, unless $GOMAXPROCS is set. The Go runtime's soft memory limit is set
+// by -memory-limit (a size such as 512MiB), by $GOMEMLIMIT, or else to
+// -memory-limit-ratio (default 0.9) of the container memory limit, so that
+// the garbage collector works harder before the container is killed for
+// running out of memory. The /-/version endpoint returns, as JSON, the
+// build version and the values in effect, with the container limits found.
+//
+// For container deployments, every option can also be set through an
+// environment variable named after it, GIR_ followed by the option name in
+// upper case with dashes as underscores: GIR_ADDR for -addr, GIR_TLS for
+// -tls, GIR_VCS for -vcs and so on. Options on the command line take
+// precedence. Without arguments, GIR_IMPORT and GIR_REPO give <import> and
+// <repo>; without -config or -snapshot, GIR_CONFIG_DATA may hold the
+// configuration file itself, in YAML.
+//
+// The -inherit-fd option serves on an already bound listening socket passed
+// in as the given file descriptor, instead of binding -addr. This lets a
+// supervisor bind a privileged port such as :443 and run go-import-redirector
+// fully unprivileged. Sockets passed by systemd socket activation (LISTEN_FDS)
+// are detected and served likewise, without any option.
+//
+// With “-addr unix:/path/to.sock”, go-import-redirector listens on a Unix
+// socket instead, for a reverse proxy such as nginx or Caddy on the same
+// host. The socket is created with the permissions given by -socket-mode
+// (default 0660), a stale socket left behind is replaced, and the socket is
+// removed on shutdown.
+//
+// The -vcs option specifies the version control system: git, hg, svn, bzr
+// or fossil (default “git”), the types the go command understands. With
+// -config, each rule may set its own vcs, so that repositories of different
+// kinds can be served under one domain; unknown types are refused at
+// startup.
+// With “-vcs mod”, <repo> is instead the base URL of a module proxy serving
+// the modules, and the go-import meta tag reads “<import root> mod <proxy URL>”.
+// <repo> then has no /* even if <import> does, and browsers are not
+// redirected to it.
+//
+// The -mod-proxy option adds a second go-import meta tag with the “mod”
+// VCS type pointing at the given module proxy base URL. In module mode the
+// go command prefers the mod entry and downloads from the proxy; clients
+// that cannot use it fall back to the VCS entry.
+//
+// For repos on github.com, gitlab.com and bitbucket.org, pages also carry a
+// go-source meta tag, so that pkg.go.dev and godoc can link to directories
+// and lines in the repo's web view. For other forges, the -source-dir and
+// -source-file options give the directory and file URL templates of the
+// go-source tag, in which {repo} stands for the repo URL (for example
+// “{repo}/src/branch/main{/dir}” and “{repo}/src/branch/main{/dir}/{file}#L{line}”).
+// In these templates, {branch} stands for the -branch option, which
+// defaults to HEAD, the repo's default branch.
+//
+// Browsers requesting a package below the module root, such as
+// rsc.io/x86/x86asm, are sent to its directory in the repo's web view, for
+// example https://github.com/rsc/x86/tree/HEAD/x86asm, rather than to the
+// repo root. For repos not on the forges above, the -dir-url option gives
+// the directory URL template, with {repo}, {branch} and {/dir} as above
+// ({dir} without the leading slash). With -config, rules may set branch
+// and dir_url.
+//
+// The -min-go option declares the minimum Go version the served modules
+// require. It is shown on the HTML page, included in the JSON responses and
+// sent in an X-Go-Min-Version header. When the User-Agent reveals an older Go
+// release, an X-Go-Version-Warning header is added as well. (The go command
+// itself does not include its version in the User-Agent.)
+//
+// The -strict option parses every rendered page the way the go command
+// does before serving it, and answers with an error instead if the page would
+// not resolve the requested import path. It also refuses to start (or to
+// reload) with a configuration that would otherwise pass with at most a
+// warning: a rule inside the namespace of another, a repo not served over
+// https, a repo without go-source templates, a -tls certificate chain that
+// does not verify for its host, or with -verify-repos, a missing repo.
+//
+// Administrative endpoints require an “Authorization: Bearer” header with the
+// token given by -admin-token or $GIR_ADMIN_TOKEN, and are disabled when no
+// token is set. /-/simulate?host=<host>&path=<path> returns, as JSON, how a
+// request would be resolved: the matched route, the wildcard captures, the
+// meta tags that would be emitted and the redirect target, without side
+// effects; with &offline=1, without the forge lookups of the archived state
+// and CODEOWNERS owner either.
+// /-/events streams live resolution events (time, path, matched route and
+// response status) as server-sent events, for watching the effect of a
+// configuration change as it happens; events are dropped for clients that
+// do not keep up.
+// Adding ?__explain=1 to any URL under an import path, with the token,
+// returns a plain-text explanation of how the request is matched instead:
+// the rules rooted at the path or a parent of it and why each was rejected
+// or lost to the chosen one, the closest rules rooted elsewhere on the
+// host, the captures of the wildcards or placeholders, and the policies
+// and redirects applied.
+// POST /-/reload reloads the -config file, as SIGHUP does, and reports
+// an error if the new configuration is rejected.
+// POST /-/prune keeps long-running instances from accumulating state: it
+// removes usage statistics older than -stats-days and the statistics of
+// import roots no longer served, empties the page cache and drops expired
+// cache entries, removes the -shard-state pins of import roots no longer
+// under a rule with shards, and deletes the -autocert-cache certificates of
+// hosts no longer served, reporting the number of entries removed from
+// each. The targets stats, caches, shard-pins and autocert can be chosen
+// with a comma-separated ?targets=; with -prune-interval, all are pruned
+// periodically. The -transparency-log and -immutable-state files are
+// records meant to be kept, and are never pruned; the -log-file is rotated
+// by renaming it and sending SIGHUP.
+// The -grpc-addr option also serves the administrative API over gRPC on
+// the given address, as the goimportredirector.admin.v1.Admin service
+// described in admin.proto (and by server reflection): simulation, reload,
+// the rule management of -admin-addr, the certificate listing, renewal and
+// revocation, and pruning. Every call, reflection included, needs the
+// token in the “authorization” metadata. With -tls or -autocert, the gRPC
+// server uses the same certificate. On shutdown, calls in flight are given
+// the -shutdown-timeout to finish, like HTTP requests.
+//
+// The -admin-addr option serves a rule management API on a separate
+// address, with the admin token, for creating routes from CI as
+// repositories are created. GET /-/rules lists the rules of the -config
+// file as JSON objects with the keys of the file, and POST /-/rules adds
+// one; GET, PUT and DELETE /-/rules/<import> show, replace and remove the
+// rule with that import path. Changes are checked like a reload, written
+// back to the -config file (keeping the comments of a YAML file, but not of
+// a TOML file) and then served. The API is plain HTTP, so the address
+// should not be reachable from the Internet.
 //
-// The -vcs option specifies the version control system, git, hg, or svn (default “git”).
+// The check subcommand validates the -config (or -snapshot) file and the
+// options as at startup, without serving: the rules' URLs, wildcards,
+// VCS types, templates and overlapping routes. The -strict problems are
+// listed as warnings, or as errors with -strict. They include the common
+// mistakes in mapping to the forges: GitHub repos that are not owner/name,
+// have uppercase letters or, under wildcards without a lower transform,
+// are reached by import paths differing in case; GitLab.com repos that are
+// not group/project or nest deeper than GitLab allows; and Bitbucket repos
+// that are not workspace/repo. For each import path
+// given, it shows how a request would be served, with the meta tags and
+// where browsers are sent, as in
+//
+//	go-import-redirector -config rules.yaml check example.com/x/y
+//
+// It exits with status 1 if the configuration is invalid or an import path
+// is not served, for checking configuration changes in CI before deploying
+// them.
+//
+// The soak subcommand sends randomized requests to the redirect handler in
+// process for -duration (default 1m), from -concurrency workers: for import
+// paths under random rules, with their wildcards and placeholders filled
+// in, and for a fraction -invalid (default 0.2) of paths that cannot be
+// served, on unknown hosts, too deep or with overlong elements. Every
+// -report interval (default 10s) it prints the request rate, median and
+// 99th percentile latency, heap allocations per request, heap size and
+// goroutines, and at the end the
+// heap growth and the drift of the 99th percentile latency between the
+// first and last intervals, for validating cache bounds before a release.
+// The options given before the subcommand apply as when serving, except
+// that module versions are only looked up if -version-proxy is given, so
+// as not to send random module paths to the public module proxy. With
+// -paths, the paths that can be served are drawn from that many distinct
+// ones, so that the pages come from the page cache, which holds the
+// rendered pages and their ETags, least recently used out first.
+//
+// The completion subcommand writes a completion script for bash, zsh or
+// fish, and the manpage subcommand writes a go-import-redirector(1) manual
+// page in roff, both generated from the options of the running binary, so
+// that packages can install them without separate artifacts.
+//
+// The client subcommand queries a running instance at -server (default
+// http://localhost) with the admin token from -token or $GIR_ADMIN_TOKEN:
+// “client routes” lists the served import paths, “client simulate
+// [-host h] <path>” shows how a request would be resolved, “client stats
+// <name>” prints the usage, egress, go-versions or referers statistics,
+// “client reload” reloads the configuration and “client events” streams
+// the resolution events.
+//
+// The certs subcommand manages the certificates of a running instance, in
+// the same way: “certs list” (the default) shows each certificate with
+// its source, the import hosts it is served for, its names, expiry and
+// renewal status, “certs renew <host>...” forces renewal and “certs
+// revoke <host>...” obtains new certificates and revokes the old ones.
+// Renewing an -autocert certificate obtains a new one at once, the old one
+// staying in the cache and served should that fail; for -tls, renewing
+// loads the certificate files again, to pick up certificates renewed by
+// other means. Only -autocert certificates can be revoked, with the ACME
+// account that obtained them. The admin endpoints
+// behind the subcommand are GET /-/certs and POST /-/certs/renew?host=<host>
+// and /-/certs/revoke?host=<host>.
+//
+// The page shown to browsers has the go get command with a copy button and,
+// unless -version-proxy is set to the empty string, a selector of the
+// module's recent versions as listed by that module proxy (default
+// https://proxy.golang.org). Versions are fetched in the background and
+// cached for an hour. With -refresh=false, the page does not redirect
+// browsers to the repo, so they stay on this landing page.
+//
+// The -browser-redirect option sends requests without ?go-get=1 (other
+// than JSON requests) elsewhere: with “pkgsite” to the documentation on
+// pkg.go.dev for the full import path, including the package suffix, or to
+// a URL template in which {path} stands for the full import path. The
+// default, “repo”, serves the page above. The go command always receives
+// the page with the meta tags.
+//
+// The -sumdb-proxy option lists checksum databases (for example
+// sum.golang.org) whose requests are passed through under /sumdb/<name>/, as
+// in the module proxy protocol, so that clients without direct Internet
+// access that list this server in GOPROXY can still verify checksums.
+//
+// An OpenAPI 3 description of these service endpoints is served on
+// /-/openapi.json.
+//
+// The -log-file option appends an access log line for each request to the
+// named file, or to standard output if it is “-”. The file is reopened on
+// SIGHUP, so that it can be rotated by renaming it and signaling the server.
+// The -log-format option selects the Apache “combined” format (the default)
+// or “json”, one object per line that also records the import root, the
+// matched rule and whether the request had ?go-get=1. With -geoip-db, the
+// lines also carry the client's country and autonomous system, as two
+// more quoted fields at the end of combined lines.
+//
+// Links on the page carry rel="noopener noreferrer", and pages are sent with
+// the Referrer-Policy given by -referrer-policy (default
+// “strict-origin-when-cross-origin”, empty for none). With -track-clicks,
+// the links go through /-/out, which counts the click in the metrics and
+// redirects to the repo or documentation of the import path.
+//
+// Responses under the import paths, including the index pages and .ping,
+// carry that Referrer-Policy with a restrictive Content-Security-Policy,
+// X-Content-Type-Options: nosniff and X-Frame-Options: DENY. The
+// security_headers section of the -config file changes these headers, or
+// with an empty value removes one, and may add others:
+//
+//	security_headers:
+//	  Content-Security-Policy: "default-src 'self'"
+//	  X-Frame-Options: ""
+//
+// The -metrics-addr option serves Prometheus metrics on /metrics at the
+// given address, separate from the public listener: request counts by
+// import root, status and client (go-get, json or browser), request latency
+// as a native histogram with classic buckets, carrying the trace ID of a
+// W3C traceparent header as an exemplar, response bytes by module as
+// gir_egress_bytes_total, failed TLS handshakes, the expiry of the served
+// certificates, the time taken by the last -config load, whether the last
+// reload was served, when the served configuration was loaded and when its
+// file was last modified, and the time from start until listening.
+//
+// The metrics subcommand writes monitoring configuration for these metrics:
+// “metrics dashboard” a Grafana dashboard to import, with panels for the
+// request rate, errors, latency, top import roots, egress, certificates
+// and configuration, and “metrics alerts” Prometheus alerting rules for a
+// high 5xx rate, certificates expiring within 14 days or with an invalid
+// chain, a rejected reload, and a -config file changed but not served for
+// 15 minutes.
+//
+// A QR code linking to the landing page of an import path is served as a PNG
+// image on /-/qr/<import path>.png, for slides and printed documentation.
+//
+// The -template option names an html/template file replacing the default
+// page, for branding, documentation links or analytics snippets. It
+// receives the same data as the default page: .ImportPath (the full import
+// path), .ImportRoot, .VCS, .VCSRoot, .Suffix, .DocsURL and so on, and must
+// include the go-import meta tag itself, with .Subdir as its fourth field
+// if set. With -config, a rule's template
+// field names a page template for that rule alone.
+//
+// The -locales option names a directory of localized page templates, one
+// per language, named after the language tag (for example de.html or
+// pt-BR.html). Each is an html/template for the complete page, receiving the
+// same data as the default page (.ImportRoot, .VCS, .VCSRoot, .Suffix, ...),
+// and is chosen by negotiation with the request's Accept-Language header.
+// An en.html replaces the built-in English page.
+//
+// Requests that cannot be served are answered with a stable error code in
+// the X-Go-Import-Error header and, for requests that accept
+// application/json, in a JSON body {"error": <code>, "message": <text>}.
+// The codes are unknown_route (no rule covers the import path),
+// wildcard_depth (the path has fewer elements than the rule's wildcards),
+// invalid_metadata (the page fails the -strict checks),
+// blocked (the import root matches a -block pattern),
+// upstream_unverified (see -verify-repos), gone (see -gone-after),
+// path_limit (see -max-depth), rate_limited (see -rate-limit),
+// expr_failed (a rule expression failed, answered with 500) and timeout
+// (see -request-timeout, answered with 504); expired is reserved.
+// 404 responses carry “X-Robots-Tag: noindex” and no meta tags, so that
+// search engines and module proxies do not index nonexistent packages.
+//
+// The -block option lists import roots not to serve, as comma-separated
+// patterns in path.Match syntax (for example “rsc.io/internal-*”).
+// The -verify-repos option checks, before serving a wildcard import path,
+// that its https repo exists, by a HEAD request answered with anything but
+// 404 or 410; outcomes are cached for -verify-repos-ttl (default 1h), and a
+// check taking longer than -verify-repos-timeout (default 5s) counts as
+// found. This keeps the wildcard from answering for arbitrary scanned
+// paths, and go get from failing deep in VCS errors for them.
+//
+// The -discover option turns /* rules into a self-maintaining namespace for
+// GitHub owners or GitLab.com groups, given as comma-separated owners such
+// as “github.com/myorg”: their repos are listed from the forge API at
+// startup and every -discover-interval (default 1h), GitHub owners as
+// organizations or else users and GitLab groups with their subgroups, and
+// a wildcard import path whose repo belongs to such an owner is only served
+// if the repo is listed, and otherwise answered with upstream_unverified.
+// An owner with more than 10000 repos fails to list. The GIR_FORGE_TOKEN
+// environment variable, if set, is sent to the forge API as a bearer
+// token, for private repos and higher rate limits. With -discover-codeowners,
+// the CODEOWNERS file of each listed repo (in .github/, the top directory or
+// docs/) is fetched after each listing, and the owners of its * pattern
+// become the owner of modules whose rule names none.
+//
+// The -forge-url option sends the requests to the forge APIs and raw files,
+// those of -discover, -discover-codeowners and -check-archived, to the
+// given base URL followed by the forge host and path, as in
+// http://127.0.0.1:8081/api.github.com/orgs/myorg/repos. The forgetest
+// package provides a fake GitHub, GitLab.com and Codeberg answering them,
+// for testing these integrations, and those of programs embedding the
+// redirector package, offline; scripts/e2e.sh checks -discover against it.
+//
+// For self-hosted forges with certificates of a private CA, -forge-ca names
+// a PEM file of CA certificates trusted, besides the system roots, by the
+// requests to forges and module proxies: those of -verify-repos,
+// -discover, -discover-codeowners, -check-archived and -version-proxy.
+// As a last resort, -forge-insecure lists hosts whose certificates are
+// not verified at all; each is logged as a warning at startup.
+//
+// Forges reachable only through a bastion are reached through the tunnels
+// of -forge-via, given per forge host as host=url, or *=url for all of
+// them: a SOCKS5 proxy as socks5://[user:password@]host:port, or an SSH
+// jump host as ssh://user@host[:port], authenticated with the private key
+// of -forge-ssh-key and verified against -forge-ssh-known-hosts (default
+// ~/.ssh/known_hosts). One SSH connection per jump host carries all the
+// requests, and is made again if it drops. The HTTP proxy of the
+// environment is not used for hosts with a tunnel.
+//
+// With -purge-proxy, a reload that changes the repo, or anything else in
+// the served metadata, of a rule has the given module proxies refetch its
+// modules, shortening the time they serve metadata pointing at the old
+// repo: the import path of a rule without wildcards or placeholders, and
+// otherwise the import roots of the rule served to the go command since
+// startup. Proxies given by base URL, such as https://proxy.golang.org, are
+// sent uncached requests for the module's @v/list and @latest; a URL
+// containing {module}, such as a private proxy's purge API, is POSTed to
+// with the module path in its place. Outcomes are logged.
+//
+// With -gone-after, a repo found missing after having existed is given a
+// grace period: its import paths are served as before for the given
+// duration after it was first found missing, and then answered with 410
+// Gone, so that consumers learn it was deleted rather than never served.
+// Repos are found missing by -verify-repos, or with -discover, by dropping
+// out of their owner's listing or being archived; the -discover-state file
+// keeps the listings and the times across restarts. The -gone-webhook
+// option names a URL to which a JSON object with the event "gone",
+// import_root, repo and missing_since is posted when that happens.
+//
+// To bound the paths crawlers can explore under wildcards, and caches must
+// hold, -max-depth limits the number of path elements of a wildcard import
+// path below the rule's import root, counting the wildcard elements, and
+// -max-element-length the length of each of them; paths beyond the limits
+// are answered with 404.
+//
+// Requests without ?go-get=1 that send “Accept: application/json” receive
+// the resolution as a JSON object instead of HTML, with the fields
+// import_root, vcs, repo_root, suffix, docs_url and go_source.
+//
+// With -check-archived, the repos on GitHub, GitLab.com and Codeberg are
+// looked up in the forge's API in the background, with the GIR_FORGE_TOKEN
+// if set, and the result kept for six hours. Modules whose repo is archived
+// are marked on the page, with "archived": true in the JSON resolution and
+// in /.well-known/go-modules, and with an “X-Go-Repo-Archived: true” header,
+// as an early warning that they are no longer maintained.
+//
+// Successful resolutions carry the import root and the repo root in
+// X-Go-Import-Root and X-Go-VCS-Root headers, for debugging with curl and
+// for CDN logs.
+//
+// Pages and JSON responses carry an ETag, answering requests with a
+// matching If-None-Match with 304 Not Modified, and “Cache-Control: public,
+// max-age=…” for the -max-age duration (default 5m; 0 for no-cache), so that
+// CDNs and the go command's HTTP layer can cache them. The rendered go get
+// pages are kept in memory. HEAD requests are answered like GET requests
+// without the body, and other methods with 405 Method Not Allowed.
+//
+// The -old-repo and -cutover options support moving a namespace between
+// repository hosts: until the cutover time (in RFC 3339 format) the old repo
+// is served, afterwards <repo>. Within the -overlap duration (default 24h)
+// either side of the cutover, responses carry a short Cache-Control max-age
+// (-overlap-max-age, default 1m) so that caches pick up the switch promptly.
+// The old repo must end in as many /* as <import>. With -config, the
+// old_repo and cutover fields of each rule are used instead.
+//
+// The -immutable-state option names a file recording how each import path
+// has been published: its repo and a hash of every option determining the
+// served metadata. Once recorded, go-import-redirector refuses to start,
+// reload or change rules through the admin API with the import path served
+// differently, whether through its repo, another option or a differently
+// spelled rule matching the same paths, protecting consumers from silently
+// repointed imports, unless -allow-repoint is also given. The file is
+// written once the configuration has passed every check. The -immutable
+// option limits this to the comma-separated namespaces (import path
+// prefixes) given, such as example.com/release; by default every import
+// path is frozen. The check subcommand makes the same check.
+//
+// The -transparency-log option names a file to which an entry (import path,
+// repo, VCS, configuration hash and time) is appended at startup whenever the
+// served metadata differs from the last entry for the import path. The entries
+// are the leaves of an RFC 6962 Merkle tree; the entries and the current tree
+// root are served as JSON on /-/transparency-log so consumers can audit that
+// import paths were not silently repointed. With ?leaf=N the response adds the
+// inclusion proof (audit path) of entry N, and with ?from=M the consistency
+// proof between the tree of the first M entries and the current one, both as
+// hex hashes in RFC 6962 order, so a client need not download and rehash the
+// whole log.
+//
+// The -sign-key option names a PEM file holding an Ed25519 private key in
+// PKCS #8 form. When given, metadata responses carry Content-Digest,
+// Signature-Input and Signature headers (RFC 9421 HTTP message signatures)
+// covering the status, content type, body digest and the requested authority
+// and path, so verification tooling can check that the metadata came from
+// this server. A 304 Not Modified response, which has no body, covers the
+// ETag of the cached body instead of its digest. The -sign-key-id option
+// sets the advertised key id.
+//
+// The namespace served is described as JSON on /.well-known/go-modules.
+// The -contact, -policy, -goproxy and -gosumdb options add a contact address,
+// a policy URL and the recommended GOPROXY and GOSUMDB settings to that document.
+//
+// The -stats option enables in-memory usage statistics, aggregated per day,
+// per source network (a /24 for IPv4, a /48 for IPv6) and per module.
+// The report is served as JSON on /-/stats/usage, or as CSV with ?format=csv,
+// and can be limited to one module with ?module=<import root>.
+// The -stats-days option sets how long statistics are kept (default 30 days).
+// At most 1000 modules are counted separately, the others as "(other)"
+// until modules drop out of the statistics, so that scanning a wildcard
+// namespace cannot grow them without limit.
+// The number of go-get requests per module and Go client version is served
+// on /-/stats/go-versions; clients that do not send their version, such as
+// the go command itself, are counted by kind. For requests from browsers, the
+// referring pages (without query strings) are counted per module and served
+// on /-/stats/referers, showing where links to each import path originate.
+// The -geoip-db option names a local MaxMind DB file (such as GeoLite2-Country
+// or GeoLite2-ASN); when given, usage rows also carry the client's country
+// and autonomous system, looked up without contacting any external service.
+// The metrics then count the requests by country, whose codes bound the
+// label values, in gir_requests_by_country_total; autonomous systems are
+// too many to be a label.
+// As they name client networks and referring pages, the /-/stats/ endpoints
+// are administrative endpoints, served only with the admin token.
+//
+// Response bytes are accounted per import root and day, kept for -stats-days
+// and bounded like the statistics, and, with -stats, served as JSON on
+// /-/stats/egress. The -egress-budget option logs a warning the first time
+// the bytes sent in a calendar month exceed the given number.
+//
+// The redirect logic itself is in the package
+// github.com/kastelo/go-import-redirector/redirector, whose New function
+// returns an http.Handler for embedding the redirector in other servers.
 //
 // # Deployment on Google Cloud Platform
 //
@@ -60,27 +890,52 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
 	"flag"
 	"fmt"
-	"html/template"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
+	"time"
+
+	"github.com/kastelo/go-import-redirector/redirector"
 )
 
 var (
-	addr       = flag.String("addr", ":http", "serve http on `address`")
-	vcs        = flag.String("vcs", "git", "set version control `system`")
-	importPath string
-	repoPath   string
-	wildcard   int
+	addr            = flag.String("addr", ":http", "serve http on `address`, or on a Unix socket with unix:path")
+	vcs             = flag.String("vcs", "git", "set version control `system`: git, hg, svn, bzr, fossil or mod")
+	refresh         = flag.Bool("refresh", true, "redirect browsers to the repo with a meta refresh")
+	browserRedirect = flag.String("browser-redirect", "repo", "send browsers to `target`: repo, pkgsite or a URL template with {path}")
+	modProxy        = flag.String("mod-proxy", "", "also advertise the module proxy at `URL` with a mod go-import tag")
+	minGo           = flag.String("min-go", "", "declare the minimum Go `version` the modules require")
+	defaultHost     = flag.String("default-host", "", "treat requests for other hosts, such as a bare IP address, as requests for `host`")
 )
 
 func usage() {
 	fmt.Fprintf(os.Stderr, "usage: go-import-redirector <import> <repo>\n")
+	fmt.Fprintf(os.Stderr, "       go-import-redirector -config file\n")
+	fmt.Fprintf(os.Stderr, "       go-import-redirector -snapshot file\n")
+	fmt.Fprintf(os.Stderr, "       go-import-redirector -config file snapshot <output>\n")
+	fmt.Fprintf(os.Stderr, "       go-import-redirector -config file check [import path ...]\n")
+	fmt.Fprintf(os.Stderr, "       go-import-redirector -config file soak [-duration d] [-concurrency n] [-invalid fraction] [-paths n]\n")
+	fmt.Fprintf(os.Stderr, "       go-import-redirector client [-server url] [-token token] <command>\n")
+	fmt.Fprintf(os.Stderr, "       go-import-redirector certs [-server url] [-token token] [list | renew host... | revoke host...]\n")
+	fmt.Fprintf(os.Stderr, "       go-import-redirector metrics dashboard|alerts\n")
+	fmt.Fprintf(os.Stderr, "       go-import-redirector completion bash|zsh|fish\n")
+	fmt.Fprintf(os.Stderr, "       go-import-redirector manpage\n")
 	fmt.Fprintf(os.Stderr, "options:\n")
-	flag.PrintDefaults()
+	visible := flag.NewFlagSet("", flag.ContinueOnError)
+	visible.SetOutput(os.Stderr)
+	for _, f := range visibleFlags() {
+		visible.Var(f.Value, f.Name, f.Usage)
+		visible.Lookup(f.Name).DefValue = f.DefValue
+	}
+	visible.PrintDefaults()
 	fmt.Fprintf(os.Stderr, "examples:\n")
 	fmt.Fprintf(os.Stderr, "\tgo-import-redirector rsc.io/* https://github.com/rsc/*\n")
 	fmt.Fprintf(os.Stderr, "\tgo-import-redirector 9fans.net/go https://github.com/9fans/go\n")
@@ -91,98 +946,504 @@ func main() {
 	log.SetPrefix("go-import-redirector: ")
 	flag.Usage = usage
 	flag.Parse()
-	if flag.NArg() != 2 {
+	if err := applyEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if err := applyLimits(); err != nil {
+		log.Fatal(err)
+	}
+	switch flag.Arg(0) {
+	case "client":
+		os.Exit(runClient(flag.Args()[1:]))
+	case "completion":
+		os.Exit(runCompletion(flag.Args()[1:]))
+	case "manpage":
+		os.Exit(runManpage(flag.Args()[1:]))
+	case "snapshot":
+		os.Exit(runSnapshot(flag.Args()[1:]))
+	case "check":
+		os.Exit(runCheck(flag.Args()[1:]))
+	case "soak":
+		os.Exit(runSoak(flag.Args()[1:]))
+	case "certs":
+		os.Exit(runCerts(flag.Args()[1:]))
+	case "metrics":
+		os.Exit(runMetrics(flag.Args()[1:]))
+	}
+	args := serveArgs()
+	if configName() == "" && len(args) != 2 || configName() != "" && len(args) != 0 {
 		flag.Usage()
 	}
-	importPath = flag.Arg(0)
-	repoPath = flag.Arg(1)
-	if !strings.Contains(repoPath, "://") {
-		log.Fatal("repo path must be full URL")
+	if err := setupForgeClients(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadRules(args); err != nil {
+		log.Fatal(err)
+	}
+	noteConfigLoad(nil)
+	if configName() != "" {
+		log.Printf("loaded %s: %d rules in %v", configName(), len(currentRules()), time.Since(startTime).Round(time.Millisecond))
+	}
+	if err := checkStrict(currentRules()); err != nil {
+		log.Fatal(err)
+	}
+	if err := checkImmutable(currentRules()); err != nil {
+		log.Fatal(err)
+	}
+	if err := appendTransparencyLog(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadSignKey(); err != nil {
+		log.Fatal(err)
+	}
+	if err := checkDegrade(); err != nil {
+		log.Fatal(err)
+	}
+	warnChaos()
+	if err := openGeoIP(); err != nil {
+		if !degrades("stats") {
+			log.Fatal(err)
+		}
+		setDegraded("stats", err)
+	}
+	if err := loadLocales(); err != nil {
+		if !degrades("template") {
+			log.Fatal(err)
+		}
+		setDegraded("template", err)
+		locales.Store(minimalLocales())
+	}
+	if err := checkBlockPatterns(); err != nil {
+		log.Fatalf("invalid -block: %v", err)
+	}
+	if err := checkReferrerPolicy(); err != nil {
+		log.Fatal(err)
+	}
+	if err := parseTrustedProxies(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadSite(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadShardState(); err != nil {
+		log.Fatal(err)
+	}
+	if err := startDiscovery(); err != nil {
+		log.Fatal(err)
+	}
+	if *pruneInterval > 0 {
+		go prunePeriodically()
+	}
+	if *defaultHost != "" && !slices.Contains(importHosts(), *defaultHost) {
+		log.Fatalf("-default-host %s is not an import host", *defaultHost)
 	}
-	if strings.HasSuffix(importPath, "/*") != strings.HasSuffix(repoPath, "/*") {
-		log.Fatal("either both import and repo must have /* or neither")
+
+	http.HandleFunc("/", chaos(withSecurityHeaders(redirect))) // outside the rules: unknown_route errors
+	for _, r := range currentRules() {
+		handleRule(r)
+	}
+	handleService("/.well-known/go-modules", serveWellKnown)
+	handleService("/robots.txt", serveRobots)
+	handleService("/favicon.ico", serveFavicon)
+	if *sitemap {
+		handleService("/sitemap.xml", serveSitemap)
+	}
+	if *transparencyLog != "" {
+		handleService("/-/transparency-log", serveTransparencyLog)
+	}
+	if *statsEnabled {
+		handleService("/-/stats/usage", adminOnly(serveUsage))
+		handleService("/-/stats/egress", adminOnly(serveEgress))
+		handleService("/-/stats/go-versions", adminOnly(serveGoVersions))
+		handleService("/-/stats/referers", adminOnly(serveReferers))
 	}
-	for strings.HasSuffix(importPath, "/*") {
-		wildcard++
-		importPath = strings.TrimSuffix(importPath, "/*")
-		repoPath = strings.TrimSuffix(repoPath, "/*")
+	handleService("/healthz", serveHealthz)
+	handleService("/readyz", serveReadyz)
+	handleService("/-/status", serveStatus)
+	handleService("/-/tlsinfo", serveTLSInfo)
+	handleService("/-/version", serveVersion)
+	handleService("/-/qr/", serveQR)
+	handleService("/-/openapi.json", serveOpenAPI)
+	handleService("/-/simulate", adminOnly(serveSimulate))
+	handleService("/-/events", adminOnly(serveEvents))
+	handleService("/-/reload", adminOnly(serveReload))
+	handleService("/-/prune", adminOnly(servePrune))
+	handleService("/-/certs", adminOnly(serveCerts))
+	handleService("/-/certs/", adminOnly(serveCerts))
+	if *trackClicks {
+		handleService("/-/out", serveOut)
+	}
+	if *sumdbProxy != "" {
+		handleService("/sumdb/", serveSumDB)
 	}
 
-	http.HandleFunc(strings.TrimSuffix(importPath, "/")+"/", redirect)
-	http.HandleFunc(importPath+"/.ping", pong) // non-redirecting URL for debugging TLS certificates
-	err := http.ListenAndServe(*addr, nil)
+	srv := &http.Server{Addr: *addr, ErrorLog: log.New(serverErrorLog{}, "", log.LstdFlags)}
+	if *hsts > 0 {
+		srv.Handler = strictTransport(http.DefaultServeMux)
+	}
+	if *rateLimit > 0 && *rateBurst < 1 {
+		log.Fatal("-rate-burst must be at least 1")
+	}
+	if *rateLimit > 0 && strings.HasPrefix(*addr, "unix:") && !trustUnix {
+		log.Fatal("-rate-limit on a Unix socket requires unix in -trusted-proxies, for the client addresses in X-Forwarded-For")
+	}
+	limitServer(srv)
+	if *logFile != "" {
+		if err := openAccessLog(); err != nil {
+			log.Fatal(err)
+		}
+		if srv.Handler == nil {
+			srv.Handler = http.DefaultServeMux
+		}
+		srv.Handler = accessLogged(srv.Handler)
+	}
+	if len(proxyNets) > 0 {
+		if srv.Handler == nil {
+			srv.Handler = http.DefaultServeMux
+		}
+		srv.Handler = forwarded(srv.Handler)
+	}
+	if *snapshotFile != "" {
+		if srv.Handler == nil {
+			srv.Handler = http.DefaultServeMux
+		}
+		srv.Handler = holdingMapping(srv.Handler)
+	}
+	switch {
+	case *tlsFlag && *autocertFlag:
+		log.Fatal("-tls and -autocert are mutually exclusive")
+	case *tlsFlag:
+		if err := loadCertificate(); err != nil {
+			log.Fatal(err)
+		}
+		if *ocspStaple {
+			startOCSP()
+		}
+		srv.TLSConfig = &tls.Config{GetCertificate: servedCertificate}
+	case *autocertFlag:
+		if *httpRedirect != "" {
+			log.Fatal("-http-redirect cannot be used with -autocert, which redirects HTTP on -autocert-http")
+		}
+		srv.TLSConfig = setupAutocert()
+	case *httpRedirect != "":
+		log.Fatal("-http-redirect requires -tls")
+	}
+	if tlsEnabled() && !flagSet("addr") {
+		srv.Addr = ":https"
+	}
+	if *httpRedirect != "" {
+		go serveHTTPRedirect(srv.Addr)
+	}
+	if *metricsAddr != "" {
+		go serveMetrics()
+	}
+	if *adminAddr != "" {
+		if *configFile == "" || adminSecret() == "" {
+			log.Fatal("-admin-addr requires -config and an admin token")
+		}
+		go serveAdmin()
+	}
+	if *grpcAddr != "" {
+		go serveGRPC()
+	}
+	ln, err := listen(srv.Addr)
 	if err != nil {
 		log.Fatal(err)
 	}
+	ln = limitListener(ln)
+	if err := recordImmutable(currentRules()); err != nil {
+		log.Fatal(err)
+	}
+	startupSeconds.Set(time.Since(startTime).Seconds())
+	done := make(chan struct{})
+	go handleSignals(srv, done)
+	if *watchConfig > 0 && configName() != "" && configEnv == "" {
+		go watchConfigFile()
+	}
+	if tlsEnabled() {
+		err = srv.ServeTLS(ln, "", "")
+	} else {
+		err = srv.Serve(ln)
+	}
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-done
 }
 
-var tmpl = template.Must(template.New("main").Parse(`<!DOCTYPE html>
-<html>
-<head>
-<meta http-equiv="Content-Type" content="text/html; charset=utf-8"/>
-<meta name="go-import" content="{{.ImportRoot}} {{.VCS}} {{.VCSRoot}}">
-<meta http-equiv="refresh" content="0; url={{.VCSRoot}}">
-</head>
-<body>
-Redirecting to <a href="{{.VCSRoot}}">{{.VCSRoot}}</a>...
-</body>
-</html>
-`))
-
-type data struct {
-	ImportRoot string
-	VCS        string
-	VCSRoot    string
-	Suffix     string
+// flagSet reports whether the option name was given explicitly.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// handledRoots are the import path roots with registered handlers.
+// Handlers cannot be unregistered; roots dropped on reload fall through to
+// unknown_route.
+var handledRoots = make(map[string]bool)
+
+// A service is an endpoint registered with handleService.
+type service struct {
+	path string
+	h    http.HandlerFunc
+}
+
+var (
+	services     []service
+	serviceHosts = make(map[string]bool) // hosts the services are registered for
+)
+
+// handleRule registers the handlers for r's import path root.
+func handleRule(r *redirector.Rule) {
+	if handledRoots[r.Root()] {
+		return
+	}
+	handledRoots[keepString(r.Root())] = true
+	http.HandleFunc(r.Root()+"/", chaos(withSecurityHeaders(redirect)))
+	http.HandleFunc(r.Root()+"/.ping", withSecurityHeaders(pong)) // non-redirecting URL for debugging TLS certificates
+	handleServiceHost(r.Host())
+}
+
+// handleService registers a service endpoint for any host and for the
+// hosts of the handled rules, where the redirect handler would otherwise
+// take precedence. Hosts first handled on reload get it then.
+func handleService(path string, h http.HandlerFunc) {
+	http.HandleFunc(path, h)
+	services = append(services, service{path, h})
+	for host := range serviceHosts {
+		http.HandleFunc(host+path, h)
+	}
+}
+
+// handleServiceHost registers the service endpoints for host, unless
+// they are already.
+func handleServiceHost(host string) {
+	if serviceHosts[host] {
+		return
+	}
+	serviceHosts[keepString(host)] = true
+	for _, s := range services {
+		http.HandleFunc(host+s.path, s.h)
+	}
+}
+
+// resolve matches path (host and URL path, without trailing slash)
+// against the rules at time now, applying the -shard-state pins.
+func resolve(path string, now time.Time) *redirector.Resolution {
+	return resolveContext(context.Background(), path, now)
+}
+
+// resolveContext is resolve, giving up once ctx is done.
+func resolveContext(ctx context.Context, path string, now time.Time) *redirector.Resolution {
+	r := rules.Load().ResolveContext(ctx, path, now)
+	pinnedShard(r)
+	return r
+}
+
+// newData returns the page data for a successful resolution. Unless
+// offline, the archived state of the repo is looked up on its forge.
+func newData(r *redirector.Resolution, offline bool) *redirector.Data {
+	d := r.Data()
+	d.Refresh = d.Refresh && *refresh
+	if !offline {
+		d.Archived = repoArchived(d.VCSRoot)
+	}
+	if d.Owner == "" && !offline {
+		d.Owner = codeowner(r)
+	}
+	if *trackClicks {
+		d.SourceLink = outLink("source", d.ImportRoot+d.Suffix)
+		d.DocsLink = outLink("docs", d.ImportRoot+d.Suffix)
+	}
+	return d
+}
+
+// requestHost returns the import host req is for: its Host, or the
+// -default-host if that is not an import host.
+func requestHost(req *http.Request) string {
+	if *defaultHost == "" {
+		return req.Host
+	}
+	host, _, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		host = req.Host
+	}
+	for _, h := range importHosts() {
+		if h == req.Host || h == host {
+			return h
+		}
+	}
+	return *defaultHost
 }
 
 func redirect(w http.ResponseWriter, req *http.Request) {
-	path := strings.TrimSuffix(req.Host+req.URL.Path, "/")
-	var importRoot, repoRoot, suffix string
-	if wildcard > 0 {
-		if path == importPath {
-			http.Redirect(w, req, repoPath, http.StatusFound)
-			return
+	if !redirector.AllowedMethod(w, req) {
+		return
+	}
+	if req.FormValue("__explain") == "1" {
+		adminOnly(serveExplain)(w, req)
+		return
+	}
+	if serveShortcut(w, req) {
+		return
+	}
+	var importRoot string
+	start := time.Now()
+	if *requestTimeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), *requestTimeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+	path := strings.TrimSuffix(requestHost(req)+req.URL.Path, "/")
+	r := resolveContext(req.Context(), path, start)
+	cw := &countingWriter{ResponseWriter: w}
+	defer func() {
+		egress.add(importRoot, cw.n)
+		events.publish(path, r.Route, cw.status)
+		observeRequest(req, r.ImportRoot, cw.status, time.Since(start))
+		noteAccess(req, r)
+	}()
+	w = cw
+
+	if r.InOverlap {
+		setOverlapHeaders(w)
+	}
+	if *indexFlag && req.FormValue("go-get") != "1" && isIndexPath(path, r) {
+		serveIndex(w, req, path)
+		return
+	}
+	switch r.Status {
+	case http.StatusFound:
+		http.Redirect(w, req, r.Location, http.StatusFound)
+		return
+	case http.StatusNotFound:
+		redirector.ServeError(w, req, http.StatusNotFound, r.Code)
+		return
+	case http.StatusInternalServerError:
+		log.Printf("%s: %s", path, r.ExprError)
+		redirector.ServeError(w, req, r.Status, r.Code)
+		return
+	case http.StatusGatewayTimeout:
+		log.Printf("%s: no resolution within -request-timeout", path)
+		redirector.ServeError(w, req, r.Status, r.Code)
+		return
+	}
+	if code := refusal(req.Context(), r, true); code != "" {
+		redirector.ServeError(w, req, refusalStatus(code), code)
+		return
+	}
+	importRoot = r.ImportRoot
+	if req.FormValue("go-get") == "1" {
+		pinShard(r)
+		noteServedModule(r)
+	}
+	d := newData(r, false)
+	w.Header()["Vary"] = varyHeader
+	w.Header().Set("X-Go-Import-Root", d.ImportRoot)
+	w.Header().Set("X-Go-VCS-Root", d.VCSRoot)
+	if d.Archived {
+		w.Header().Set("X-Go-Repo-Archived", "true")
+	}
+	if d.MinGo != "" {
+		w.Header().Set("X-Go-Min-Version", d.MinGo)
+		if v := goVersionFromUA(req.UserAgent()); v != "" && goVersionLess(v, d.MinGo) {
+			w.Header().Set("X-Go-Version-Warning", "requires Go "+d.MinGo+" or later, client is Go "+v)
 		}
-		if !strings.HasPrefix(path, importPath+"/") {
-			http.NotFound(w, req)
+	}
+	if req.FormValue("go-get") != "1" && redirector.AcceptsJSON(req) {
+		body, err := json.Marshal(d)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
 			return
 		}
-		elem := path[len(importPath)+1:]
-		if parts := strings.Split(elem, "/"); len(parts) >= wildcard {
-			elem = strings.Join(parts[:wildcard], "/")
-			suffix = strings.Join(parts[wildcard:], "/")
-			if suffix != "" {
-				suffix = "/" + suffix
-			}
-		} else {
-			http.NotFound(w, req)
+		stats.record(req, importRoot)
+		w.Header().Set("Content-Type", "application/json")
+		writeBody(w, req, body, "")
+		return
+	}
+	if r.Canonical != "" {
+		// An alias of moved modules sends browsers on to the new import
+		// path at once, below, rather than through the canonical one.
+		if req.FormValue("go-get") != "1" && r.MovedTo == "" {
+			stats.record(req, importRoot)
+			http.Redirect(w, req, redirectScheme(req)+"://"+r.Canonical, http.StatusMovedPermanently)
 			return
 		}
-		importRoot = importPath + "/" + elem
-		repoRoot = repoPath + "/" + elem
-	} else {
-		if path != importPath && !strings.HasPrefix(path, importPath+"/") {
-			http.NotFound(w, req)
+		w.Header().Set("Link", "<https://"+r.Canonical+">; rel=\"canonical\"")
+	}
+	if r.MovedTo != "" {
+		if req.FormValue("go-get") != "1" {
+			stats.record(req, importRoot)
+			http.Redirect(w, req, redirectScheme(req)+"://"+r.MovedTo, http.StatusMovedPermanently)
 			return
 		}
-		importRoot = importPath
-		repoRoot = repoPath
-		suffix = path[len(importPath):]
+		w.Header().Add("Link", "<https://"+r.MovedTo+">; rel=\"successor-version\"")
 	}
-	d := &data{
-		ImportRoot: importRoot,
-		VCS:        *vcs,
-		VCSRoot:    repoRoot,
-		Suffix:     suffix,
-	}
-	var buf bytes.Buffer
-	err := tmpl.Execute(&buf, d)
-	if err != nil {
-		http.Error(w, err.Error(), 500)
+	if u := r.BrowserURL(); u != "" && req.FormValue("go-get") != "1" {
+		stats.record(req, importRoot)
+		http.Redirect(w, req, u, http.StatusFound)
 		return
 	}
-	w.Write(buf.Bytes())
+	goGet := req.FormValue("go-get") == "1"
+	if !goGet {
+		d.Versions = moduleVersions(d.ImportRoot)
+	}
+	// Rendering the templates is most of the cost of a request, so the
+	// pages are memoized, along with their ETags.
+	lang := req.Header.Get("Accept-Language")
+	key := pageKey(path, lang, d.Archived, goGet)
+	p := cachedPage(key, r, d.Owner, d.Versions)
+	if p == nil {
+		buf := bufPool.Get().(*bytes.Buffer)
+		defer bufPool.Put(buf)
+		buf.Reset()
+		err := pageTemplate(r.Rule, lang).Execute(buf, d)
+		if err != nil && degrades("template") {
+			setDegraded("template", fmt.Errorf("%s: %v", path, err))
+			buf.Reset()
+			err = redirector.MinimalPage.Execute(buf, d)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if *strict {
+			if err := checkMeta(buf.Bytes(), path); err != nil {
+				log.Printf("%s: rendered page fails go command checks: %v", path, err)
+				redirector.ServeError(w, req, http.StatusInternalServerError, redirector.CodeInvalidMetadata)
+				return
+			}
+		}
+		p = storePage(key, r, d.Owner, d.Versions, bytes.Clone(buf.Bytes()))
+	}
+	stats.record(req, importRoot)
+	w.Header()["Content-Type"] = htmlContentType
+	writeBody(w, req, p.body, p.etag)
+}
+
+// The headers set on every page are shared rather than allocated anew.
+var (
+	varyHeader      = []string{"Accept, Accept-Language"}
+	htmlContentType = []string{"text/html; charset=utf-8"}
+)
+
+// pageKey returns the pageCache key of a page.
+func pageKey(path, lang string, archived, goGet bool) string {
+	flags := "\x00\x00"
+	switch {
+	case archived && goGet:
+		flags = "\x00\x03"
+	case archived:
+		flags = "\x00\x01"
+	case goGet:
+		flags = "\x00\x02"
+	}
+	return path + "\x00" + lang + flags
 }
 
 func pong(w http.ResponseWriter, req *http.Request) {
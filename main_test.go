@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testConfig = `rules:
+  - import: example.com/foo
+    repo: https://github.com/example/foo
+  - import: example.com/x/*
+    repo: https://github.com/example/*
+`
+
+// serveConfig serves the rules of the YAML configuration conf, as read
+// from a -config file, offline, and returns the name of the file.
+func serveConfig(tb testing.TB, conf string) string {
+	tb.Helper()
+	name := filepath.Join(tb.TempDir(), "config.yaml")
+	if err := os.WriteFile(name, []byte(conf), 0o644); err != nil {
+		tb.Fatal(err)
+	}
+	oldConfig, oldProxy := *configFile, *versionProxy
+	*configFile, *versionProxy = name, ""
+	tb.Cleanup(func() { *configFile, *versionProxy = oldConfig, oldProxy })
+	if err := loadOffline(); err != nil {
+		tb.Fatal(err)
+	}
+	clearPageCache()
+	return name
+}
+
+// get serves a GET request for url with the redirect handler.
+func get(url string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	redirect(w, httptest.NewRequest(http.MethodGet, url, nil))
+	return w
+}
+
+func TestRedirect(t *testing.T) {
+	serveConfig(t, testConfig)
+	tests := []struct {
+		url    string
+		status int
+		meta   string
+	}{
+		{"http://example.com/foo/?go-get=1", 200, `<meta name="go-import" content="example.com/foo git https://github.com/example/foo">`},
+		{"http://example.com/foo/bar/baz?go-get=1", 200, `<meta name="go-import" content="example.com/foo git https://github.com/example/foo">`},
+		{"http://example.com/x/y/z?go-get=1", 200, `<meta name="go-import" content="example.com/x/y git https://github.com/example/y">`},
+		{"http://example.com/nope/?go-get=1", 404, ""},
+	}
+	for _, tt := range tests {
+		w := get(tt.url)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.url, w.Code, tt.status)
+			continue
+		}
+		if tt.meta != "" && !strings.Contains(w.Body.String(), tt.meta) {
+			t.Errorf("%s: page lacks %s:\n%s", tt.url, tt.meta, w.Body)
+		}
+	}
+}
+
+func BenchmarkRedirect(b *testing.B) {
+	serveConfig(b, testConfig)
+	for _, bb := range []struct{ name, url string }{
+		{"go-get", "http://example.com/foo/bar?go-get=1"},
+		{"browser", "http://example.com/foo/bar"},
+		{"wildcard", "http://example.com/x/y/z?go-get=1"},
+		{"not-found", "http://example.com/nope/?go-get=1"},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			req := httptest.NewRequest(http.MethodGet, bb.url, nil)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				redirect(httptest.NewRecorder(), req)
+			}
+		})
+	}
+}
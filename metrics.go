@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kastelo/go-import-redirector/redirector"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var metricsAddr = flag.String("metrics-addr", "", "serve Prometheus metrics on /metrics at `address`")
+
+// maxMetricRoots bounds the import_root label values, so that scans of a
+// wildcard namespace cannot grow the metrics without limit.
+const maxMetricRoots = 1000
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gir_requests_total",
+		Help: "Requests to the redirect handler by import root, status and client kind.",
+	}, []string{"import_root", "status", "client"})
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:                            "gir_request_duration_seconds",
+		Help:                            "Latency of the redirect handler by client kind.",
+		Buckets:                         prometheus.DefBuckets,
+		NativeHistogramBucketFactor:     1.1,
+		NativeHistogramMaxBucketNumber:  100,
+		NativeHistogramMinResetDuration: time.Hour,
+	}, []string{"client"})
+	requestsByCountry = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gir_requests_by_country_total",
+		Help: "Requests to the redirect handler by client country, with -geoip-db.",
+	}, []string{"country"})
+	outboundClicks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gir_outbound_clicks_total",
+		Help: "Clicks on the page links routed through /-/out, by import root and target.",
+	}, []string{"import_root", "target"})
+	egressBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gir_egress_bytes_total",
+		Help: "Response bytes sent by the redirect handler, by module (import root).",
+	}, []string{"module"})
+	tlsChainInvalid = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gir_tls_chain_invalid",
+		Help: "Whether the certificate chain served for the import host does not verify for it (1) or does (0).",
+	}, []string{"host"})
+	tlsHandshakeErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gir_tls_handshake_errors_total",
+		Help: "Failed TLS handshakes.",
+	})
+	configLoadSeconds = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gir_config_load_seconds",
+		Help: "Time taken by the last load of the -config file, from reading to validated rules.",
+	})
+	startupSeconds = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gir_startup_seconds",
+		Help: "Time from process start until the server was listening.",
+	})
+	configReloadSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gir_config_last_reload_successful",
+		Help: "Whether the last load of the -config file was served (1) or rejected (0).",
+	})
+	configLoadedTime = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gir_config_loaded_timestamp_seconds",
+		Help: "Time the served configuration was loaded, in seconds since the epoch.",
+	})
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "gir_config_file_modified_timestamp_seconds",
+		Help: "Modification time of the -config or -snapshot file, in seconds since the epoch (0 if unknown).",
+	}, configModified)
+)
+
+// certExpiryDesc describes the expiry of the served certificates.
+var certExpiryDesc = prometheus.NewDesc("gir_cert_expiry_timestamp_seconds",
+	"Expiry of the certificate served for the import host, in seconds since the epoch.",
+	[]string{"host"}, nil)
+
+// certCollector exports the expiry of the certificates in the
+// certInventory, as read at the time of the scrape.
+type certCollector struct{}
+
+func (certCollector) Describe(ch chan<- *prometheus.Desc) { ch <- certExpiryDesc }
+
+func (certCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, e := range certInventory(context.Background()) {
+		if e.NotAfter.IsZero() {
+			continue
+		}
+		for _, host := range e.Hosts {
+			ch <- prometheus.MustNewConstMetric(certExpiryDesc, prometheus.GaugeValue, float64(e.NotAfter.Unix()), host)
+		}
+	}
+}
+
+func init() {
+	prometheus.MustRegister(certCollector{})
+}
+
+// configModified returns the modification time of the -config or
+// -snapshot file in seconds since the epoch, or 0.
+func configModified() float64 {
+	name := *configFile
+	if *snapshotFile != "" {
+		name = *snapshotFile
+	}
+	fi, err := os.Stat(name)
+	if name == "" || configEnv != "" || err != nil {
+		return 0
+	}
+	return float64(fi.ModTime().Unix())
+}
+
+// noteConfigLoad records in the metrics whether a load of the
+// configuration succeeded.
+func noteConfigLoad(err error) {
+	if err != nil {
+		configReloadSuccess.Set(0)
+		return
+	}
+	configReloadSuccess.Set(1)
+	configLoadedTime.SetToCurrentTime()
+}
+
+// metricRoots holds the import_root label values in use, each the string
+// its metrics keep.
+var metricRoots = struct {
+	sync.Mutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+// metricRoot returns the import_root label value for root.
+func metricRoot(root string) string {
+	if root == "" {
+		return "(unmatched)"
+	}
+	metricRoots.Lock()
+	defer metricRoots.Unlock()
+	label, ok := metricRoots.m[root]
+	if !ok {
+		if len(metricRoots.m) >= maxMetricRoots {
+			return "(other)"
+		}
+		label = keepString(root)
+		metricRoots.m[label] = label
+	}
+	return label
+}
+
+// clientKind classifies a request as go-get, json or browser traffic.
+func clientKind(req *http.Request) string {
+	switch {
+	case req.FormValue("go-get") == "1":
+		return "go-get"
+	case redirector.AcceptsJSON(req):
+		return "json"
+	}
+	return "browser"
+}
+
+// observeRequest records a request to the redirect handler. The latency
+// observation carries the trace ID of an incoming W3C traceparent header
+// as an exemplar.
+func observeRequest(req *http.Request, root string, status int, d time.Duration) {
+	client := clientKind(req)
+	requestsTotal.WithLabelValues(metricRoot(root), strconv.Itoa(status), client).Inc()
+	if geoIPDB != nil {
+		requestsByCountry.WithLabelValues(metricCountry(clientIP(req))).Inc()
+	}
+	obs := requestDuration.WithLabelValues(client)
+	if id := traceID(req.Header.Get("Traceparent")); id != "" {
+		obs.(prometheus.ExemplarObserver).ObserveWithExemplar(d.Seconds(), prometheus.Labels{"trace_id": id})
+		return
+	}
+	obs.Observe(d.Seconds())
+}
+
+// traceID returns the trace ID in a traceparent header value, or "".
+func traceID(traceparent string) string {
+	_, rest, ok := strings.Cut(traceparent, "-")
+	id, rest, ok2 := strings.Cut(rest, "-")
+	if !ok || !ok2 || !strings.Contains(rest, "-") || len(id) != 32 || strings.Trim(id, "0") == "" {
+		return ""
+	}
+	return id
+}
+
+// serveMetrics serves the metrics on -metrics-addr, in OpenMetrics format
+// when the scraper accepts it, so that exemplars are included.
+func serveMetrics() {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+	log.Fatal(http.ListenAndServe(*metricsAddr, mux))
+}
+
+// serverErrorLog is the error log of the HTTP server. It counts TLS
+// handshake errors before passing the messages on to the standard log.
+type serverErrorLog struct{}
+
+func (serverErrorLog) Write(p []byte) (int, error) {
+	if bytes.Contains(p, []byte("TLS handshake error")) {
+		tlsHandshakeErrors.Inc()
+	}
+	return os.Stderr.Write(p)
+}
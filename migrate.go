@@ -0,0 +1,22 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var (
+	oldRepo       = flag.String("old-repo", "", "serve `repo` until the -cutover time")
+	cutoverFlag   = flag.String("cutover", "", "switch from -old-repo to <repo> at `time` (RFC 3339)")
+	overlap       = flag.Duration("overlap", 24*time.Hour, "shorten cache lifetimes within `duration` of the cutover")
+	overlapMaxAge = flag.Duration("overlap-max-age", time.Minute, "Cache-Control max-age during the overlap `duration`")
+)
+
+// setOverlapHeaders makes caches revalidate quickly while a migration is
+// in progress.
+func setOverlapHeaders(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d, must-revalidate", int(overlapMaxAge.Seconds())))
+	w.Header().Set("Pragma", "no-cache")
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// mapFile reads the file name into memory, where it cannot be mapped.
+func mapFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+// unmapFile leaves data, which was read rather than mapped, to the garbage
+// collector.
+func unmapFile(data []byte) error {
+	return nil
+}
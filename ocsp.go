@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+var ocspStaple = flag.Bool("ocsp-staple", false, "staple OCSP responses to the -tls certificates")
+
+var ocspClient = &http.Client{Timeout: 30 * time.Second}
+
+// servedCertificate returns the certificate to present: the one obtained
+// by -autocert, or the -tls certificate for the requested server name with
+// its current OCSP staple if there is one.
+func servedCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if err := chaosTLSFailure(hello.ServerName); err != nil {
+		return nil, err
+	}
+	if m := certManager.Load(); m != nil {
+		return m.GetCertificate(hello)
+	}
+	sc, _ := certFor(hello.ServerName)
+	if c := sc.stapled.Load(); c != nil {
+		return c, nil
+	}
+	return sc.cert.Load(), nil
+}
+
+// startOCSP starts keeping OCSP staples for the -tls certificates.
+func startOCSP() {
+	for _, sc := range tlsCerts {
+		go sc.refreshOCSP()
+	}
+}
+
+// refreshOCSP keeps an OCSP staple for sc up to date.
+func (sc *servedCert) refreshOCSP() {
+	for {
+		time.Sleep(sc.staple())
+	}
+}
+
+// staple fetches an OCSP staple for sc and returns when to refresh it:
+// halfway through the validity of the response.
+func (sc *servedCert) staple() time.Duration {
+	next := 5 * time.Minute
+	cert := sc.cert.Load()
+	staple, resp, err := fetchOCSP(cert)
+	if err != nil {
+		log.Printf("OCSP: %s: %v", sc.file, err)
+		return next
+	}
+	if resp.Status != ocsp.Good {
+		log.Printf("WARNING: OCSP: %s: certificate status is %s", sc.file, ocspStatus(resp.Status))
+	}
+	c := *cert
+	c.OCSPStaple = staple
+	sc.stapled.Store(&c)
+	if d := time.Until(resp.NextUpdate) / 2; d > next {
+		next = d
+	}
+	return min(next, 24*time.Hour)
+}
+
+// fetchOCSP asks the certificate's OCSP responder about its status.
+func fetchOCSP(cert *tls.Certificate) ([]byte, *ocsp.Response, error) {
+	if len(cert.Certificate) < 2 {
+		return nil, nil, errors.New("certificate chain has no issuer certificate")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(leaf.OCSPServer) == 0 {
+		return nil, nil, errors.New("certificate names no OCSP responder")
+	}
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	hr, err := ocspClient.Post(leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer hr.Body.Close()
+	if hr.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("%s: %s", leaf.OCSPServer[0], hr.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(hr.Body, 1<<20))
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := ocsp.ParseResponseForCert(body, leaf, issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, resp, nil
+}
+
+func ocspStatus(s int) string {
+	switch s {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	}
+	return "unknown"
+}
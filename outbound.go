@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/net/proxy"
+)
+
+var (
+	forgeCA       = flag.String("forge-ca", "", "trust the CA certificates in PEM `file` too for the requests to forges and module proxies, such as a private CA of a self-hosted forge")
+	forgeInsecure = flag.String("forge-insecure", "", "do not verify the TLS certificates of these comma-separated forge `hosts` (insecure)")
+	forgeVia      = flag.String("forge-via", "", "reach forge hosts through tunnels, given as comma-separated `host=url` with a socks5:// proxy or ssh:// jump host URL, and * for any host")
+	forgeSSHKey   = flag.String("forge-ssh-key", "", "authenticate to -forge-via ssh:// jump hosts with the private key in `file`")
+	forgeSSHHosts = flag.String("forge-ssh-known-hosts", "", "verify -forge-via ssh:// jump hosts against the known_hosts `file` (default ~/.ssh/known_hosts)")
+)
+
+// setupForgeClients sets up the TLS trust of the clients for the forges
+// and module proxies: verify-repos, -discover, -check-archived and the
+// -version-proxy. Hosts in -forge-insecure are not verified, which is
+// logged. Hosts in -forge-via are reached through their tunnels.
+func setupForgeClients() error {
+	if *forgeCA == "" && *forgeInsecure == "" && *forgeVia == "" {
+		return nil
+	}
+	rt, err := newForgeTransport(*forgeCA, *forgeInsecure)
+	if err != nil {
+		return err
+	}
+	if rt.via, err = parseForgeVia(*forgeVia); err != nil {
+		return err
+	}
+	versionClient.Transport, repoClient.Transport = rt, rt
+	return nil
+}
+
+// A forgeTransport sends requests to the insecure hosts without verifying
+// their certificates, and the others trusting the system roots and the
+// certificates of the -forge-ca file.
+type forgeTransport struct {
+	secure, insecure http.RoundTripper
+	hosts            []string                       // insecure
+	via              map[string]proxy.ContextDialer // by host, or * for any
+}
+
+func (t *forgeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if slices.Contains(t.hosts, strings.ToLower(req.URL.Hostname())) {
+		return t.insecure.RoundTrip(req)
+	}
+	return t.secure.RoundTrip(req)
+}
+
+// newForgeTransport returns the transport trusting the system roots and
+// the certificates in the PEM file ca, and skipping the verification of
+// the comma-separated hosts insecure.
+func newForgeTransport(ca, insecure string) (*forgeTransport, error) {
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if ca != "" {
+		pem, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, fmt.Errorf("-forge-ca: %v", err)
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("-forge-ca: no certificates in %s", ca)
+		}
+	}
+	t := new(forgeTransport)
+	for _, h := range strings.Split(insecure, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			t.hosts = append(t.hosts, h)
+			log.Printf("WARNING: -forge-insecure: the TLS certificate of %s is not verified; anyone on the path can impersonate it", h)
+		}
+	}
+	verified := http.DefaultTransport.(*http.Transport).Clone()
+	verified.TLSClientConfig = &tls.Config{RootCAs: roots}
+	verified.DialContext, verified.Proxy = t.dial, t.proxy
+	unverified := http.DefaultTransport.(*http.Transport).Clone()
+	unverified.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	unverified.DialContext, unverified.Proxy = t.dial, t.proxy
+	t.secure, t.insecure = verified, unverified
+	return t, nil
+}
+
+var directDialer = &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
+// tunnel returns the -forge-via tunnel to host, or nil.
+func (t *forgeTransport) tunnel(host string) proxy.ContextDialer {
+	if d := t.via[strings.ToLower(host)]; d != nil {
+		return d
+	}
+	return t.via["*"]
+}
+
+// proxy returns the HTTP proxy of the environment for req, unless its
+// host is reached through a tunnel.
+func (t *forgeTransport) proxy(req *http.Request) (*url.URL, error) {
+	if t.tunnel(req.URL.Hostname()) != nil {
+		return nil, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// dial connects to addr through the tunnel of its host, if any.
+func (t *forgeTransport) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if d := t.tunnel(host); d != nil {
+		return d.DialContext(ctx, network, addr)
+	}
+	return directDialer.DialContext(ctx, network, addr)
+}
+
+// parseForgeVia parses the -forge-via tunnels.
+func parseForgeVia(list string) (map[string]proxy.ContextDialer, error) {
+	via := make(map[string]proxy.ContextDialer)
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		host, raw, ok := strings.Cut(s, "=")
+		if !ok || host == "" {
+			return nil, fmt.Errorf("-forge-via %s: want host=url", s)
+		}
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("-forge-via %s: %v", host, err)
+		}
+		var d proxy.ContextDialer
+		switch u.Scheme {
+		case "socks5", "socks5h":
+			var auth *proxy.Auth
+			if u.User != nil {
+				auth = &proxy.Auth{User: u.User.Username()}
+				auth.Password, _ = u.User.Password()
+			}
+			pd, err := proxy.SOCKS5("tcp", u.Host, auth, directDialer)
+			if err != nil {
+				return nil, fmt.Errorf("-forge-via %s: %v", host, err)
+			}
+			d = pd.(proxy.ContextDialer)
+		case "ssh":
+			if d, err = newSSHDialer(u); err != nil {
+				return nil, fmt.Errorf("-forge-via %s: %v", host, err)
+			}
+		default:
+			return nil, fmt.Errorf("-forge-via %s: unsupported tunnel %q: want socks5:// or ssh://", host, raw)
+		}
+		via[strings.ToLower(host)] = d
+	}
+	return via, nil
+}
+
+// An sshDialer connects through an SSH jump host, keeping one SSH
+// connection for all the tunneled connections and connecting again once
+// it fails.
+type sshDialer struct {
+	addr   string
+	config *ssh.ClientConfig
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// newSSHDialer returns the dialer of the ssh://user@host[:port] URL u,
+// with the -forge-ssh-key and -forge-ssh-known-hosts files.
+func newSSHDialer(u *url.URL) (*sshDialer, error) {
+	if u.User == nil || u.User.Username() == "" {
+		return nil, errors.New("ssh:// URL needs a user")
+	}
+	if *forgeSSHKey == "" {
+		return nil, errors.New("ssh:// tunnels need -forge-ssh-key")
+	}
+	pem, err := os.ReadFile(*forgeSSHKey)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(pem)
+	if err != nil {
+		return nil, fmt.Errorf("-forge-ssh-key: %v", err)
+	}
+	known := *forgeSSHHosts
+	if known == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		known = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKey, err := knownhosts.New(known)
+	if err != nil {
+		return nil, fmt.Errorf("-forge-ssh-known-hosts: %v", err)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	return &sshDialer{addr: addr, config: &ssh.ClientConfig{
+		User:            u.User.Username(),
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKey,
+		Timeout:         30 * time.Second,
+	}}, nil
+}
+
+func (d *sshDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.client != nil {
+		c, err := d.client.DialContext(ctx, network, addr)
+		if err == nil {
+			return c, nil
+		}
+		// The SSH connection may have dropped; connect again.
+		d.client.Close()
+		d.client = nil
+	}
+	client, err := ssh.Dial("tcp", d.addr, d.config)
+	if err != nil {
+		return nil, fmt.Errorf("ssh %s: %v", d.addr, err)
+	}
+	d.client = client
+	return client.DialContext(ctx, network, addr)
+}
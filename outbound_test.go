@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestForgeTransport(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ca, insecure string
+		ok           bool
+	}{
+		{"", "", false},
+		{ca, "", true},
+		{"", "127.0.0.1", true},
+		{"", "other.example.com", false},
+	}
+	for _, tt := range tests {
+		rt, err := newForgeTransport(tt.ca, tt.insecure)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &http.Client{Transport: rt}
+		resp, err := c.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err == nil) != tt.ok {
+			t.Errorf("-forge-ca %q -forge-insecure %q: error %v, want success %v", tt.ca, tt.insecure, err, tt.ok)
+		}
+	}
+
+	if _, err := newForgeTransport(filepath.Join(t.TempDir(), "missing.pem"), ""); err == nil {
+		t.Error("missing -forge-ca file accepted")
+	}
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	os.WriteFile(empty, []byte("not a certificate\n"), 0o644)
+	if _, err := newForgeTransport(empty, ""); err == nil {
+		t.Error("-forge-ca file without certificates accepted")
+	}
+}
+
+// serveSOCKS5 serves a SOCKS5 proxy without authentication for
+// connections to IPv4 addresses, and returns its address and the number
+// of connections it made.
+func serveSOCKS5(t *testing.T) (string, *atomic.Int32) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	var n atomic.Int32
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				buf := make([]byte, 10)
+				// Greeting with one method, then a CONNECT request to an
+				// IPv4 address and port.
+				if _, err := io.ReadFull(c, buf[:3]); err != nil {
+					return
+				}
+				c.Write([]byte{5, 0})
+				if _, err := io.ReadFull(c, buf); err != nil || buf[3] != 1 {
+					return
+				}
+				addr := net.JoinHostPort(net.IP(buf[4:8]).String(), strconv.Itoa(int(buf[8])<<8|int(buf[9])))
+				up, err := net.Dial("tcp", addr)
+				if err != nil {
+					c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer up.Close()
+				n.Add(1)
+				c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				go io.Copy(up, c)
+				io.Copy(c, up)
+			}()
+		}
+	}()
+	return l.Addr().String(), &n
+}
+
+func TestForgeVia(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { io.WriteString(w, "ok") }))
+	defer srv.Close()
+	socks, n := serveSOCKS5(t)
+
+	for _, via := range []string{"127.0.0.1=socks5://" + socks, "*=socks5://" + socks, "other.example.com=socks5://" + socks} {
+		rt, err := newForgeTransport("", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rt.via, err = parseForgeVia(via); err != nil {
+			t.Fatal(err)
+		}
+		before := n.Load()
+		resp, err := (&http.Client{Transport: rt}).Get(srv.URL)
+		if err != nil {
+			t.Fatalf("-forge-via %s: %v", via, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		tunneled := n.Load() > before
+		if string(body) != "ok" || tunneled == strings.HasPrefix(via, "other") {
+			t.Errorf("-forge-via %s: %q, tunneled %v", via, body, tunneled)
+		}
+	}
+
+	for _, via := range []string{"socks5://" + socks, "example.com=http://proxy", "example.com=ssh://bastion", "example.com=ssh://user@bastion"} {
+		if _, err := parseForgeVia(via); err == nil {
+			t.Errorf("-forge-via %s accepted", via)
+		}
+	}
+}
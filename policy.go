@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kastelo/go-import-redirector/redirector"
+)
+
+var (
+	blockPatterns    = flag.String("block", "", "answer import roots matching these comma-separated `patterns` with 404")
+	verifyRepos      = flag.Bool("verify-repos", false, "answer wildcard import paths whose https repo does not exist with 404")
+	repoCheckTTL     = flag.Duration("verify-repos-ttl", time.Hour, "cache -verify-repos outcomes for `duration`")
+	repoCheckTimeout = flag.Duration("verify-repos-timeout", 5*time.Second, "give up a -verify-repos check after `duration`, serving the import path")
+	goneAfter        = flag.Duration("gone-after", 0, "with -verify-repos or -discover, answer wildcard import paths whose repo existed but has been missing (or archived, with -discover) for `duration` with 410 Gone (0 to answer 404 at once)")
+	goneWebhook      = flag.String("gone-webhook", "", "POST a JSON notice to `URL` when an import path turns 410 Gone")
+	maxDepth         = flag.Int("max-depth", 0, "answer wildcard import paths with more than `n` elements below the import root with 404 (0 for no limit)")
+	maxElemLen       = flag.Int("max-element-length", 0, "answer wildcard import paths with an element longer than `n` bytes with 404 (0 for no limit)")
+)
+
+const maxCheckedRepos = 100000
+
+// checkBlockPatterns validates the -block patterns.
+func checkBlockPatterns() error {
+	for _, p := range blockList() {
+		if _, err := path.Match(p, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func blockList() []string {
+	if *blockPatterns == "" {
+		return nil
+	}
+	var list []string
+	for _, p := range strings.Split(*blockPatterns, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// refusal returns the error code for a successful resolution that must
+// nevertheless not be served: a blocked import root, a wildcard import
+// path beyond the -max-depth or -max-element-length limits or whose repo
+// is not among the -discover repos or gone from them, or with verify set,
+// a wildcard child whose repo does not exist.
+func refusal(ctx context.Context, r *redirector.Resolution, verify bool) string {
+	for _, p := range blockList() {
+		if ok, _ := path.Match(p, r.ImportRoot); ok {
+			return redirector.CodeBlocked
+		}
+	}
+	if len(r.Captures) > 0 && beyondLimits(r) {
+		return redirector.CodePathLimit
+	}
+	if code := discoveryRefusal(r, time.Now()); code != "" {
+		return code
+	}
+	if verify && *verifyRepos && len(r.Captures) > 0 {
+		c, err := checkRepo(ctx, r.RepoRoot)
+		switch {
+		case err != nil:
+			return redirector.CodeTimeout
+		case !c.missing:
+		case *goneAfter == 0 || !c.existed:
+			return redirector.CodeUpstreamUnverified
+		case time.Since(c.missingSince) >= *goneAfter:
+			notifyGone(r, c)
+			return redirector.CodeGone
+		}
+	}
+	return ""
+}
+
+// refusalStatus returns the HTTP status for a refusal code.
+func refusalStatus(code string) int {
+	switch code {
+	case redirector.CodeGone:
+		return http.StatusGone
+	case redirector.CodeTimeout:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusNotFound
+}
+
+// beyondLimits reports whether the captured and suffix elements of r
+// exceed -max-depth or -max-element-length.
+func beyondLimits(r *redirector.Resolution) bool {
+	depth, longest := len(r.Captures), 0
+	for _, e := range r.Captures {
+		longest = max(longest, len(e))
+	}
+	for rest := r.Suffix; rest != ""; depth++ {
+		e := rest[1:]
+		if i := strings.IndexByte(e, '/'); i >= 0 {
+			e, rest = e[:i], e[i:]
+		} else {
+			rest = ""
+		}
+		longest = max(longest, len(e))
+	}
+	return *maxDepth > 0 && depth > *maxDepth || *maxElemLen > 0 && longest > *maxElemLen
+}
+
+type repoCheck struct {
+	missing      bool
+	checked      time.Time
+	existed      bool      // found to exist by an earlier check
+	missingSince time.Time // first check finding it missing, since it existed
+	notified     bool      // the -gone-webhook was sent
+}
+
+type repoEntry struct {
+	repo  string
+	check repoCheck
+}
+
+// repoChecks caches the outcome of repo existence checks by repo URL,
+// evicting the least recently used beyond maxCheckedRepos, except those
+// of repos gone missing, whose grace period and notice they track.
+var repoChecks = struct {
+	sync.Mutex
+	m   map[string]*list.Element
+	lru list.List // of *repoEntry, most recently used first
+}{m: make(map[string]*list.Element)}
+
+// cachedRepoCheck returns the cached check of repo, with repoChecks locked.
+func cachedRepoCheck(repo string) (repoCheck, bool) {
+	e := repoChecks.m[repo]
+	if e == nil {
+		return repoCheck{}, false
+	}
+	repoChecks.lru.MoveToFront(e)
+	return e.Value.(*repoEntry).check, true
+}
+
+// storeRepoCheck caches c as the check of repo, with repoChecks locked.
+func storeRepoCheck(repo string, c repoCheck) {
+	if e := repoChecks.m[repo]; e != nil {
+		e.Value.(*repoEntry).check = c
+		repoChecks.lru.MoveToFront(e)
+		return
+	}
+	repo = keepString(repo)
+	repoChecks.m[repo] = repoChecks.lru.PushFront(&repoEntry{repo, c})
+	if repoChecks.lru.Len() <= maxCheckedRepos {
+		return
+	}
+	victim := repoChecks.lru.Back()
+	for e := victim; e != nil; e = e.Prev() {
+		if c := e.Value.(*repoEntry).check; c.missingSince.IsZero() && !c.notified {
+			victim = e
+			break
+		}
+	}
+	repoChecks.lru.Remove(victim)
+	delete(repoChecks.m, victim.Value.(*repoEntry).repo)
+}
+
+var repoClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// repoMissing reports whether the https repo URL is known not to exist,
+// because a HEAD request for it was answered with 404 or 410. Other
+// outcomes, including errors, count as existing so that an unreachable
+// forge does not take the namespace down.
+func repoMissing(repo string) bool {
+	c, _ := checkRepo(context.Background(), repo)
+	return c.missing
+}
+
+// checkRepo returns the cached outcome of the existence check of repo,
+// checking it again when the cache is missing or stale. It returns the
+// error of ctx if ctx is done before the check is, without caching an
+// outcome; a check running out of -verify-repos-timeout finds the repo.
+func checkRepo(ctx context.Context, repo string) (repoCheck, error) {
+	if !strings.HasPrefix(repo, "https://") {
+		return repoCheck{}, nil
+	}
+	repoChecks.Lock()
+	prev, ok := cachedRepoCheck(repo)
+	repoChecks.Unlock()
+	if ok && time.Since(prev.checked) < *repoCheckTTL {
+		return prev, nil
+	}
+
+	c := repoCheck{checked: time.Now()}
+	checkCtx, cancel := context.WithTimeout(ctx, *repoCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(checkCtx, http.MethodHead, repo, nil)
+	if err != nil {
+		return c, nil
+	}
+	resp, err := repoClient.Do(req)
+	if err != nil && ctx.Err() != nil {
+		return repoCheck{}, ctx.Err()
+	}
+	if err != nil {
+		log.Printf("verifying %s: %v", repo, err)
+	} else {
+		resp.Body.Close()
+		c.missing = resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone
+	}
+	c.existed = prev.existed || !c.missing
+	if c.missing && c.existed {
+		c.missingSince, c.notified = prev.missingSince, prev.notified
+		if c.missingSince.IsZero() {
+			c.missingSince = c.checked
+		}
+	}
+	repoChecks.Lock()
+	storeRepoCheck(repo, c)
+	repoChecks.Unlock()
+	return c, nil
+}
+
+// notifyGone posts the -gone-webhook notice for the import root of r,
+// once per repo.
+func notifyGone(r *redirector.Resolution, c repoCheck) {
+	if *goneWebhook == "" || c.notified {
+		return
+	}
+	repoChecks.Lock()
+	e, ok := cachedRepoCheck(r.RepoRoot)
+	if !ok || e.notified {
+		repoChecks.Unlock()
+		return
+	}
+	e.notified = true
+	storeRepoCheck(r.RepoRoot, e)
+	repoChecks.Unlock()
+	postGoneNotice(r, c.missingSince)
+}
+
+// postGoneNotice posts the -gone-webhook notice for the import root of r,
+// whose repo has been missing since the given time.
+func postGoneNotice(r *redirector.Resolution, since time.Time) {
+	if *goneWebhook == "" {
+		return
+	}
+	body, _ := json.Marshal(struct {
+		Event        string    `json:"event"`
+		ImportRoot   string    `json:"import_root"`
+		Repo         string    `json:"repo"`
+		MissingSince time.Time `json:"missing_since"`
+	}{"gone", r.ImportRoot, r.RepoRoot, since})
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, *goneWebhook, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("gone webhook: %v", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Printf("gone webhook: %v", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			log.Printf("gone webhook: %s", resp.Status)
+		}
+	}()
+}
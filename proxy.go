@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+var trustedProxies = flag.String("trusted-proxies", "", "honor X-Forwarded-Host and X-Forwarded-Proto from peers in these comma-separated `CIDRs`, or unix for Unix socket peers")
+
+var (
+	// proxyNets are the parsed -trusted-proxies.
+	proxyNets []netip.Prefix
+	// trustUnix is whether -trusted-proxies lists unix.
+	trustUnix bool
+)
+
+// parseTrustedProxies parses -trusted-proxies. A bare address stands for
+// itself alone, and unix for the peers of a Unix socket.
+func parseTrustedProxies() error {
+	proxyNets, trustUnix = nil, false
+	for _, s := range strings.Split(*trustedProxies, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if s == "unix" {
+			trustUnix = true
+			continue
+		}
+		var p netip.Prefix
+		var err error
+		if strings.Contains(s, "/") {
+			p, err = netip.ParsePrefix(s)
+		} else {
+			var a netip.Addr
+			a, err = netip.ParseAddr(s)
+			p = netip.PrefixFrom(a, a.BitLen())
+		}
+		if err != nil {
+			return fmt.Errorf("invalid -trusted-proxies: %v", err)
+		}
+		proxyNets = append(proxyNets, p.Masked())
+	}
+	return nil
+}
+
+// unixPeer reports whether req came over a Unix socket, whose peers have
+// no address.
+func unixPeer(req *http.Request) bool {
+	if a, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		return a.Network() == "unix"
+	}
+	return req.RemoteAddr == "" || req.RemoteAddr == "@"
+}
+
+// fromTrustedProxy reports whether the peer of req is a -trusted-proxies
+// address, or a Unix socket peer with unix listed.
+func fromTrustedProxy(req *http.Request) bool {
+	if unixPeer(req) {
+		return trustUnix
+	}
+	a, err := netip.ParseAddr(remoteHost(req))
+	return err == nil && trustedProxy(a)
+}
+
+func trustedProxy(a netip.Addr) bool {
+	a = a.Unmap()
+	for _, p := range proxyNets {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client of req: its peer, or for
+// requests from trusted proxies, the last address in X-Forwarded-For that
+// is not a trusted proxy.
+func clientIP(req *http.Request) string {
+	ip := remoteHost(req)
+	if !fromTrustedProxy(req) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		a, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		ip = a.String()
+		if !trustedProxy(a) {
+			break
+		}
+	}
+	return ip
+}
+
+// forwardedValue returns the first value of the X-Forwarded header name,
+// as set by the proxy nearest the client.
+func forwardedValue(req *http.Request, name string) string {
+	v, _, _ := strings.Cut(req.Header.Get(name), ",")
+	return strings.TrimSpace(v)
+}
+
+// forwardedProto returns the X-Forwarded-Proto of a request from a trusted
+// proxy, http or https, or "".
+func forwardedProto(req *http.Request) string {
+	if !fromTrustedProxy(req) {
+		return ""
+	}
+	switch p := strings.ToLower(forwardedValue(req, "X-Forwarded-Proto")); p {
+	case "http", "https":
+		return p
+	}
+	return ""
+}
+
+// isHTTPS reports whether the client sent req over HTTPS, to us or to a
+// trusted proxy.
+func isHTTPS(req *http.Request) bool {
+	if p := forwardedProto(req); p != "" {
+		return p == "https"
+	}
+	return req.TLS != nil
+}
+
+// redirectScheme returns the scheme for absolute redirects to our own
+// paths: the one the client used with a trusted proxy, or https.
+func redirectScheme(req *http.Request) string {
+	if p := forwardedProto(req); p != "" {
+		return p
+	}
+	return "https"
+}
+
+// forwarded wraps h to take the Host of requests from trusted proxies from
+// X-Forwarded-Host, so that they are routed and matched against the import
+// paths by the host the client asked for rather than the one the proxy
+// connects to.
+func forwarded(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if fromTrustedProxy(req) {
+			if host := forwardedValue(req, "X-Forwarded-Host"); host != "" {
+				req.Host = host
+			}
+		}
+		h.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// useTrustedProxies sets -trusted-proxies to list for the test.
+func useTrustedProxies(t *testing.T, list string) {
+	t.Helper()
+	old := *trustedProxies
+	*trustedProxies = list
+	t.Cleanup(func() {
+		*trustedProxies = old
+		parseTrustedProxies()
+	})
+	if err := parseTrustedProxies(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		desc, trusted, peer string
+		xff                 []string
+		want                string
+	}{
+		{"no proxies", "", "192.0.2.1:1234", []string{"198.51.100.7"}, "192.0.2.1"},
+		{"spoofed from an untrusted peer", "10.0.0.0/8", "192.0.2.1:1234", []string{"198.51.100.7"}, "192.0.2.1"},
+		{"trusted peer", "10.0.0.0/8", "10.1.2.3:1234", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"trusted peer without the header", "10.0.0.0/8", "10.1.2.3:1234", nil, "10.1.2.3"},
+		{"bare address", "10.1.2.3", "10.1.2.3:1234", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"multi-hop", "10.0.0.0/8", "10.1.2.3:1234", []string{"198.51.100.7, 10.9.9.9, 10.8.8.8"}, "198.51.100.7"},
+		{"multi-hop spoofed", "10.0.0.0/8", "10.1.2.3:1234", []string{"203.0.113.66, 198.51.100.7, 10.9.9.9"}, "198.51.100.7"},
+		{"multi-hop headers", "10.0.0.0/8", "10.1.2.3:1234", []string{"203.0.113.66", "198.51.100.7, 10.9.9.9"}, "198.51.100.7"},
+		{"untrusted hop", "10.0.0.0/8", "10.1.2.3:1234", []string{"10.9.9.9, 192.0.2.5, 10.8.8.8"}, "192.0.2.5"},
+		{"invalid hop", "10.0.0.0/8", "10.1.2.3:1234", []string{"198.51.100.7, garbage, 10.8.8.8"}, "10.8.8.8"},
+		{"IPv6 CIDR", "2001:db8::/32", "[2001:db8::1]:1234", []string{"2001:db8:ffff::9, 2001:db9::7"}, "2001:db9::7"},
+		{"IPv6 untrusted", "2001:db8::/32", "[2001:db9::1]:1234", []string{"198.51.100.7"}, "2001:db9::1"},
+		{"IPv4-mapped peer", "10.0.0.0/8", "[::ffff:10.1.2.3]:1234", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"unix untrusted", "10.0.0.0/8", "@", []string{"198.51.100.7"}, "@"},
+		{"unix trusted", "unix", "@", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"unix trusted, unnamed", "unix,10.0.0.0/8", "", []string{"198.51.100.7, 10.9.9.9"}, "198.51.100.7"},
+		{"unix listed, TCP peer", "unix", "10.1.2.3:1234", []string{"198.51.100.7"}, "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		useTrustedProxies(t, tt.trusted)
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/foo", nil)
+		req.RemoteAddr = tt.peer
+		for _, v := range tt.xff {
+			req.Header.Add("X-Forwarded-For", v)
+		}
+		if got := clientIP(req); got != tt.want {
+			t.Errorf("%s: clientIP = %q, want %q", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestForwardedHost(t *testing.T) {
+	tests := []struct {
+		trusted, peer string
+		host, proto   string
+	}{
+		{"", "10.1.2.3:1234", "internal", ""},
+		{"10.0.0.0/8", "192.0.2.1:1234", "internal", ""},
+		{"10.0.0.0/8", "10.1.2.3:1234", "example.com", "https"},
+		{"10.0.0.0/8", "@", "internal", ""},
+		{"unix", "@", "example.com", "https"},
+	}
+	for _, tt := range tests {
+		useTrustedProxies(t, tt.trusted)
+		req := httptest.NewRequest(http.MethodGet, "http://internal/foo", nil)
+		req.RemoteAddr = tt.peer
+		req.Header.Set("X-Forwarded-Host", "example.com")
+		req.Header.Set("X-Forwarded-Proto", "https")
+		var host string
+		forwarded(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			host = req.Host
+		})).ServeHTTP(httptest.NewRecorder(), req)
+		if host != tt.host || forwardedProto(req) != tt.proto {
+			t.Errorf("%s from %q: host %q, proto %q; want %q, %q", tt.trusted, tt.peer, host, forwardedProto(req), tt.host, tt.proto)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	for _, s := range []string{"10.0.0.0/33", "example.com", "unix:/run/x.sock"} {
+		old := *trustedProxies
+		*trustedProxies = s
+		if err := parseTrustedProxies(); err == nil {
+			t.Errorf("%s: no error", s)
+		}
+		*trustedProxies = old
+	}
+	parseTrustedProxies()
+}
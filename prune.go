@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+var pruneInterval = flag.Duration("prune-interval", 0, "prune expired statistics, cache entries, shard pins and certificates every `duration` (0 to prune only on POST /-/prune)")
+
+// pruneTargets names the state prune can shrink, in the order it does.
+// The -transparency-log and -immutable-state files are records meant to be
+// kept, and are never pruned; the -log-file is rotated by renaming it and
+// sending SIGHUP.
+var pruneTargets = []string{"stats", "caches", "shard-pins", "autocert"}
+
+// prune removes the entries of the targets that have expired or no longer
+// belong to a served import path, and returns the number removed from
+// each.
+func prune(targets []string) (map[string]int, error) {
+	for _, t := range targets {
+		if !slices.Contains(pruneTargets, t) {
+			return nil, fmt.Errorf("unknown prune target %q: want one of %s", t, strings.Join(pruneTargets, ", "))
+		}
+	}
+	gen := holdMapping()
+	defer releaseMapping(gen)
+	removed := make(map[string]int)
+	for _, t := range targets {
+		var n int
+		var err error
+		switch t {
+		case "stats":
+			n = pruneStats()
+		case "caches":
+			n = pruneCaches()
+		case "shard-pins":
+			n, err = pruneShardPins()
+		case "autocert":
+			n, err = pruneAutocert()
+		}
+		if err != nil {
+			return removed, fmt.Errorf("pruning %s: %v", t, err)
+		}
+		removed[t] = n
+	}
+	return removed, nil
+}
+
+// served reports whether the import root is still served by a rule.
+func served(root string, now time.Time) bool {
+	r := rules.Load().Resolve(root, now)
+	return r.Status == http.StatusOK && r.ImportRoot == root
+}
+
+// pruneStats removes the usage, client, referrer and egress counts older
+// than -stats-days, and the client, referrer and egress counts of import
+// roots no longer served.
+func pruneStats() int {
+	now := time.Now()
+	oldest := oldestDay(now)
+	stats.mu.Lock()
+	n := stats.expire(oldest)
+	for _, m := range []map[dayModule]map[string]int64{stats.clients, stats.referers} {
+		for k := range m {
+			if k.Module != "(other)" && !served(k.Module, now) {
+				delete(m, k)
+				n++
+			}
+		}
+	}
+	stats.mu.Unlock()
+	egress.mu.Lock()
+	n += egress.expire(oldest)
+	for k := range egress.total {
+		if k.Module != "(unmatched)" && k.Module != "(other)" && !served(k.Module, now) {
+			delete(egress.total, k)
+			n++
+		}
+	}
+	egress.mu.Unlock()
+	return n
+}
+
+// pruneCaches empties the page cache and removes the expired entries of
+// the module version, archived repo, GitHub listing and repo check
+// caches. Repo checks that track a missing repo for -gone-after are kept.
+func pruneCaches() int {
+	n := clearPageCache()
+
+	versionCache.Lock()
+	for k, e := range versionCache.m {
+		if v := e.Value.(*versionList); !v.fetching && time.Since(v.fetched) > versionsTTL {
+			versionCache.lru.Remove(e)
+			delete(versionCache.m, k)
+			n++
+		}
+	}
+	versionCache.Unlock()
+
+	archivedCache.Lock()
+	for k, e := range archivedCache.m {
+		if s := e.Value.(*archivedState); !s.fetching && time.Since(s.fetched) > archivedTTL {
+			archivedCache.lru.Remove(e)
+			delete(archivedCache.m, k)
+			n++
+		}
+	}
+	archivedCache.Unlock()
+
+	githubCache.Lock()
+	for k, l := range githubCache.m {
+		if !l.fetching && time.Since(l.fetched) > githubListTTL {
+			delete(githubCache.m, k)
+			n++
+		}
+	}
+	githubCache.Unlock()
+
+	repoChecks.Lock()
+	for k, e := range repoChecks.m {
+		if c := e.Value.(*repoEntry).check; !c.existed && time.Since(c.checked) > *repoCheckTTL {
+			repoChecks.lru.Remove(e)
+			delete(repoChecks.m, k)
+			n++
+		}
+	}
+	repoChecks.Unlock()
+	return n
+}
+
+// pruneShardPins removes the pins of import roots no longer served by a
+// rule with shards, and rewrites the -shard-state file without them.
+func pruneShardPins() (int, error) {
+	if *shardState == "" {
+		return 0, nil
+	}
+	now := time.Now()
+	shardPins.Lock()
+	defer shardPins.Unlock()
+	n := 0
+	var buf bytes.Buffer
+	roots := make([]string, 0, len(shardPins.m))
+	for root := range shardPins.m {
+		roots = append(roots, root)
+	}
+	slices.Sort(roots)
+	for _, root := range roots {
+		if r := rules.Load().Resolve(root, now); r.Rule == nil || len(r.Rule.Shards) == 0 || r.ImportRoot != root {
+			delete(shardPins.m, root)
+			n++
+			continue
+		}
+		line, err := json.Marshal(shardPin{root, shardPins.m[root]})
+		if err != nil {
+			return 0, err
+		}
+		buf.Write(append(line, '\n'))
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	shardPins.full = false
+	return n, writeFileAtomic(*shardState, buf.Bytes())
+}
+
+// pruneAutocert removes the certificates of hosts that are no longer import
+// hosts from the -autocert-cache directory, keeping the ACME account key
+// and any challenge tokens.
+func pruneAutocert() (int, error) {
+	if !*autocertFlag {
+		return 0, nil
+	}
+	entries, err := os.ReadDir(*autocertCache)
+	if err != nil {
+		return 0, err
+	}
+	hosts := importHosts()
+	n := 0
+	for _, e := range entries {
+		host := strings.TrimSuffix(e.Name(), "+rsa")
+		if e.IsDir() || strings.Contains(host, "+") || !strings.Contains(host, ".") || slices.Contains(hosts, host) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(*autocertCache, e.Name())); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// prunePeriodically prunes all targets every -prune-interval.
+func prunePeriodically() {
+	for range time.Tick(*pruneInterval) {
+		removed, err := prune(pruneTargets)
+		if err != nil {
+			log.Printf("prune: %v", err)
+			continue
+		}
+		log.Printf("pruned %v", removed)
+	}
+}
+
+// servePrune prunes the targets of the comma-separated ?targets=, or all,
+// on POST, and reports the number of entries removed from each.
+func servePrune(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	targets := pruneTargets
+	if t := req.FormValue("targets"); t != "" {
+		targets = strings.Split(t, ",")
+	}
+	removed, err := prune(targets)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("pruned %v", removed)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(removed)
+}
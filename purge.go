@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/mod/module"
+
+	"github.com/kastelo/go-import-redirector/redirector"
+)
+
+var purgeProxies = flag.String("purge-proxy", "", "when a reload changes the repo of a rule, have the module proxies at these comma-separated `URLs` refetch its modules (such as https://proxy.golang.org); a URL containing {module} is POSTed to instead, as a purge API")
+
+// maxPurgeModules bounds the number of served wildcard modules remembered
+// for purging.
+const maxPurgeModules = 100000
+
+// servedModules holds the import roots served to the go command under
+// wildcard and template rules, by the Import of the rule, as the modules
+// to purge when the rule changes.
+var servedModules struct {
+	sync.Mutex
+	m map[string]map[string]bool
+	n int
+}
+
+func purgeList() []string {
+	var list []string
+	for _, u := range strings.Split(*purgeProxies, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			list = append(list, u)
+		}
+	}
+	return list
+}
+
+// noteServedModule remembers the import root of r, served to the go
+// command, if its rule has more than one.
+func noteServedModule(r *redirector.Resolution) {
+	if *purgeProxies == "" || r.ImportRoot == r.Rule.Root() {
+		return
+	}
+	servedModules.Lock()
+	defer servedModules.Unlock()
+	mods := servedModules.m[r.Rule.Import]
+	if mods[r.ImportRoot] || servedModules.n >= maxPurgeModules {
+		return
+	}
+	if mods == nil {
+		if servedModules.m == nil {
+			servedModules.m = make(map[string]map[string]bool)
+		}
+		mods = make(map[string]bool)
+		servedModules.m[keepString(r.Rule.Import)] = mods
+	}
+	mods[keepString(r.ImportRoot)] = true
+	servedModules.n++
+}
+
+// purgeChanged has the -purge-proxy proxies refetch the modules of the
+// rules in next whose metadata differs from that of the rule with the same
+// import path in prev.
+func purgeChanged(prev, next []*redirector.Rule) {
+	if *purgeProxies == "" {
+		return
+	}
+	old := make(map[string]string, len(prev))
+	for _, r := range prev {
+		old[r.Import] = r.Fingerprint()
+	}
+	var mods []string
+	for _, r := range next {
+		if fp, ok := old[r.Import]; !ok || fp == r.Fingerprint() {
+			continue
+		}
+		if !strings.ContainsAny(r.Import, "*{") {
+			mods = append(mods, keepString(r.Root()))
+			continue
+		}
+		servedModules.Lock()
+		for m := range servedModules.m[r.Import] {
+			mods = append(mods, m)
+		}
+		servedModules.Unlock()
+	}
+	if len(mods) > 0 {
+		go purgeModules(mods)
+	}
+}
+
+// purgeModules asks each -purge-proxy to refetch mods. Proxies speaking
+// the GOPROXY protocol are sent requests for the version list and latest
+// version that bypass their caches; purge APIs are POSTed to, with the
+// module path in place of {module}.
+func purgeModules(mods []string) {
+	for _, proxy := range purgeList() {
+		for _, mod := range mods {
+			var err error
+			if strings.Contains(proxy, "{module}") {
+				err = purgeRequest(http.MethodPost, strings.ReplaceAll(proxy, "{module}", mod))
+			} else {
+				err = refetchModule(proxy, mod)
+			}
+			if err != nil {
+				log.Printf("purging %s from %s: %v", mod, proxy, err)
+				continue
+			}
+			log.Printf("purged %s from %s", mod, proxy)
+		}
+	}
+}
+
+// refetchModule requests the version list and latest version of mod from
+// the module proxy at base, uncached.
+func refetchModule(base, mod string) error {
+	escaped, err := module.EscapePath(mod)
+	if err != nil {
+		return err
+	}
+	base = strings.TrimSuffix(base, "/") + "/" + escaped
+	for _, p := range []string{"/@v/list", "/@latest"} {
+		if err := purgeRequest(http.MethodGet, base+p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func purgeRequest(method, u string) error {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Cache-Control", "no-cache")
+	resp, err := versionClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	// A module without tagged versions has no @latest, which is fine.
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound && resp.StatusCode != http.StatusGone {
+		return fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"rsc.io/qr"
+)
+
+// serveQR serves /-/qr/<import path>.png, a QR code linking to the landing
+// page of an import path in the served namespace.
+func serveQR(w http.ResponseWriter, req *http.Request) {
+	path, ok := strings.CutSuffix(strings.TrimPrefix(req.URL.Path, "/-/qr/"), ".png")
+	if !ok || resolve(path, time.Now()).Status == http.StatusNotFound {
+		http.NotFound(w, req)
+		return
+	}
+	code, err := qr.Encode("https://"+path, qr.M)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	code.Scale = 8
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(code.PNG())
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kastelo/go-import-redirector/redirector"
+	"golang.org/x/net/netutil"
+)
+
+var (
+	rateLimit    = flag.Float64("rate-limit", 0, "allow each client IP address `n` requests per second on average, answering more with 429 (0 for no limit)")
+	rateBurst    = flag.Int("rate-burst", 20, "with -rate-limit, allow bursts of `n` requests")
+	maxConns     = flag.Int("max-conns", 0, "serve at most `n` connections at once, leaving more waiting to be accepted (0 for no limit)")
+	readTimeout  = flag.Duration("read-timeout", 10*time.Second, "give clients `duration` to send a request, headers and body")
+	writeTimeout = flag.Duration("write-timeout", 30*time.Second, "give up writing a response after `duration`, except for /-/events streams")
+	idleTimeout  = flag.Duration("idle-timeout", 2*time.Minute, "close keep-alive connections idle for `duration`")
+
+	requestTimeout = flag.Duration("request-timeout", 10*time.Second, "give resolving an import path, with its upstream checks, `duration`, answering 504 when it runs out (0 for no limit)")
+)
+
+// rateSweepInterval is how often buckets that have filled up again are
+// dropped.
+const rateSweepInterval = time.Minute
+
+// A bucket is a token bucket of a client address.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// buckets holds the token bucket of each client address seen recently.
+var buckets = struct {
+	sync.Mutex
+	m     map[string]*bucket
+	swept time.Time
+}{m: make(map[string]*bucket)}
+
+// allow takes a token from the bucket of client at time now. If there is
+// none, it returns how long until there is one.
+func allow(client string, now time.Time) (bool, time.Duration) {
+	rate, burst := *rateLimit, float64(*rateBurst)
+	buckets.Lock()
+	defer buckets.Unlock()
+	if now.Sub(buckets.swept) > rateSweepInterval {
+		for k, b := range buckets.m {
+			if b.tokens+now.Sub(b.last).Seconds()*rate >= burst {
+				delete(buckets.m, k)
+			}
+		}
+		buckets.swept = now
+	}
+	b := buckets.m[client]
+	if b == nil {
+		b = &bucket{tokens: burst, last: now}
+		buckets.m[client] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// rateLimited wraps h to answer clients exceeding the -rate-limit with 429
+// Too Many Requests and a Retry-After header. Requests without a client
+// address, from Unix socket peers not trusted to give one in
+// X-Forwarded-For, are not limited rather than all sharing one bucket.
+func rateLimited(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		client := clientIP(req)
+		if _, err := netip.ParseAddr(client); err != nil {
+			h.ServeHTTP(w, req)
+			return
+		}
+		if ok, wait := allow(client, time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			redirector.ServeError(w, req, http.StatusTooManyRequests, redirector.CodeRateLimited)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// limitServer applies the timeouts to srv and wraps its handler in the
+// -rate-limit.
+func limitServer(srv *http.Server) {
+	srv.ReadTimeout = *readTimeout
+	srv.WriteTimeout = *writeTimeout
+	srv.IdleTimeout = *idleTimeout
+	if *rateLimit > 0 {
+		if srv.Handler == nil {
+			srv.Handler = http.DefaultServeMux
+		}
+		srv.Handler = rateLimited(srv.Handler)
+	}
+}
+
+// limitListener caps ln at -max-conns connections at once.
+func limitListener(ln net.Listener) net.Listener {
+	if *maxConns <= 0 {
+		return ln
+	}
+	return netutil.LimitListener(ln, *maxConns)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// useRateLimit sets -rate-limit and -rate-burst for the test, with empty
+// buckets.
+func useRateLimit(t *testing.T, rate float64, burst int) {
+	t.Helper()
+	oldRate, oldBurst := *rateLimit, *rateBurst
+	*rateLimit, *rateBurst = rate, burst
+	buckets.Lock()
+	buckets.m = make(map[string]*bucket)
+	buckets.Unlock()
+	t.Cleanup(func() { *rateLimit, *rateBurst = oldRate, oldBurst })
+}
+
+func TestAllow(t *testing.T) {
+	useRateLimit(t, 2, 3)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if ok, _ := allow("192.0.2.1", now); !ok {
+			t.Fatalf("request %d of the burst refused", i+1)
+		}
+	}
+	ok, wait := allow("192.0.2.1", now)
+	if ok || wait != 500*time.Millisecond {
+		t.Errorf("beyond the burst: %v, wait %v; want refused, 500ms", ok, wait)
+	}
+	if ok, _ := allow("192.0.2.2", now); !ok {
+		t.Error("another client refused")
+	}
+
+	// Refilled at the rate, up to the burst.
+	if ok, _ := allow("192.0.2.1", now.Add(400*time.Millisecond)); ok {
+		t.Error("allowed before a token refilled")
+	}
+	if ok, _ := allow("192.0.2.1", now.Add(time.Second)); !ok {
+		t.Error("refused after a token refilled")
+	}
+	later := now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if ok, _ := allow("192.0.2.1", later); !ok {
+			t.Fatalf("request %d after refilling refused", i+1)
+		}
+	}
+	if ok, _ := allow("192.0.2.1", later); ok {
+		t.Error("bucket refilled beyond the burst")
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	useRateLimit(t, 0.5, 2)
+	useTrustedProxies(t, "")
+	h := rateLimited(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	do := func(peer, xff string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/foo", nil)
+		req.RemoteAddr = peer
+		if xff != "" {
+			req.Header.Set("X-Forwarded-For", xff)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := do("192.0.2.1:1234", ""); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i+1, w.Code)
+		}
+	}
+	w := do("192.0.2.1:5678", "")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("beyond the burst: status %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After %q, want 2", got)
+	}
+	if w := do("192.0.2.2:1234", ""); w.Code != http.StatusOK {
+		t.Errorf("another client: status %d", w.Code)
+	}
+
+	// Unix socket peers have no address: untrusted, they are not limited
+	// rather than sharing a bucket, and trusted, they are limited by the
+	// client in X-Forwarded-For.
+	for i := 0; i < 5; i++ {
+		if w := do("@", "198.51.100.1"); w.Code != http.StatusOK {
+			t.Fatalf("untrusted Unix socket request %d: status %d", i+1, w.Code)
+		}
+	}
+	useTrustedProxies(t, "unix")
+	for i := 0; i < 2; i++ {
+		if w := do("@", "198.51.100.1"); w.Code != http.StatusOK {
+			t.Fatalf("Unix socket request %d: status %d", i+1, w.Code)
+		}
+	}
+	if w := do("@", "198.51.100.1"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Unix socket client beyond the burst: status %d, want 429", w.Code)
+	}
+	if w := do("@", "198.51.100.2"); w.Code != http.StatusOK {
+		t.Errorf("another Unix socket client: status %d", w.Code)
+	}
+}
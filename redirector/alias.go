@@ -0,0 +1,33 @@
+package redirector
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AliasRules returns a rule for each of r's aliases, serving the same
+// repos under the alias in place of r's import path root. Resolutions
+// through an alias rule carry the path under r's root as Canonical.
+func (r *Rule) AliasRules() ([]*Rule, error) {
+	var list []*Rule
+	for _, alias := range r.Aliases {
+		if alias == "" || strings.ContainsAny(alias, "*{}") || strings.HasSuffix(alias, "/") {
+			return nil, fmt.Errorf("invalid alias %q: want an import path root such as go.example.com/x", alias)
+		}
+		if alias == r.importPath {
+			return nil, fmt.Errorf("alias %s is the import path root itself", alias)
+		}
+		a := *r
+		a.Import = alias + strings.TrimPrefix(r.Import, r.importPath)
+		a.Aliases = nil
+		if err := a.Init(); err != nil {
+			return nil, fmt.Errorf("alias %s: %v", alias, err)
+		}
+		a.canonical = r.importPath
+		list = append(list, &a)
+	}
+	return list, nil
+}
+
+// Canonical returns the import path root this rule is an alias of, or "".
+func (r *Rule) Canonical() string { return r.canonical }
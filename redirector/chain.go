@@ -0,0 +1,36 @@
+package redirector
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FlattenMoves points each rule whose MovedTo lies under another rule that
+// has moved as well at the end of the chain of moves, so that browsers
+// are redirected once rather than through every step. It returns an error
+// for a chain that comes back to a rule already in it.
+func FlattenMoves(rules []*Rule) error {
+	x := NewIndex(rules)
+	for _, r := range rules {
+		if r.MovedTo == "" {
+			continue
+		}
+		seen := map[*Rule]bool{r: true}
+		chain := []string{r.importPath, r.MovedTo}
+		target := r.MovedTo
+		for {
+			next := x.Match(target)
+			if next == nil || next.MovedTo == "" || !hasPathPrefix(target, next.importPath) {
+				break
+			}
+			if seen[next] {
+				return fmt.Errorf("moved to loop: %s", strings.Join(chain, " -> "))
+			}
+			seen[next] = true
+			target = next.MovedTo + target[len(next.importPath):]
+			chain = append(chain, target)
+		}
+		r.movedTo = target
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package redirector
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// explainNearby is how many of the rules on the host of a path that are
+// not candidates for it Explain lists, those with the longest import path
+// roots in common with the path first.
+const explainNearby = 5
+
+// Explain writes to w a plain-text account of how path is matched and
+// resolved at time now: the candidate rules, why each is rejected or
+// loses to the chosen one, and the resolution with its captures.
+func (x *Index) Explain(w io.Writer, path string, now time.Time) {
+	fmt.Fprintf(w, "path: %s\n", path)
+	var cands []int
+	for p := path; ; {
+		cands = append(cands, x.byRoot[p]...)
+		i := strings.LastIndexByte(p, '/')
+		if i < 0 {
+			break
+		}
+		p = p[:i]
+	}
+	slices.Sort(cands)
+	match := x.Match(path)
+	parts := strings.Split(path, "/")
+
+	fmt.Fprintf(w, "\ncandidates (rules rooted at the path or a parent, in rule order): %d\n", len(cands))
+	for _, c := range cands {
+		r := x.rules[c]
+		fmt.Fprintf(w, "  %s", describeRule(r))
+		n, why := r.explainMatch(parts)
+		switch {
+		case why != "":
+			fmt.Fprintf(w, "\n    rejected: %s\n", why)
+		case r == match:
+			fmt.Fprintf(w, "\n    chosen: matches import root %s (%d literal elements)\n", path[:n], r.literals)
+		default:
+			mn, _ := match.explainMatch(parts)
+			switch {
+			case n < mn:
+				why = "its import root " + path[:n] + " is shorter than " + path[:mn]
+			case r.literals < match.literals:
+				why = fmt.Sprintf("it has fewer literal elements (%d) than the chosen rule (%d)", r.literals, match.literals)
+			default:
+				why = "it ties with the chosen rule, which comes first"
+			}
+			fmt.Fprintf(w, "\n    loses: %s\n", why)
+		}
+	}
+
+	host, _, _ := strings.Cut(path, "/")
+	var nearby []int
+	for i, r := range x.rules {
+		if r.Host() == host && !slices.Contains(cands, i) {
+			nearby = append(nearby, i)
+		}
+	}
+	if len(nearby) > 0 {
+		common := func(i int) int {
+			root, n := x.rules[i].importPath, 0
+			for n < len(root) && n < len(path) && root[n] == path[n] {
+				n++
+			}
+			return n
+		}
+		slices.SortStableFunc(nearby, func(a, b int) int { return common(b) - common(a) })
+		fmt.Fprintf(w, "\nnot considered (rooted elsewhere on %s): %d\n", host, len(nearby))
+		for _, i := range nearby[:min(len(nearby), explainNearby)] {
+			r := x.rules[i]
+			fmt.Fprintf(w, "  %s\n    root %s is neither the path nor a parent of it\n", describeRule(r), r.importPath)
+		}
+		if len(nearby) > explainNearby {
+			fmt.Fprintf(w, "  ...\n")
+		}
+	}
+
+	res := resolveRule(context.Background(), match, path, now)
+	fmt.Fprintf(w, "\nresult: %d %s", res.Status, http.StatusText(res.Status))
+	if res.Code != "" {
+		fmt.Fprintf(w, " (%s)", res.Code)
+	}
+	fmt.Fprintln(w)
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(w, "  %-16s %s\n", name+":", value)
+		}
+	}
+	field("route", res.Route)
+	if res.Rule != nil && res.Rule.elems != nil {
+		var names []string
+		for _, e := range res.Rule.elems {
+			if placeholderRE.MatchString(e) {
+				names = append(names, e)
+			}
+		}
+		for i, c := range res.Captures {
+			field("capture "+names[i], c)
+		}
+	} else {
+		for i, c := range res.Captures {
+			field(fmt.Sprintf("capture *%d", i+1), c)
+		}
+	}
+	field("import root", res.ImportRoot)
+	field("repo root", res.RepoRoot)
+	field("suffix", res.Suffix)
+	field("subdir", res.Subdir)
+	field("vcs", res.VCS)
+	field("browse url", res.BrowseURL)
+	field("location", res.Location)
+	field("canonical", res.Canonical)
+	field("moved to", res.MovedTo)
+	field("expr error", res.ExprError)
+	if res.InOverlap {
+		field("cutover", "in the overlap window around "+res.Rule.Cutover)
+	}
+	switch res.Code {
+	case CodeUnknownRoute:
+		fmt.Fprintf(w, "  no rule is rooted at %s or a parent of it\n", path)
+	case CodeWildcardDepth:
+		fmt.Fprintf(w, "  %s needs %d path elements below %s\n", match.Import, match.wildcard, match.importPath)
+	}
+	if res.Status == http.StatusFound {
+		fmt.Fprintf(w, "  the path is the wildcard root itself, which redirects to the repo\n")
+	}
+}
+
+// describeRule returns the import path and repo of r for Explain.
+func describeRule(r *Rule) string {
+	s := r.Import + " -> " + r.Repo
+	if r.canonical != "" {
+		s += " (alias of " + r.canonical + ")"
+	}
+	return s
+}
+
+// explainMatch is Match for the one rule r: it returns the length of the
+// import root r matches in the path with elements parts, or why r does
+// not match.
+func (r *Rule) explainMatch(parts []string) (int, string) {
+	if r.elems == nil {
+		path := strings.Join(parts, "/")
+		if path != r.importPath && !strings.HasPrefix(path, r.importPath+"/") {
+			return 0, "the path is not under " + r.importPath
+		}
+		return len(r.importPath), ""
+	}
+	if len(parts) < len(r.elems) {
+		return 0, fmt.Sprintf("the template needs %d path elements, the path has %d", len(r.elems), len(parts))
+	}
+	for i, e := range r.elems {
+		if placeholderRE.MatchString(e) {
+			if parts[i] == "" {
+				return 0, fmt.Sprintf("element %d is empty, but %s needs a value", i+1, e)
+			}
+		} else if parts[i] != e {
+			return 0, fmt.Sprintf("element %d is %q, the template wants %q", i+1, parts[i], e)
+		}
+	}
+	return len(strings.Join(parts[:len(r.elems)], "/")), ""
+}
@@ -0,0 +1,630 @@
+package redirector
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Rule expressions compute the repo root, VCS and browse URL of an import
+// root from the request path, for mappings the other rule fields cannot
+// express. The language is a small subset of CEL without side effects:
+//
+//	has_prefix(name, "go-") ? "https://git.example.com/go/" + trim_prefix(name, "go-") : repo
+//
+// Values are strings, integers, booleans and lists of strings. There are
+// literals ("text", 42, true, false), variables, + on strings, integers
+// and lists, - on integers, the comparisons == != < <= > >=, && || and !,
+// the conditional c ? a : b, list indexing (list[0], list[-1] for the
+// last) and the functions of exprFuncs. Regular expressions must be string
+// literals, so that they are compiled with the rule. An expression runs
+// for at most exprTimeout and builds no string or list longer than
+// exprMaxLen bytes.
+
+const (
+	exprMaxSource = 4 << 10 // longest expression accepted
+	exprMaxLen    = 8 << 10 // longest string or list a step may produce
+	exprTimeout   = 10 * time.Millisecond
+)
+
+// An expr is a parsed expression.
+type expr interface {
+	eval(env *exprEnv) (any, error)
+}
+
+// An exprEnv holds the variables an expression is evaluated with.
+type exprEnv struct {
+	vars     map[string]any
+	deadline time.Time
+}
+
+var errExprTimeout = fmt.Errorf("expression ran longer than %v", exprTimeout)
+
+// step checks that evaluation may go on.
+func (env *exprEnv) step() error {
+	if time.Now().After(env.deadline) {
+		return errExprTimeout
+	}
+	return nil
+}
+
+// evalExpr evaluates e with vars, within exprTimeout or the deadline of
+// ctx, whichever is sooner.
+func evalExpr(ctx context.Context, e expr, vars map[string]any) (any, error) {
+	deadline := time.Now().Add(exprTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	return e.eval(&exprEnv{vars: vars, deadline: deadline})
+}
+
+// An exprFunc is a function callable from expressions. Arguments at the
+// positions in regexps must be regular expression literals, which are
+// passed compiled.
+type exprFunc struct {
+	args    int
+	regexps []int
+	call    func(args []any, res []*regexp.Regexp) (any, error)
+}
+
+var exprFuncs = map[string]exprFunc{
+	"lower": {args: 1, call: func(a []any, _ []*regexp.Regexp) (any, error) {
+		s, err := exprString("lower", a[0])
+		return strings.ToLower(s), err
+	}},
+	"upper": {args: 1, call: func(a []any, _ []*regexp.Regexp) (any, error) {
+		s, err := exprString("upper", a[0])
+		return strings.ToUpper(s), err
+	}},
+	"replace": {args: 3, call: func(a []any, _ []*regexp.Regexp) (any, error) {
+		s, old, new, err := exprStrings3("replace", a)
+		if err != nil {
+			return nil, err
+		}
+		n := strings.Count(s, old)
+		if old == "" {
+			n = utf8.RuneCountInString(s) + 1
+		}
+		if len(s)+n*(len(new)-len(old)) > exprMaxLen {
+			return nil, errExprTooLong
+		}
+		return strings.ReplaceAll(s, old, new), nil
+	}},
+	"trim_prefix": {args: 2, call: func(a []any, _ []*regexp.Regexp) (any, error) {
+		s, p, err := exprStrings2("trim_prefix", a)
+		return strings.TrimPrefix(s, p), err
+	}},
+	"trim_suffix": {args: 2, call: func(a []any, _ []*regexp.Regexp) (any, error) {
+		s, p, err := exprStrings2("trim_suffix", a)
+		return strings.TrimSuffix(s, p), err
+	}},
+	"has_prefix": {args: 2, call: func(a []any, _ []*regexp.Regexp) (any, error) {
+		s, p, err := exprStrings2("has_prefix", a)
+		return strings.HasPrefix(s, p), err
+	}},
+	"has_suffix": {args: 2, call: func(a []any, _ []*regexp.Regexp) (any, error) {
+		s, p, err := exprStrings2("has_suffix", a)
+		return strings.HasSuffix(s, p), err
+	}},
+	"contains": {args: 2, call: func(a []any, _ []*regexp.Regexp) (any, error) {
+		sub, err := exprString("contains", a[1])
+		if err != nil {
+			return nil, err
+		}
+		switch x := a[0].(type) {
+		case string:
+			return strings.Contains(x, sub), nil
+		case []string:
+			return slices.Contains(x, sub), nil
+		}
+		return nil, fmt.Errorf("contains: want string or list, have %s", exprType(a[0]))
+	}},
+	"split": {args: 2, call: func(a []any, _ []*regexp.Regexp) (any, error) {
+		s, sep, err := exprStrings2("split", a)
+		if err != nil {
+			return nil, err
+		}
+		if sep == "" {
+			return nil, errors.New("split: empty separator")
+		}
+		return strings.Split(s, sep), nil
+	}},
+	"join": {args: 2, call: func(a []any, _ []*regexp.Regexp) (any, error) {
+		list, ok := a[0].([]string)
+		if !ok {
+			return nil, fmt.Errorf("join: want list, have %s", exprType(a[0]))
+		}
+		sep, err := exprString("join", a[1])
+		if err != nil {
+			return nil, err
+		}
+		if exprLen(list)+len(list)*len(sep) > exprMaxLen {
+			return nil, errExprTooLong
+		}
+		return strings.Join(list, sep), nil
+	}},
+	"size": {args: 1, call: func(a []any, _ []*regexp.Regexp) (any, error) {
+		switch x := a[0].(type) {
+		case string:
+			return len(x), nil
+		case []string:
+			return len(x), nil
+		}
+		return nil, fmt.Errorf("size: want string or list, have %s", exprType(a[0]))
+	}},
+	"matches": {args: 2, regexps: []int{1}, call: func(a []any, res []*regexp.Regexp) (any, error) {
+		s, err := exprString("matches", a[0])
+		return err == nil && res[1].MatchString(s), err
+	}},
+	"sub": {args: 3, regexps: []int{1}, call: func(a []any, res []*regexp.Regexp) (any, error) {
+		s, err := exprString("sub", a[0])
+		if err != nil {
+			return nil, err
+		}
+		repl, err := exprString("sub", a[2])
+		if err != nil {
+			return nil, err
+		}
+		var b []byte
+		last := 0
+		for _, m := range res[1].FindAllStringSubmatchIndex(s, -1) {
+			b = append(b, s[last:m[0]]...)
+			b = res[1].ExpandString(b, repl, s, m)
+			last = m[1]
+			if len(b) > exprMaxLen {
+				return nil, errExprTooLong
+			}
+		}
+		return string(append(b, s[last:]...)), nil
+	}},
+}
+
+var errExprTooLong = fmt.Errorf("expression built a value longer than %d bytes", exprMaxLen)
+
+func exprType(v any) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case int:
+		return "int"
+	case bool:
+		return "bool"
+	case []string:
+		return "list"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func exprString(fn string, v any) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s: want string, have %s", fn, exprType(v))
+	}
+	return s, nil
+}
+
+func exprStrings2(fn string, a []any) (string, string, error) {
+	s, err := exprString(fn, a[0])
+	if err != nil {
+		return "", "", err
+	}
+	t, err := exprString(fn, a[1])
+	return s, t, err
+}
+
+func exprStrings3(fn string, a []any) (string, string, string, error) {
+	s, t, err := exprStrings2(fn, a)
+	if err != nil {
+		return "", "", "", err
+	}
+	u, err := exprString(fn, a[2])
+	return s, t, u, err
+}
+
+// exprLen returns the number of bytes in the strings of list.
+func exprLen(list []string) int {
+	n := 0
+	for _, s := range list {
+		n += len(s)
+	}
+	return n
+}
+
+type (
+	litExpr struct{ v any }
+	varExpr struct{ name string }
+	notExpr struct{ x expr }
+	negExpr struct{ x expr }
+
+	binaryExpr struct {
+		op   string
+		x, y expr
+	}
+	condExpr  struct{ cond, then, els expr }
+	indexExpr struct{ x, i expr }
+	callExpr  struct {
+		name string
+		fn   exprFunc
+		args []expr
+		res  []*regexp.Regexp
+	}
+)
+
+func (e litExpr) eval(env *exprEnv) (any, error) { return e.v, nil }
+
+func (e varExpr) eval(env *exprEnv) (any, error) { return env.vars[e.name], nil }
+
+func (e notExpr) eval(env *exprEnv) (any, error) {
+	b, err := evalBool(env, e.x, "!")
+	return !b, err
+}
+
+func (e negExpr) eval(env *exprEnv) (any, error) {
+	v, err := e.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	n, ok := v.(int)
+	if !ok {
+		return nil, fmt.Errorf("-: want int, have %s", exprType(v))
+	}
+	return -n, nil
+}
+
+// evalBool evaluates x, which must be a boolean operand of op.
+func evalBool(env *exprEnv, x expr, op string) (bool, error) {
+	if err := env.step(); err != nil {
+		return false, err
+	}
+	v, err := x.eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s: want bool, have %s", op, exprType(v))
+	}
+	return b, nil
+}
+
+func (e binaryExpr) eval(env *exprEnv) (any, error) {
+	switch e.op {
+	case "&&", "||":
+		x, err := evalBool(env, e.x, e.op)
+		if err != nil || x == (e.op == "||") {
+			return x, err
+		}
+		return evalBool(env, e.y, e.op)
+	}
+	if err := env.step(); err != nil {
+		return nil, err
+	}
+	x, err := e.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	y, err := e.y.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if exprType(x) != exprType(y) {
+		return nil, fmt.Errorf("%s: mismatched %s and %s", e.op, exprType(x), exprType(y))
+	}
+	switch e.op {
+	case "==", "!=":
+		if _, ok := x.([]string); ok {
+			return nil, fmt.Errorf("%s: lists cannot be compared", e.op)
+		}
+		return (x == y) == (e.op == "=="), nil
+	case "+":
+		switch x := x.(type) {
+		case string:
+			if len(x)+len(y.(string)) > exprMaxLen {
+				return nil, errExprTooLong
+			}
+			return x + y.(string), nil
+		case int:
+			return x + y.(int), nil
+		case []string:
+			if exprLen(x)+exprLen(y.([]string)) > exprMaxLen {
+				return nil, errExprTooLong
+			}
+			return append(x[:len(x):len(x)], y.([]string)...), nil
+		}
+	case "-":
+		if x, ok := x.(int); ok {
+			return x - y.(int), nil
+		}
+	default: // < <= > >=
+		var c int
+		switch x := x.(type) {
+		case string:
+			c = cmp.Compare(x, y.(string))
+		case int:
+			c = cmp.Compare(x, y.(int))
+		default:
+			return nil, fmt.Errorf("%s: cannot order %s", e.op, exprType(x))
+		}
+		switch e.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	}
+	return nil, fmt.Errorf("%s: cannot apply to %s", e.op, exprType(x))
+}
+
+func (e condExpr) eval(env *exprEnv) (any, error) {
+	c, err := evalBool(env, e.cond, "?")
+	if err != nil {
+		return nil, err
+	}
+	if c {
+		return e.then.eval(env)
+	}
+	return e.els.eval(env)
+}
+
+func (e indexExpr) eval(env *exprEnv) (any, error) {
+	if err := env.step(); err != nil {
+		return nil, err
+	}
+	x, err := e.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	i, err := e.i.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := x.([]string)
+	if !ok {
+		return nil, fmt.Errorf("[]: want list, have %s", exprType(x))
+	}
+	n, ok := i.(int)
+	if !ok {
+		return nil, fmt.Errorf("[]: want int index, have %s", exprType(i))
+	}
+	if n < 0 {
+		n += len(list)
+	}
+	if n < 0 || n >= len(list) {
+		return nil, fmt.Errorf("[]: index %v out of range for list of %d", i, len(list))
+	}
+	return list[n], nil
+}
+
+func (e callExpr) eval(env *exprEnv) (any, error) {
+	if err := env.step(); err != nil {
+		return nil, err
+	}
+	args := make([]any, len(e.args))
+	for i, a := range e.args {
+		v, err := a.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	return e.fn.call(args, e.res)
+}
+
+// parseExpr parses the expression src, which may refer to the variables
+// in vars.
+func parseExpr(src string, vars []string) (expr, error) {
+	if len(src) > exprMaxSource {
+		return nil, fmt.Errorf("expression longer than %d bytes", exprMaxSource)
+	}
+	p := &exprParser{src: src, vars: vars}
+	p.next()
+	e := p.cond()
+	if p.err == nil && p.tok != "" {
+		p.fail("unexpected %q", p.tok)
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return e, nil
+}
+
+// An exprParser parses an expression by recursive descent.
+type exprParser struct {
+	src  string
+	pos  int    // offset of the next token
+	tok  string // current token, "" at the end
+	lit  any    // value of a literal token
+	vars []string
+	err  error
+}
+
+func (p *exprParser) fail(format string, args ...any) {
+	if p.err == nil {
+		p.err = fmt.Errorf("offset %d: %s", p.pos-len(p.tok), fmt.Sprintf(format, args...))
+	}
+	p.tok = ""
+}
+
+// next reads the next token.
+func (p *exprParser) next() {
+	for p.pos < len(p.src) && strings.IndexByte(" \t\r\n", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+	p.lit = nil
+	if p.pos == len(p.src) || p.err != nil {
+		p.tok = ""
+		return
+	}
+	s := p.src[p.pos:]
+	n := 1
+	switch c := s[0]; {
+	case c == '"':
+		q, err := strconv.QuotedPrefix(s)
+		if err != nil {
+			p.pos++
+			p.fail("unterminated string")
+			return
+		}
+		n = len(q)
+		p.lit, _ = strconv.Unquote(q)
+	case '0' <= c && c <= '9':
+		for n < len(s) && '0' <= s[n] && s[n] <= '9' {
+			n++
+		}
+		v, err := strconv.Atoi(s[:n])
+		if err != nil {
+			p.pos += n
+			p.fail("invalid number %s", s[:n])
+			return
+		}
+		p.lit = v
+	case c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+		for n < len(s) && (s[n] == '_' || 'a' <= s[n] && s[n] <= 'z' || 'A' <= s[n] && s[n] <= 'Z' || '0' <= s[n] && s[n] <= '9') {
+			n++
+		}
+	default:
+		for _, op := range []string{"==", "!=", "<=", ">=", "&&", "||"} {
+			if strings.HasPrefix(s, op) {
+				n = 2
+			}
+		}
+		if n == 1 && strings.IndexByte("+-!<>?:()[],", c) < 0 {
+			p.pos++
+			p.fail("unexpected %q", s[:1])
+			return
+		}
+	}
+	p.tok = s[:n]
+	p.pos += n
+}
+
+func (p *exprParser) expect(tok string) {
+	if p.tok != tok {
+		p.fail("want %q, have %q", tok, p.tok)
+		return
+	}
+	p.next()
+}
+
+// cond parses or [? cond : cond].
+func (p *exprParser) cond() expr {
+	c := p.binary(0)
+	if p.tok != "?" {
+		return c
+	}
+	p.next()
+	then := p.cond()
+	p.expect(":")
+	return condExpr{c, then, p.cond()}
+}
+
+// exprPrec lists the binary operators, loosest binding first.
+var exprPrec = [][]string{{"||"}, {"&&"}, {"==", "!=", "<", "<=", ">", ">="}, {"+", "-"}}
+
+// binary parses the binary operators of precedence level and tighter.
+func (p *exprParser) binary(level int) expr {
+	if level == len(exprPrec) {
+		return p.unary()
+	}
+	x := p.binary(level + 1)
+	for p.err == nil && slices.Contains(exprPrec[level], p.tok) {
+		op := p.tok
+		p.next()
+		x = binaryExpr{op, x, p.binary(level + 1)}
+		if level == 2 {
+			break // comparisons do not chain
+		}
+	}
+	return x
+}
+
+// unary parses [! | -] postfix.
+func (p *exprParser) unary() expr {
+	switch p.tok {
+	case "!":
+		p.next()
+		return notExpr{p.unary()}
+	case "-":
+		p.next()
+		return negExpr{p.unary()}
+	}
+	x := p.primary()
+	for p.tok == "[" {
+		p.next()
+		i := p.cond()
+		p.expect("]")
+		x = indexExpr{x, i}
+	}
+	return x
+}
+
+// primary parses a literal, variable, call or parenthesized expression.
+func (p *exprParser) primary() expr {
+	tok, lit := p.tok, p.lit
+	switch {
+	case lit != nil:
+		p.next()
+		return litExpr{lit}
+	case tok == "(":
+		p.next()
+		x := p.cond()
+		p.expect(")")
+		return x
+	case tok == "true" || tok == "false":
+		p.next()
+		return litExpr{tok == "true"}
+	case tok == "" || !(tok[0] == '_' || 'a' <= tok[0] && tok[0] <= 'z' || 'A' <= tok[0] && tok[0] <= 'Z'):
+		if p.err == nil {
+			p.fail("want operand, have %q", tok)
+		}
+		return litExpr{}
+	}
+	p.next()
+	if p.tok != "(" {
+		if !slices.Contains(p.vars, tok) {
+			p.fail("unknown variable %s: want one of %s", tok, strings.Join(p.vars, ", "))
+		}
+		return varExpr{tok}
+	}
+	fn, ok := exprFuncs[tok]
+	if !ok {
+		p.fail("unknown function %s", tok)
+		return litExpr{}
+	}
+	call := callExpr{name: tok, fn: fn, res: make([]*regexp.Regexp, fn.args)}
+	p.next()
+	for p.tok != ")" && p.err == nil {
+		if len(call.args) > 0 {
+			p.expect(",")
+		}
+		if slices.Contains(fn.regexps, len(call.args)) {
+			s, ok := p.lit.(string)
+			if !ok {
+				p.fail("%s: argument %d must be a regular expression literal", tok, len(call.args)+1)
+				return litExpr{}
+			}
+			re, err := regexp.Compile(s)
+			if err != nil {
+				p.fail("%s: %v", tok, err)
+				return litExpr{}
+			}
+			call.res[len(call.args)] = re
+		}
+		call.args = append(call.args, p.cond())
+	}
+	p.expect(")")
+	if p.err == nil && len(call.args) != fn.args {
+		p.fail("%s: want %d arguments, have %d", tok, fn.args, len(call.args))
+	}
+	return call
+}
@@ -0,0 +1,138 @@
+package redirector
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// serve serves a method request for url with h, with the header fields
+// given as name, value pairs.
+func serve(h http.Handler, method, url string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandlerResponses(t *testing.T) {
+	h, err := New("example.com/foo", "https://github.com/example/foo", "git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const url = "http://example.com/foo/bar?go-get=1"
+
+	w := serve(h, http.MethodGet, "http://example.com/other")
+	if w.Code != http.StatusNotFound || w.Header().Get("X-Robots-Tag") != "noindex" || w.Header().Get("X-Go-Import-Error") != CodeUnknownRoute {
+		t.Errorf("unknown path: %d, X-Robots-Tag %q, X-Go-Import-Error %q; want 404, noindex, %s", w.Code, w.Header().Get("X-Robots-Tag"), w.Header().Get("X-Go-Import-Error"), CodeUnknownRoute)
+	}
+	if w := serve(h, http.MethodGet, "http://example.com/other", "Accept", "application/json"); !strings.Contains(w.Body.String(), `"error":"`+CodeUnknownRoute+`"`) {
+		t.Errorf("unknown path as JSON: %s", w.Body)
+	}
+
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete} {
+		w := serve(h, method, url)
+		if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, HEAD" {
+			t.Errorf("%s: %d, Allow %q; want 405, GET, HEAD", method, w.Code, w.Header().Get("Allow"))
+		}
+	}
+
+	get := serve(h, http.MethodGet, url)
+	etag := get.Header().Get("Etag")
+	if get.Code != http.StatusOK || etag == "" {
+		t.Fatalf("GET: %d, ETag %q", get.Code, etag)
+	}
+	head := serve(h, http.MethodHead, url)
+	if head.Code != http.StatusOK || head.Body.Len() != 0 || head.Header().Get("Etag") != etag || head.Header().Get("Content-Length") != get.Header().Get("Content-Length") {
+		t.Errorf("HEAD: %d, %d bytes, ETag %q, Content-Length %q; want the headers of GET without a body", head.Code, head.Body.Len(), head.Header().Get("Etag"), head.Header().Get("Content-Length"))
+	}
+	for _, inm := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		if w := serve(h, http.MethodGet, url, "If-None-Match", inm); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Errorf("If-None-Match %s: %d with %d bytes, want 304 without a body", inm, w.Code, w.Body.Len())
+		}
+	}
+	if w := serve(h, http.MethodGet, url, "If-None-Match", `"other"`); w.Code != http.StatusOK {
+		t.Errorf("If-None-Match of another ETag: %d, want 200", w.Code)
+	}
+	json := serve(h, http.MethodGet, "http://example.com/foo/bar", "Accept", "application/json")
+	if json.Header().Get("Etag") == "" || json.Header().Get("Etag") == etag {
+		t.Errorf("JSON ETag %q, want one of its own", json.Header().Get("Etag"))
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, tt := range []struct{ imp, repo, vcs string }{
+		{"example.com/foo", "https://github.com/example/foo", "cvs"},
+		{"example.com/x/*", "https://github.com/example/x", "git"},
+		{"example.com/foo", "github.com/example/foo", "git"},
+	} {
+		if _, err := New(tt.imp, tt.repo, tt.vcs); err == nil {
+			t.Errorf("New(%q, %q, %q) succeeded", tt.imp, tt.repo, tt.vcs)
+		}
+	}
+
+	h, err := New("example.com/x/*", "https://github.com/example/*", "git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		url, accept string
+		status      int
+		want        string // in the body
+	}{
+		{"http://example.com/x/y?go-get=1", "", http.StatusOK, `<meta name="go-import" content="example.com/x/y git https://github.com/example/y">`},
+		{"http://example.com/x/y/sub/pkg?go-get=1", "", http.StatusOK, `<meta name="go-import" content="example.com/x/y git https://github.com/example/y">`},
+		{"http://example.com/x/y/sub", "", http.StatusOK, "https://github.com/example/y"},
+		{"http://example.com/x/y/sub", "application/json", http.StatusOK, `"import_root":"example.com/x/y","vcs":"git","repo_root":"https://github.com/example/y","suffix":"/sub"`},
+		{"http://example.com/x?go-get=1", "", http.StatusFound, "https://github.com/example"},
+		{"http://example.com/other/y?go-get=1", "", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := serve(h, http.MethodGet, tt.url, "Accept", tt.accept)
+		if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("%s (%s): %d, want %d with %q in:\n%s", tt.url, tt.accept, w.Code, tt.status, tt.want, w.Body)
+		}
+	}
+
+	h, err = New("example.com/pkg", "https://hg.example.com/pkg", "hg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := serve(h, http.MethodGet, "http://example.com/pkg?go-get=1")
+	if want := `<meta name="go-import" content="example.com/pkg hg https://hg.example.com/pkg">`; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("hg page lacks %s:\n%s", want, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type %q", ct)
+	}
+}
+
+func TestHasTags(t *testing.T) {
+	r := &Rule{Import: "example.com/foo", Repo: "https://github.com/example/foo", Tags: []string{"tier=internal", "team-infra"}}
+	if err := r.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		tags []string
+		want bool
+	}{
+		{nil, true},
+		{[]string{"team-infra"}, true},
+		{[]string{"team-infra", "tier=internal"}, true},
+		{[]string{"team-infra", "tier=public"}, false},
+	} {
+		if got := r.HasTags(tt.tags); got != tt.want {
+			t.Errorf("HasTags(%q) = %v, want %v", tt.tags, got, tt.want)
+		}
+	}
+	for _, tag := range []string{"", "a b", "a,b"} {
+		r.Tags = []string{tag}
+		if err := r.Init(); err == nil {
+			t.Errorf("tag %q accepted", tag)
+		}
+	}
+}
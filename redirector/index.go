@@ -0,0 +1,113 @@
+package redirector
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+)
+
+// An Index looks up the rule matching a path among many rules without
+// scanning them all. It matches and resolves exactly as Match and
+// Resolve do on its rules, which must not change after NewIndex.
+type Index struct {
+	rules  []*Rule
+	byRoot map[string][]int // rule numbers by import path root
+	hosts  []string
+}
+
+// NewIndex indexes rules by their import path roots.
+func NewIndex(rules []*Rule) *Index {
+	x := &Index{rules: rules, byRoot: make(map[string][]int, len(rules))}
+	seen := make(map[string]bool)
+	for i, r := range rules {
+		x.byRoot[r.importPath] = append(x.byRoot[r.importPath], i)
+		if host := r.Host(); !seen[host] {
+			seen[host] = true
+			x.hosts = append(x.hosts, host)
+		}
+	}
+	return x
+}
+
+// Rules returns the indexed rules.
+func (x *Index) Rules() []*Rule { return x.rules }
+
+// Hosts returns the distinct hosts of the import paths, in rule order.
+func (x *Index) Hosts() []string { return x.hosts }
+
+// Match is Match on the indexed rules. Only the rules rooted at path or
+// at one of its parents can match it; they are weighed in rule order.
+func (x *Index) Match(path string) *Rule {
+	var buf [8]int
+	cands := buf[:0]
+	for p := path; ; {
+		cands = append(cands, x.byRoot[p]...)
+		i := strings.LastIndexByte(p, '/')
+		if i < 0 {
+			break
+		}
+		p = p[:i]
+	}
+	slices.Sort(cands)
+	m := matcher{path: path}
+	for _, c := range cands {
+		m.consider(x.rules[c])
+	}
+	return m.match
+}
+
+// Resolve is Resolve on the indexed rules.
+func (x *Index) Resolve(path string, now time.Time) *Resolution {
+	return x.ResolveContext(context.Background(), path, now)
+}
+
+// ResolveContext is Resolve, giving up with a 504 Gateway Timeout
+// resolution and CodeTimeout once ctx is done, including while evaluating
+// the rule's expressions.
+func (x *Index) ResolveContext(ctx context.Context, path string, now time.Time) *Resolution {
+	return resolveRule(ctx, x.Match(path), path, now)
+}
+
+// Overlapping returns the first rule b that Overlaps an earlier rule a,
+// comparing only the rules that can overlap: those with the same import
+// path root, or templates and wildcard rules with the same host and number
+// of elements.
+func Overlapping(rules []*Rule) (a, b int, ok bool) {
+	type group struct {
+		host  string
+		elems int
+	}
+	roots := make(map[string]int)
+	templates := make(map[group][]int)
+	wildcards := make(map[group][]int)
+	for i, r := range rules {
+		p := r.pattern()
+		g := group{r.Host(), len(p)}
+		if r.elems == nil {
+			if j, ok := roots[r.importPath]; ok {
+				return j, i, true
+			}
+			roots[r.importPath] = i
+			if p == nil {
+				continue
+			}
+		}
+		for _, j := range templates[g] {
+			if r.Overlaps(rules[j]) {
+				return j, i, true
+			}
+		}
+		if r.elems == nil {
+			wildcards[g] = append(wildcards[g], i)
+			continue
+		}
+		for _, j := range wildcards[g] {
+			if r.Overlaps(rules[j]) {
+				return j, i, true
+			}
+		}
+		templates[g] = append(templates[g], i)
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,209 @@
+package redirector
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// Page is the default page template, executed with a *Data.
+var Page = template.Must(template.New("main").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="go-import" content="{{.ImportRoot}} {{.VCS}} {{.VCSRoot}}{{with .Subdir}} {{.}}{{end}}">
+{{- if .ModProxy}}
+<meta name="go-import" content="{{.ImportRoot}} mod {{.ModProxy}}">
+{{- end}}
+{{- with .Source}}
+<meta name="go-source" content="{{$.ImportRoot}} {{.Home}} {{.Dir}} {{.File}}">
+{{- end}}
+{{- if .Refresh}}
+<meta http-equiv="refresh" content="0; url={{.BrowseURL}}">
+{{- end}}
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="color-scheme" content="light dark">
+<title>{{.ImportRoot}}{{.Suffix}}</title>
+<style>
+:root { --fg: #1f2328; --bg: #fff; --muted: #59636e; --code: #f6f8fa; --link: #0969da; }
+@media (prefers-color-scheme: dark) {
+  :root { --fg: #e6edf3; --bg: #0d1117; --muted: #9198a1; --code: #161b22; --link: #4493f8; }
+}
+body { margin: 0; font: 1rem/1.5 system-ui, sans-serif; color: var(--fg); background: var(--bg); }
+main { max-width: 40rem; margin: 0 auto; padding: 2rem 1rem; }
+h1 { font-size: 1.5rem; overflow-wrap: anywhere; }
+pre { background: var(--code); padding: .75rem 1rem; border-radius: 6px; overflow-x: auto; margin: 0; flex: 1; }
+.snippet { display: flex; gap: .5rem; align-items: center; }
+button, select { font: inherit; }
+a { color: var(--link); overflow-wrap: anywhere; }
+a:focus-visible { outline: 2px solid var(--link); outline-offset: 2px; }
+.muted { color: var(--muted); }
+</style>
+</head>
+<body>
+<main>
+<h1>{{.ImportRoot}}{{.Suffix}}</h1>
+<p>Install with:</p>
+<div class="snippet">
+<pre><code id="cmd">go get {{.ImportRoot}}{{.Suffix}}</code></pre>
+<button type="button" id="copy" hidden>Copy</button>
+</div>
+{{- if .Versions}}
+<p><label for="version">Version</label>
+<select id="version">
+<option value="">latest</option>
+{{- range .Versions}}
+<option>{{.}}</option>
+{{- end}}
+</select></p>
+{{- end}}
+<ul>
+<li>Source: <a href="{{.SourceLink}}" rel="noopener noreferrer">{{.VCSRoot}}</a></li>
+<li>Documentation: <a href="{{.DocsLink}}" rel="noopener noreferrer">{{.DocsURL}}</a></li>
+</ul>
+{{- if .MinGo}}
+<p>Requires Go {{.MinGo}} or later.</p>
+{{- end}}
+{{- if or .Owner .Team .Contact}}
+<p>Maintained by
+{{- with .Owner}} {{.}}{{end}}
+{{- with .Team}}{{if $.Owner}},{{end}} {{.}}{{end}}
+{{- with .Contact}} ({{if $.ContactURL}}<a href="{{$.ContactURL}}">{{.}}</a>{{else}}{{.}}{{end}}){{end}}.</p>
+{{- end}}
+{{- if .Archived}}
+<p role="note"><strong>This repository is archived and no longer maintained.</strong></p>
+{{- end}}
+{{- with .MovedTo}}
+<p role="note"><strong>Deprecated: this package has moved to <a href="https://{{.}}">{{.}}</a>.</strong> Update your imports to the new path.</p>
+{{- end}}
+{{- if .Refresh}}
+<p class="muted" role="status">Redirecting to <a href="{{.SourceLink}}" rel="noopener noreferrer">{{.VCSRoot}}</a>...</p>
+{{- end}}
+</main>
+<script>
+(function() {
+  var cmd = document.getElementById("cmd"), base = cmd.textContent;
+  var version = document.getElementById("version"), copy = document.getElementById("copy");
+  if (version) {
+    version.addEventListener("change", function() {
+      cmd.textContent = base + (version.value ? "@" + version.value : "");
+    });
+  }
+  if (navigator.clipboard) {
+    copy.hidden = false;
+    copy.addEventListener("click", function() {
+      navigator.clipboard.writeText(cmd.textContent).then(function() {
+        copy.textContent = "Copied";
+        setTimeout(function() { copy.textContent = "Copy"; }, 1500);
+      });
+    });
+  }
+})();
+</script>
+</body>
+</html>
+`))
+
+// MinimalPage is a page with only the meta tags the go command and
+// pkg.go.dev read, and a link to the repo, executed with a *Data, for
+// serving when the page templates cannot be used.
+var MinimalPage = template.Must(template.New("minimal").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="go-import" content="{{.ImportRoot}} {{.VCS}} {{.VCSRoot}}{{with .Subdir}} {{.}}{{end}}">
+{{- if .ModProxy}}
+<meta name="go-import" content="{{.ImportRoot}} mod {{.ModProxy}}">
+{{- end}}
+{{- with .Source}}
+<meta name="go-source" content="{{$.ImportRoot}} {{.Home}} {{.Dir}} {{.File}}">
+{{- end}}
+<title>{{.ImportRoot}}{{.Suffix}}</title>
+</head>
+<body>
+<a href="{{.SourceLink}}" rel="noopener noreferrer">{{.VCSRoot}}</a>
+</body>
+</html>
+`))
+
+// Data is the data for the page template, also served as JSON.
+type Data struct {
+	ImportPath string    `json:"-"` // ImportRoot + Suffix
+	ImportRoot string    `json:"import_root"`
+	VCS        string    `json:"vcs"`
+	VCSRoot    string    `json:"repo_root"`
+	Suffix     string    `json:"suffix"`
+	Subdir     string    `json:"subdir,omitempty"` // directory of the module in the repo
+	DocsURL    string    `json:"docs_url"`
+	Source     *GoSource `json:"go_source,omitempty"`
+	ModProxy   string    `json:"mod_proxy,omitempty"`
+	MinGo      string    `json:"min_go,omitempty"`
+	Versions   []string  `json:"versions,omitempty"`
+	Archived   bool      `json:"archived,omitempty"` // the repo is archived, as set by the caller
+	MovedTo    string    `json:"moved_to,omitempty"` // the import path the package has moved to
+	Owner      string    `json:"owner,omitempty"`
+	Team       string    `json:"team,omitempty"`
+	Contact    string    `json:"contact,omitempty"`
+	ContactURL string    `json:"-"`                    // href of the link to Contact
+	Refresh    bool      `json:"-"`                    // redirect browsers with a meta refresh
+	BrowseURL  string    `json:"browse_url,omitempty"` // web page of the package directory in the repo
+	SourceLink string    `json:"-"`                    // href of the link to VCSRoot
+	DocsLink   string    `json:"-"`                    // href of the link to DocsURL
+}
+
+// Data returns the page data for a successful resolution.
+func (r *Resolution) Data() *Data {
+	docs := "https://pkg.go.dev/" + r.ImportRoot + r.Suffix
+	browse := r.BrowseURL
+	if browse == "" {
+		dir := r.Suffix
+		if r.Subdir != "" {
+			dir = "/" + r.Subdir + dir
+		}
+		browse = r.Rule.browseURL(r.RepoRoot, dir)
+	}
+	return &Data{
+		ImportPath: r.ImportRoot + r.Suffix,
+		ImportRoot: r.ImportRoot,
+		VCS:        r.VCS,
+		VCSRoot:    r.RepoRoot,
+		Suffix:     r.Suffix,
+		Subdir:     r.Subdir,
+		DocsURL:    docs,
+		Source:     r.Rule.source(r.RepoRoot, r.Subdir),
+		ModProxy:   r.Rule.ModProxy,
+		MinGo:      r.Rule.MinGo,
+		MovedTo:    r.MovedTo,
+		Owner:      r.Rule.Owner,
+		Team:       r.Rule.Team,
+		Contact:    r.Rule.Contact,
+		ContactURL: contactURL(r.Rule.Contact),
+		Refresh:    r.VCS != "mod", // a module proxy has no page to show
+		BrowseURL:  browse,
+		SourceLink: browse,
+		DocsLink:   docs,
+	}
+}
+
+// contactURL returns the link target for a contact: a mailto: URL for an
+// email address, the contact itself for a URL, or "".
+func contactURL(contact string) string {
+	switch {
+	case strings.Contains(contact, "://"):
+		return contact
+	case strings.Contains(contact, "@"):
+		return "mailto:" + contact
+	}
+	return ""
+}
+
+// AcceptsJSON reports whether the request asks for an application/json response.
+func AcceptsJSON(req *http.Request) bool {
+	for _, r := range strings.Split(req.Header.Get("Accept"), ",") {
+		mt, _, _ := strings.Cut(r, ";")
+		if strings.TrimSpace(mt) == "application/json" {
+			return true
+		}
+	}
+	return false
+}
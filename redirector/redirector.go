@@ -0,0 +1,398 @@
+// Package redirector implements the go-import-redirector handler: it
+// answers requests for URLs in an import path root with a go-import meta
+// tag naming the source repository and a redirect to that repository.
+//
+// To serve an import path from an existing web server:
+//
+//	h, err := redirector.New("rsc.io/*", "https://github.com/rsc/*", "git")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	http.Handle("rsc.io/", h)
+//
+// If both the import path and the repo end in /*, the corresponding path
+// element is taken from the request and substituted in the repo.
+package redirector
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A Rule maps an import path to a source repository.
+// Either may end in as many /* as there are wildcard path elements, or
+// the import path may be a template with {name} placeholders used in the
+// repo. Call Init before using a Rule.
+type Rule struct {
+	Import     string `json:"import,omitempty" yaml:"import,omitempty" toml:"import,omitempty"`
+	Repo       string `json:"repo,omitempty" yaml:"repo,omitempty" toml:"repo,omitempty"`
+	VCS        string `json:"vcs,omitempty" yaml:"vcs,omitempty" toml:"vcs,omitempty"`                         // default git; mod for a module proxy
+	ModProxy   string `json:"mod_proxy,omitempty" yaml:"mod_proxy,omitempty" toml:"mod_proxy,omitempty"`       // also advertise this module proxy
+	MinGo      string `json:"min_go,omitempty" yaml:"min_go,omitempty" toml:"min_go,omitempty"`                // minimum Go version required
+	OldRepo    string `json:"old_repo,omitempty" yaml:"old_repo,omitempty" toml:"old_repo,omitempty"`          // served until Cutover
+	Cutover    string `json:"cutover,omitempty" yaml:"cutover,omitempty" toml:"cutover,omitempty"`             // RFC 3339 time
+	SourceDir  string `json:"source_dir,omitempty" yaml:"source_dir,omitempty" toml:"source_dir,omitempty"`    // go-source directory URL template
+	SourceFile string `json:"source_file,omitempty" yaml:"source_file,omitempty" toml:"source_file,omitempty"` // go-source file URL template
+	Branch     string `json:"branch,omitempty" yaml:"branch,omitempty" toml:"branch,omitempty"`                // default branch in source URLs, default HEAD
+	DirURL     string `json:"dir_url,omitempty" yaml:"dir_url,omitempty" toml:"dir_url,omitempty"`             // URL template of repo directories for browsers
+
+	// BrowserRedirect is where requests without ?go-get=1 are sent:
+	// "repo" (or empty) for the page redirecting to the repo, "pkgsite"
+	// for a redirect to pkg.go.dev, or a URL template in which {path}
+	// stands for the full import path.
+	BrowserRedirect string `json:"browser_redirect,omitempty" yaml:"browser_redirect,omitempty" toml:"browser_redirect,omitempty"`
+
+	// Owner, Team and Contact say who is responsible for the modules.
+	// Contact is an email address or a URL.
+	Owner   string `json:"owner,omitempty" yaml:"owner,omitempty" toml:"owner,omitempty"`
+	Team    string `json:"team,omitempty" yaml:"team,omitempty" toml:"team,omitempty"`
+	Contact string `json:"contact,omitempty" yaml:"contact,omitempty" toml:"contact,omitempty"`
+
+	// Tags are free-form labels, such as tier=internal or team-infra,
+	// for selecting rules in listings. They do not change what is served.
+	Tags []string `json:"tags,omitempty" yaml:"tags,omitempty" toml:"tags,omitempty"`
+
+	// Aliases are other import path roots, such as go.example.com/x for
+	// example.com/x, serving the same repos while the namespace moves
+	// between hosts. See AliasRules.
+	Aliases []string `json:"aliases,omitempty" yaml:"aliases,omitempty" toml:"aliases,omitempty"`
+
+	// MovedTo is the import path root the modules have moved to, such as
+	// new.example.com/x for example.com/x. The old paths keep resolving
+	// for the go command, while browsers are sent to the new ones.
+	MovedTo string `json:"moved_to,omitempty" yaml:"moved_to,omitempty" toml:"moved_to,omitempty"`
+
+	// Transforms rewrite the wildcard elements or placeholder values
+	// substituted in the repo: lower, replace:<old>:<new>,
+	// trim-prefix:<prefix> or trim-suffix:<suffix>, applied in order.
+	Transforms []string `json:"transforms,omitempty" yaml:"transforms,omitempty" toml:"transforms,omitempty"`
+
+	// Subdir is the directory of the repo holding the module, for modules
+	// nested in a monorepo. With wildcards, it rather than the repo ends
+	// in /*. The go command needs to be Go 1.25 or later.
+	Subdir string `json:"subdir,omitempty" yaml:"subdir,omitempty" toml:"subdir,omitempty"`
+
+	// Shards are further repos of the same form as Repo, each optionally
+	// followed by a space and a weight (default 1, as for Repo), across
+	// which the import roots are distributed by a hash of their path
+	// below the import path root, such as to spread a namespace across
+	// several GitHub organizations.
+	Shards []string `json:"shards,omitempty" yaml:"shards,omitempty" toml:"shards,omitempty"`
+
+	// RepoExpr, VCSExpr and BrowseExpr are expressions computing the
+	// repo root, VCS and browse URL of each import root from the request
+	// path, for mappings the other fields cannot express. They replace
+	// what the rule resolves otherwise, which they can refer to as repo
+	// and vcs. See expr.go for the language.
+	RepoExpr   string `json:"repo_expr,omitempty" yaml:"repo_expr,omitempty" toml:"repo_expr,omitempty"`
+	VCSExpr    string `json:"vcs_expr,omitempty" yaml:"vcs_expr,omitempty" toml:"vcs_expr,omitempty"`
+	BrowseExpr string `json:"browse_expr,omitempty" yaml:"browse_expr,omitempty" toml:"browse_expr,omitempty"`
+
+	// Template names an html/template file for the page, executed with
+	// a *Data. The caller loads it into Page.
+	Template string `json:"template,omitempty" yaml:"template,omitempty" toml:"template,omitempty"`
+
+	// Page, if set, replaces the default page template for this rule.
+	Page *template.Template `json:"-" yaml:"-" toml:"-"`
+
+	// Overlap is how long either side of the cutover counts as the
+	// overlap window reported in Resolution.InOverlap.
+	Overlap time.Duration `json:"-" yaml:"-" toml:"-"`
+
+	importPath  string // Import without the wildcards
+	repoPath    string // Repo without the wildcards
+	oldRepoPath string // OldRepo without the wildcards
+	wildcard    int
+	elems       []string // import path template elements, if any
+	literals    int      // number of literal elements in the import path
+	canonical   string   // import path root of the rule this is an alias of
+	movedTo     string   // MovedTo, or the end of its chain of moves
+	transforms  []func(string) string
+	shards      []shard
+	subdir      string // Subdir without the wildcards
+	repoExpr    expr
+	vcsExpr     expr
+	browseExpr  expr
+	cutover     time.Time
+}
+
+// vcsTypes are the VCS types the go command accepts in go-import tags.
+var vcsTypes = []string{"git", "hg", "svn", "bzr", "fossil", "mod"}
+
+// CheckVCS returns an error if the go command does not understand vcs.
+func CheckVCS(vcs string) error {
+	if !slices.Contains(vcsTypes, vcs) {
+		return fmt.Errorf("unknown vcs %q: want one of %s", vcs, strings.Join(vcsTypes, ", "))
+	}
+	return nil
+}
+
+// goVersionRE matches the versions accepted as MinGo.
+var goVersionRE = regexp.MustCompile(`^1\.\d+(\.\d+)?$`)
+
+// Init validates r and trims its wildcards.
+func (r *Rule) Init() error {
+	if r.Import == "" || r.Repo == "" {
+		return errors.New("rule needs both import and repo")
+	}
+	if !strings.Contains(r.Repo, "://") {
+		return errors.New("repo path must be full URL")
+	}
+	if r.VCS == "" {
+		r.VCS = "git"
+	}
+	if err := CheckVCS(r.VCS); err != nil {
+		return err
+	}
+	if r.VCS == "mod" && r.ModProxy != "" {
+		return errors.New("mod proxy cannot be used with vcs mod")
+	}
+	r.elems, r.literals = nil, 0
+	switch {
+	case isTemplate(r.Import):
+		if err := r.initTemplate(); err != nil {
+			return err
+		}
+	// A module proxy serves every module under its base URL, so with
+	// vcs mod the repo has no wildcards to substitute.
+	case r.VCS == "mod" && strings.HasSuffix(r.Repo, "/*"):
+		return errors.New("with vcs mod, repo must be the proxy base URL without /*")
+	case r.VCS != "mod" && r.Subdir == "" && strings.HasSuffix(r.Import, "/*") != strings.HasSuffix(r.Repo, "/*"):
+		return errors.New("either both import and repo must have /* or neither")
+	}
+	if r.elems == nil {
+		r.importPath, r.repoPath, r.wildcard = r.Import, r.Repo, 0
+		for strings.HasSuffix(r.importPath, "/*") {
+			r.wildcard++
+			r.importPath = strings.TrimSuffix(r.importPath, "/*")
+			r.repoPath = strings.TrimSuffix(r.repoPath, "/*")
+		}
+		r.importPath = strings.TrimSuffix(r.importPath, "/")
+		r.literals = strings.Count(r.importPath, "/") + 1
+	}
+	if r.ModProxy != "" && !strings.Contains(r.ModProxy, "://") {
+		return errors.New("mod proxy must be full URL")
+	}
+	if r.MinGo != "" && !goVersionRE.MatchString(r.MinGo) {
+		return fmt.Errorf("invalid minimum Go version %q: want a version such as 1.21", r.MinGo)
+	}
+	if (r.SourceDir == "") != (r.SourceFile == "") {
+		return errors.New("source dir and file templates must be given together")
+	}
+	if r.DirURL != "" && !strings.Contains(r.DirURL, "://") && !strings.HasPrefix(r.DirURL, "{repo}") {
+		return errors.New("dir URL template must be full URL or start with {repo}")
+	}
+	switch r.BrowserRedirect {
+	case "", "repo", "pkgsite":
+	default:
+		if !strings.Contains(r.BrowserRedirect, "://") {
+			return fmt.Errorf("browser redirect %q must be repo, pkgsite or a URL template", r.BrowserRedirect)
+		}
+	}
+	for _, t := range r.Tags {
+		if t == "" || strings.ContainsAny(t, ", \t\n") {
+			return fmt.Errorf("invalid tag %q: want a label without spaces or commas", t)
+		}
+	}
+	if err := r.initTransforms(); err != nil {
+		return err
+	}
+	if err := r.initSubdir(); err != nil {
+		return err
+	}
+	if err := r.initShards(); err != nil {
+		return err
+	}
+	if err := r.initExprs(); err != nil {
+		return err
+	}
+	if r.MovedTo != "" {
+		if strings.ContainsAny(r.MovedTo, "*{}") || strings.HasSuffix(r.MovedTo, "/") || strings.Contains(r.MovedTo, "://") {
+			return fmt.Errorf("invalid moved to %q: want an import path root such as new.example.com/x", r.MovedTo)
+		}
+		if r.MovedTo == r.importPath {
+			return fmt.Errorf("moved to %s is the import path root itself", r.MovedTo)
+		}
+	}
+	r.movedTo = r.MovedTo
+	return r.initMigration()
+}
+
+// initMigration validates the old repo and cutover time.
+// It must run after the wildcards have been trimmed from the import path.
+func (r *Rule) initMigration() error {
+	r.oldRepoPath, r.cutover = "", time.Time{}
+	if r.OldRepo == "" {
+		if r.Cutover != "" {
+			return errors.New("cutover requires old repo")
+		}
+		return nil
+	}
+	if r.Cutover == "" {
+		return errors.New("old repo requires cutover")
+	}
+	t, err := time.Parse(time.RFC3339, r.Cutover)
+	if err != nil {
+		return fmt.Errorf("invalid cutover: %v", err)
+	}
+	r.cutover = t
+	r.oldRepoPath = r.OldRepo
+	if !strings.Contains(r.oldRepoPath, "://") {
+		return errors.New("old repo path must be full URL")
+	}
+	for i := 0; i < r.wildcard && r.VCS != "mod" && r.Subdir == ""; i++ {
+		if !strings.HasSuffix(r.oldRepoPath, "/*") {
+			return errors.New("old repo must have as many /* as import")
+		}
+		r.oldRepoPath = strings.TrimSuffix(r.oldRepoPath, "/*")
+	}
+	if strings.HasSuffix(r.oldRepoPath, "/*") {
+		return errors.New("old repo must have as many /* as import")
+	}
+	return nil
+}
+
+// repoRoot returns the repo root for the wildcard element elem in repo.
+func (r *Rule) repoRoot(repo, elem string) string {
+	if r.VCS == "mod" || r.Subdir != "" {
+		return repo
+	}
+	return repo + "/" + r.transform(elem)
+}
+
+// Root returns the import path without the wildcards.
+func (r *Rule) Root() string { return r.importPath }
+
+// Host returns the host part of the import path.
+func (r *Rule) Host() string {
+	host, _, _ := strings.Cut(r.importPath, "/")
+	return host
+}
+
+// HasTags reports whether r carries all of tags.
+func (r *Rule) HasTags(tags []string) bool {
+	for _, t := range tags {
+		if !slices.Contains(r.Tags, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// Fingerprint returns a hash over everything in r that determines the
+// served metadata.
+func (r *Rule) Fingerprint() string {
+	importPath := r.importPath
+	if r.elems != nil {
+		importPath = r.Import
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%d\n%s\n%s\n%s\n", importPath, r.repoPath, r.wildcard, r.VCS, r.oldRepoPath, r.cutover.Format(time.RFC3339))
+	if len(r.Transforms) > 0 {
+		fmt.Fprintf(h, "%s\n", strings.Join(r.Transforms, "\x00"))
+	}
+	if r.Subdir != "" {
+		fmt.Fprintf(h, "subdir %s\n", r.Subdir)
+	}
+	if len(r.Shards) > 0 {
+		fmt.Fprintf(h, "%s\n", strings.Join(r.Shards, "\x00"))
+	}
+	if r.RepoExpr != "" || r.VCSExpr != "" || r.BrowseExpr != "" {
+		fmt.Fprintf(h, "%s\x00%s\x00%s\n", r.RepoExpr, r.VCSExpr, r.BrowseExpr)
+	}
+	if r.ModProxy != "" {
+		fmt.Fprintf(h, "mod_proxy %s\n", r.ModProxy)
+	}
+	movedTo := r.movedTo
+	if movedTo == "" {
+		movedTo = r.MovedTo
+	}
+	if movedTo != "" {
+		fmt.Fprintf(h, "moved_to %s\n", movedTo)
+	}
+	if len(r.Aliases) > 0 {
+		fmt.Fprintf(h, "aliases %s\n", strings.Join(r.Aliases, "\x00"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ActiveRepo returns the repo path (without wildcards) to serve at time
+// now, and whether now falls within the overlap window around the cutover.
+func (r *Rule) ActiveRepo(now time.Time) (repo string, inOverlap bool) {
+	if r.oldRepoPath == "" {
+		return r.repoPath, false
+	}
+	d := now.Sub(r.cutover)
+	inOverlap = d > -r.Overlap && d < r.Overlap
+	if d < 0 {
+		return r.oldRepoPath, inOverlap
+	}
+	return r.repoPath, inOverlap
+}
+
+// New returns a handler serving importPath from repoPath using the given
+// version control system, with the default page. It answers GET and HEAD
+// requests, with ETags for conditional requests, and marks its 404
+// responses as not to be indexed.
+func New(importPath, repoPath, vcs string) (http.Handler, error) {
+	r := &Rule{Import: importPath, Repo: repoPath, VCS: vcs}
+	if err := r.Init(); err != nil {
+		return nil, err
+	}
+	return handler{r}, nil
+}
+
+type handler struct{ rule *Rule }
+
+func (h handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !AllowedMethod(w, req) {
+		return
+	}
+	path := strings.TrimSuffix(req.Host+req.URL.Path, "/")
+	r := Resolve([]*Rule{h.rule}, path, time.Now())
+	switch r.Status {
+	case http.StatusFound:
+		http.Redirect(w, req, r.Location, http.StatusFound)
+		return
+	case http.StatusNotFound, http.StatusInternalServerError:
+		ServeError(w, req, r.Status, r.Code)
+		return
+	}
+	d := r.Data()
+	w.Header().Set("Vary", "Accept")
+	if req.FormValue("go-get") != "1" && AcceptsJSON(req) {
+		body, err := json.Marshal(d)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		WriteBody(w, req, body, "")
+		return
+	}
+	if u := r.BrowserURL(); u != "" && req.FormValue("go-get") != "1" {
+		http.Redirect(w, req, u, http.StatusFound)
+		return
+	}
+	t := Page
+	if h.rule.Page != nil {
+		t = h.rule.Page
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, d); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	WriteBody(w, req, buf.Bytes(), "")
+}
@@ -0,0 +1,267 @@
+package redirector
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// initRules returns initialized rules for the import paths, each served
+// from a repo of a matching shape.
+func initRules(t *testing.T, imports ...string) []*Rule {
+	t.Helper()
+	var rules []*Rule
+	for _, imp := range imports {
+		r := &Rule{Import: imp, Repo: "https://github.com/example/repo"}
+		switch {
+		case isTemplate(imp):
+			for _, e := range placeholderNameRE.FindAllString(imp, -1) {
+				r.Repo += "-" + e
+			}
+		default:
+			for p := imp; len(p) > 2 && p[len(p)-2:] == "/*"; p = p[:len(p)-2] {
+				r.Repo += "/*"
+			}
+		}
+		if err := r.Init(); err != nil {
+			t.Fatalf("%s: %v", imp, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+func TestOverlapping(t *testing.T) {
+	tests := []struct {
+		imports []string
+		a, b    int // the overlapping rules, or -1
+	}{
+		// Distinct rules.
+		{[]string{"example.com/foo", "example.com/bar"}, -1, -1},
+		{[]string{"example.com/foo", "example.com/foo/bar"}, -1, -1},
+		{[]string{"example.com/x/*", "example.com/y/*"}, -1, -1},
+		{[]string{"example.com/x/{name}", "example.com/y/{name}"}, -1, -1},
+		{[]string{"example.com/x/{name}", "other.com/x/{name}"}, -1, -1},
+
+		// Same import path root.
+		{[]string{"example.com/foo", "example.com/bar", "example.com/foo"}, 0, 2},
+		{[]string{"example.com/x", "example.com/x/*"}, 0, 1},
+		{[]string{"example.com/x/*", "example.com/x/*/*"}, 0, 1},
+
+		// Templates matching the same paths with as many literals.
+		{[]string{"example.com/x/{name}", "example.com/x/{other}"}, 0, 1},
+		{[]string{"example.com/{org}/foo", "example.com/x/{name}"}, 0, 1},
+		{[]string{"example.com/{a}/{b}/c", "example.com/x/{b}/d", "example.com/{a}/y/e"}, -1, -1},
+		{[]string{"example.com/{a}/{b}/c", "example.com/x/{b}/{c}"}, 0, 1},
+		{[]string{"example.com/{a}/{b}/c", "example.com/{x}/{y}/c"}, 0, 1},
+
+		// A template with more literals, or more elements, takes precedence.
+		{[]string{"example.com/x/{name}", "example.com/x/foo"}, -1, -1},
+		{[]string{"example.com/{org}/{name}", "example.com/x/{name}"}, -1, -1},
+		{[]string{"example.com/x/{name}", "example.com/x/{name}/{sub}"}, -1, -1},
+
+		// Wildcards compete with templates of the same shape.
+		{[]string{"example.com/x/*", "example.com/x/{name}"}, 0, 1},
+		{[]string{"example.com/x/{name}", "example.com/x/*"}, 0, 1},
+		{[]string{"example.com/x/*", "example.com/{org}/foo"}, 0, 1},
+		{[]string{"example.com/x/*/*", "example.com/x/{a}/{b}"}, 0, 1},
+		{[]string{"example.com/x/*", "example.com/x/{a}/{b}"}, -1, -1},
+		{[]string{"example.com/x/*", "example.com/y/{name}"}, -1, -1},
+		{[]string{"example.com/x/*", "example.com/{org}/{name}"}, -1, -1},
+	}
+	for _, tt := range tests {
+		a, b, ok := Overlapping(initRules(t, tt.imports...))
+		switch {
+		case tt.a < 0 && ok:
+			t.Errorf("%q: rule %d overlaps rule %d, want no overlap", tt.imports, b, a)
+		case tt.a >= 0 && !ok:
+			t.Errorf("%q: no overlap, want rule %d overlapping rule %d", tt.imports, tt.b, tt.a)
+		case tt.a >= 0 && (a != tt.a || b != tt.b):
+			t.Errorf("%q: rule %d overlaps rule %d, want rule %d overlapping rule %d", tt.imports, b, a, tt.b, tt.a)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		imports []string
+		path    string
+		want    string // the Import of the matching rule, or ""
+	}{
+		{[]string{"example.com/foo"}, "example.com/foo", "example.com/foo"},
+		{[]string{"example.com/foo"}, "example.com/foo/bar", "example.com/foo"},
+		{[]string{"example.com/foo"}, "example.com/foobar", ""},
+		{[]string{"example.com/foo"}, "example.com/bar", ""},
+
+		// The longest import path wins.
+		{[]string{"example.com/foo", "example.com/foo/bar"}, "example.com/foo/bar/baz", "example.com/foo/bar"},
+		{[]string{"example.com/foo/bar", "example.com/foo"}, "example.com/foo/baz", "example.com/foo"},
+
+		// A template counts with the import root it matches.
+		{[]string{"example.com/x/{name}", "example.com/x"}, "example.com/x/y/z", "example.com/x/{name}"},
+		{[]string{"example.com/x", "example.com/x/{name}"}, "example.com/x", "example.com/x"},
+		{[]string{"example.com/x/{name}", "example.com/x/{name}/{sub}"}, "example.com/x/y/z", "example.com/x/{name}/{sub}"},
+		{[]string{"example.com/x/{name}", "example.com/x/{name}/{sub}"}, "example.com/x/y", "example.com/x/{name}"},
+
+		// Of equally long import roots, more literal elements win.
+		{[]string{"example.com/x/{name}", "example.com/x/foo"}, "example.com/x/foo/bar", "example.com/x/foo"},
+		{[]string{"example.com/x/foo", "example.com/x/{name}"}, "example.com/x/foo", "example.com/x/foo"},
+		{[]string{"example.com/{org}/{name}", "example.com/x/{name}"}, "example.com/x/y", "example.com/x/{name}"},
+		{[]string{"example.com/x/{name}", "example.com/{org}/{name}"}, "example.com/x/y", "example.com/x/{name}"},
+		{[]string{"example.com/{org}/{name}", "example.com/x/{name}"}, "example.com/z/y", "example.com/{org}/{name}"},
+
+		// A wildcard rule counts with its import path without the wildcards.
+		{[]string{"example.com/*", "example.com/x/y"}, "example.com/x/y/z", "example.com/x/y"},
+		{[]string{"example.com/*", "example.com/x/{name}"}, "example.com/x/y", "example.com/x/{name}"},
+		{[]string{"example.com/*", "example.com/x/{name}"}, "example.com/z/y", "example.com/*"},
+	}
+	for _, tt := range tests {
+		rules := initRules(t, tt.imports...)
+		for _, match := range []func(string) *Rule{
+			func(path string) *Rule { return Match(rules, path) },
+			NewIndex(rules).Match,
+		} {
+			var got string
+			if r := match(tt.path); r != nil {
+				got = r.Import
+			}
+			if got != tt.want {
+				t.Errorf("%q: %s matched %q, want %q", tt.imports, tt.path, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestResolveTemplate(t *testing.T) {
+	r := &Rule{Import: "example.com/x/{name}", Repo: "https://git.example.com/group-{name}/{name}.git"}
+	if err := r.Init(); err != nil {
+		t.Fatal(err)
+	}
+	res := Resolve([]*Rule{r}, "example.com/x/foo/bar", time.Now())
+	if res.ImportRoot != "example.com/x/foo" || res.RepoRoot != "https://git.example.com/group-foo/foo.git" || res.Suffix != "/bar" {
+		t.Errorf("resolved to %s from %s with suffix %q, want example.com/x/foo from https://git.example.com/group-foo/foo.git with suffix /bar", res.ImportRoot, res.RepoRoot, res.Suffix)
+	}
+}
+
+func TestTransforms(t *testing.T) {
+	tests := []struct {
+		imp, repo  string
+		transforms []string
+		path, want string
+	}{
+		{"example.com/x/*", "https://github.com/x/*", []string{"lower"}, "example.com/x/Foo_Bar", "https://github.com/x/foo_bar"},
+		{"example.com/x/*", "https://github.com/x/*", []string{"replace:_:-", "lower"}, "example.com/x/Foo_Bar", "https://github.com/x/foo-bar"},
+		{"example.com/x/*", "https://github.com/x/*", []string{"trim-prefix:go-"}, "example.com/x/go-foo", "https://github.com/x/foo"},
+		{"example.com/x/*", "https://github.com/x/*", []string{"trim-suffix:.go"}, "example.com/x/foo.go", "https://github.com/x/foo"},
+		{"example.com/x/*", "https://github.com/x/*", []string{"trim-prefix:go-"}, "example.com/x/foo", "https://github.com/x/foo"},
+		// Wildcard elements are transformed together, placeholder values one by one.
+		{"example.com/x/*/*", "https://git.example.com/*", []string{"replace:/:-"}, "example.com/x/a/b", "https://git.example.com/a-b"},
+		{"example.com/x/{group}/{name}", "https://git.example.com/{group}/{name}", []string{"trim-prefix:go-", "lower"}, "example.com/x/go-Tools/go-Foo", "https://git.example.com/tools/foo"},
+	}
+	for _, tt := range tests {
+		r := &Rule{Import: tt.imp, Repo: tt.repo, Transforms: tt.transforms}
+		if err := r.Init(); err != nil {
+			t.Errorf("%s %q: %v", tt.imp, tt.transforms, err)
+			continue
+		}
+		if res := Resolve([]*Rule{r}, tt.path, time.Now()); res.RepoRoot != tt.want {
+			t.Errorf("%s %q: %s resolved to %s, want %s", tt.imp, tt.transforms, tt.path, res.RepoRoot, tt.want)
+		}
+	}
+
+	errs := []struct {
+		imp, repo  string
+		transforms []string
+		err        string
+	}{
+		{"example.com/foo", "https://github.com/x/foo", []string{"lower"}, "transforms need wildcards"},
+		{"example.com/x/*", "https://github.com/x/*", []string{"upper"}, "unknown transform"},
+		{"example.com/x/*", "https://github.com/x/*", []string{"lower:x"}, "takes no argument"},
+		{"example.com/x/*", "https://github.com/x/*", []string{"replace:_"}, "want replace:<old>:<new>"},
+		{"example.com/x/*", "https://github.com/x/*", []string{"replace::-"}, "want replace:<old>:<new>"},
+		{"example.com/x/*", "https://github.com/x/*", []string{"trim-prefix:"}, "want trim-prefix:<prefix>"},
+		{"example.com/x/*", "https://github.com/x/*", []string{"trim-suffix"}, "want trim-suffix:<suffix>"},
+	}
+	for _, tt := range errs {
+		r := &Rule{Import: tt.imp, Repo: tt.repo, Transforms: tt.transforms}
+		if err := r.Init(); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s %q: error %v, want %s", tt.imp, tt.transforms, err, tt.err)
+		}
+	}
+}
+
+// TestShards checks that the shard of an import root depends only on the
+// import root and the shards, so that reloading the rules, even with the
+// shards reordered, serves every import root from the same repo, and that
+// adding a shard only moves import roots to it.
+func TestShards(t *testing.T) {
+	resolve := func(shards ...string) map[string]string {
+		r := &Rule{Import: "example.com/x/*", Repo: "https://git.example.com/a/*", Shards: shards}
+		if err := r.Init(); err != nil {
+			t.Fatal(err)
+		}
+		m := make(map[string]string)
+		for i := 0; i < 1000; i++ {
+			res := Resolve([]*Rule{r}, fmt.Sprintf("example.com/x/m%d/sub", i), time.Now())
+			m[res.ImportRoot] = strings.TrimSuffix(res.RepoRoot, fmt.Sprintf("/m%d", i))
+		}
+		return m
+	}
+
+	first := resolve("https://git.example.com/b/*", "https://git.example.com/c/* 2")
+	counts := make(map[string]int)
+	for _, repo := range first {
+		counts[repo]++
+	}
+	// Weights 1, 1 and 2 of 1000 import roots.
+	for repo, want := range map[string]int{"https://git.example.com/a": 250, "https://git.example.com/b": 250, "https://git.example.com/c": 500} {
+		if n := counts[repo]; n < want*8/10 || n > want*12/10 {
+			t.Errorf("%s serves %d import roots, want about %d", repo, n, want)
+		}
+	}
+
+	for name, m := range map[string]map[string]string{
+		"reload":    resolve("https://git.example.com/b/*", "https://git.example.com/c/* 2"),
+		"reordered": resolve("https://git.example.com/c/* 2", "https://git.example.com/b/*"),
+	} {
+		for root, repo := range first {
+			if m[root] != repo {
+				t.Errorf("%s: %s served from %s, was %s", name, root, m[root], repo)
+			}
+		}
+	}
+
+	added := resolve("https://git.example.com/b/*", "https://git.example.com/c/* 2", "https://git.example.com/d/*")
+	moved := 0
+	for root, repo := range first {
+		if added[root] != repo {
+			moved++
+			if added[root] != "https://git.example.com/d" {
+				t.Errorf("adding a shard moved %s from %s to %s", root, repo, added[root])
+			}
+		}
+	}
+	if moved < 200*8/10 || moved > 200*12/10 {
+		t.Errorf("adding a shard of weight 1 to weight 4 moved %d import roots, want about 200", moved)
+	}
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct{ imports []string }{
+		{[]string{"example.com/foo", "example.com/foo/"}},
+		{[]string{"example.com/x/*", "example.com/x/{name}", "example.com/x/{other}"}},
+		{[]string{"example.com/x/*/*", "example.com/x/{a}/{b}"}},
+	}
+	for _, tt := range tests {
+		rules := initRules(t, tt.imports...)
+		for _, r := range rules[1:] {
+			if r.Key() != rules[0].Key() {
+				t.Errorf("%s has key %s, want %s as for %s", r.Import, r.Key(), rules[0].Key(), rules[0].Import)
+			}
+		}
+	}
+	if rules := initRules(t, "example.com/x/*", "example.com/x/*/*", "example.com/x/{name}/y"); rules[0].Key() == rules[1].Key() || rules[0].Key() == rules[2].Key() {
+		t.Errorf("keys %s, %s and %s, want distinct", rules[0].Key(), rules[1].Key(), rules[2].Key())
+	}
+}
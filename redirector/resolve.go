@@ -0,0 +1,188 @@
+package redirector
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Error codes for failed resolutions. They are stable; automation may
+// branch on them.
+const (
+	CodeUnknownRoute       = "unknown_route"       // no rule covers the import path
+	CodeWildcardDepth      = "wildcard_depth"      // fewer path elements than the rule's wildcards
+	CodeInvalidMetadata    = "invalid_metadata"    // the rendered page fails the go command's checks
+	CodeBlocked            = "blocked"             // the import path is blocked by policy
+	CodeExpired            = "expired"             // reserved: the rule is no longer served
+	CodeUpstreamUnverified = "upstream_unverified" // the repo was found not to exist
+	CodePathLimit          = "path_limit"          // the path exceeds the configured depth or element length
+	CodeGone               = "gone"                // the repo existed but has been missing for a while
+	CodeRateLimited        = "rate_limited"        // the client sent too many requests
+	CodeExprFailed         = "expr_failed"         // a rule expression failed for the import path
+	CodeTimeout            = "timeout"             // the request deadline passed before it was resolved
+)
+
+// A Resolution is the outcome of matching a request path against the
+// import path rules.
+type Resolution struct {
+	Rule       *Rule    `json:"-"`
+	Status     int      `json:"status"`
+	Route      string   `json:"route,omitempty"`
+	Captures   []string `json:"captures,omitempty"`
+	ImportRoot string   `json:"import_root,omitempty"`
+	RepoRoot   string   `json:"repo_root,omitempty"`
+	Suffix     string   `json:"suffix,omitempty"`
+	Subdir     string   `json:"subdir,omitempty"` // directory of the module in the repo
+	VCS        string   `json:"vcs,omitempty"`
+	BrowseURL  string   `json:"browse_url,omitempty"` // as computed by a browse URL expression
+	Location   string   `json:"location,omitempty"`
+	InOverlap  bool     `json:"in_overlap,omitempty"`
+	Canonical  string   `json:"canonical,omitempty"` // import path under the canonical root, for aliases
+	MovedTo    string   `json:"moved_to,omitempty"`  // import path under the root the modules moved to
+	Code       string   `json:"code,omitempty"`      // error code if not served
+	ExprError  string   `json:"expr_error,omitempty"`
+}
+
+// Match returns the rule with the longest import path containing path,
+// or nil. A template rule counts with the import root it matches; of
+// rules matching equally long import roots, the one with more literal
+// path elements is chosen.
+func Match(rules []*Rule, path string) *Rule {
+	m := matcher{path: path}
+	for _, r := range rules {
+		m.consider(r)
+	}
+	return m.match
+}
+
+// A matcher finds the rule Match chooses among the rules it considers in
+// turn.
+type matcher struct {
+	path  string
+	parts []string // path elements, split once a template is considered
+	match *Rule
+	n     int // length of the import root match matches
+}
+
+func (m *matcher) consider(r *Rule) {
+	n := len(r.importPath)
+	if r.elems != nil {
+		if m.parts == nil {
+			m.parts = strings.Split(m.path, "/")
+		}
+		k := r.matchTemplate(m.parts)
+		if k == 0 {
+			return
+		}
+		n = k - 1 // slashes
+		for _, p := range m.parts[:k] {
+			n += len(p)
+		}
+	} else if !hasPathPrefix(m.path, r.importPath) {
+		return
+	}
+	if m.match == nil || n > m.n || n == m.n && r.literals > m.match.literals {
+		m.match, m.n = r, n
+	}
+}
+
+// hasPathPrefix reports whether path is root or below it.
+func hasPathPrefix(path, root string) bool {
+	return strings.HasPrefix(path, root) && (len(path) == len(root) || path[len(root)] == '/')
+}
+
+// Resolve matches path (host and URL path, without trailing slash)
+// against the rules at time now.
+func Resolve(rules []*Rule, path string, now time.Time) *Resolution {
+	return resolveRule(context.Background(), Match(rules, path), path, now)
+}
+
+// resolveRule resolves path with rl, the rule matching it, if any, unless
+// ctx is done first.
+func resolveRule(ctx context.Context, rl *Rule, path string, now time.Time) *Resolution {
+	r := &Resolution{Status: http.StatusNotFound}
+	if ctx.Err() != nil {
+		r.Status, r.Code = http.StatusGatewayTimeout, CodeTimeout
+		return r
+	}
+	if rl == nil {
+		r.Code = CodeUnknownRoute
+		return r
+	}
+	repo, inOverlap := rl.ActiveRepo(now)
+	r.Rule = rl
+	r.Route = rl.Import
+	r.VCS = rl.VCS
+	r.InOverlap = inOverlap
+	if rl.elems != nil {
+		parts := strings.Split(path, "/")
+		n := len(rl.elems)
+		if suffix := strings.Join(parts[n:], "/"); suffix != "" {
+			r.Suffix = "/" + suffix
+		}
+		r.ImportRoot = strings.Join(parts[:n], "/")
+		r.RepoRoot, r.Captures = rl.expand(rl.shard(repo, r.ImportRoot[len(rl.importPath)+1:]), parts)
+		r.Subdir = rl.subdirOf("", parts)
+	} else if rl.wildcard > 0 {
+		if path == rl.importPath {
+			r.Status = http.StatusFound
+			r.Location = repo
+			return r
+		}
+		// The import root and suffix are sliced from path, rather
+		// than split and joined again.
+		rest := path[len(rl.importPath)+1:]
+		n := -1 // end of the wildcard elements in rest
+		for k := 0; k < rl.wildcard; k++ {
+			if n == len(rest) {
+				r.Code = CodeWildcardDepth
+				return r
+			}
+			if j := strings.IndexByte(rest[n+1:], '/'); j >= 0 {
+				n += 1 + j
+			} else {
+				n = len(rest)
+			}
+		}
+		elem := rest[:n]
+		r.Suffix = rest[n:]
+		r.Captures = strings.Split(elem, "/")
+		r.ImportRoot = path[:len(path)-len(rest)+n]
+		r.RepoRoot = rl.repoRoot(rl.shard(repo, elem), elem)
+		r.Subdir = rl.subdirOf(elem, nil)
+	} else {
+		r.ImportRoot = rl.importPath
+		r.RepoRoot = repo
+		r.Suffix = path[len(rl.importPath):]
+		r.Subdir = rl.subdirOf("", nil)
+	}
+	if err := rl.evalExprs(ctx, r, path); err != nil {
+		r.Status, r.Code, r.ExprError = http.StatusInternalServerError, CodeExprFailed, err.Error()
+		if ctx.Err() != nil {
+			r.Status, r.Code = http.StatusGatewayTimeout, CodeTimeout
+		}
+		return r
+	}
+	if rl.canonical != "" {
+		r.Canonical = rl.canonical + strings.TrimPrefix(r.ImportRoot+r.Suffix, rl.importPath)
+	}
+	if rl.movedTo != "" {
+		r.MovedTo = rl.movedTo + strings.TrimPrefix(r.ImportRoot+r.Suffix, rl.importPath)
+	}
+	r.Status = http.StatusOK
+	return r
+}
+
+// BrowserURL returns where to redirect browsers for a successful
+// resolution, or "" to serve them the page.
+func (r *Resolution) BrowserURL() string {
+	path := r.ImportRoot + r.Suffix
+	switch r.Rule.BrowserRedirect {
+	case "", "repo":
+		return ""
+	case "pkgsite":
+		return "https://pkg.go.dev/" + path
+	}
+	return strings.ReplaceAll(r.Rule.BrowserRedirect, "{path}", path)
+}
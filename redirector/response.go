@@ -0,0 +1,103 @@
+package redirector
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// errorMessages are the human-readable descriptions of the error codes,
+// which are returned in the X-Go-Import-Error header and in JSON error bodies.
+var errorMessages = map[string]string{
+	CodeUnknownRoute:       "import path not served here",
+	CodeWildcardDepth:      "import path has too few elements for the route's wildcards",
+	CodeInvalidMetadata:    "rendered page fails go command checks",
+	CodeBlocked:            "import path is blocked",
+	CodeExpired:            "route has expired",
+	CodeUpstreamUnverified: "repository does not exist",
+	CodeGone:               "repository was deleted",
+	CodePathLimit:          "import path is too deep or has too long an element",
+	CodeRateLimited:        "too many requests, try again later",
+	CodeExprFailed:         "rule expression failed for the import path",
+	CodeTimeout:            "import path not resolved in time, try again later",
+}
+
+// ServeError answers with status and the error code, as JSON if the
+// request asks for it and as plain text otherwise. Not found and gone
+// responses are marked as not to be indexed.
+func ServeError(w http.ResponseWriter, req *http.Request, status int, code string) {
+	w.Header().Set("X-Go-Import-Error", code)
+	if status == http.StatusNotFound || status == http.StatusGone {
+		w.Header().Set("X-Robots-Tag", "noindex")
+	}
+	msg := errorMessages[code]
+	if !AcceptsJSON(req) {
+		http.Error(w, msg, status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}{code, msg})
+}
+
+// AllowedMethod answers requests other than GET and HEAD with 405 Method
+// Not Allowed and reports whether req may proceed.
+func AllowedMethod(w http.ResponseWriter, req *http.Request) bool {
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return true
+	}
+	w.Header().Set("Allow", "GET, HEAD")
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+// ETag returns the ETag of body.
+func ETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// NotModified reports whether the If-None-Match header of req matches
+// etag, comparing weakly as RFC 9110 requires.
+func NotModified(req *http.Request, etag string) bool {
+	header := req.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteBody writes body as a 200 response with its ETag etag, computed if
+// empty, and Content-Length, without the body for a HEAD request. A
+// request whose If-None-Match matches the ETag is answered with 304 Not
+// Modified instead.
+func WriteBody(w http.ResponseWriter, req *http.Request, body []byte, etag string) {
+	if etag == "" {
+		etag = ETag(body)
+	}
+	h := w.Header()
+	h["Etag"] = []string{etag}
+	if NotModified(req, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	if req.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.Write(body)
+}
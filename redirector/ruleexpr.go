@@ -0,0 +1,112 @@
+package redirector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// exprVars are the variables of rule expressions, besides the
+// placeholders of the import path: the requested import path, its import
+// root and suffix, the elements of the path, the wildcard elements or
+// placeholder values, and the repo root and VCS as resolved so far.
+var exprVars = []string{"path", "root", "suffix", "elems", "captures", "repo", "vcs"}
+
+// initExprs parses the repo, VCS and browse URL expressions of r.
+func (r *Rule) initExprs() error {
+	r.repoExpr, r.vcsExpr, r.browseExpr = nil, nil, nil
+	vars := slices.Clone(exprVars)
+	for _, e := range r.elems {
+		if m := placeholderRE.FindStringSubmatch(e); m != nil {
+			if slices.Contains(exprVars, m[1]) {
+				return fmt.Errorf("placeholder {%s} hides the expression variable of that name", m[1])
+			}
+			vars = append(vars, m[1])
+		}
+	}
+	for _, x := range []struct {
+		name, src string
+		e         *expr
+	}{
+		{"repo_expr", r.RepoExpr, &r.repoExpr},
+		{"vcs_expr", r.VCSExpr, &r.vcsExpr},
+		{"browse_expr", r.BrowseExpr, &r.browseExpr},
+	} {
+		if x.src == "" {
+			continue
+		}
+		e, err := parseExpr(x.src, vars)
+		if err != nil {
+			return fmt.Errorf("%s: %v", x.name, err)
+		}
+		*x.e = e
+	}
+	return nil
+}
+
+// evalExprs applies the expressions of r, in the order repo, VCS and
+// browse URL, to res, the resolution of path by r.
+func (r *Rule) evalExprs(ctx context.Context, res *Resolution, path string) error {
+	if r.repoExpr == nil && r.vcsExpr == nil && r.browseExpr == nil {
+		return nil
+	}
+	vars := map[string]any{
+		"path":     path,
+		"root":     res.ImportRoot,
+		"suffix":   res.Suffix,
+		"elems":    strings.Split(path, "/"),
+		"captures": res.Captures,
+		"repo":     res.RepoRoot,
+		"vcs":      res.VCS,
+	}
+	k := 0
+	for _, e := range r.elems {
+		if m := placeholderRE.FindStringSubmatch(e); m != nil {
+			vars[m[1]] = res.Captures[k]
+			k++
+		}
+	}
+	eval := func(name string, e expr) (string, error) {
+		v, err := evalExpr(ctx, e, vars)
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", name, err)
+		}
+		s, ok := v.(string)
+		if !ok {
+			return "", fmt.Errorf("%s: want string, have %s", name, exprType(v))
+		}
+		return s, nil
+	}
+	if r.repoExpr != nil {
+		s, err := eval("repo_expr", r.repoExpr)
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(s, "://") {
+			return fmt.Errorf("repo_expr: %q is not a full URL", s)
+		}
+		res.RepoRoot, vars["repo"] = s, s
+	}
+	if r.vcsExpr != nil {
+		s, err := eval("vcs_expr", r.vcsExpr)
+		if err != nil {
+			return err
+		}
+		if err := CheckVCS(s); err != nil {
+			return fmt.Errorf("vcs_expr: %v", err)
+		}
+		res.VCS, vars["vcs"] = s, s
+	}
+	if r.browseExpr != nil {
+		s, err := eval("browse_expr", r.browseExpr)
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(s, "://") {
+			return fmt.Errorf("browse_expr: %q is not a full URL", s)
+		}
+		res.BrowseURL = s
+	}
+	return nil
+}
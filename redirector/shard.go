@@ -0,0 +1,91 @@
+package redirector
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// A shard is one of the repos the import roots of a rule are distributed
+// across.
+type shard struct {
+	repo   string // without the wildcards
+	weight float64
+}
+
+// initShards parses the shards of r. Each is a repo of the same form as
+// r.Repo, optionally followed by a space and a positive weight; r.Repo is
+// a shard of weight 1.
+func (r *Rule) initShards() error {
+	r.shards = nil
+	if len(r.Shards) == 0 {
+		return nil
+	}
+	if r.wildcard == 0 && r.elems == nil || r.VCS == "mod" {
+		return errors.New("shards need wildcards or placeholders substituted in the repo")
+	}
+	r.shards = []shard{{r.repoPath, 1}}
+	for _, s := range r.Shards {
+		repo, w, _ := strings.Cut(strings.TrimSpace(s), " ")
+		weight := 1.0
+		if w = strings.TrimSpace(w); w != "" {
+			var err error
+			weight, err = strconv.ParseFloat(w, 64)
+			if err != nil || !(weight > 0) || math.IsInf(weight, 1) {
+				return fmt.Errorf("invalid shard %q: want a repo and optionally a positive weight", s)
+			}
+		}
+		if !strings.Contains(repo, "://") {
+			return fmt.Errorf("shard %s must be full URL", repo)
+		}
+		if r.elems != nil {
+			if strings.HasSuffix(repo, "/*") {
+				return errors.New("placeholders and /* cannot be used together")
+			}
+			if err := r.checkPlaceholders(repo); err != nil {
+				return err
+			}
+		} else {
+			for i := 0; i < r.wildcard; i++ {
+				if !strings.HasSuffix(repo, "/*") {
+					return fmt.Errorf("shard %s must have as many /* as import", repo)
+				}
+				repo = strings.TrimSuffix(repo, "/*")
+			}
+			if strings.HasSuffix(repo, "/*") {
+				return fmt.Errorf("shard %s must have as many /* as import", repo)
+			}
+		}
+		for _, o := range r.shards {
+			if o.repo == repo {
+				return fmt.Errorf("shard %s listed twice", repo)
+			}
+		}
+		r.shards = append(r.shards, shard{repo, weight})
+	}
+	return nil
+}
+
+// shard returns the repo (without wildcards) serving the import root
+// with the path key below the import path root of r: repo itself if r
+// has no shards or repo is the old repo, or else the shard chosen by
+// weighted rendezvous hashing of key. Adding a shard thus only moves
+// import roots to the new shard, in proportion to its weight.
+func (r *Rule) shard(repo, key string) string {
+	if len(r.shards) == 0 || repo != r.repoPath {
+		return repo
+	}
+	best, bestScore := repo, math.Inf(-1)
+	for _, s := range r.shards {
+		h := sha256.Sum256([]byte(s.repo + "\x00" + key))
+		u := (float64(binary.BigEndian.Uint64(h[:])>>11) + 0.5) / (1 << 53) // in (0, 1)
+		if score := -s.weight / math.Log(u); score > bestScore {
+			best, bestScore = s.repo, score
+		}
+	}
+	return best
+}
@@ -0,0 +1,136 @@
+package redirector
+
+import (
+	"net/url"
+	"strings"
+)
+
+// GoSource holds the URL templates of a go-source meta tag.
+type GoSource struct {
+	Home string `json:"home"`
+	Dir  string `json:"dir"`
+	File string `json:"file"`
+}
+
+// forgeSource are the directory and file URL templates of the well-known
+// forges, by host.
+var forgeSource = map[string][2]string{
+	"github.com":    {"{repo}/tree/{branch}{/dir}", "{repo}/blob/{branch}{/dir}/{file}#L{line}"},
+	"gitlab.com":    {"{repo}/-/tree/{branch}{/dir}", "{repo}/-/blob/{branch}{/dir}/{file}#L{line}"},
+	"bitbucket.org": {"{repo}/src/{branch}{/dir}", "{repo}/src/{branch}{/dir}/{file}#lines-{line}"},
+}
+
+// forgeDir returns the directory URL template of the forge hosting repo,
+// or "".
+func forgeDir(repo string) string {
+	return forgeSource[httpsHost(repo)][0]
+}
+
+// branch returns the branch named in source and directory URLs.
+func (r *Rule) branch() string {
+	if r.Branch == "" {
+		return "HEAD"
+	}
+	return r.Branch
+}
+
+// HasSource reports whether pages for r carry a go-source meta tag: r has
+// source templates, or its repo is on a known forge.
+func (r *Rule) HasSource() bool {
+	if r.SourceDir != "" {
+		return true
+	}
+	u, err := url.Parse(r.repoPath)
+	if err != nil || u.Scheme != "https" {
+		return false
+	}
+	_, ok := forgeSource[u.Host]
+	return ok
+}
+
+// source returns the go-source templates for the repo root served by r,
+// with the module in the directory subdir of the repo, or nil if the repo
+// is not on a known forge and r has no templates. In r.SourceDir and
+// r.SourceFile, {repo} stands for the repo URL and {branch} for r.Branch.
+func (r *Rule) source(repoRoot, subdir string) *GoSource {
+	repo := strings.TrimSuffix(repoRoot, ".git")
+	dir, file := r.SourceDir, r.SourceFile
+	if dir == "" {
+		t, ok := forgeSource[httpsHost(repo)]
+		if !ok {
+			return nil
+		}
+		dir, file = t[0], t[1]
+	}
+	subdirDir := "{/dir}"
+	if subdir != "" {
+		subdirDir = "/" + subdir + "{/dir}"
+	}
+	return &GoSource{
+		Home: repo,
+		Dir:  expandTemplate(dir, "{repo}", repo, "{branch}", r.branch(), "{/dir}", subdirDir),
+		File: expandTemplate(file, "{repo}", repo, "{branch}", r.branch(), "{/dir}", subdirDir),
+	}
+}
+
+// httpsHost returns the host of an https URL, or "".
+func httpsHost(u string) string {
+	rest, ok := strings.CutPrefix(u, "https://")
+	if !ok {
+		return ""
+	}
+	host, _, _ := strings.Cut(rest, "/")
+	return host
+}
+
+// expandTemplate replaces the fields of t named in vars, which alternate
+// fields such as {repo} and their values, in a single pass; other fields
+// are left as they are. It is strings.NewReplacer(vars...).Replace(t)
+// without building a replacer on every request.
+func expandTemplate(t string, vars ...string) string {
+	if strings.IndexByte(t, '{') < 0 {
+		return t
+	}
+	var b strings.Builder
+	b.Grow(len(t) + 64)
+	for {
+		i := strings.IndexByte(t, '{')
+		if i < 0 {
+			break
+		}
+		b.WriteString(t[:i])
+		t = t[i:]
+		k := 0
+		for k < len(vars) && !strings.HasPrefix(t, vars[k]) {
+			k += 2
+		}
+		if k < len(vars) {
+			b.WriteString(vars[k+1])
+			t = t[len(vars[k]):]
+		} else {
+			b.WriteByte('{')
+			t = t[1:]
+		}
+	}
+	b.WriteString(t)
+	return b.String()
+}
+
+// browseURL returns the web page of the directory suffix (with a leading
+// slash, or empty for the root) of the repo root served by r: r.DirURL,
+// or the directory URL template of the repo's forge, expanded; or the
+// repo root if there is neither.
+func (r *Rule) browseURL(repoRoot, suffix string) string {
+	if suffix == "" {
+		return repoRoot
+	}
+	repo := strings.TrimSuffix(repoRoot, ".git")
+	t := r.DirURL
+	if t == "" {
+		t = forgeDir(repo)
+	}
+	if t == "" {
+		return repoRoot
+	}
+	return expandTemplate(t, "{repo}", repo, "{branch}", r.branch(), "{/dir}", suffix, "{dir}", suffix[1:])
+}
@@ -0,0 +1,61 @@
+package redirector
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// initSubdir validates the subdirectory of r. For a rule with wildcards,
+// the subdirectory rather than the repo ends in as many /* as the import
+// path; a template may use its placeholders in it.
+func (r *Rule) initSubdir() error {
+	r.subdir = ""
+	if r.Subdir == "" {
+		return nil
+	}
+	switch {
+	case r.VCS == "mod":
+		return errors.New("subdir cannot be used with vcs mod")
+	case len(r.Shards) > 0:
+		return errors.New("subdir and shards cannot be used together")
+	case strings.HasSuffix(r.Repo, "/*"):
+		return errors.New("with subdir, the wildcards go in subdir instead of repo")
+	}
+	sub := r.Subdir
+	for i := 0; i < r.wildcard; i++ {
+		if sub != "*" && !strings.HasSuffix(sub, "/*") {
+			return errors.New("subdir must have as many /* as import")
+		}
+		sub = strings.TrimSuffix(strings.TrimSuffix(sub, "*"), "/")
+	}
+	if strings.HasSuffix(sub, "*") {
+		return errors.New("subdir must have as many /* as import")
+	}
+	if r.elems != nil {
+		if err := r.checkPlaceholders(sub); err != nil {
+			return err
+		}
+	}
+	if sub != "" && (path.IsAbs(sub) || path.Clean(sub) != sub || sub == "." || sub == ".." || strings.HasPrefix(sub, "../")) {
+		return fmt.Errorf("invalid subdir %q: want a relative path such as tools/foo", r.Subdir)
+	}
+	r.subdir = sub
+	return nil
+}
+
+// subdirOf returns the subdirectory of the repo holding the import root
+// with the wildcard elements elem, or the placeholders matching parts.
+func (r *Rule) subdirOf(elem string, parts []string) string {
+	switch {
+	case r.Subdir == "":
+		return ""
+	case r.elems != nil:
+		sub, _ := r.expand(r.subdir, parts)
+		return sub
+	case r.wildcard > 0:
+		return path.Join(r.subdir, r.transform(elem))
+	}
+	return r.subdir
+}
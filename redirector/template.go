@@ -0,0 +1,164 @@
+package redirector
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// An import path template has {name} placeholders as path elements, each
+// matching one element of the requested path; the repo (and old repo)
+// templates may use the captured values anywhere, as in
+//
+//	import: example.com/x/{name}
+//	repo:   https://git.example.com/group-{name}/{name}.git
+
+var (
+	placeholderRE     = regexp.MustCompile(`^\{([A-Za-z_][A-Za-z0-9_]*)\}$`)
+	placeholderNameRE = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+)
+
+// isTemplate reports whether the import path of r has placeholders.
+func isTemplate(importPath string) bool {
+	return strings.Contains(importPath, "{")
+}
+
+// initTemplate parses the import path template of r. The import path
+// root (for Root and Host) is the literal part before the first
+// placeholder.
+func (r *Rule) initTemplate() error {
+	if strings.HasSuffix(r.Import, "/*") || strings.HasSuffix(r.Repo, "/*") {
+		return errors.New("placeholders and /* cannot be used together")
+	}
+	r.elems = strings.Split(strings.TrimSuffix(r.Import, "/"), "/")
+	names := make(map[string]bool)
+	first := -1
+	for i, e := range r.elems {
+		m := placeholderRE.FindStringSubmatch(e)
+		if m == nil {
+			if e == "" || strings.ContainsAny(e, "{}*") {
+				return fmt.Errorf("invalid import path element %q: placeholders must be whole elements such as {name}", e)
+			}
+			r.literals++
+			continue
+		}
+		if i == 0 {
+			return errors.New("the import path host cannot be a placeholder")
+		}
+		if names[m[1]] {
+			return fmt.Errorf("placeholder {%s} used twice in import path", m[1])
+		}
+		names[m[1]] = true
+		if first < 0 {
+			first = i
+		}
+	}
+	for _, t := range []string{r.Repo, r.OldRepo} {
+		if err := r.checkPlaceholders(t); err != nil {
+			return err
+		}
+	}
+	r.importPath = strings.Join(r.elems[:first], "/")
+	r.repoPath = r.Repo
+	r.wildcard = 0
+	return nil
+}
+
+// checkPlaceholders returns an error if the repo template t uses a
+// placeholder that is not in the import path of r.
+func (r *Rule) checkPlaceholders(t string) error {
+	for _, m := range placeholderNameRE.FindAllStringSubmatch(t, -1) {
+		if !slices.Contains(r.elems, "{"+m[1]+"}") {
+			return fmt.Errorf("placeholder {%s} in %s is not in the import path", m[1], t)
+		}
+	}
+	return nil
+}
+
+// matchTemplate matches path against the import path template of r. It
+// returns the number of path elements making up the import root, or 0.
+func (r *Rule) matchTemplate(parts []string) int {
+	if len(parts) < len(r.elems) {
+		return 0
+	}
+	for i, e := range r.elems {
+		if placeholderRE.MatchString(e) {
+			if parts[i] == "" {
+				return 0
+			}
+		} else if parts[i] != e {
+			return 0
+		}
+	}
+	return len(r.elems)
+}
+
+// expand substitutes the placeholders in the repo template repo with the
+// elements of the import root parts they matched.
+func (r *Rule) expand(repo string, parts []string) (string, []string) {
+	values := make(map[string]string)
+	var captures []string
+	for i, e := range r.elems {
+		if m := placeholderRE.FindStringSubmatch(e); m != nil {
+			values[m[1]] = r.transform(parts[i])
+			captures = append(captures, parts[i])
+		}
+	}
+	return placeholderNameRE.ReplaceAllStringFunc(repo, func(s string) string {
+		return values[s[1:len(s)-1]]
+	}), captures
+}
+
+// Overlaps reports whether r and o compete for the same requests with
+// neither taking precedence: they have the same import path root and no
+// placeholders, or their templates match the same paths with the same
+// number of literal elements, wildcards counting as placeholders.
+func (r *Rule) Overlaps(o *Rule) bool {
+	if r.elems == nil && o.elems == nil {
+		return r.importPath == o.importPath
+	}
+	re, oe := r.pattern(), o.pattern()
+	if re == nil || oe == nil || len(re) != len(oe) || r.literals != o.literals {
+		return false
+	}
+	for i := range re {
+		a, b := re[i], oe[i]
+		if a != b && a != "*" && b != "*" && !placeholderRE.MatchString(a) && !placeholderRE.MatchString(b) {
+			return false
+		}
+	}
+	return true
+}
+
+// pattern returns the import path elements of a rule with placeholders or
+// wildcards, the wildcards as *, or nil for a plain import path.
+func (r *Rule) pattern() []string {
+	if r.elems != nil || r.wildcard == 0 {
+		return r.elems
+	}
+	p := strings.Split(r.importPath, "/")
+	for i := 0; i < r.wildcard; i++ {
+		p = append(p, "*")
+	}
+	return p
+}
+
+// Key returns the import path of r with its wildcards and placeholders
+// as *, which is the same for the rules matching the same paths however
+// they are spelled.
+func (r *Rule) Key() string {
+	p := r.pattern()
+	if p == nil {
+		return r.importPath
+	}
+	key := make([]string, len(p))
+	for i, e := range p {
+		if placeholderRE.MatchString(e) {
+			e = "*"
+		}
+		key[i] = e
+	}
+	return strings.Join(key, "/")
+}
@@ -0,0 +1,59 @@
+package redirector
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// initTransforms parses the transforms of r. The wildcard elements of a
+// rule are transformed together, joined with slashes; placeholder values
+// are transformed one by one.
+func (r *Rule) initTransforms() error {
+	r.transforms = nil
+	if len(r.Transforms) == 0 {
+		return nil
+	}
+	if r.wildcard == 0 && r.elems == nil || r.VCS == "mod" {
+		return errors.New("transforms need wildcards or placeholders substituted in the repo")
+	}
+	for _, t := range r.Transforms {
+		name, arg, _ := strings.Cut(t, ":")
+		var f func(string) string
+		switch name {
+		case "lower":
+			if arg != "" {
+				return fmt.Errorf("transform %q takes no argument", t)
+			}
+			f = strings.ToLower
+		case "replace":
+			old, new, ok := strings.Cut(arg, ":")
+			if !ok || old == "" {
+				return fmt.Errorf("invalid transform %q: want replace:<old>:<new>", t)
+			}
+			f = func(s string) string { return strings.ReplaceAll(s, old, new) }
+		case "trim-prefix":
+			if arg == "" {
+				return fmt.Errorf("invalid transform %q: want trim-prefix:<prefix>", t)
+			}
+			f = func(s string) string { return strings.TrimPrefix(s, arg) }
+		case "trim-suffix":
+			if arg == "" {
+				return fmt.Errorf("invalid transform %q: want trim-suffix:<suffix>", t)
+			}
+			f = func(s string) string { return strings.TrimSuffix(s, arg) }
+		default:
+			return fmt.Errorf("unknown transform %q: want lower, replace, trim-prefix or trim-suffix", t)
+		}
+		r.transforms = append(r.transforms, f)
+	}
+	return nil
+}
+
+// transform applies the transforms of r to the value v.
+func (r *Rule) transform(v string) string {
+	for _, f := range r.transforms {
+		v = f(v)
+	}
+	return v
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/kastelo/go-import-redirector/redirector"
+)
+
+var shutdownTimeout = flag.Duration("shutdown-timeout", 30*time.Second, "on SIGTERM or SIGINT, wait up to `duration` for in-flight requests to finish")
+
+// handleSignals reloads on SIGHUP, and on SIGTERM or SIGINT shuts srv and
+// the -grpc-addr server down gracefully and then closes done. A second
+// SIGTERM or SIGINT during the shutdown terminates the process at once.
+func handleSignals(srv *http.Server, done chan<- struct{}) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP, syscall.SIGTERM, os.Interrupt)
+	for sig := range c {
+		if sig == syscall.SIGHUP {
+			reload()
+			continue
+		}
+		signal.Stop(c)
+		shuttingDown.Store(true)
+		log.Printf("%v: draining connections for up to %v", sig, *shutdownTimeout)
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+		stopGRPC(ctx)
+		cancel()
+		close(done)
+		return
+	}
+}
+
+// reload reopens the access log and reloads the -config file. If the new
+// configuration is invalid, the current rules stay in effect.
+func reload() {
+	if *logFile != "" && *logFile != "-" {
+		if err := reopenAccessLog(); err != nil {
+			log.Printf("reopening access log: %v", err)
+		}
+	}
+	if configName() != "" {
+		reloadConfig()
+	}
+}
+
+// reloadConfig reloads the rules and logs the outcome.
+func reloadConfig() {
+	if err := reloadRules(); err != nil {
+		log.Printf("reloading %s, keeping the current rules: %v", configName(), err)
+		return
+	}
+	log.Printf("reloaded %s: %d rules", configName(), len(currentRules()))
+}
+
+// reloadMu serializes reloads.
+var reloadMu sync.Mutex
+
+// reloadRules reads the -config file and swaps in its rules, subject to
+// the same checks as at startup.
+func reloadRules() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	err := swapRules()
+	noteConfigLoad(err)
+	lastReload.Lock()
+	lastReload.time, lastReload.err = time.Now(), err
+	lastReload.Unlock()
+	return err
+}
+
+func swapRules() error {
+	c, err := readConfig()
+	if err != nil {
+		return err
+	}
+	rs := c.Rules
+	l, tmplErr := readLocales()
+	if tmplErr != nil {
+		if !degrades("template") {
+			unmapFile(c.mapping)
+			return tmplErr
+		}
+		l = minimalLocales()
+	} else {
+		tmplErr = c.templateErr
+	}
+	if err := checkStrict(rs); err != nil {
+		unmapFile(c.mapping)
+		return err
+	}
+	if err := checkImmutable(rs); err != nil {
+		unmapFile(c.mapping)
+		return err
+	}
+	for _, r := range rs {
+		handleRule(r)
+	}
+	prev := currentRules()
+	rules.Store(redirector.NewIndex(rs))
+	purgeChanged(prev, rs)
+	if err := recordImmutable(rs); err != nil {
+		log.Printf("recording %s: %v", *immutableState, err)
+	}
+	storeShortcuts(c.Redirects)
+	storeSecurityHeaders(c.SecurityHeaders)
+	locales.Store(l)
+	setDegraded("template", tmplErr)
+	clearPageCache()
+	retireMapping(servedMapping)
+	servedMapping = c.mapping
+	if *transparencyLog != "" {
+		for _, r := range rs {
+			if err := logRule(r); err != nil {
+				log.Printf("transparency log: %v", err)
+			}
+		}
+	}
+	if len(tlsCerts) > 0 {
+		for _, h := range importHosts() {
+			certMu.RLock()
+			_, ok := hostCerts[h]
+			certMu.RUnlock()
+			if !ok {
+				assignCert(h)
+			}
+		}
+	}
+	return nil
+}
+
+// serveReload reloads the -config file on POST and reports the number of
+// rules now served.
+func serveReload(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	err := errors.New("no -config file to reload")
+	if configName() != "" {
+		err = reloadRules()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	log.Printf("reloaded %s: %d rules", configName(), len(currentRules()))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Rules int `json:"rules"`
+	}{len(currentRules())})
+}
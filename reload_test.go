@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestReloadKeepsRules checks that reloading an invalid configuration, as
+// on SIGHUP, keeps the current rules in effect and reports the error.
+func TestReloadKeepsRules(t *testing.T) {
+	name := serveConfig(t, testConfig)
+	served := len(currentRules())
+
+	bad := map[string]string{
+		"syntax":  "rules: [\n",
+		"invalid": "rules:\n  - import: example.com/bar\n",
+		"overlap": testConfig + "  - import: example.com/foo\n    repo: https://github.com/example/other\n",
+	}
+	for desc, conf := range bad {
+		if err := os.WriteFile(name, []byte(conf), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		reload()
+		if n := len(currentRules()); n != served {
+			t.Errorf("%s: %d rules after the reload, want %d", desc, n, served)
+		}
+		w := get("http://example.com/foo?go-get=1")
+		if w.Code != 200 || !strings.Contains(w.Body.String(), "https://github.com/example/foo") {
+			t.Errorf("%s: example.com/foo after the reload: %d %s", desc, w.Code, w.Body)
+		}
+		if h := currentHealth(); h.ReloadError == "" {
+			t.Errorf("%s: no reload error in the health", desc)
+		}
+	}
+
+	good := testConfig + "  - import: example.com/bar\n    repo: https://github.com/example/bar\n"
+	if err := os.WriteFile(name, []byte(good), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	reload()
+	if n := len(currentRules()); n != served+1 {
+		t.Errorf("%d rules after a good reload, want %d", n, served+1)
+	}
+	if h := currentHealth(); h.ReloadError != "" {
+		t.Errorf("reload error %q after a good reload", h.ReloadError)
+	}
+	if w := get("http://example.com/bar?go-get=1"); w.Code != 200 {
+		t.Errorf("example.com/bar after a good reload: %d", w.Code)
+	}
+}
+
+// TestServiceOnReloadedHost checks that the service endpoints are served
+// on an import host first added on reload, rather than redirected.
+func TestServiceOnReloadedHost(t *testing.T) {
+	name := serveConfig(t, testConfig)
+	path := fmt.Sprintf("/-/test-service-%d", time.Now().UnixNano())
+	handleService(path, func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, "service")
+	})
+
+	host := fmt.Sprintf("reloaded-%d.example", time.Now().UnixNano())
+	// Rooted at the host, the rule's handler takes every path on it.
+	conf := testConfig + "  - import: " + host + "/*\n    repo: https://github.com/example/*\n"
+	if err := os.WriteFile(name, []byte(conf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadRules(); err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range []string{"example.com", host} {
+		w := httptest.NewRecorder()
+		http.DefaultServeMux.ServeHTTP(w, httptest.NewRequest("GET", "http://"+h+path, nil))
+		if w.Code != 200 || w.Body.String() != "service" {
+			t.Errorf("%s%s: %d %q, want the service", h, path, w.Code, w.Body)
+		}
+	}
+}
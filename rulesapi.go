@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/kastelo/go-import-redirector/redirector"
+	"gopkg.in/yaml.v3"
+)
+
+var adminAddr = flag.String("admin-addr", "", "serve the rule management API on `address`, with the admin token, changing the -config file")
+
+// configMu serializes changes to the -config file through the API.
+var configMu sync.Mutex
+
+// Errors of rule changes, answered with their own status.
+var (
+	errNoRule     = errors.New("no rule for this import path")
+	errRuleExists = errors.New("a rule for this import path exists")
+)
+
+// serveAdmin serves the rule management API on -admin-addr.
+func serveAdmin() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/-/rules", adminOnly(serveRules))
+	mux.HandleFunc("/-/rules/", adminOnly(serveRules))
+	log.Fatal(http.ListenAndServe(*adminAddr, mux))
+}
+
+// serveRules lists the configured rules on GET /-/rules, those with all
+// of the ?tag= tags if given, and adds one on POST /-/rules; GET, PUT and
+// DELETE /-/rules/<import> show, replace and remove the rule with that
+// import path. Rules are JSON objects with the keys of the -config file.
+func serveRules(w http.ResponseWriter, req *http.Request) {
+	imp := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/-/rules"), "/")
+	var (
+		result any
+		status = http.StatusOK
+		err    error
+	)
+	switch {
+	case req.Method == http.MethodGet && imp == "":
+		var rs []*redirector.Rule
+		rs, err = configuredRules()
+		result = taggedRules(rs, queryTags(req))
+	case req.Method == http.MethodGet:
+		result, err = configuredRule(imp)
+	case req.Method == http.MethodPost && imp == "":
+		var r *redirector.Rule
+		if r, err = decodeRule(req, ""); err == nil {
+			result, status = r, http.StatusCreated
+			err = addRule(r)
+		}
+	case req.Method == http.MethodPut && imp != "":
+		var r *redirector.Rule
+		if r, err = decodeRule(req, imp); err == nil {
+			result = r
+			err = replaceRule(imp, r)
+		}
+	case req.Method == http.MethodDelete && imp != "":
+		status = http.StatusNoContent
+		err = deleteRule(imp)
+	default:
+		if imp == "" {
+			w.Header().Set("Allow", "GET, POST")
+		} else {
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+		}
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch {
+	case errors.Is(err, errNoRule):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errRuleExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case status == http.StatusNoContent:
+		w.WriteHeader(status)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// decodeRule decodes the rule in the body of req. For imp other than "",
+// the rule's import path must be imp or empty.
+func decodeRule(req *http.Request, imp string) (*redirector.Rule, error) {
+	return parseRule(http.MaxBytesReader(nil, req.Body, 1<<20), imp)
+}
+
+// parseRule decodes the rule in JSON from body, as decodeRule does.
+func parseRule(body io.Reader, imp string) (*redirector.Rule, error) {
+	d := json.NewDecoder(body)
+	d.DisallowUnknownFields()
+	r := new(redirector.Rule)
+	if err := d.Decode(r); err != nil {
+		return nil, err
+	}
+	if imp != "" {
+		if r.Import != "" && r.Import != imp {
+			return nil, fmt.Errorf("rule import path %s does not match %s", r.Import, imp)
+		}
+		r.Import = imp
+	}
+	return r, nil
+}
+
+// addRule adds r to the rules of the -config file.
+func addRule(r *redirector.Rule) error {
+	return changeRules(func(rs []*redirector.Rule) ([]*redirector.Rule, error) {
+		if findRule(rs, r.Import) >= 0 {
+			return nil, errRuleExists
+		}
+		return append(rs, r), nil
+	})
+}
+
+// replaceRule replaces the rule of the -config file for the import path
+// imp with r.
+func replaceRule(imp string, r *redirector.Rule) error {
+	return changeRules(func(rs []*redirector.Rule) ([]*redirector.Rule, error) {
+		i := findRule(rs, imp)
+		if i < 0 {
+			return nil, errNoRule
+		}
+		rs[i] = r
+		return rs, nil
+	})
+}
+
+// deleteRule removes the rule of the -config file for the import path imp.
+func deleteRule(imp string) error {
+	return changeRules(func(rs []*redirector.Rule) ([]*redirector.Rule, error) {
+		i := findRule(rs, imp)
+		if i < 0 {
+			return nil, errNoRule
+		}
+		return append(rs[:i], rs[i+1:]...), nil
+	})
+}
+
+func findRule(rs []*redirector.Rule, imp string) int {
+	for i, r := range rs {
+		if r.Import == imp {
+			return i
+		}
+	}
+	return -1
+}
+
+// configuredRules returns the rules of the -config file, as written.
+func configuredRules() ([]*redirector.Rule, error) {
+	buf, err := os.ReadFile(*configFile)
+	if err != nil {
+		return nil, err
+	}
+	c, err := decodeConfig(buf)
+	if err != nil {
+		return nil, err
+	}
+	return c.Rules, nil
+}
+
+func configuredRule(imp string) (*redirector.Rule, error) {
+	rs, err := configuredRules()
+	if err != nil {
+		return nil, err
+	}
+	i := findRule(rs, imp)
+	if i < 0 {
+		return nil, errNoRule
+	}
+	return rs[i], nil
+}
+
+// changeRules applies change to the rules of the -config file, writes the
+// file back if the result is a valid configuration, and reloads it. If the
+// reload fails, the previous file is restored.
+func changeRules(change func([]*redirector.Rule) ([]*redirector.Rule, error)) error {
+	configMu.Lock()
+	defer configMu.Unlock()
+	old, err := os.ReadFile(*configFile)
+	if err != nil {
+		return err
+	}
+	c, err := decodeConfig(old)
+	if err != nil {
+		return err
+	}
+	orig := append([]*redirector.Rule(nil), c.Rules...)
+	rs, err := change(c.Rules)
+	if err != nil {
+		return err
+	}
+	var buf []byte
+	if isTOML() {
+		buf, err = encodeTOML(c, rs)
+	} else {
+		buf, err = encodeYAML(old, orig, rs)
+	}
+	if err != nil {
+		return err
+	}
+	check, err := expandConfig(buf)
+	if err != nil {
+		return err
+	}
+	if err := check.init(); err != nil {
+		return err
+	}
+	if err := checkStrict(check.Rules); err != nil {
+		return err
+	}
+	if err := checkImmutable(check.Rules); err != nil {
+		return err
+	}
+	if err := writeConfig(buf); err != nil {
+		return err
+	}
+	if err := reloadRules(); err != nil {
+		if err := writeConfig(old); err != nil {
+			log.Printf("restoring %s: %v", *configFile, err)
+		}
+		return err
+	}
+	log.Printf("rules changed through the API, reloaded %s: %d rules", *configFile, len(currentRules()))
+	return nil
+}
+
+// encodeTOML encodes c with the rules rs. Comments in the file are lost.
+func encodeTOML(c *config, rs []*redirector.Rule) ([]byte, error) {
+	c.Rules = rs
+	var b bytes.Buffer
+	if err := toml.NewEncoder(&b).Encode(c); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// encodeYAML replaces the rules orig in the YAML document old by rs,
+// keeping the nodes, and so the comments, of the rules that stay.
+func encodeYAML(old []byte, orig, rs []*redirector.Rule) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(old, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("configuration is not a YAML mapping")
+	}
+	m := doc.Content[0]
+	var seq *yaml.Node
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == "rules" {
+			seq = m.Content[i+1]
+		}
+	}
+	if seq == nil || len(seq.Content) != len(orig) {
+		return nil, errors.New("cannot locate the rules in the YAML document")
+	}
+	nodes := make(map[*redirector.Rule]*yaml.Node)
+	for i, r := range orig {
+		nodes[r] = seq.Content[i]
+	}
+	var content []*yaml.Node
+	for _, r := range rs {
+		n, ok := nodes[r]
+		if !ok {
+			n = new(yaml.Node)
+			if err := n.Encode(r); err != nil {
+				return nil, err
+			}
+		}
+		content = append(content, n)
+	}
+	seq.Content = content
+	seq.Style = 0 // block style, also after removing the last rule
+	var b bytes.Buffer
+	e := yaml.NewEncoder(&b)
+	e.SetIndent(2)
+	if err := e.Encode(&doc); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// writeConfig replaces the -config file with buf, atomically.
+func writeConfig(buf []byte) error {
+	return writeFileAtomic(*configFile, buf)
+}
+
+// writeFileAtomic replaces the file name with buf, keeping its mode, by
+// renaming a temporary file over it.
+func writeFileAtomic(name string, buf []byte) error {
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(name); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kastelo/go-import-redirector/redirector"
+)
+
+// TestChangeRulesRestore checks that a change whose reload fails leaves
+// the -config file and the served rules as they were.
+func TestChangeRulesRestore(t *testing.T) {
+	name := serveConfig(t, testConfig)
+	before, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := len(currentRules())
+
+	// The template is only read on reload, so the change itself checks out.
+	old := *templateFile
+	*templateFile = filepath.Join(t.TempDir(), "missing.html")
+	t.Cleanup(func() { *templateFile = old })
+
+	err = changeRules(func(rs []*redirector.Rule) ([]*redirector.Rule, error) {
+		return append(rs, &redirector.Rule{Import: "example.com/new", Repo: "https://github.com/example/new"}), nil
+	})
+	if err == nil {
+		t.Fatal("change with a failing reload succeeded")
+	}
+	after, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after, before) {
+		t.Errorf("config after a failed reload:\n%s\nwant:\n%s", after, before)
+	}
+	if n := len(currentRules()); n != served {
+		t.Errorf("%d rules served after a failed reload, want %d", n, served)
+	}
+	if r := rules.Load().Match("example.com/new"); r != nil {
+		t.Errorf("example.com/new served by %s after a failed reload", r.Import)
+	}
+}
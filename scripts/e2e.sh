@@ -0,0 +1,74 @@
+#!/bin/bash
+#
+# End-to-end check that the go command can resolve and download a module
+# through go-import-redirector. Everything runs locally: a throwaway git
+# repository is served by git daemon, the redirector maps a vanity import
+# path under example.test to it, and the go command reaches the redirector
+# through HTTP_PROXY, so no DNS or hosts file changes are needed. The
+# -discover namespaces are checked against a fake forge (scripts/fakeforge).
+#
+# Usage: scripts/e2e.sh [path to go-import-redirector binary]
+
+set -euo pipefail
+
+bin=${1:-}
+work=$(mktemp -d)
+pids=()
+cleanup() {
+	for pid in "${pids[@]}"; do
+		kill "$pid" 2>/dev/null || true
+	done
+	chmod -R u+w "$work" 2>/dev/null || true
+	rm -rf "$work"
+}
+trap cleanup EXIT
+
+if [[ -z $bin ]]; then
+	bin=$work/go-import-redirector
+	go build -o "$bin" "$(dirname "$0")/.."
+fi
+go build -o "$work/fakeforge" "$(dirname "$0")/fakeforge"
+
+# A module repository, tagged v1.0.0.
+repo=$work/repos/hello
+mkdir -p "$repo"
+(
+	cd "$repo"
+	git init -q
+	printf 'module example.test/hello\n\ngo 1.21\n' > go.mod
+	printf 'package hello\n\nconst Greeting = "hello"\n' > hello.go
+	git add .
+	git -c user.name=e2e -c user.email=e2e@example.test commit -qm init
+	git tag v1.0.0
+)
+
+git daemon --reuseaddr --export-all --base-path="$work/repos" --listen=127.0.0.1 --port=19418 "$work/repos" &
+pids+=($!)
+"$bin" -addr 127.0.0.1:18080 'example.test/*' 'git://127.0.0.1:19418/*' &
+pids+=($!)
+sleep 1
+
+export GOPATH=$work/gopath GOMODCACHE=$work/gopath/pkg/mod GOCACHE=$work/gocache
+export GOPROXY=direct GONOSUMDB=example.test GOINSECURE=example.test GOFLAGS=-mod=mod
+export HTTP_PROXY=http://127.0.0.1:18080 NO_PROXY=127.0.0.1
+
+mkdir "$work/consumer"
+cd "$work/consumer"
+go mod init example.test/consumer >/dev/null 2>&1
+go mod download -json example.test/hello@v1.0.0
+
+# A -discover namespace serves the repos the forge lists, and only those.
+"$work/fakeforge" -addr 127.0.0.1:18081 -codeowners @acme/core github.com/acme/hello &
+pids+=($!)
+sleep 1
+"$bin" -addr 127.0.0.1:18082 -discover github.com/acme -discover-codeowners -forge-url http://127.0.0.1:18081 'acme.test/*' 'https://github.com/acme/*' &
+pids+=($!)
+sleep 1
+status() {
+	curl -s -o /dev/null -w '%{http_code}' -H 'Host: acme.test' "http://127.0.0.1:18082/$1?go-get=1"
+}
+[[ $(status hello/pkg) == 200 ]] || { echo "e2e: listed repo not served" >&2; exit 1; }
+[[ $(status missing/pkg) == 404 ]] || { echo "e2e: unlisted repo served" >&2; exit 1; }
+curl -s -H 'Host: acme.test' -H 'Accept: application/json' http://127.0.0.1:18082/hello | grep -q '"owner":"@acme/core"' ||
+	{ echo "e2e: CODEOWNERS owner not taken" >&2; exit 1; }
+echo "e2e: OK"
@@ -0,0 +1,31 @@
+// Command fakeforge serves a fake forge of the forgetest package on -addr,
+// with the repos given as arguments, host/owner/name with an optional
+// ":archived" suffix, for end-to-end checks of the forge integrations.
+//
+// Usage: go run ./scripts/fakeforge [-addr addr] [-codeowners owners] repo ...
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/kastelo/go-import-redirector/forgetest"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:18081", "serve on `addr`")
+	codeowners := flag.String("codeowners", "", "give the repos a CODEOWNERS file naming `owners` for *")
+	flag.Parse()
+	f := forgetest.New()
+	for _, arg := range flag.Args() {
+		path, state, _ := strings.Cut(arg, ":")
+		r := forgetest.Repo{Archived: state == "archived"}
+		if *codeowners != "" {
+			r.Files = map[string]string{"CODEOWNERS": "* " + *codeowners + "\n"}
+		}
+		f.AddRepo(path, r)
+	}
+	log.Fatal(http.ListenAndServe(*addr, f))
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/kastelo/go-import-redirector/redirector"
+)
+
+var shardState = flag.String("shard-state", "", "pin each import root of a rule with shards to the repo it was first fetched from, recorded in `file`")
+
+// maxShardPins caps the pins recorded, as any path under a wildcard can
+// be fetched.
+const maxShardPins = 1 << 20
+
+// A shardPin records the repo root an import root of a sharded rule was
+// first served with to the go command.
+type shardPin struct {
+	ImportRoot string `json:"import_root"`
+	RepoRoot   string `json:"repo_root"`
+}
+
+// shardPins holds the -shard-state pins by import root.
+var shardPins struct {
+	sync.RWMutex
+	m    map[string]string
+	full bool // maxShardPins reached, logged
+}
+
+// loadShardState reads the -shard-state file, one JSON shardPin per line.
+func loadShardState() error {
+	if *shardState == "" {
+		return nil
+	}
+	shardPins.m = make(map[string]string)
+	f, err := os.Open(*shardState)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var p shardPin
+		if err := json.Unmarshal(sc.Bytes(), &p); err != nil {
+			return fmt.Errorf("%s: %v", *shardState, err)
+		}
+		shardPins.m[p.ImportRoot] = p.RepoRoot
+	}
+	return sc.Err()
+}
+
+// pinnedShard replaces the repo root of a resolution by a rule with
+// shards by the one its import root is pinned to, if any.
+func pinnedShard(r *redirector.Resolution) {
+	if r.Status != http.StatusOK || len(r.Rule.Shards) == 0 || shardPins.m == nil {
+		return
+	}
+	shardPins.RLock()
+	repo, ok := shardPins.m[r.ImportRoot]
+	shardPins.RUnlock()
+	if ok {
+		r.RepoRoot = repo
+	}
+}
+
+// pinShard records the repo root of r, served to the go command, as the
+// pin of its import root if it has none yet.
+func pinShard(r *redirector.Resolution) {
+	if len(r.Rule.Shards) == 0 || shardPins.m == nil {
+		return
+	}
+	shardPins.Lock()
+	defer shardPins.Unlock()
+	if _, ok := shardPins.m[r.ImportRoot]; ok {
+		return
+	}
+	if len(shardPins.m) >= maxShardPins {
+		if !shardPins.full {
+			log.Printf("%s: %d import roots pinned, pinning no more", *shardState, maxShardPins)
+			shardPins.full = true
+		}
+		return
+	}
+	line, err := json.Marshal(shardPin{r.ImportRoot, r.RepoRoot})
+	if err != nil {
+		log.Printf("%s: %v", *shardState, err)
+		return
+	}
+	f, err := os.OpenFile(*shardState, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err == nil {
+		_, err = f.Write(append(line, '\n'))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		log.Printf("%s: %v", *shardState, err)
+		return
+	}
+	shardPins.m[keepString(r.ImportRoot)] = keepString(r.RepoRoot)
+}
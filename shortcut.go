@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// A shortcut is a plain HTTP redirect from a path on the served hosts,
+// listed under redirects in the -config file.
+type shortcut struct {
+	From      string `yaml:"from" toml:"from"`           // host/path, or /path for any host
+	To        string `yaml:"to" toml:"to"`               // target URL
+	Permanent bool   `yaml:"permanent" toml:"permanent"` // 301 instead of 302
+
+	// The end of the chain of shortcuts through To, if flattened.
+	flatTo        string
+	flatPermanent bool
+}
+
+// shortcuts maps the From of the configured shortcuts, without a
+// trailing slash, to the shortcut.
+var shortcuts atomic.Pointer[map[string]*shortcut]
+
+func (s *shortcut) init() error {
+	if s.From == "" || s.To == "" {
+		return errors.New("redirect needs both from and to")
+	}
+	if !strings.Contains(s.To, "://") {
+		return errors.New("redirect target must be full URL")
+	}
+	s.From = strings.TrimSuffix(s.From, "/")
+	return nil
+}
+
+// flattenShortcuts points each shortcut whose target is another shortcut
+// on one of hosts at the end of the chain of shortcuts, so that clients
+// are redirected once, permanently only if every step is. It returns an
+// error for a chain that comes back to a shortcut already in it.
+func flattenShortcuts(list []*shortcut, hosts []string) error {
+	m := make(map[string]*shortcut)
+	for _, s := range list {
+		m[s.From] = s
+	}
+	next := func(s *shortcut) *shortcut {
+		u, err := url.Parse(s.To)
+		if err != nil || u.Scheme != "http" && u.Scheme != "https" {
+			return nil
+		}
+		p := strings.TrimSuffix(u.Path, "/")
+		if t := m[u.Host+p]; t != nil {
+			return t
+		}
+		if slices.Contains(hosts, u.Host) {
+			return m[p]
+		}
+		return nil
+	}
+	for _, s := range list {
+		seen := map[*shortcut]bool{s: true}
+		chain := []string{s.From}
+		to, permanent := s.To, s.Permanent
+		for t := next(s); t != nil; t = next(t) {
+			chain = append(chain, t.From)
+			if seen[t] {
+				return fmt.Errorf("redirect loop: %s", strings.Join(chain, " -> "))
+			}
+			seen[t] = true
+			to, permanent = t.To, permanent && t.Permanent
+		}
+		s.flatTo, s.flatPermanent = to, permanent
+	}
+	return nil
+}
+
+func storeShortcuts(list []*shortcut) {
+	m := make(map[string]*shortcut)
+	for _, s := range list {
+		m[s.From] = s
+	}
+	shortcuts.Store(&m)
+}
+
+// findShortcut returns the shortcut req matches, for its host or for any
+// host, or nil.
+func findShortcut(req *http.Request) *shortcut {
+	m := shortcuts.Load()
+	if m == nil {
+		return nil
+	}
+	p := strings.TrimSuffix(req.URL.Path, "/")
+	if s, ok := (*m)[requestHost(req)+p]; ok {
+		return s
+	}
+	return (*m)[p]
+}
+
+// serveShortcut redirects req if it matches a shortcut and reports whether
+// it did.
+func serveShortcut(w http.ResponseWriter, req *http.Request) bool {
+	s := findShortcut(req)
+	if s == nil {
+		return false
+	}
+	to, permanent := s.To, s.Permanent
+	if s.flatTo != "" {
+		to, permanent = s.flatTo, s.flatPermanent
+	}
+	code := http.StatusFound
+	if permanent {
+		code = http.StatusMovedPermanently
+	}
+	http.Redirect(w, req, to, code)
+	return true
+}
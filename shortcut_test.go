@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestFlattenShortcuts(t *testing.T) {
+	hosts := []string{"example.com"}
+	parse := func(pairs ...string) []*shortcut {
+		var list []*shortcut
+		for i := 0; i < len(pairs); i += 2 {
+			s := &shortcut{From: pairs[i], To: pairs[i+1], Permanent: true}
+			if err := s.init(); err != nil {
+				t.Fatal(err)
+			}
+			list = append(list, s)
+		}
+		return list
+	}
+
+	loops := map[string][]*shortcut{
+		"direct": parse("/a", "https://example.com/a"),
+		"indirect": parse(
+			"/a", "https://example.com/b",
+			"example.com/b", "https://example.com/c/",
+			"/c", "https://example.com/a",
+		),
+	}
+	for name, list := range loops {
+		err := flattenShortcuts(list, hosts)
+		if err == nil || !strings.Contains(err.Error(), "redirect loop: /a -> ") {
+			t.Errorf("%s loop: %v, want a redirect loop", name, err)
+		}
+	}
+
+	// The longest chain without a loop goes through every shortcut.
+	var pairs []string
+	const n = 20
+	for i := 0; i < n; i++ {
+		pairs = append(pairs, fmt.Sprintf("/s%d", i), fmt.Sprintf("https://example.com/s%d", i+1))
+	}
+	pairs[len(pairs)-1] = "https://elsewhere.example/end"
+	list := parse(pairs...)
+	list[n/2].Permanent = false
+	if err := flattenShortcuts(list, hosts); err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range list {
+		if s.flatTo != "https://elsewhere.example/end" {
+			t.Errorf("%s flattened to %s", s.From, s.flatTo)
+		}
+		if want := i > n/2; s.flatPermanent != want {
+			t.Errorf("%s permanent %v, want %v", s.From, s.flatPermanent, want)
+		}
+	}
+
+	// Closing the chain makes it a loop through every shortcut.
+	list = parse(pairs...)
+	list[n-1].To = "https://example.com/s0"
+	if err := flattenShortcuts(list, hosts); err == nil || strings.Count(err.Error(), " -> ") != n {
+		t.Errorf("loop through %d shortcuts: %v", n, err)
+	}
+}
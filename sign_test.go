@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// sigInputRE matches the Signature-Input header of signResponse.
+var sigInputRE = regexp.MustCompile(`^sig1=(\(([^)]*)\);created=\d+;keyid="[^"]*";alg="ed25519")$`)
+
+// verifySignature verifies the RFC 9421 signature of the response w to req
+// with pub, rebuilding the signature base from the covered components.
+func verifySignature(w *httptest.ResponseRecorder, req *http.Request, pub ed25519.PublicKey) error {
+	m := sigInputRE.FindStringSubmatch(w.Header().Get("Signature-Input"))
+	if m == nil {
+		return fmt.Errorf("Signature-Input %q", w.Header().Get("Signature-Input"))
+	}
+	var lines []string
+	for _, c := range strings.Fields(m[2]) {
+		var v string
+		switch c {
+		case `"@status"`:
+			v = strconv.Itoa(w.Code)
+		case `"@authority";req`:
+			v = strings.ToLower(req.Host)
+		case `"@path";req`:
+			v = req.URL.EscapedPath()
+		case `"content-digest"`:
+			sum := sha256.Sum256(w.Body.Bytes())
+			v = "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+			if got := w.Header().Get("Content-Digest"); got != v {
+				return fmt.Errorf("Content-Digest %s, want %s", got, v)
+			}
+		default:
+			v = w.Header().Get(strings.Trim(c, `"`))
+		}
+		lines = append(lines, c+": "+v)
+	}
+	lines = append(lines, `"@signature-params": `+m[1])
+	sig, ok := strings.CutPrefix(w.Header().Get("Signature"), "sig1=:")
+	if !ok || !strings.HasSuffix(sig, ":") {
+		return fmt.Errorf("Signature %q", w.Header().Get("Signature"))
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(sig, ":"))
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, []byte(strings.Join(lines, "\n")), raw) {
+		return fmt.Errorf("signature does not verify over\n%s", strings.Join(lines, "\n"))
+	}
+	return nil
+}
+
+func TestSignResponse(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKey, oldID := signKey, *signKeyID
+	signKey, *signKeyID = key, "test"
+	t.Cleanup(func() { signKey, *signKeyID = oldKey, oldID })
+	serveConfig(t, testConfig)
+
+	first := get("http://example.com/foo/bar?go-get=1")
+	etag := first.Header().Get("Etag")
+	tests := []struct {
+		method, url, accept, inm string
+		status                   int
+	}{
+		{http.MethodGet, "http://example.com/foo/bar?go-get=1", "", "", http.StatusOK},
+		{http.MethodGet, "http://example.com/x/y", "", "", http.StatusOK},
+		{http.MethodGet, "http://example.com/foo", "application/json", "", http.StatusOK},
+		{http.MethodGet, "http://example.com/foo/bar?go-get=1", "", etag, http.StatusNotModified},
+		{http.MethodGet, "http://example.com/foo/bar?go-get=1", "", `"other"`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.url, nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		if tt.inm != "" {
+			req.Header.Set("If-None-Match", tt.inm)
+		}
+		w := httptest.NewRecorder()
+		redirect(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.url, w.Code, tt.status)
+			continue
+		}
+		if err := verifySignature(w, req, pub); err != nil {
+			t.Errorf("%s %s (%d): %v", tt.method, tt.url, w.Code, err)
+		}
+	}
+
+	// Another key does not verify it.
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/foo?go-get=1", nil)
+	w := httptest.NewRecorder()
+	redirect(w, req)
+	if err := verifySignature(w, req, other); err == nil {
+		t.Error("signature verifies with another key")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kastelo/go-import-redirector/redirector"
+)
+
+// A simulation describes how a request would be resolved.
+type simulation struct {
+	Path string `json:"path"`
+	*redirector.Resolution
+	Tags     []string `json:"tags,omitempty"`
+	Redirect string   `json:"redirect,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// simulate resolves a request for path p on host (by default the import
+// host) without recording statistics or other side effects, and if
+// offline, without looking up the repo on its forge.
+func simulate(host, p string, offline bool) *simulation {
+	if host == "" {
+		host = importHost()
+	}
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	path := strings.TrimSuffix(host+p, "/")
+
+	sim := &simulation{Path: path, Resolution: resolve(path, time.Now())}
+	switch sim.Status {
+	case http.StatusFound:
+		sim.Redirect = sim.Location
+	case http.StatusInternalServerError:
+		sim.Error = sim.ExprError
+	case http.StatusOK:
+		if code := refusal(context.Background(), sim.Resolution, false); code != "" {
+			sim.Status, sim.Code = http.StatusNotFound, code
+			break
+		}
+		d := newData(sim.Resolution, offline)
+		switch {
+		case sim.MovedTo != "":
+			sim.Redirect = "https://" + sim.MovedTo
+		case sim.Canonical != "":
+			sim.Redirect = "https://" + sim.Canonical
+		case sim.BrowserURL() != "":
+			sim.Redirect = sim.BrowserURL()
+		case d.Refresh:
+			sim.Redirect = d.BrowseURL
+		default:
+			sim.Redirect = d.VCSRoot
+		}
+		var buf bytes.Buffer
+		if err := pageTemplate(sim.Rule, "").Execute(&buf, d); err != nil {
+			sim.Error = err.Error()
+			break
+		}
+		sim.Tags = metaTags(buf.Bytes())
+		if err := checkMeta(buf.Bytes(), path); err != nil {
+			sim.Error = err.Error()
+		}
+	}
+	return sim
+}
+
+// serveSimulate reports how a request for ?host=...&path=... would be
+// resolved, with &offline=1 without forge lookups.
+func serveSimulate(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(simulate(req.FormValue("host"), req.FormValue("path"), req.FormValue("offline") == "1"))
+}
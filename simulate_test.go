@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestSimulateRedirect(t *testing.T) {
+	serveConfig(t, `rules:
+  - import: example.com/old
+    repo: https://github.com/example/old
+    aliases: [go.example.com/old]
+    moved_to: example.com/new
+  - import: example.com/foo
+    repo: https://github.com/example/foo
+    aliases: [go.example.com/foo]
+`)
+	tests := []struct {
+		host, path, redirect string
+	}{
+		{"example.com", "/old/sub", "https://example.com/new/sub"},
+		{"go.example.com", "/old/sub", "https://example.com/new/sub"},
+		{"go.example.com", "/foo/sub", "https://example.com/foo/sub"},
+		{"example.com", "/foo/sub", "https://github.com/example/foo/tree/HEAD/sub"},
+	}
+	for _, tt := range tests {
+		for _, offline := range []bool{false, true} {
+			sim := simulate(tt.host, tt.path, offline)
+			if sim.Redirect != tt.redirect {
+				t.Errorf("%s%s (offline %v): redirect %q, want %q", tt.host, tt.path, offline, sim.Redirect, tt.redirect)
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	robots  = flag.String("robots", "deny", "answer /robots.txt allowing or denying all crawling, `allow` or deny, or with the contents of a file")
+	sitemap = flag.Bool("sitemap", false, "serve /sitemap.xml listing the configured and discovered modules of each import host")
+	favicon = flag.String("favicon", "", "serve /favicon.ico from `file` (default an empty response)")
+)
+
+// maxSitemapURLs is the most URLs a sitemap may list.
+const maxSitemapURLs = 50000
+
+var (
+	robotsFile  []byte // contents of the -robots file, if one is named
+	faviconData []byte
+	faviconType string
+)
+
+// loadSite reads the -robots and -favicon files.
+func loadSite() error {
+	if *robots != "allow" && *robots != "deny" {
+		data, err := os.ReadFile(*robots)
+		if err != nil {
+			return fmt.Errorf("-robots: %v", err)
+		}
+		robotsFile = data
+	}
+	if *favicon != "" {
+		data, err := os.ReadFile(*favicon)
+		if err != nil {
+			return fmt.Errorf("-favicon: %v", err)
+		}
+		faviconData = data
+		if faviconType = mime.TypeByExtension(filepath.Ext(*favicon)); faviconType == "" {
+			faviconType = http.DetectContentType(data)
+		}
+	}
+	return nil
+}
+
+// serveRobots answers /robots.txt as set by -robots. Allowing crawling,
+// it points crawlers to the -sitemap.
+func serveRobots(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	switch {
+	case robotsFile != nil:
+		w.Write(robotsFile)
+	case *robots == "deny":
+		fmt.Fprintf(w, "User-agent: *\nDisallow: /\n")
+	default:
+		fmt.Fprintf(w, "User-agent: *\nAllow: /\n")
+		if *sitemap {
+			fmt.Fprintf(w, "\nSitemap: %s://%s/sitemap.xml\n", redirectScheme(req), requestHost(req))
+		}
+	}
+}
+
+// serveFavicon answers /favicon.ico with the -favicon file, or with an
+// empty response, so that browser requests for it do not reach the rules.
+func serveFavicon(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	if faviconData == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", faviconType)
+	w.Write(faviconData)
+}
+
+type sitemapURL struct {
+	Loc string `xml:"loc"`
+}
+
+type urlset struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// serveSitemap lists the modules of the requested import host: the
+// import paths of its rules without wildcards, the repos listed for its
+// /* rules with -index-github, and those found by -discover.
+func serveSitemap(w http.ResponseWriter, req *http.Request) {
+	host := requestHost(req)
+	now := time.Now()
+	seen := make(map[string]bool)
+	for _, e := range indexEntries(host, nil, now) {
+		if e.DocsURL != "" {
+			seen[e.ImportPath] = true
+		}
+	}
+	for _, rl := range currentRules() {
+		if rl.Host() != host || rl.Canonical() != "" || rl.Import != rl.Root()+"/*" {
+			continue
+		}
+		repo, _ := rl.ActiveRepo(now)
+		for _, owner := range discoverList() {
+			if strings.TrimSuffix(repo, "/") != "https://"+owner {
+				continue
+			}
+			names, _ := discoveredRepos(owner)
+			for _, name := range names {
+				p := rl.Root() + "/" + name
+				if res := resolve(p, now); res.Status == http.StatusOK && res.Rule == rl {
+					seen[p] = true
+				}
+			}
+		}
+	}
+	paths := make([]string, 0, len(seen))
+	for p := range seen {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	if len(paths) > maxSitemapURLs {
+		paths = paths[:maxSitemapURLs]
+	}
+	set := urlset{URLs: make([]sitemapURL, len(paths))}
+	for i, p := range paths {
+		set.URLs[i].Loc = redirectScheme(req) + "://" + p
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	fmt.Fprint(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(set)
+	fmt.Fprintln(w)
+}
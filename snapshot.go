@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/kastelo/go-import-redirector/redirector"
+)
+
+var snapshotFile = flag.String("snapshot", "", "serve the rules compiled into `file` by the snapshot subcommand, instead of -config")
+
+// snapshotMagic starts a snapshot file. Its last byte is the layout
+// version; bump it when the layout, such as the list of ruleStrings,
+// changes.
+const snapshotMagic = "GIRSNAP\x08"
+
+// A snapshot file holds the rules of a -config file, with the defaults
+// from the per-rule flags filled in, and its redirects, certificates and
+// security headers, as length-prefixed strings after snapshotMagic.
+// Loading it maps the file and refers to the strings in place, without
+// parsing or copying them.
+
+// ruleStrings returns the string fields of r in snapshot order.
+func ruleStrings(r *redirector.Rule) []*string {
+	return []*string{
+		&r.Import, &r.Repo, &r.VCS, &r.ModProxy, &r.MinGo, &r.OldRepo,
+		&r.Cutover, &r.SourceDir, &r.SourceFile, &r.Branch, &r.DirURL,
+		&r.BrowserRedirect, &r.Owner, &r.Team, &r.Contact, &r.Template,
+		&r.MovedTo, &r.RepoExpr, &r.VCSExpr, &r.BrowseExpr, &r.Subdir,
+	}
+}
+
+// configName returns the name of the file the rules are read from, or ""
+// when they come from the command line.
+func configName() string {
+	switch {
+	case *snapshotFile != "":
+		return *snapshotFile
+	case configEnv != "":
+		return "$" + configEnv
+	}
+	return *configFile
+}
+
+// runSnapshot compiles the -config file into the snapshot file args[0].
+func runSnapshot(args []string) int {
+	if len(args) != 1 || *configFile == "" {
+		fmt.Fprintf(os.Stderr, "usage: go-import-redirector -config file snapshot <output>\n")
+		return 2
+	}
+	buf, err := os.ReadFile(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "go-import-redirector snapshot: %v\n", err)
+		return 1
+	}
+	c, err := expandConfig(buf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "go-import-redirector snapshot: %v\n", err)
+		return 1
+	}
+	configured := slices.Clone(c.Rules) // init adds the alias rules
+	if err := c.init(); err != nil {
+		fmt.Fprintf(os.Stderr, "go-import-redirector snapshot: %v\n", err)
+		return 1
+	}
+	c.Rules = configured
+	if err := writeFileAtomic(args[0], encodeSnapshot(c)); err != nil {
+		fmt.Fprintf(os.Stderr, "go-import-redirector snapshot: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func encodeSnapshot(c *config) []byte {
+	b := []byte(snapshotMagic)
+	b = binary.AppendUvarint(b, uint64(len(c.Rules)))
+	for _, r := range c.Rules {
+		for _, s := range ruleStrings(r) {
+			b = appendString(b, *s)
+		}
+		for _, list := range [][]string{r.Aliases, r.Transforms, r.Shards, r.Tags} {
+			b = binary.AppendUvarint(b, uint64(len(list)))
+			for _, s := range list {
+				b = appendString(b, s)
+			}
+		}
+	}
+	b = binary.AppendUvarint(b, uint64(len(c.Redirects)))
+	for _, s := range c.Redirects {
+		b = appendString(appendString(b, s.From), s.To)
+		if s.Permanent {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+	}
+	b = binary.AppendUvarint(b, uint64(len(c.Certificates)))
+	for _, p := range c.Certificates {
+		b = appendString(appendString(b, p.Cert), p.Key)
+	}
+	names := make([]string, 0, len(c.SecurityHeaders))
+	for k := range c.SecurityHeaders {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+	b = binary.AppendUvarint(b, uint64(len(names)))
+	for _, k := range names {
+		b = appendString(appendString(b, k), c.SecurityHeaders[k])
+	}
+	return b
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// readSnapshot maps the -snapshot file and decodes it. The strings of the
+// returned config refer to the mapping, which it keeps until a reload
+// replaces it and the requests that may be using it are done; see
+// retireMapping. Snapshots must be replaced by renaming, as the snapshot
+// subcommand does, rather than rewritten in place.
+func readSnapshot() (*config, error) {
+	data, err := mapFile(*snapshotFile)
+	if err != nil {
+		return nil, err
+	}
+	c, err := decodeSnapshot(data)
+	if err != nil {
+		unmapFile(data)
+		return nil, err
+	}
+	c.mapping = data
+	return c, nil
+}
+
+// decodeSnapshot decodes the snapshot in data.
+func decodeSnapshot(data []byte) (*config, error) {
+	d := &snapshotDecoder{data: data}
+	if magic := d.bytes(len(snapshotMagic)); string(magic) != snapshotMagic {
+		if strings.HasPrefix(string(magic), snapshotMagic[:len(snapshotMagic)-1]) {
+			return nil, fmt.Errorf("%s: snapshot from another version, compile it again", *snapshotFile)
+		}
+		return nil, fmt.Errorf("%s: not a snapshot", *snapshotFile)
+	}
+	var c config
+	c.Rules = make([]*redirector.Rule, d.count())
+	for i := range c.Rules {
+		r := new(redirector.Rule)
+		for _, s := range ruleStrings(r) {
+			*s = d.string()
+		}
+		for _, list := range []*[]string{&r.Aliases, &r.Transforms, &r.Shards, &r.Tags} {
+			if n := d.count(); n > 0 {
+				*list = make([]string, n)
+				for j := range *list {
+					(*list)[j] = d.string()
+				}
+			}
+		}
+		c.Rules[i] = r
+	}
+	c.Redirects = make([]*shortcut, d.count())
+	for i := range c.Redirects {
+		c.Redirects[i] = &shortcut{From: d.string(), To: d.string(), Permanent: d.byte() == 1}
+	}
+	c.Certificates = make([]certPair, d.count())
+	for i := range c.Certificates {
+		c.Certificates[i] = certPair{Cert: d.string(), Key: d.string()}
+	}
+	if n := d.count(); n > 0 {
+		c.SecurityHeaders = make(map[string]string, n)
+		for i := 0; i < n; i++ {
+			k := d.string()
+			c.SecurityHeaders[k] = d.string()
+		}
+	}
+	if d.err == nil && len(d.data) > 0 {
+		d.err = errors.New("trailing data")
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("%s: corrupt snapshot: %v", *snapshotFile, d.err)
+	}
+	return &c, nil
+}
+
+// A snapshotDecoder reads a snapshot, recording the first error.
+type snapshotDecoder struct {
+	data []byte
+	err  error
+}
+
+var errTruncated = errors.New("truncated")
+
+func (d *snapshotDecoder) bytes(n int) []byte {
+	if d.err != nil || n > len(d.data) {
+		d.err = errTruncated
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *snapshotDecoder) byte() byte {
+	if b := d.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *snapshotDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = errTruncated
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+// count reads a number of items, each taking at least a byte.
+func (d *snapshotDecoder) count() int {
+	n := d.uvarint()
+	if n > uint64(len(d.data)) {
+		d.err = errTruncated
+		return 0
+	}
+	return int(n)
+}
+
+// string returns the next string, referring to the snapshot data.
+func (d *snapshotDecoder) string() string {
+	b := d.bytes(d.count())
+	if len(b) == 0 {
+		return ""
+	}
+	return unsafe.String(&b[0], len(b))
+}
+
+// keepString returns s, or with -snapshot a copy of it, for keeping beyond
+// the configuration it may have come from, whose mapping is unmapped once
+// replaced.
+func keepString(s string) string {
+	if *snapshotFile == "" {
+		return s
+	}
+	return strings.Clone(s)
+}
+
+// servedMapping is the -snapshot mapping of the served configuration.
+var servedMapping []byte
+
+// mappingUsers counts the requests in flight by the generation of the
+// configuration they started under, so that the mapping of a replaced
+// snapshot is unmapped only once no request can be using its strings.
+// State kept beyond a request, such as caches and statistics, clones the
+// strings it takes from the rules.
+var mappingUsers = struct {
+	sync.Mutex
+	gen     uint64
+	n       map[uint64]int
+	retired []retiredMapping
+}{n: make(map[uint64]int)}
+
+// A retiredMapping is the mapping of a snapshot served up to generation gen.
+type retiredMapping struct {
+	gen  uint64
+	data []byte
+}
+
+// holdMapping records a request starting, and returns the generation to
+// pass to releaseMapping when it is done.
+func holdMapping() uint64 {
+	mappingUsers.Lock()
+	defer mappingUsers.Unlock()
+	mappingUsers.n[mappingUsers.gen]++
+	return mappingUsers.gen
+}
+
+// releaseMapping records a request of generation gen being done.
+func releaseMapping(gen uint64) {
+	mappingUsers.Lock()
+	defer mappingUsers.Unlock()
+	if mappingUsers.n[gen]--; mappingUsers.n[gen] == 0 {
+		delete(mappingUsers.n, gen)
+	}
+	unmapRetired()
+}
+
+// retireMapping starts a new generation, the configuration with the
+// mapping data having been replaced, and unmaps data once the requests
+// started before are done.
+func retireMapping(data []byte) {
+	if data == nil {
+		return
+	}
+	mappingUsers.Lock()
+	defer mappingUsers.Unlock()
+	mappingUsers.retired = append(mappingUsers.retired, retiredMapping{mappingUsers.gen, data})
+	mappingUsers.gen++
+	unmapRetired()
+}
+
+// unmapRetired unmaps the retired mappings no request can be using.
+func unmapRetired() {
+	if len(mappingUsers.retired) == 0 {
+		return
+	}
+	oldest := mappingUsers.gen
+	for g := range mappingUsers.n {
+		oldest = min(oldest, g)
+	}
+	keep := mappingUsers.retired[:0]
+	for _, m := range mappingUsers.retired {
+		if m.gen >= oldest {
+			keep = append(keep, m)
+			continue
+		}
+		if err := unmapFile(m.data); err != nil {
+			log.Printf("unmapping %s: %v", *snapshotFile, err)
+		}
+	}
+	mappingUsers.retired = keep
+}
+
+// holdingMapping has the requests to h hold the -snapshot mapping while
+// they are served.
+func holdingMapping(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		gen := holdMapping()
+		defer releaseMapping(gen)
+		h.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// soakSamples is the number of latencies kept per report interval.
+const soakSamples = 4096
+
+// runSoak runs the soak subcommand: it sends randomized requests for
+// import paths under the rules, and for paths that cannot be served, to the
+// redirect handler in process for a while, and reports the memory use and
+// latency as it goes.
+func runSoak(args []string) int {
+	fs := flag.NewFlagSet("soak", flag.ExitOnError)
+	duration := fs.Duration("duration", time.Minute, "send requests for `duration`")
+	every := fs.Duration("report", 10*time.Second, "report every `interval`")
+	workers := fs.Int("concurrency", runtime.GOMAXPROCS(0), "send `n` requests at once")
+	invalid := fs.Float64("invalid", 0.2, "make `fraction` of the paths ones that cannot be served")
+	distinct := fs.Int("paths", 0, "draw the paths that can be served from `n` distinct ones, so that pages are served from the cache (0 for all random)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: go-import-redirector -config file soak [options]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if configName() == "" || fs.NArg() != 0 || *workers < 1 || *every <= 0 {
+		fs.Usage()
+		return 2
+	}
+	if err := loadOffline(); err != nil {
+		fmt.Fprintf(os.Stderr, "go-import-redirector soak: %v\n", err)
+		return 1
+	}
+	if !flagSet("version-proxy") {
+		*versionProxy = "" // do not send the module proxy random module paths
+	}
+	if *every > *duration {
+		*every = *duration
+	}
+
+	s := &soak{invalid: *invalid}
+	if *distinct > 0 {
+		rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+		s.paths = make([]string, *distinct)
+		for i := range s.paths {
+			s.paths[i] = soakPath(rng, false)
+		}
+	}
+	startHeap := heapAfterGC()
+	fmt.Printf("soaking %d rules for %v with %d workers, %.0f%% invalid paths; heap %s\n", len(currentRules()), *duration, *workers, 100**invalid, megabytes(startHeap))
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			s.work(rand.New(rand.NewSource(seed)), stop)
+		}(time.Now().UnixNano() + int64(i))
+	}
+
+	start := time.Now()
+	tick := time.NewTicker(*every)
+	var first, last time.Duration // p99 latency of the first and last intervals
+	var prev int64
+	prevMallocs := mallocs()
+	prevTime := start
+	for now := start; now.Sub(start) < *duration; {
+		now = <-tick.C
+		n := s.requests.Load()
+		p50, p99 := s.percentiles()
+		if first == 0 {
+			first = p99
+		}
+		if p99 > 0 {
+			last = p99
+		}
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		allocs := 0.0
+		if n > prev {
+			allocs = float64(ms.Mallocs-prevMallocs) / float64(n-prev)
+		}
+		fmt.Printf("%8v %10d requests %8.0f/s  p50 %-9v p99 %-9v %6.1f allocs/req  heap %-9s goroutines %d\n",
+			now.Sub(start).Round(time.Second), n, float64(n-prev)/now.Sub(prevTime).Seconds(), p50, p99, allocs, megabytes(ms.HeapAlloc), runtime.NumGoroutine())
+		prev, prevMallocs, prevTime = n, ms.Mallocs, now
+	}
+	tick.Stop()
+	close(stop)
+	wg.Wait()
+
+	endHeap := heapAfterGC()
+	fmt.Printf("heap after GC: %s at start, %s at end (%+.1f MB)\n", megabytes(startHeap), megabytes(endHeap), (float64(endHeap)-float64(startHeap))/1e6)
+	if first > 0 {
+		fmt.Printf("p99 latency: %v in the first interval, %v in the last (%+.0f%%)\n", first, last, 100*(float64(last)/float64(first)-1))
+	}
+	fmt.Printf("responses:")
+	status := 0
+	for code := range s.statuses {
+		if n := s.statuses[code].Load(); n > 0 {
+			fmt.Printf(" %d: %d", code, n)
+			if code >= 500 {
+				status = 1
+			}
+		}
+	}
+	fmt.Println()
+	return status
+}
+
+type soak struct {
+	invalid  float64
+	paths    []string // the paths that can be served, if drawn from a set
+	requests atomic.Int64
+	statuses [600]atomic.Int64
+
+	mu      sync.Mutex
+	seen    int // latencies seen in the interval
+	samples []time.Duration
+}
+
+// work sends requests until stop is closed.
+func (s *soak) work(rng *rand.Rand, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		var path string
+		switch invalid := rng.Float64() < s.invalid; {
+		case !invalid && s.paths != nil:
+			path = s.paths[rng.Intn(len(s.paths))]
+		default:
+			path = soakPath(rng, invalid)
+		}
+		target := "http://" + path
+		if rng.Intn(2) == 0 {
+			target += "?go-get=1"
+		}
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		w := httptest.NewRecorder()
+		t := time.Now()
+		redirect(w, req)
+		s.record(rng, time.Since(t))
+		if w.Code > 0 && w.Code < len(s.statuses) {
+			s.statuses[w.Code].Add(1)
+		}
+	}
+}
+
+// record notes a request latency, keeping a uniform sample of those of
+// the interval.
+func (s *soak) record(rng *rand.Rand, d time.Duration) {
+	s.requests.Add(1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seen++
+	if len(s.samples) < soakSamples {
+		s.samples = append(s.samples, d)
+	} else if i := rng.Intn(s.seen); i < soakSamples {
+		s.samples[i] = d
+	}
+}
+
+// percentiles returns the median and 99th percentile latency of the
+// interval and starts a new one.
+func (s *soak) percentiles() (p50, p99 time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.samples) == 0 {
+		return 0, 0
+	}
+	sort.Slice(s.samples, func(i, j int) bool { return s.samples[i] < s.samples[j] })
+	p50, p99 = s.samples[len(s.samples)/2], s.samples[len(s.samples)*99/100]
+	s.samples, s.seen = s.samples[:0], 0
+	return p50, p99
+}
+
+// soakPath returns a random import path with a package path below it:
+// under a random rule with its wildcards and placeholders filled in, or if
+// invalid, an import path that cannot be served.
+func soakPath(rng *rand.Rand, invalid bool) string {
+	rs := currentRules()
+	r := rs[rng.Intn(len(rs))]
+	if invalid {
+		switch rng.Intn(4) {
+		case 0:
+			return "unknown-" + soakElem(rng) + ".invalid/" + soakElem(rng)
+		case 1:
+			return r.Host() + "/" + soakElem(rng) + "-" + soakElem(rng) + "/" + soakElem(rng)
+		case 2:
+			return r.Root() + "/" + strings.Repeat(soakElem(rng), 40)
+		default:
+			elems := make([]string, 30+rng.Intn(30))
+			for i := range elems {
+				elems[i] = soakElem(rng)
+			}
+			return r.Root() + "/" + strings.Join(elems, "/")
+		}
+	}
+	elems := strings.Split(r.Import, "/")
+	for i, e := range elems {
+		if e == "*" || strings.HasPrefix(e, "{") {
+			elems[i] = soakElem(rng)
+		}
+	}
+	for n := rng.Intn(3); n > 0; n-- {
+		elems = append(elems, soakElem(rng))
+	}
+	return strings.Join(elems, "/")
+}
+
+// soakElem returns a random import path element.
+func soakElem(rng *rand.Rand) string {
+	const chars = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, 1+rng.Intn(12))
+	for i := range b {
+		b[i] = chars[rng.Intn(len(chars))]
+	}
+	return string(b)
+}
+
+func heapAfterGC() uint64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+// mallocs returns the number of heap objects allocated so far.
+func mallocs() uint64 {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.Mallocs
+}
+
+func megabytes(n uint64) string {
+	return fmt.Sprintf("%.1f MB", float64(n)/1e6)
+}
@@ -0,0 +1,10 @@
+package main
+
+import "flag"
+
+var (
+	sourceDir  = flag.String("source-dir", "", "use `template` for go-source directory URLs, with {repo} for the repo URL")
+	sourceFile = flag.String("source-file", "", "use `template` for go-source file URLs, with {repo} for the repo URL")
+	branch     = flag.String("branch", "", "name `branch` in source and directory URLs (default HEAD, the repo's default branch)")
+	dirURL     = flag.String("dir-url", "", "send browsers to the package directory at URL `template`, with {repo}, {branch} and {/dir}")
+)
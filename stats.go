@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	statsEnabled = flag.Bool("stats", false, "collect usage statistics and serve them on /-/stats/")
+	statsDays    = flag.Int("stats-days", 30, "keep usage statistics for `days`")
+)
+
+// A usageKey identifies one row of the usage report.
+type usageKey struct {
+	Day     string
+	Source  string
+	Country string
+	AS      string
+	Module  string
+}
+
+// A dayModule identifies the counts of a module on a day.
+type dayModule struct {
+	Day    string
+	Module string
+}
+
+// usageStats aggregates resolved requests per day, source network and module.
+type usageStats struct {
+	mu       sync.Mutex
+	today    string
+	rows     map[usageKey]int64
+	clients  map[dayModule]map[string]int64 // Go client -> go-get requests
+	referers map[dayModule]map[string]int64 // referring page -> browser requests
+	modules  moduleSet
+}
+
+var stats = newUsageStats()
+
+func newUsageStats() *usageStats {
+	return &usageStats{
+		rows:     make(map[usageKey]int64),
+		clients:  make(map[dayModule]map[string]int64),
+		referers: make(map[dayModule]map[string]int64),
+		modules:  make(moduleSet),
+	}
+}
+
+// maxReferers bounds the number of distinct referring pages kept per
+// module and day.
+const maxReferers = 1000
+
+// maxStatsModules bounds the modules kept in the statistics, so that
+// scans of a wildcard namespace cannot grow them without limit. Further
+// modules are counted as "(other)" until others age out.
+const maxStatsModules = 1000
+
+// A moduleSet holds the modules kept in statistics, with the day each was
+// last counted.
+type moduleSet map[string]string
+
+// add records module as counted on day, and returns the name to count
+// it under: module, or "(other)" when maxStatsModules are already kept.
+func (s moduleSet) add(module, day string) string {
+	if _, ok := s[module]; !ok && len(s) >= maxStatsModules {
+		module = "(other)"
+	}
+	module = keepString(module)
+	s[module] = day
+	return module
+}
+
+// expire removes the modules last counted before the day oldest.
+func (s moduleSet) expire(oldest string) {
+	for m, day := range s {
+		if day < oldest {
+			delete(s, m)
+		}
+	}
+}
+
+// oldestDay returns the first day within -stats-days of now.
+func oldestDay(now time.Time) string {
+	return now.UTC().AddDate(0, 0, -*statsDays).Format("2006-01-02")
+}
+
+// record counts a successful resolution of module for req.
+func (s *usageStats) record(req *http.Request, module string) {
+	if !*statsEnabled {
+		return
+	}
+	now := time.Now().UTC()
+	day := now.Format("2006-01-02")
+	ip := clientIP(req)
+	country, as := geoLookup(ip)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if day != s.today {
+		s.today = day
+		s.expire(oldestDay(now))
+	}
+	module = s.modules.add(module, day)
+	s.rows[usageKey{Day: day, Source: sourceNetwork(ip), Country: country, AS: as, Module: module}]++
+	dm := dayModule{day, module}
+	if req.FormValue("go-get") == "1" {
+		c := s.clients[dm]
+		if c == nil {
+			c = make(map[string]int64)
+			s.clients[dm] = c
+		}
+		c[goClient(req.UserAgent())]++
+	} else if ref := referringPage(req.Referer()); ref != "" {
+		r := s.referers[dm]
+		if r == nil {
+			r = make(map[string]int64)
+			s.referers[dm] = r
+		}
+		if _, ok := r[ref]; ok || len(r) < maxReferers {
+			r[ref]++
+		}
+	}
+}
+
+// expire removes the counts of the days before oldest, returning the
+// number of entries removed. The caller holds s.mu.
+func (s *usageStats) expire(oldest string) int {
+	n := 0
+	for k := range s.rows {
+		if k.Day < oldest {
+			delete(s.rows, k)
+			n++
+		}
+	}
+	for _, m := range []map[dayModule]map[string]int64{s.clients, s.referers} {
+		for k := range m {
+			if k.Day < oldest {
+				delete(m, k)
+				n++
+			}
+		}
+	}
+	s.modules.expire(oldest)
+	return n
+}
+
+// referringPage returns the Referer without query and fragment,
+// or "" if it is not an http(s) URL.
+func referringPage(referer string) string {
+	u, err := url.Parse(referer)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	u.RawQuery, u.Fragment, u.User = "", "", nil
+	return u.String()
+}
+
+// sourceNetwork returns the network the client address belongs to,
+// as a /24 for IPv4 and a /48 for IPv6.
+func sourceNetwork(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return "unknown"
+	}
+	addr = addr.Unmap()
+	bits := 48
+	if addr.Is4() {
+		bits = 24
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return "unknown"
+	}
+	return prefix.String()
+}
+
+type usageRow struct {
+	Day      string `json:"day"`
+	Source   string `json:"source"`
+	Country  string `json:"country,omitempty"`
+	AS       string `json:"as,omitempty"`
+	Module   string `json:"module"`
+	Requests int64  `json:"requests"`
+	Owner    string `json:"owner,omitempty"`
+	Team     string `json:"team,omitempty"`
+}
+
+// report returns the usage rows, optionally limited to one module and to
+// the modules of rules with all of tags, sorted by day, module and source.
+func (s *usageStats) report(module string, tags []string) []usageRow {
+	s.mu.Lock()
+	rows := make([]usageRow, 0, len(s.rows))
+	for k, n := range s.rows {
+		if module != "" && k.Module != module {
+			continue
+		}
+		rows = append(rows, usageRow{Day: k.Day, Source: k.Source, Country: k.Country, AS: k.AS, Module: k.Module, Requests: n})
+	}
+	s.mu.Unlock()
+
+	x, now := rules.Load(), time.Now()
+	if len(tags) > 0 {
+		rows = slices.DeleteFunc(rows, func(r usageRow) bool { return !moduleTagged(x, r.Module, tags, now) })
+	}
+	for i := range rows {
+		if r := x.Resolve(rows[i].Module, now); r.Rule != nil {
+			rows[i].Owner, rows[i].Team = r.Rule.Owner, r.Rule.Team
+			if rows[i].Owner == "" {
+				rows[i].Owner = codeowner(r)
+			}
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.Module != b.Module {
+			return a.Module < b.Module
+		}
+		return a.Source < b.Source
+	})
+	return rows
+}
+
+// serveUsage serves the usage report as JSON, or as CSV with ?format=csv.
+func serveUsage(w http.ResponseWriter, req *http.Request) {
+	rows := stats.report(req.FormValue("module"), queryTags(req))
+	if req.FormValue("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="usage.csv"`)
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"day", "source", "country", "as", "module", "requests", "owner", "team"})
+		for _, r := range rows {
+			cw.Write([]string{r.Day, r.Source, r.Country, r.AS, r.Module, strconv.FormatInt(r.Requests, 10), r.Owner, r.Team})
+		}
+		cw.Flush()
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rows)
+}
+
+// serveGoVersions serves, per module, the number of go-get requests by Go
+// client version.
+func serveGoVersions(w http.ResponseWriter, req *http.Request) {
+	module := req.FormValue("module")
+	resp := make(map[string]map[string]int64)
+	stats.mu.Lock()
+	sumDays(resp, stats.clients, module)
+	stats.mu.Unlock()
+	filterTagged(resp, queryTags(req))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// serveReferers serves, per module, the number of browser requests by
+// referring page.
+func serveReferers(w http.ResponseWriter, req *http.Request) {
+	module := req.FormValue("module")
+	resp := make(map[string]map[string]int64)
+	stats.mu.Lock()
+	sumDays(resp, stats.referers, module)
+	stats.mu.Unlock()
+	filterTagged(resp, queryTags(req))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// sumDays adds the counts of each module in days, or of module only if
+// not empty, to resp, summing over the days.
+func sumDays(resp map[string]map[string]int64, days map[dayModule]map[string]int64, module string) {
+	for dm, counts := range days {
+		if module != "" && dm.Module != module {
+			continue
+		}
+		sum := resp[dm.Module]
+		if sum == nil {
+			sum = make(map[string]int64, len(counts))
+			resp[dm.Module] = sum
+		}
+		for k, v := range counts {
+			sum[k] += v
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatsBounded(t *testing.T) {
+	old := *statsEnabled
+	*statsEnabled = true
+	s := newUsageStats()
+	t.Cleanup(func() { *statsEnabled = old })
+	for i := 0; i < maxStatsModules+10; i++ {
+		req := httptest.NewRequest("GET", "/?go-get=1", nil)
+		s.record(req, fmt.Sprintf("example.com/x/m%d", i))
+		req = httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Referer", "https://example.org/page")
+		s.record(req, fmt.Sprintf("example.com/x/m%d", i))
+	}
+	if n := len(s.modules); n != maxStatsModules+1 {
+		t.Errorf("%d modules kept, want %d and (other)", n, maxStatsModules)
+	}
+	other := dayModule{s.today, "(other)"}
+	var n int64
+	for _, c := range s.clients[other] {
+		n += c
+	}
+	if n != 10 {
+		t.Errorf("%d go-get requests counted as (other), want 10", n)
+	}
+	if n := s.referers[other]["https://example.org/page"]; n != 10 {
+		t.Errorf("%d browser requests counted as (other), want 10", n)
+	}
+
+	// Once the days are past, everything has aged out.
+	s.expire("9999-12-31")
+	if len(s.rows)+len(s.clients)+len(s.referers)+len(s.modules) != 0 {
+		t.Errorf("%d rows, %d client and %d referrer counts and %d modules left after expiry", len(s.rows), len(s.clients), len(s.referers), len(s.modules))
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/kastelo/go-import-redirector/redirector"
+)
+
+var sumdbProxy = flag.String("sumdb-proxy", "", "pass requests for these comma-separated checksum database `names` through under /sumdb/")
+
+// sumdbNames returns the checksum databases given by -sumdb-proxy.
+func sumdbNames() map[string]bool {
+	names := make(map[string]bool)
+	for _, n := range strings.Split(*sumdbProxy, ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			names[n] = true
+		}
+	}
+	return names
+}
+
+// sumdbPassThrough forwards /sumdb/<name>/<rest> to https://<name>/<rest>.
+var sumdbPassThrough = &httputil.ReverseProxy{
+	Rewrite: func(pr *httputil.ProxyRequest) {
+		name, rest, _ := strings.Cut(strings.TrimPrefix(pr.In.URL.Path, "/sumdb/"), "/")
+		pr.SetURL(&url.URL{Scheme: "https", Host: name})
+		pr.Out.URL.Path = "/" + rest
+		pr.Out.URL.RawPath = ""
+		pr.Out.URL.RawQuery = ""
+		pr.Out.Host = ""
+	},
+}
+
+// serveSumDB implements the checksum database part of the module proxy
+// protocol: /sumdb/<name>/supported answers 200 for the databases given by
+// -sumdb-proxy, and their /latest, /lookup/ and /tile/ requests are passed
+// through to the database. The go command verifies what it receives
+// against the database's public key, so the responses need no checking
+// here.
+func serveSumDB(w http.ResponseWriter, req *http.Request) {
+	name, rest, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, "/sumdb/"), "/")
+	if !sumdbNames()[name] {
+		http.NotFound(w, req)
+		return
+	}
+	if !redirector.AllowedMethod(w, req) {
+		return
+	}
+	switch {
+	case rest == "supported":
+		w.WriteHeader(http.StatusOK)
+	case rest == "latest", strings.HasPrefix(rest, "lookup/"), strings.HasPrefix(rest, "tile/"):
+		sumdbPassThrough.ServeHTTP(w, req)
+	default:
+		http.NotFound(w, req)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kastelo/go-import-redirector/redirector"
+)
+
+// queryTags returns the tags req selects with ?tag=, repeated or comma
+// separated. A rule is selected if it has all of them.
+func queryTags(req *http.Request) []string {
+	var tags []string
+	for _, v := range req.URL.Query()["tag"] {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tags = append(tags, t)
+			}
+		}
+	}
+	return tags
+}
+
+// moduleTagged reports whether the rule serving module, an import path in
+// the statistics, has all of tags.
+func moduleTagged(x *redirector.Index, module string, tags []string, now time.Time) bool {
+	if len(tags) == 0 {
+		return true
+	}
+	r := x.Resolve(module, now)
+	return r.Rule != nil && r.Rule.HasTags(tags)
+}
+
+// taggedRules returns the rules in rs that have all of tags.
+func taggedRules(rs []*redirector.Rule, tags []string) []*redirector.Rule {
+	if len(tags) == 0 {
+		return rs
+	}
+	var list []*redirector.Rule
+	for _, r := range rs {
+		if r.HasTags(tags) {
+			list = append(list, r)
+		}
+	}
+	return list
+}
+
+// filterTagged removes from the per-module statistics in resp the modules
+// whose rule does not have all of tags.
+func filterTagged[V any](resp map[string]V, tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+	x, now := rules.Load(), time.Now()
+	for module := range resp {
+		if !moduleTagged(x, module, tags, now) {
+			delete(resp, module)
+		}
+	}
+}
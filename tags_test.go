@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+const taggedConfig = `rules:
+  - import: example.com/foo
+    repo: https://github.com/example/foo
+    tags: [tier=internal, team-infra]
+  - import: example.com/bar
+    repo: https://github.com/example/bar
+    tags: [tier=internal]
+  - import: example.com/x/*
+    repo: https://github.com/example/*
+`
+
+func TestTags(t *testing.T) {
+	serveConfig(t, taggedConfig)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"example.com/foo", "example.com/bar", "example.com/x/*"}},
+		{"?tag=tier=internal", []string{"example.com/foo", "example.com/bar"}},
+		{"?tag=tier=internal&tag=team-infra", []string{"example.com/foo"}},
+		{"?tag=tier=internal,team-infra", []string{"example.com/foo"}},
+		{"?tag=other", nil},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		serveWellKnown(w, httptest.NewRequest("GET", "/.well-known/go-modules"+tt.query, nil))
+		var wk wellKnown
+		if err := json.Unmarshal(w.Body.Bytes(), &wk); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, ns := range wk.Namespaces {
+			got = append(got, ns.ImportPath)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q: %q, want %q", tt.query, got, tt.want)
+		}
+	}
+
+	old := *statsEnabled
+	*statsEnabled = true
+	t.Cleanup(func() { *statsEnabled = old })
+	s := newUsageStats()
+	for _, m := range []string{"example.com/foo", "example.com/bar", "example.com/x/y"} {
+		s.record(httptest.NewRequest("GET", "/?go-get=1", nil), m)
+	}
+	var got []string
+	for _, r := range s.report("", []string{"team-infra"}) {
+		got = append(got, r.Module)
+	}
+	if want := []string{"example.com/foo"}; !slices.Equal(got, want) {
+		t.Errorf("usage of team-infra modules: %q, want %q", got, want)
+	}
+
+	c, err := readConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc, err := decodeSnapshot(encodeSnapshot(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := dc.Rules[0].Tags; !slices.Equal(got, c.Rules[0].Tags) {
+		t.Errorf("snapshot tags %q, want %q", got, c.Rules[0].Tags)
+	}
+}
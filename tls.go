@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	tlsFlag = flag.Bool("tls", false, "serve https on :443 using <host>.crt and <host>.key")
+	tlsCA   = flag.String("tls-ca", "", "verify the served certificate chain against the CA certificates in `file` instead of the system roots")
+)
+
+// A servedCert is a -tls certificate and its current OCSP staple.
+type servedCert struct {
+	file, key string
+	cert      atomic.Pointer[tls.Certificate]
+	stapled   atomic.Pointer[tls.Certificate]
+}
+
+// A certPair names a certificate file and its key file in the -config file.
+type certPair struct {
+	Cert string `yaml:"cert" toml:"cert"`
+	Key  string `yaml:"key" toml:"key"` // default: the key is in the cert file
+}
+
+var (
+	configCerts []certPair    // certificates listed in the -config file
+	tlsCerts    []*servedCert // loaded -tls certificates, the default first
+
+	certMu    sync.RWMutex
+	hostCerts = make(map[string]*servedCert) // certificate served for each import host
+	chainErrs = make(map[string]error)       // result of verifying its chain, by host
+)
+
+// importHost returns the host part of the first rule's import path.
+func importHost() string {
+	return importHosts()[0]
+}
+
+// loadCertificate loads the certificates listed in the -config file, or
+// the -tls-cert and -tls-key files, or else <host>.crt and <host>.key for
+// each import host that has them (required for the first), and assigns
+// each import host its certificate.
+func loadCertificate() error {
+	var pairs []certPair
+	switch {
+	case len(configCerts) > 0:
+		if *tlsCertFile != "" || *tlsKeyFile != "" {
+			return errors.New("-tls-cert and -tls-key cannot be used with certificates in -config")
+		}
+		pairs = configCerts
+	case *tlsCertFile != "":
+		pairs = []certPair{{*tlsCertFile, *tlsKeyFile}}
+	default:
+		for i, h := range importHosts() {
+			if _, err := os.Stat(h + ".crt"); i > 0 && err != nil {
+				continue
+			}
+			key := h + ".key"
+			if i == 0 && *tlsKeyFile != "" {
+				key = *tlsKeyFile
+			}
+			pairs = append(pairs, certPair{h + ".crt", key})
+		}
+	}
+	for _, p := range pairs {
+		cert, err := loadKeyPair(p.Cert, p.Key)
+		if err != nil {
+			return err
+		}
+		sc := &servedCert{file: p.Cert, key: p.Key}
+		sc.cert.Store(&cert)
+		tlsCerts = append(tlsCerts, sc)
+	}
+	for _, h := range importHosts() {
+		if err := assignCert(h); err != nil && *strict {
+			return fmt.Errorf("-strict: %v", err)
+		}
+	}
+	return nil
+}
+
+// assignCert serves host the first certificate valid for it, or else the
+// default one, and checks its chain for host.
+func assignCert(host string) error {
+	host = keepString(host)
+	sc := tlsCerts[0]
+	for _, c := range tlsCerts {
+		if leaf, err := x509.ParseCertificate(c.cert.Load().Certificate[0]); err == nil && leaf.VerifyHostname(host) == nil {
+			sc = c
+			break
+		}
+	}
+	err := verifyChain(sc.cert.Load(), host)
+	certMu.Lock()
+	hostCerts[host] = sc
+	certMu.Unlock()
+	noteChain(host, sc.file, err)
+	if err != nil {
+		return fmt.Errorf("certificate chain in %s does not verify for %s: %v", sc.file, host, err)
+	}
+	return nil
+}
+
+// noteChain records the result err of verifying the chain from source
+// served for host, warning if it does not verify.
+func noteChain(host, source string, err error) {
+	if err != nil {
+		log.Printf("WARNING: served certificate chain in %s does not verify for %s, go get will fail: %v", source, host, err)
+	}
+	certMu.Lock()
+	chainErrs[host] = err
+	certMu.Unlock()
+	invalid := 0.0
+	if err != nil {
+		invalid = 1
+	}
+	tlsChainInvalid.WithLabelValues(host).Set(invalid)
+}
+
+// certFor returns the certificate to serve for the TLS server name, and
+// the result of verifying its chain for it. A server name that is not an
+// import host gets the default certificate, whose chain was not verified
+// for it.
+func certFor(serverName string) (*servedCert, error) {
+	certMu.RLock()
+	defer certMu.RUnlock()
+	if sc, ok := hostCerts[serverName]; ok {
+		return sc, chainErrs[serverName]
+	}
+	return tlsCerts[0], fmt.Errorf("%q is not an import host: chain not verified for it", serverName)
+}
+
+// verifyChain checks that the certificate chain builds to a trusted root
+// for host, as clients will check it.
+func verifyChain(cert *tls.Certificate, host string) error {
+	if len(cert.Certificate) == 0 {
+		return errors.New("no certificates")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	opts := x509.VerifyOptions{
+		DNSName:       host,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, der := range cert.Certificate[1:] {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return err
+		}
+		opts.Intermediates.AddCert(c)
+	}
+	if *tlsCA != "" {
+		pem, err := os.ReadFile(*tlsCA)
+		if err != nil {
+			return err
+		}
+		opts.Roots = x509.NewCertPool()
+		if !opts.Roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: no certificates found", *tlsCA)
+		}
+	}
+	_, err = leaf.Verify(opts)
+	var uae x509.UnknownAuthorityError
+	if errors.As(err, &uae) && len(cert.Certificate) == 1 && leaf.Issuer.String() != leaf.Subject.String() {
+		return fmt.Errorf("%v (intermediate certificates missing?)", err)
+	}
+	return err
+}
+
+type certInfo struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	DNSNames    []string  `json:"dns_names,omitempty"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	Fingerprint string    `json:"sha256_fingerprint"`
+}
+
+type tlsInfo struct {
+	TLS                bool       `json:"tls"`
+	Version            string     `json:"version,omitempty"`
+	CipherSuite        string     `json:"cipher_suite,omitempty"`
+	ServerName         string     `json:"server_name,omitempty"`
+	NegotiatedProtocol string     `json:"negotiated_protocol,omitempty"`
+	Chain              []certInfo `json:"chain,omitempty"`
+	ChainVerified      bool       `json:"chain_verified"`
+	ChainError         string     `json:"chain_error,omitempty"`
+}
+
+// serveTLSInfo describes the TLS connection the request arrived on and the
+// certificate chain presented to the client. With -autocert, that is the
+// chain in the cache as cachedCert chooses it.
+func serveTLSInfo(w http.ResponseWriter, req *http.Request) {
+	var info tlsInfo
+	if cs := req.TLS; cs != nil {
+		info.TLS = true
+		info.Version = tls.VersionName(cs.Version)
+		info.CipherSuite = tls.CipherSuiteName(cs.CipherSuite)
+		info.ServerName = cs.ServerName
+		info.NegotiatedProtocol = cs.NegotiatedProtocol
+		var (
+			chain    [][]byte
+			chainErr error
+		)
+		if m := certManager.Load(); m != nil {
+			chain, chainErr = cachedChain(req.Context(), m.Cache, cs.ServerName)
+			if chainErr == nil {
+				chainErr = verifyChain(&tls.Certificate{Certificate: chain}, cs.ServerName)
+			}
+		} else if len(tlsCerts) > 0 {
+			var sc *servedCert
+			sc, chainErr = certFor(cs.ServerName)
+			chain = sc.cert.Load().Certificate
+		}
+		if chain != nil || chainErr != nil {
+			info.ChainVerified = chainErr == nil
+			if chainErr != nil {
+				info.ChainError = chainErr.Error()
+			}
+			for _, der := range chain {
+				c, err := x509.ParseCertificate(der)
+				if err != nil {
+					continue
+				}
+				sum := sha256.Sum256(der)
+				info.Chain = append(info.Chain, certInfo{
+					Subject:     c.Subject.String(),
+					Issuer:      c.Issuer.String(),
+					DNSNames:    c.DNSNames,
+					NotBefore:   c.NotBefore,
+					NotAfter:    c.NotAfter,
+					Fingerprint: hex.EncodeToString(sum[:]),
+				})
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestGetCertificate checks that each import host is served the first
+// certificate valid for it, and other server names the default one.
+func TestGetCertificate(t *testing.T) {
+	serveConfig(t, `rules:
+  - import: example.com/foo
+    repo: https://github.com/example/foo
+  - import: other.org/bar
+    repo: https://github.com/other/bar
+  - import: third.net/baz
+    repo: https://github.com/third/baz
+`)
+	ca, caKey := newCert(t, nil, nil)
+	useTLSCA(t, ca)
+	dir := t.TempDir()
+	var pairs []certPair
+	leaves := make(map[string]*x509.Certificate)
+	for _, host := range []string{"example.com", "other.org"} {
+		leaf, key := newCert(t, ca, caKey, host)
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := filepath.Join(dir, host+".pem")
+		data := append(pemCerts(leaf, ca), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})...)
+		if err := os.WriteFile(file, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		pairs = append(pairs, certPair{Cert: file})
+		leaves[host] = leaf
+	}
+
+	oldConfigCerts, oldTLSCerts := configCerts, tlsCerts
+	configCerts, tlsCerts = pairs, nil
+	t.Cleanup(func() {
+		configCerts, tlsCerts = oldConfigCerts, oldTLSCerts
+		certMu.Lock()
+		for _, h := range []string{"example.com", "other.org", "third.net"} {
+			delete(hostCerts, h)
+			delete(chainErrs, h)
+		}
+		certMu.Unlock()
+	})
+	if err := loadCertificate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		serverName, served string
+		verified           bool
+	}{
+		{"example.com", "example.com", true},
+		{"other.org", "other.org", true},
+		{"third.net", "example.com", false},    // an import host without a certificate
+		{"unknown.test", "example.com", false}, // not an import host
+		{"", "example.com", false},             // no SNI
+	}
+	for _, tt := range tests {
+		c, err := servedCertificate(&tls.ClientHelloInfo{ServerName: tt.serverName})
+		if err != nil {
+			t.Errorf("%q: %v", tt.serverName, err)
+			continue
+		}
+		if string(c.Certificate[0]) != string(leaves[tt.served].Raw) {
+			leaf, _ := x509.ParseCertificate(c.Certificate[0])
+			t.Errorf("%q: served the certificate of %s, want %s", tt.serverName, leaf.DNSNames, tt.served)
+		}
+		if _, err := certFor(tt.serverName); (err == nil) != tt.verified {
+			t.Errorf("%q: chain error %v, want verified %v", tt.serverName, err, tt.verified)
+		} else if err != nil && tt.serverName == "third.net" && !strings.Contains(err.Error(), "third.net") {
+			t.Errorf("%q: chain error %v", tt.serverName, err)
+		}
+	}
+}
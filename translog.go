@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kastelo/go-import-redirector/redirector"
+)
+
+var transparencyLog = flag.String("transparency-log", "", "append metadata changes to the Merkle tree log in `file`")
+
+// A logEntry records the metadata served for an import path at some time.
+// Entries are the leaves of a Merkle tree hashed as in RFC 6962.
+type logEntry struct {
+	ImportPath string    `json:"import_path"`
+	Repo       string    `json:"repo"`
+	VCS        string    `json:"vcs"`
+	ConfigHash string    `json:"config_hash"`
+	Time       time.Time `json:"time"`
+}
+
+// transLog is the in-memory copy of the transparency log.
+var transLog struct {
+	sync.Mutex
+	entries []logEntry
+	leaves  [][]byte // leaf hashes
+}
+
+// appendTransparencyLog loads the -transparency-log file and appends an
+// entry for each import path whose served metadata changed since its last entry.
+func appendTransparencyLog() error {
+	if *transparencyLog == "" {
+		return nil
+	}
+	f, err := os.Open(*transparencyLog)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			var e logEntry
+			if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+				f.Close()
+				return fmt.Errorf("%s: %v", *transparencyLog, err)
+			}
+			addLeaf(e, sc.Bytes())
+		}
+		f.Close()
+		if err := sc.Err(); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range currentRules() {
+		if err := logRule(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logRule appends an entry for r to the transparency log unless the last
+// entry for its import path has the same configuration hash.
+func logRule(r *redirector.Rule) error {
+	e := logEntry{
+		ImportPath: keepString(r.Import),
+		Repo:       keepString(r.Repo),
+		VCS:        keepString(r.VCS),
+		ConfigHash: r.Fingerprint(),
+		Time:       time.Now().UTC(),
+	}
+	for i := len(transLog.entries) - 1; i >= 0; i-- {
+		if last := transLog.entries[i]; last.ImportPath == e.ImportPath {
+			if last.ConfigHash == e.ConfigHash {
+				return nil
+			}
+			break
+		}
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(*transparencyLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	addLeaf(e, line)
+	return nil
+}
+
+func addLeaf(e logEntry, line []byte) {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(line)
+	transLog.Lock()
+	transLog.entries = append(transLog.entries, e)
+	transLog.leaves = append(transLog.leaves, h.Sum(nil))
+	transLog.Unlock()
+}
+
+// merkleRoot computes the RFC 6962 Merkle tree hash of the given leaf hashes.
+func merkleRoot(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		h := sha256.Sum256(nil)
+		return h[:]
+	case 1:
+		return leaves[0]
+	}
+	k := splitPoint(len(leaves))
+	return nodeHash(merkleRoot(leaves[:k]), merkleRoot(leaves[k:]))
+}
+
+// splitPoint returns the largest power of two smaller than n > 1, where
+// the tree of n leaves is split into its subtrees.
+func splitPoint(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// nodeHash returns the hash of the interior node with children l and r.
+func nodeHash(l, r []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(l)
+	h.Write(r)
+	return h.Sum(nil)
+}
+
+// inclusionProof returns the RFC 6962 audit path of leaf m, from the
+// leaf up, in the tree of leaves.
+func inclusionProof(leaves [][]byte, m int) [][]byte {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := splitPoint(len(leaves))
+	if m < k {
+		return append(inclusionProof(leaves[:k], m), merkleRoot(leaves[k:]))
+	}
+	return append(inclusionProof(leaves[k:], m-k), merkleRoot(leaves[:k]))
+}
+
+// consistencyProof returns the RFC 6962 consistency proof between the
+// tree of the first m leaves and that of all of them, for 0 < m <= n.
+func consistencyProof(leaves [][]byte, m int) [][]byte {
+	return subproof(leaves, m, true)
+}
+
+// subproof is SUBPROOF of RFC 6962 section 2.1.2; whole reports whether
+// the first m leaves are a complete subtree of the old tree.
+func subproof(leaves [][]byte, m int, whole bool) [][]byte {
+	n := len(leaves)
+	if m == n {
+		if whole {
+			return nil
+		}
+		return [][]byte{merkleRoot(leaves)}
+	}
+	k := splitPoint(n)
+	if m <= k {
+		return append(subproof(leaves[:k], m, whole), merkleRoot(leaves[k:]))
+	}
+	return append(subproof(leaves[k:], m-k, false), merkleRoot(leaves[:k]))
+}
+
+// serveTransparencyLog serves the log entries and the current tree head.
+// With ?leaf=<index>, it also serves the inclusion proof of that entry in
+// the current tree, and with ?from=<size>, the consistency proof from the
+// tree of that size, as lists of hex hashes.
+func serveTransparencyLog(w http.ResponseWriter, req *http.Request) {
+	type response struct {
+		Size        int        `json:"size"`
+		Root        string     `json:"root"`
+		Entries     []logEntry `json:"entries"`
+		Inclusion   *[]string  `json:"inclusion,omitempty"`
+		Consistency *[]string  `json:"consistency,omitempty"`
+	}
+	transLog.Lock()
+	resp := response{
+		Size:    len(transLog.entries),
+		Root:    hex.EncodeToString(merkleRoot(transLog.leaves)),
+		Entries: transLog.entries,
+	}
+	leaves := transLog.leaves
+	transLog.Unlock()
+	proof := func(param string, min, max int, f func([][]byte, int) [][]byte) (*[]string, bool) {
+		v := req.FormValue(param)
+		if v == "" {
+			return nil, true
+		}
+		i, err := strconv.Atoi(v)
+		if err != nil || i < min || i > max {
+			http.Error(w, fmt.Sprintf("%s %q out of range for a tree of %d entries", param, v, len(leaves)), http.StatusBadRequest)
+			return nil, false
+		}
+		hashes := []string{}
+		for _, h := range f(leaves, i) {
+			hashes = append(hashes, hex.EncodeToString(h))
+		}
+		return &hashes, true
+	}
+	var ok bool
+	if resp.Inclusion, ok = proof("leaf", 0, len(leaves)-1, inclusionProof); !ok {
+		return
+	}
+	if resp.Consistency, ok = proof("from", 1, len(leaves), consistencyProof); !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// rfc6962Leaves are the leaf inputs of the RFC 6962 test vectors of the
+// certificate-transparency reference implementation.
+var rfc6962Leaves = []string{
+	"",
+	"00",
+	"10",
+	"2021",
+	"3031",
+	"40414243",
+	"5051525354555657",
+	"606162636465666768696a6b6c6d6e6f",
+}
+
+// rfc6962Roots are the roots of the trees of the first 1 to 8 leaves.
+var rfc6962Roots = []string{
+	"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+	"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+	"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+	"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+	"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+	"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+	"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+	"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+}
+
+func testLeaves(t *testing.T) [][]byte {
+	t.Helper()
+	var leaves [][]byte
+	for _, s := range rfc6962Leaves {
+		in, err := hex.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := sha256.Sum256(append([]byte{0}, in...))
+		leaves = append(leaves, h[:])
+	}
+	return leaves
+}
+
+func hexList(hashes [][]byte) []string {
+	list := []string{}
+	for _, h := range hashes {
+		list = append(list, hex.EncodeToString(h))
+	}
+	return list
+}
+
+func TestMerkleRoot(t *testing.T) {
+	leaves := testLeaves(t)
+	if got, want := hex.EncodeToString(merkleRoot(nil)), "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"; got != want {
+		t.Errorf("empty tree: %s, want %s", got, want)
+	}
+	for n, want := range rfc6962Roots {
+		if got := hex.EncodeToString(merkleRoot(leaves[:n+1])); got != want {
+			t.Errorf("%d leaves: %s, want %s", n+1, got, want)
+		}
+	}
+}
+
+// verifyInclusion checks the inclusion proof of leaf index i in the tree
+// of size n with the root, as in RFC 9162 section 2.1.3.2.
+func verifyInclusion(leaf []byte, i, n int, proof [][]byte, root []byte) bool {
+	if i >= n {
+		return false
+	}
+	fn, sn, r := i, n-1, leaf
+	for _, p := range proof {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn, sn = fn>>1, sn>>1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn, sn = fn>>1, sn>>1
+	}
+	return sn == 0 && bytes.Equal(r, root)
+}
+
+// verifyConsistency checks the consistency proof between the trees of
+// sizes m and n with the roots, as in RFC 9162 section 2.1.4.2.
+func verifyConsistency(m, n int, proof [][]byte, oldRoot, newRoot []byte) bool {
+	if m == n {
+		return len(proof) == 0 && bytes.Equal(oldRoot, newRoot)
+	}
+	if m&(m-1) == 0 { // a power of two: the old root is the first hash
+		proof = append([][]byte{oldRoot}, proof...)
+	}
+	if len(proof) == 0 {
+		return false
+	}
+	fn, sn := m-1, n-1
+	for fn&1 == 1 {
+		fn, sn = fn>>1, sn>>1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			fr, sr = nodeHash(c, fr), nodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn, sn = fn>>1, sn>>1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+		fn, sn = fn>>1, sn>>1
+	}
+	return sn == 0 && bytes.Equal(fr, oldRoot) && bytes.Equal(sr, newRoot)
+}
+
+func TestInclusionProof(t *testing.T) {
+	leaves := testLeaves(t)
+	vectors := []struct {
+		leaf, size int
+		want       []string
+	}{
+		{0, 1, []string{}},
+		{0, 8, []string{
+			"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+		}},
+		{5, 8, []string{
+			"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+			"ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+			"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		}},
+		{2, 3, []string{
+			"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+		}},
+		{1, 5, []string{
+			"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+		}},
+	}
+	for _, v := range vectors {
+		if got := hexList(inclusionProof(leaves[:v.size], v.leaf)); !slices.Equal(got, v.want) {
+			t.Errorf("inclusion of leaf %d in %d: %q, want %q", v.leaf, v.size, got, v.want)
+		}
+	}
+
+	for _, n := range []int{1, 2, 3, 7, 8} {
+		root := merkleRoot(leaves[:n])
+		for i := 0; i < n; i++ {
+			proof := inclusionProof(leaves[:n], i)
+			if !verifyInclusion(leaves[i], i, n, proof, root) {
+				t.Errorf("inclusion proof of leaf %d in %d does not verify", i, n)
+			}
+			if i+1 < n && verifyInclusion(leaves[i+1], i, n, proof, root) {
+				t.Errorf("inclusion proof of leaf %d in %d verifies for leaf %d", i, n, i+1)
+			}
+		}
+	}
+}
+
+func TestConsistencyProof(t *testing.T) {
+	leaves := testLeaves(t)
+	vectors := []struct {
+		m, n int
+		want []string
+	}{
+		{1, 1, []string{}},
+		{1, 8, []string{
+			"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+		}},
+		{6, 8, []string{
+			"0ebc5d3437fbe2db158b9f126a1d118e308181031d0a949f8dededebc558ef6a",
+			"ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+			"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		}},
+		{2, 5, []string{
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+		}},
+	}
+	for _, v := range vectors {
+		if got := hexList(consistencyProof(leaves[:v.n], v.m)); !slices.Equal(got, v.want) {
+			t.Errorf("consistency of %d with %d: %q, want %q", v.m, v.n, got, v.want)
+		}
+	}
+
+	for _, n := range []int{1, 2, 3, 7, 8} {
+		root := merkleRoot(leaves[:n])
+		for m := 1; m <= n; m++ {
+			proof := consistencyProof(leaves[:n], m)
+			if !verifyConsistency(m, n, proof, merkleRoot(leaves[:m]), root) {
+				t.Errorf("consistency proof of %d with %d does not verify", m, n)
+			}
+			if m < n && verifyConsistency(m, n, proof, merkleRoot(leaves[1:m+1]), root) {
+				t.Errorf("consistency proof of %d with %d verifies for another old tree", m, n)
+			}
+		}
+	}
+}
+
+func TestServeTransparencyLogProofs(t *testing.T) {
+	transLog.Lock()
+	oldEntries, oldLeaves := transLog.entries, transLog.leaves
+	transLog.entries, transLog.leaves = make([]logEntry, 3), testLeaves(t)[:3]
+	transLog.Unlock()
+	t.Cleanup(func() {
+		transLog.Lock()
+		transLog.entries, transLog.leaves = oldEntries, oldLeaves
+		transLog.Unlock()
+	})
+
+	w := httptest.NewRecorder()
+	serveTransparencyLog(w, httptest.NewRequest("GET", "/-/transparency-log?leaf=2&from=3", nil))
+	var resp struct {
+		Root        string
+		Inclusion   []string
+		Consistency *[]string
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Root != rfc6962Roots[2] || !slices.Equal(resp.Inclusion, []string{rfc6962Roots[1]}) || resp.Consistency == nil || len(*resp.Consistency) != 0 {
+		t.Errorf("proofs %s", w.Body)
+	}
+	for _, q := range []string{"leaf=3", "leaf=-1", "from=0", "from=4", "from=x"} {
+		w := httptest.NewRecorder()
+		serveTransparencyLog(w, httptest.NewRequest("GET", "/-/transparency-log?"+q, nil))
+		if w.Code != 400 {
+			t.Errorf("%s: %d, want 400", q, w.Code)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// uaGoVersion matches a Go release embedded in a User-Agent, as in
+// "go1.22.3" or "Go/1.22". The go command itself sends
+// "GoCommand/1 (+https://go.dev/cmd/go)", and older releases the net/http
+// default "Go-http-client/1.1", neither of which carries the Go version.
+var uaGoVersion = regexp.MustCompile(`(?i)\bgo/?(1\.\d+(?:\.\d+)?)`)
+
+// goVersionFromUA returns the Go version in the User-Agent, or "".
+func goVersionFromUA(ua string) string {
+	m := uaGoVersion.FindStringSubmatch(ua)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// goClient classifies the User-Agent of a go-get request for the Go
+// version report: the Go version if the User-Agent carries one, otherwise
+// the kind of client.
+func goClient(ua string) string {
+	if v := goVersionFromUA(ua); v != "" {
+		return "go" + v
+	}
+	switch {
+	case strings.HasPrefix(ua, "GoCommand/"):
+		return "GoCommand (version not sent)"
+	case strings.HasPrefix(ua, "Go-http-client/"):
+		return "Go-http-client (version not sent)"
+	}
+	return "other"
+}
+
+// goVersionLess reports whether Go version a (such as "1.21.3") is older than b.
+func goVersionLess(a, b string) bool {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			return x < y
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"container/list"
+	"flag"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+var versionProxy = flag.String("version-proxy", "https://proxy.golang.org", "list module versions on the page from the module proxy at `URL` (empty to disable)")
+
+const (
+	versionsTTL      = time.Hour
+	maxVersions      = 20
+	maxCachedModules = 10000
+)
+
+type versionList struct {
+	mod      string
+	versions []string
+	fetched  time.Time
+	fetching bool
+}
+
+// versionCache holds the recent versions of each module, newest first,
+// evicting the least recently used modules beyond maxCachedModules.
+var versionCache = struct {
+	sync.Mutex
+	m   map[string]*list.Element
+	lru list.List // of *versionList, most recently used first
+}{m: make(map[string]*list.Element)}
+
+var versionClient = &http.Client{Timeout: 5 * time.Second}
+
+// moduleVersions returns the cached versions of mod, newest first, and
+// refreshes the cache in the background when it is missing or stale,
+// so that pages are never held up by the proxy.
+func moduleVersions(mod string) []string {
+	if *versionProxy == "" {
+		return nil
+	}
+	versionCache.Lock()
+	defer versionCache.Unlock()
+	var vl *versionList
+	if e := versionCache.m[mod]; e != nil {
+		versionCache.lru.MoveToFront(e)
+		vl = e.Value.(*versionList)
+	} else {
+		vl = &versionList{mod: keepString(mod)}
+		versionCache.m[mod] = versionCache.lru.PushFront(vl)
+		if versionCache.lru.Len() > maxCachedModules {
+			old := versionCache.lru.Remove(versionCache.lru.Back()).(*versionList)
+			delete(versionCache.m, old.mod)
+		}
+	}
+	if !vl.fetching && time.Since(vl.fetched) > versionsTTL {
+		vl.fetching = true
+		go fetchVersions(vl.mod, vl)
+	}
+	return vl.versions
+}
+
+func fetchVersions(mod string, vl *versionList) {
+	versions, err := listVersions(mod)
+	versionCache.Lock()
+	defer versionCache.Unlock()
+	vl.fetching = false
+	vl.fetched = time.Now()
+	if err == nil {
+		vl.versions = versions
+	}
+}
+
+// listVersions asks the module proxy for the tagged versions of mod.
+func listVersions(mod string) ([]string, error) {
+	escaped, err := module.EscapePath(mod)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := versionClient.Get(strings.TrimSuffix(*versionProxy, "/") + "/" + escaped + "/@v/list")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil
+	}
+	var versions []string
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		if v := strings.TrimSpace(sc.Text()); semver.IsValid(v) {
+			versions = append(versions, v)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return semver.Compare(versions[i], versions[j]) > 0 })
+	if len(versions) > maxVersions {
+		versions = versions[:maxVersions]
+	}
+	return versions, sc.Err()
+}
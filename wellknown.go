@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"time"
+)
+
+var (
+	contact = flag.String("contact", "", "advertise `contact` for the namespace in /.well-known/go-modules")
+	policy  = flag.String("policy", "", "advertise namespace policy `URL` in /.well-known/go-modules")
+	goproxy = flag.String("goproxy", "", "recommend GOPROXY `value` in /.well-known/go-modules")
+	gosumdb = flag.String("gosumdb", "", "recommend GOSUMDB `value` in /.well-known/go-modules")
+)
+
+type namespace struct {
+	ImportPath string   `json:"import_path"`
+	Repo       string   `json:"repo"`
+	VCS        string   `json:"vcs"`
+	MinGo      string   `json:"min_go,omitempty"`
+	Archived   bool     `json:"archived,omitempty"`
+	MovedTo    string   `json:"moved_to,omitempty"`
+	Owner      string   `json:"owner,omitempty"`
+	Team       string   `json:"team,omitempty"`
+	Contact    string   `json:"contact,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+}
+
+type wellKnown struct {
+	Namespaces []namespace `json:"namespaces"`
+	Contact    string      `json:"contact,omitempty"`
+	Policy     string      `json:"policy,omitempty"`
+	GOPROXY    string      `json:"goproxy,omitempty"`
+	GOSUMDB    string      `json:"gosumdb,omitempty"`
+}
+
+// serveWellKnown describes the served namespaces and its governance on
+// /.well-known/go-modules, limited to the rules with all of the ?tag= tags
+// if given.
+func serveWellKnown(w http.ResponseWriter, req *http.Request) {
+	wk := wellKnown{
+		Contact: *contact,
+		Policy:  *policy,
+		GOPROXY: *goproxy,
+		GOSUMDB: *gosumdb,
+	}
+	for _, r := range taggedRules(currentRules(), queryTags(req)) {
+		ns := namespace{
+			ImportPath: r.Import,
+			Repo:       r.Repo,
+			VCS:        r.VCS,
+			MinGo:      r.MinGo,
+			MovedTo:    r.MovedTo,
+			Owner:      r.Owner,
+			Team:       r.Team,
+			Contact:    r.Contact,
+			Tags:       r.Tags,
+		}
+		if r.Import == r.Root() {
+			repo, _ := r.ActiveRepo(time.Now())
+			ns.Archived = repoArchived(repo)
+		}
+		wk.Namespaces = append(wk.Namespaces, ns)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(wk)
+}